@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kou12345/gollm/internal/chat"
+	"github.com/kou12345/gollm/internal/config"
+	"github.com/kou12345/gollm/internal/jsonschema"
+	"github.com/kou12345/gollm/pkg/utils"
+)
+
+// askRoom は、`gollm ask` と `gollm run` のやり取りを記録するルームです。
+// 使用量の集計のために保存しますが、次の質問のコンテキストには含めません。
+const askRoom = "ask"
+
+// oneShotFlags は、1回だけ質問するサブコマンドに共通するフラグです。
+type oneShotFlags struct {
+	room       *string
+	schemaPath *string
+}
+
+// addOneShotFlags は、flags に --room と --json-schema を追加します。
+func addOneShotFlags(flags *flag.FlagSet) *oneShotFlags {
+	return &oneShotFlags{
+		room:       flags.String("room", "", "continue the conversation in this room instead of starting a fresh one"),
+		schemaPath: flags.String("json-schema", "", "restrict the response to compact JSON matching this JSON schema file"),
+	}
+}
+
+// runAsk は、`gollm ask [--room name] [--json-schema file] [prompt...]` サブコマンドとして、
+// 1回だけ質問して応答を標準出力に表示します。prompt を省略した場合は標準入力を読み込みます。
+func runAsk(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("ask", flag.ContinueOnError)
+	f := addOneShotFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	prompt := strings.Join(flags.Args(), " ")
+	if prompt == "" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read prompt from stdin: %w", err)
+		}
+		prompt = string(data)
+	}
+	return f.ask(cfg, prompt)
+}
+
+// ask は、prompt を1回だけ送信して応答を標準出力に表示します。
+// 応答以外の表示は標準エラー出力に書き込むため、スクリプトから応答だけを受け取れます。
+func (f *oneShotFlags) ask(cfg *config.Config, prompt string) error {
+	if strings.TrimSpace(prompt) == "" {
+		return fmt.Errorf("no prompt given")
+	}
+
+	o := chat.Options{
+		Room:   *f.room,
+		Output: os.Stderr,
+		// 標準入力はプロンプトに使用するため、副作用のあるツールは確認できず常に拒否します。
+		Confirm: func(prompt string) bool {
+			fmt.Fprintln(os.Stderr, utils.ErrorColor(prompt+" Declined: one-shot mode cannot ask for confirmation."))
+			return false
+		},
+	}
+	if o.Room == "" {
+		o.Room = askRoom
+		o.Fresh = true
+	}
+	if *f.schemaPath != "" {
+		schema, err := jsonschema.Load(*f.schemaPath)
+		if err != nil {
+			return fmt.Errorf("failed to load JSON schema: %w", err)
+		}
+		o.JSONSchema = schema
+	}
+
+	c, closeChat, err := openChat(cfg, o)
+	if err != nil {
+		return err
+	}
+	defer closeChat()
+
+	response, err := c.Ask(prompt)
+	if err != nil {
+		return err
+	}
+	fmt.Println(response)
+	return nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kou12345/gollm/internal/atomicfile"
+	"github.com/kou12345/gollm/internal/attach"
+	"github.com/kou12345/gollm/internal/chat"
+	"github.com/kou12345/gollm/internal/config"
+	"github.com/kou12345/gollm/internal/fake"
+	"github.com/kou12345/gollm/internal/history"
+	"github.com/kou12345/gollm/internal/mcp"
+	"github.com/kou12345/gollm/internal/project"
+	"github.com/kou12345/gollm/internal/secret"
+	"github.com/kou12345/gollm/internal/tools"
+	"github.com/kou12345/gollm/pkg/utils"
+	"google.golang.org/api/option"
+)
+
+// defaultRoom は、ルーム名を指定せずに `gollm chat` を実行したときに使用するルームです。
+const defaultRoom = "default"
+
+// runChat は、`gollm chat [room]` サブコマンドとして対話型のチャットセッションを開始します。
+func runChat(cfg *config.Config, args []string) error {
+	room := defaultRoom
+	if len(args) > 0 {
+		room = args[0]
+	}
+
+	c, closeChat, err := openChat(cfg, chat.Options{Room: room, Pick: pickCandidate})
+	if err != nil {
+		return err
+	}
+	defer closeChat()
+
+	c.Run()
+	return nil
+}
+
+// openChat は、設定に従ってバックエンド・データベース・ツールを準備し、Chat を作成します。
+// o の Model, Strategy, Store などの設定に関する項目は cfg の値で上書きされます。
+// ルームは、作業ディレクトリが属するプロジェクトのものを使用します。
+// 返された関数は、使用後に全てのリソースを解放します。
+func openChat(cfg *config.Config, o chat.Options) (*chat.Chat, func(), error) {
+	env, err := openChatEnv(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	o.Project = env.project.Root
+	c, err := env.newChat(o)
+	if err != nil {
+		env.Close()
+		return nil, nil, err
+	}
+	return c, func() {
+		c.Close()
+		env.Close()
+	}, nil
+}
+
+// chatEnv は、設定から準備した、複数の Chat で共有するリソースです。
+type chatEnv struct {
+	cfg          *config.Config
+	clientOpts   []option.ClientOption
+	templatesDir string
+	store        *history.Store
+	tools        *tools.Registry
+	servers      []*mcp.Client
+	project      project.Project // ルームを分けるプロジェクト（プロジェクトに属さない場合はゼロ値）
+	instructions string          // 全ての Chat でモデルに渡すプロジェクトのコンテキスト
+}
+
+// openChatEnv は、バックエンドへの接続を準備し、データベースを開いてツールを準備します。
+func openChatEnv(cfg *config.Config) (*chatEnv, error) {
+	name, backend, err := cfg.ActiveBackend()
+	if err != nil {
+		return nil, err
+	}
+	templatesDir, err := cfg.TemplatesPath()
+	if err != nil {
+		return nil, err
+	}
+	clientOpts, err := backendClientOptions(name, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := tools.NewRegistry()
+	if cfg.Tools.Enabled == nil || *cfg.Tools.Enabled {
+		opts := tools.BuiltinOptions{RunShell: cfg.Tools.RunShell, Root: toolsRoot()}
+		if err := tools.RegisterBuiltins(registry, opts); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	return &chatEnv{
+		cfg:          cfg,
+		clientOpts:   clientOpts,
+		templatesDir: templatesDir,
+		store:        store,
+		tools:        registry,
+		servers:      startMCPServers(cfg, registry),
+		project:      currentProject(cfg),
+		instructions: projectInstructions(cfg),
+	}, nil
+}
+
+// legacyDataFiles は、以前のバージョンが作業ディレクトリに作成していたデータファイルと、データディレクトリでの名前です。
+var legacyDataFiles = []struct{ name, target string }{
+	{"db.sql", "gollm.db"},
+	{"chat_history.json", "chat_history.json"},
+}
+
+// openStore は、設定されたパス（既定ではデータディレクトリの gollm.db）のデータベースを開きます。
+// 作業ディレクトリに以前のバージョンのデータファイルが残っている場合は、データディレクトリに移動します。
+// データディレクトリに以前のバージョンの履歴ファイルがあれば、ルームに取り込みます。
+func openStore(cfg *config.Config) (*history.Store, error) {
+	path, err := cfg.DatabasePath()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if cfg.DBPath == "" {
+		migrateLegacyData(".", filepath.Dir(path))
+	}
+	store, err := history.OpenStore(path)
+	if err != nil {
+		return nil, err
+	}
+	importLegacyHistory(cfg, store)
+	return store, nil
+}
+
+// migrateLegacyData は、以前のバージョンが from に作成したデータファイルを、初回の起動時に dir へ移動します。
+// 移動先に既にファイルがある場合は、どちらのファイルも変更せずに警告を表示します。
+// 移動は移動先のロックを取得して行うため、複数のプロセスが同時に起動しても一度だけ移動します。
+func migrateLegacyData(from, dir string) {
+	for _, f := range legacyDataFiles {
+		src, dst := filepath.Join(from, f.name), filepath.Join(dir, f.target)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		switch err := atomicfile.Move(src, dst); {
+		case err == nil:
+			fmt.Fprintln(os.Stderr, utils.SuccessColor(fmt.Sprintf("Moved %s from an older version to %s", src, dst)))
+		case errors.Is(err, fs.ErrNotExist):
+			// 他のプロセスが先に移動しました。
+		case errors.Is(err, fs.ErrExist):
+			fmt.Fprintln(os.Stderr, utils.ErrorColor(fmt.Sprintf("Found %s from an older version, but %s already exists; move or remove one of them yourself", src, dst)))
+		default:
+			fmt.Fprintln(os.Stderr, utils.ErrorColor(fmt.Sprintf("Failed to move %s to %s: %v", src, dst, err)))
+		}
+	}
+}
+
+// importedRoom は、default ルームに既にメッセージがある場合に、以前のバージョンの履歴を取り込むルームです。
+const importedRoom = "imported"
+
+// importLegacyHistory は、以前のバージョンがデータディレクトリに残した JSON 形式の履歴を一度だけルームに取り込みます。
+// 取り込み先は、メッセージがなければ default ルーム、あれば imported ルームです。
+// 取り込めなかった場合は、警告を表示して無視します（次回の起動時に再び試みます）。
+func importLegacyHistory(cfg *config.Config, store *history.Store) {
+	path, err := cfg.HistoryPath()
+	if err != nil {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+
+	name := defaultRoom
+	if room, err := store.OpenRoom(defaultRoom); err == nil && room.MessageCount > 0 {
+		name = importedRoom
+	}
+	n, renamed, err := store.ImportChatHistory(path, name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, utils.ErrorColor(fmt.Sprintf("Failed to import the chat history from %s: %v", path, err)))
+		return
+	}
+	if n > 0 || renamed != "" {
+		fmt.Fprintln(os.Stderr, utils.SuccessColor(fmt.Sprintf("Imported %d messages from %s into the room %s (the file was renamed to %s)", n, path, name, renamed)))
+	}
+}
+
+// currentProject は、ルームを分けるプロジェクトとして、作業ディレクトリが属する git リポジトリを返します。
+// リポジトリの外の場合や、project.scoped を無効にした場合はゼロ値を返します。
+func currentProject(cfg *config.Config) project.Project {
+	if cfg.Project.Scoped != nil && !*cfg.Project.Scoped {
+		return project.Project{}
+	}
+	p, ok, err := project.Detect(".")
+	if err != nil || !ok {
+		return project.Project{}
+	}
+	return p
+}
+
+// toolsRoot は、ファイルを読むツールがアクセスできるディレクトリとして、作業ディレクトリが属する git リポジトリのルートを返します。
+// リポジトリの外の場合は空文字列（作業ディレクトリ）を返します。project.scoped の設定には関係しません。
+func toolsRoot() string {
+	p, ok, err := project.Detect(".")
+	if err != nil || !ok {
+		return ""
+	}
+	return p.Root
+}
+
+// projectInstructions は、作業ディレクトリが属する git リポジトリのルートにあるコンテキストファイル（GOLLM.md など）を、
+// モデルへの指示として返します。ファイルがない場合や project.attach_context を無効にした場合は空文字列を返します。
+// ファイルを読み込めない場合は、警告を表示して無視します。
+func projectInstructions(cfg *config.Config) string {
+	if cfg.Project.AttachContext != nil && !*cfg.Project.AttachContext {
+		return ""
+	}
+	p, ok, err := project.Detect(".")
+	if err != nil || !ok {
+		return ""
+	}
+	text, err := p.ReadContext(cfg.Project.ContextFile, cfg.Attach.MaxFileSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, utils.ErrorColor(fmt.Sprintf("Skipping project context: %v", err)))
+		return ""
+	}
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+	return fmt.Sprintf("The following is the project context from %s in the repository %s:\n\n%s", cfg.Project.ContextFile, p.Name(), text)
+}
+
+// newChat は、共有するリソースを使用して Chat を作成します。
+func (e *chatEnv) newChat(o chat.Options) (*chat.Chat, error) {
+	o.TemplatesDir = e.templatesDir
+	o.Model = e.cfg.ModelName()
+	o.Strategy = chat.Strategy(e.cfg.Context.Strategy)
+	o.MaxTokens = e.cfg.Context.MaxTokens
+	o.Threshold = e.cfg.Context.Threshold
+	o.Store = e.store
+	o.AttachLimits = attach.Limits{
+		MaxFileSize:  e.cfg.Attach.MaxFileSize,
+		MaxTotalSize: e.cfg.Attach.MaxTotalSize,
+		MaxMediaSize: e.cfg.Attach.MaxMediaSize,
+	}
+	o.Tools = e.tools
+	o.Instructions = e.instructions
+	o.Backends = e.candidateBackend
+	return chat.NewChat(o, e.clientOpts...)
+}
+
+// candidateBackend は、応答を比較するときに name のバックエンドに接続するオプションと、そのバックエンドのモデルを返します。
+func (e *chatEnv) candidateBackend(name string) (chat.CandidateOptions, error) {
+	backend, err := e.cfg.BackendNamed(name)
+	if err != nil {
+		return chat.CandidateOptions{}, err
+	}
+	clientOpts, err := backendClientOptions(name, backend)
+	if err != nil {
+		return chat.CandidateOptions{}, err
+	}
+	return chat.CandidateOptions{Backend: name, Model: backend.Model, Client: clientOpts}, nil
+}
+
+// backendClientOptions は、バックエンドの種類に応じて genai のクライアントに渡すオプションを返します。
+// fake バックエンドは Gemini API を模倣するため、HTTP クライアントを差し替えるだけで同じように扱えます。
+func backendClientOptions(name string, backend config.Backend) ([]option.ClientOption, error) {
+	switch backend.Type {
+	case "gemini":
+		apiKey, err := backend.ResolveAPIKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get API key for backend %q: %w", name, err)
+		}
+		if apiKey == "" {
+			return nil, fmt.Errorf("no API key configured for backend %q (set api_key_env, api_key_file or api_key_cmd in backends.%s)", name, name)
+		}
+		return []option.ClientOption{option.WithAPIKey(apiKey)}, nil
+	case "fake":
+		script := fake.DefaultScript()
+		if path := backend.ScriptPath(); path != "" {
+			var err error
+			if script, err = fake.LoadScript(path); err != nil {
+				return nil, err
+			}
+		}
+		fb, err := fake.New(script)
+		if err != nil {
+			return nil, err
+		}
+		// genai はクライアントの作成に認証情報を必要とするため、使用されないキーを渡します。
+		return []option.ClientOption{option.WithHTTPClient(fb.Client()), option.WithAPIKey("fake")}, nil
+	}
+	return nil, fmt.Errorf("backend %q has unsupported type %q", name, backend.Type)
+}
+
+// Close は、MCP サーバーを終了してデータベースを閉じます。
+func (e *chatEnv) Close() {
+	for _, s := range e.servers {
+		s.Close()
+	}
+	e.store.Close()
+}
+
+// mcpStartTimeout は、MCP サーバーの起動とツールの取得を待つ時間です。
+const mcpStartTimeout = 30 * time.Second
+
+// startMCPServers は、設定された MCP サーバーを起動し、そのツールを registry に登録します。
+// 起動できなかったサーバーは警告を表示して無視します。
+func startMCPServers(cfg *config.Config, registry *tools.Registry) []*mcp.Client {
+	names := make([]string, 0, len(cfg.MCPServers))
+	for name := range cfg.MCPServers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var clients []*mcp.Client
+	for _, name := range names {
+		s := cfg.MCPServers[name]
+		ctx, cancel := context.WithTimeout(context.Background(), mcpStartTimeout)
+		c, err := mcp.Start(ctx, name, mcp.Server{Command: s.Command, Args: s.Args, Env: s.Env, Dir: s.Dir})
+		if err == nil {
+			if err = c.Register(ctx, registry); err != nil {
+				c.Close()
+			}
+		}
+		cancel()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, utils.ErrorColor(fmt.Sprintf("Skipping MCP server %s: %v", name, secret.Redact(err.Error()))))
+			continue
+		}
+		clients = append(clients, c)
+	}
+	return clients
+}
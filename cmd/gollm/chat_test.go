@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMigrateLegacyData は、以前のバージョンのデータファイルをデータディレクトリに移動し、
+// 移動先に既にファイルがある場合はどちらも変更しないことを確認します。
+func TestMigrateLegacyData(t *testing.T) {
+	from, dir := t.TempDir(), t.TempDir()
+	for name, content := range map[string]string{"db.sql": "old database", "chat_history.json": "old history"} {
+		if err := os.WriteFile(filepath.Join(from, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "chat_history.json"), []byte("new history"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	migrateLegacyData(from, dir)
+
+	if got, err := os.ReadFile(filepath.Join(dir, "gollm.db")); err != nil || string(got) != "old database" {
+		t.Errorf("gollm.db = %q, %v, want the moved database", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(from, "db.sql")); !os.IsNotExist(err) {
+		t.Errorf("db.sql was not moved: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "chat_history.json")); string(got) != "new history" {
+		t.Errorf("chat_history.json in the data directory = %q, want it unchanged", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(from, "chat_history.json")); string(got) != "old history" {
+		t.Errorf("chat_history.json in the old directory = %q, want it left in place", got)
+	}
+
+	// 移動した後は、何もしません。
+	migrateLegacyData(from, dir)
+	if got, _ := os.ReadFile(filepath.Join(dir, "gollm.db")); string(got) != "old database" {
+		t.Errorf("gollm.db = %q after a second run", got)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/kou12345/gollm/internal/chat"
+	"github.com/kou12345/gollm/internal/render"
+)
+
+// minColumnWidth は、比較画面の1列の最小の幅です。
+const minColumnWidth = 30
+
+var (
+	columnStyle = lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("240")).
+			Padding(0, 1)
+	selectedColumnStyle = columnStyle.BorderForeground(focusedColor)
+	columnTitleStyle    = lipgloss.NewStyle().Bold(true)
+	compareHelpStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+)
+
+// compareModel は、複数の応答を横に並べて表示し、1つを選択させる Bubble Tea のモデルです。
+// 選択を確定するか取り消すと done になります。TUI の中でも、単独のプログラム（pickCandidate）としても使用します。
+type compareModel struct {
+	candidates []chat.Candidate
+	selected   int
+	picked     int  // 確定した応答の位置（取り消した場合は -1）
+	done       bool // 選択を確定したか取り消したかどうか
+	offset     int  // 全ての列で共通のスクロール位置
+	width      int
+	height     int
+	lines      [][]string // 現在の幅で描画した各列の行
+}
+
+// newCompareModel は、candidates を比較するモデルを作成します。
+func newCompareModel(candidates []chat.Candidate) compareModel {
+	return compareModel{candidates: candidates, picked: -1}
+}
+
+// pickCandidate は、candidates を全画面で横に並べて表示し、利用者が選択した応答の位置を返します。
+// 利用者が取り消した場合は -1 を返します。REPL の /compare で使用する chat.PickFunc です。
+func pickCandidate(candidates []chat.Candidate) (int, error) {
+	m, err := tea.NewProgram(comparePicker{newCompareModel(candidates)}, tea.WithAltScreen()).Run()
+	if err != nil {
+		return -1, err
+	}
+	return m.(comparePicker).picked, nil
+}
+
+// comparePicker は、compareModel を単独のプログラムとして実行し、選択が終わると終了します。
+type comparePicker struct {
+	compareModel
+}
+
+func (p comparePicker) Init() tea.Cmd {
+	return nil
+}
+
+func (p comparePicker) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	p.compareModel = p.compareModel.Update(msg)
+	if p.done {
+		return p, tea.Quit
+	}
+	return p, nil
+}
+
+// Update は、応答の選択とスクロールのキーを処理します。
+func (m compareModel) Update(msg tea.Msg) compareModel {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.lines = m.renderColumns()
+		m.offset = min(m.offset, m.maxOffset())
+
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "left", "h", "shift+tab":
+			m.selected = (m.selected + len(m.candidates) - 1) % len(m.candidates)
+		case "right", "l", "tab":
+			m.selected = (m.selected + 1) % len(m.candidates)
+		case "up", "k":
+			m.offset = max(m.offset-1, 0)
+		case "down", "j":
+			m.offset = min(m.offset+1, m.maxOffset())
+		case "pgup":
+			m.offset = max(m.offset-m.bodyHeight(), 0)
+		case "pgdown", " ":
+			m.offset = min(m.offset+m.bodyHeight(), m.maxOffset())
+		case "enter":
+			if m.candidates[m.selected].Err == nil {
+				m.picked, m.done = m.selected, true
+			}
+		case "esc", "q", "ctrl+c":
+			m.picked, m.done = -1, true
+		}
+	}
+	return m
+}
+
+func (m compareModel) View() string {
+	if m.lines == nil {
+		return "Loading..."
+	}
+
+	inner := m.columnWidth()
+	columns := make([]string, len(m.candidates))
+	for i, c := range m.candidates {
+		lines := m.lines[i]
+		end := min(m.offset+m.bodyHeight(), len(lines))
+		start := min(m.offset, end)
+		body := strings.Join(lines[start:end], "\n")
+
+		style := columnStyle
+		if i == m.selected {
+			style = selectedColumnStyle
+		}
+		title := columnTitleStyle.Render(truncate(fmt.Sprintf("%d. %s", i+1, c.Label()), inner))
+		columns[i] = style.Width(inner + 2).Height(m.bodyHeight() + 1).Render(title + "\n" + body)
+	}
+
+	help := compareHelpStyle.Render("←/→ select • ↑/↓ scroll • enter keep • esc discard")
+	return lipgloss.JoinHorizontal(lipgloss.Top, columns...) + "\n" + help
+}
+
+// renderColumns は、各応答を列の幅で Markdown として描画し、行に分割します。
+func (m compareModel) renderColumns() [][]string {
+	lines := make([][]string, len(m.candidates))
+	for i, c := range m.candidates {
+		var text string
+		if c.Err != nil {
+			text = errorStyle.Width(m.columnWidth()).Render("Error: " + c.Err.Error())
+		} else {
+			text = strings.Trim(render.RenderMarkdownWidth(c.Text, m.columnWidth()), "\n")
+		}
+		lines[i] = strings.Split(text, "\n")
+	}
+	return lines
+}
+
+// columnWidth は、枠と余白を除いた1列の幅を返します。
+func (m compareModel) columnWidth() int {
+	// 枠（左右1桁ずつ）と余白（左右1桁ずつ）を除きます。
+	return max(m.width/len(m.candidates)-4, minColumnWidth)
+}
+
+// bodyHeight は、各列で応答を表示できる行数を返します。
+func (m compareModel) bodyHeight() int {
+	// 枠（上下1行ずつ）、見出し、操作説明を除きます。
+	return max(m.height-4, 1)
+}
+
+// maxOffset は、最も長い応答の末尾まで表示できるスクロール位置の上限を返します。
+func (m compareModel) maxOffset() int {
+	longest := 0
+	for _, l := range m.lines {
+		longest = max(longest, len(l))
+	}
+	return max(longest-m.bodyHeight(), 0)
+}
+
+// truncate は、s を width 桁以内に切り詰めます。
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	return string(r[:max(width-1, 0)]) + "…"
+}
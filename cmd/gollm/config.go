@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/kou12345/gollm/internal/config"
+	"github.com/kou12345/gollm/pkg/utils"
+)
+
+// runConfig は、`gollm config show|set` サブコマンドを実行します。
+func runConfig(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: gollm config show | gollm config set <key> <value>")
+	}
+
+	switch args[0] {
+	case "show":
+		return showConfig(cfg)
+	case "set":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: gollm config set <key> <value>")
+		}
+		if err := config.Set(args[1], args[2]); err != nil {
+			return err
+		}
+		path, _ := config.UserConfigPath()
+		fmt.Printf("Set %s in %s\n", args[1], path)
+		if strings.HasSuffix(args[1], ".api_key") {
+			fmt.Println(utils.ErrorColor("Warning: the API key is stored in plain text. Consider api_key_cmd or api_key_file instead."))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown config command %q", args[0])
+	}
+}
+
+// showConfig は、全てのレイヤーを適用した後の設定をTOML形式で表示します。
+// APIキーは表示しません。db_path は、既定のデータディレクトリを使用する場合も実際のパスを表示します。
+func showConfig(cfg *config.Config) error {
+	shown := *cfg
+	if path, err := cfg.DatabasePath(); err == nil {
+		shown.DBPath = path
+	}
+	shown.Backends = make(map[string]config.Backend, len(cfg.Backends))
+	for name, b := range cfg.Backends {
+		if b.APIKey != "" {
+			b.APIKey = "********"
+		}
+		shown.Backends[name] = b
+	}
+
+	for _, src := range cfg.Sources {
+		fmt.Printf("# loaded from %s\n", src)
+	}
+	return toml.NewEncoder(os.Stdout).Encode(shown)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/key"
+)
+
+// mode は、キー入力の解釈を切り替える TUI の状態です。フォーカスを持つ領域で決まります。
+type mode string
+
+const (
+	modeList   mode = "list"   // サイドバーでルームを選んでいる
+	modeNormal mode = "normal" // 会話を読んでいる（vim のノーマルモード）
+	modeInsert mode = "insert" // メッセージを入力している（vim の挿入モード）
+)
+
+// keyMap は、TUI の操作とキーの対応です。設定ファイルの keybindings で変更できます。
+type keyMap struct {
+	Quit           key.Binding
+	Help           key.Binding
+	NextPane       key.Binding
+	PrevPane       key.Binding
+	ToggleSidebar  key.Binding
+	Back           key.Binding
+	OpenRoom       key.Binding
+	Filter         key.Binding
+	PinRoom        key.Binding
+	ArchiveRoom    key.Binding
+	ToggleArchived key.Binding
+	ToggleProjects key.Binding
+	Up             key.Binding
+	Down           key.Binding
+	PageUp         key.Binding
+	PageDown       key.Binding
+	Top            key.Binding
+	Bottom         key.Binding
+	PrevMessage    key.Binding
+	NextMessage    key.Binding
+	Search         key.Binding
+	NextMatch      key.Binding
+	PrevMatch      key.Binding
+	Yank           key.Binding
+	PrevBranch     key.Binding
+	NextBranch     key.Binding
+	Insert         key.Binding
+	Attach         key.Binding
+	Compare        key.Binding
+	Send           key.Binding
+	Newline        key.Binding
+}
+
+// action は、keybindings に指定できる操作です。
+type action struct {
+	name    string
+	desc    string
+	binding func(k *keyMap) *key.Binding
+}
+
+// actions は、keybindings に指定できる全ての操作です。
+var actions = []action{
+	{"quit", "quit", func(k *keyMap) *key.Binding { return &k.Quit }},
+	{"help", "toggle help", func(k *keyMap) *key.Binding { return &k.Help }},
+	{"next_pane", "next pane", func(k *keyMap) *key.Binding { return &k.NextPane }},
+	{"prev_pane", "previous pane", func(k *keyMap) *key.Binding { return &k.PrevPane }},
+	{"toggle_sidebar", "toggle rooms", func(k *keyMap) *key.Binding { return &k.ToggleSidebar }},
+	{"back", "back", func(k *keyMap) *key.Binding { return &k.Back }},
+	{"open_room", "open room", func(k *keyMap) *key.Binding { return &k.OpenRoom }},
+	{"filter", "filter rooms", func(k *keyMap) *key.Binding { return &k.Filter }},
+	{"pin_room", "pin room", func(k *keyMap) *key.Binding { return &k.PinRoom }},
+	{"archive_room", "archive room", func(k *keyMap) *key.Binding { return &k.ArchiveRoom }},
+	{"toggle_archived", "show archived", func(k *keyMap) *key.Binding { return &k.ToggleArchived }},
+	{"toggle_projects", "all projects", func(k *keyMap) *key.Binding { return &k.ToggleProjects }},
+	{"up", "up", func(k *keyMap) *key.Binding { return &k.Up }},
+	{"down", "down", func(k *keyMap) *key.Binding { return &k.Down }},
+	{"page_up", "page up", func(k *keyMap) *key.Binding { return &k.PageUp }},
+	{"page_down", "page down", func(k *keyMap) *key.Binding { return &k.PageDown }},
+	{"top", "first message", func(k *keyMap) *key.Binding { return &k.Top }},
+	{"bottom", "last message", func(k *keyMap) *key.Binding { return &k.Bottom }},
+	{"prev_message", "previous message", func(k *keyMap) *key.Binding { return &k.PrevMessage }},
+	{"next_message", "next message", func(k *keyMap) *key.Binding { return &k.NextMessage }},
+	{"search", "search in room", func(k *keyMap) *key.Binding { return &k.Search }},
+	{"next_match", "next match", func(k *keyMap) *key.Binding { return &k.NextMatch }},
+	{"prev_match", "previous match", func(k *keyMap) *key.Binding { return &k.PrevMatch }},
+	{"yank", "copy message", func(k *keyMap) *key.Binding { return &k.Yank }},
+	{"prev_branch", "previous branch", func(k *keyMap) *key.Binding { return &k.PrevBranch }},
+	{"next_branch", "next branch", func(k *keyMap) *key.Binding { return &k.NextBranch }},
+	{"insert", "write message", func(k *keyMap) *key.Binding { return &k.Insert }},
+	{"attach", "attach files", func(k *keyMap) *key.Binding { return &k.Attach }},
+	{"compare", "compare responses", func(k *keyMap) *key.Binding { return &k.Compare }},
+	{"send", "send", func(k *keyMap) *key.Binding { return &k.Send }},
+	{"newline", "new line", func(k *keyMap) *key.Binding { return &k.Newline }},
+}
+
+// newKeyMap は、設定の keybindings（操作名とキーのリストの対応）から keyMap を作成します。
+// 設定されていない操作にはキーが割り当てられません。未知の操作名はエラーになります。
+func newKeyMap(bindings map[string][]string) (keyMap, error) {
+	var k keyMap
+	known := map[string]bool{}
+	for _, a := range actions {
+		known[a.name] = true
+		*a.binding(&k) = newBinding(bindings[a.name], a.desc)
+	}
+
+	var unknown []string
+	for name := range bindings {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return keyMap{}, fmt.Errorf("unknown keybinding action %q", unknown[0])
+	}
+	return k, nil
+}
+
+// newBinding は、keys に desc の説明を付けた key.Binding を作成します。
+// スペースキーは、設定ファイルで書きやすいように "space" とも書けます。
+// "g g" のように空白で区切ったキーは、続けて押すキーの並びです（ノーマルモードでのみ使用できます）。
+func newBinding(keys []string, desc string) key.Binding {
+	if len(keys) == 0 {
+		return key.NewBinding(key.WithDisabled())
+	}
+	names := make([]string, len(keys))
+	matched := make([]string, len(keys))
+	for i, k := range keys {
+		names[i], matched[i] = k, k
+		switch k {
+		case "space":
+			matched[i] = " "
+		case " ":
+			names[i] = "space"
+		default:
+			names[i] = strings.ReplaceAll(k, " ", "")
+		}
+	}
+	return key.NewBinding(key.WithKeys(matched...), key.WithHelp(strings.Join(names, "/"), desc))
+}
+
+// forMode は、md で有効なキーだけを残した keyMap を返します。
+// 挿入モードでは、1文字のキー（q や ? など）は文字の入力として扱うため、キーの並びと共に操作から外します。
+func (k keyMap) forMode(md mode) keyMap {
+	if md != modeInsert {
+		return k
+	}
+	for _, a := range actions {
+		b := a.binding(&k)
+		var keys []string
+		for _, s := range b.Keys() {
+			if utf8.RuneCountInString(s) != 1 && !isSequence(s) {
+				keys = append(keys, s)
+			}
+		}
+		*b = newBinding(keys, b.Help().Desc)
+	}
+	return k
+}
+
+// isSequence は、k が続けて押すキーの並びかどうかを返します。
+func isSequence(k string) bool {
+	return len(k) > 1 && strings.Contains(k, " ")
+}
+
+// matches は、押されたキー（またはキーの並び）の pressed が b に割り当てられているかどうかを返します。
+func matches(pressed string, b key.Binding) bool {
+	return b.Enabled() && slices.Contains(b.Keys(), pressed)
+}
+
+// hasPrefix は、pressed の後に続けて押すと操作になるキーの並びがあるかどうかを返します。
+func (k keyMap) hasPrefix(pressed string) bool {
+	for _, a := range actions {
+		for _, s := range a.binding(&k).Keys() {
+			if strings.HasPrefix(s, pressed+" ") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shortHelp は、md でフッターに表示する主な操作です。
+func (k keyMap) shortHelp(md mode) []key.Binding {
+	k = k.forMode(md)
+	switch md {
+	case modeList:
+		return []key.Binding{k.OpenRoom, k.Filter, k.NextPane, k.ToggleSidebar, k.Help, k.Quit}
+	case modeNormal:
+		return []key.Binding{k.Down, k.Up, k.NextMessage, k.Search, k.Yank, k.Insert, k.Help, k.Quit}
+	}
+	return []key.Binding{k.Send, k.Newline, k.Back, k.NextPane, k.Quit}
+}
+
+// helpSection は、ヘルプ画面の見出しとその下に表示する操作です。
+type helpSection struct {
+	title    string
+	bindings []key.Binding
+}
+
+// fullHelp は、ヘルプ画面に表示する全ての操作を、使用できる状態ごとにまとめて返します。
+func (k keyMap) fullHelp() []helpSection {
+	return []helpSection{
+		{"Global", []key.Binding{k.NextPane, k.PrevPane, k.ToggleSidebar, k.Help, k.Quit}},
+		{"Rooms", []key.Binding{k.Up, k.Down, k.PageUp, k.PageDown, k.OpenRoom, k.Filter, k.PinRoom, k.ArchiveRoom, k.ToggleArchived, k.ToggleProjects}},
+		{"Conversation (normal mode)", []key.Binding{k.Up, k.Down, k.PageUp, k.PageDown, k.Top, k.Bottom, k.PrevMessage, k.NextMessage, k.Search, k.NextMatch, k.PrevMatch, k.Yank, k.PrevBranch, k.NextBranch, k.Insert, k.Attach, k.Back}},
+		{"Composer (insert mode)", []key.Binding{k.Send, k.Newline, k.Attach, k.Compare, k.Back}},
+	}
+}
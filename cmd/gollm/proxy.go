@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/kou12345/gollm/internal/chat"
+	"github.com/kou12345/gollm/internal/config"
+	"github.com/kou12345/gollm/internal/proxy"
+)
+
+// proxyRoom は、`gollm proxy` がやり取りを記録する既定のチャットルームです。
+const proxyRoom = "proxy"
+
+// runProxy は、`gollm proxy` サブコマンドとして OpenAI 互換の API を起動します。
+// リクエストは設定されたバックエンドに転送し、全てのやり取りを --room のチャットルームに記録します。
+// 認証には `gollm serve` と同じトークンを使用します。
+func runProxy(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	addr := flags.String("addr", "127.0.0.1:8081", "address to listen on")
+	room := flags.String("room", proxyRoom, "chat room that records every exchange")
+	tokenFile := flags.String("token-file", "", "file holding the API token (default: serve_token next to the user config file)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	token, source, err := serveToken(*tokenFile)
+	if err != nil {
+		return err
+	}
+
+	env, err := openChatEnv(cfg)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+
+	handler, closeProxy, err := newProxy(env, *room, token)
+	if err != nil {
+		return err
+	}
+	defer closeProxy()
+	return listenAndServe(*addr, handler, source)
+}
+
+// newProxy は、env のバックエンドにリクエストを転送し、room のチャットルームに記録する OpenAI 互換の API を作成します。
+// 返された関数は、使用後にチャットのリソースを解放します。
+func newProxy(env *chatEnv, room, token string) (*proxy.Server, func(), error) {
+	c, err := env.newChat(proxyChatOptions(env, room))
+	if err != nil {
+		return nil, nil, err
+	}
+	return proxy.New(proxy.Options{
+		Backend: c,
+		Token:   token,
+		Models:  []string{env.cfg.ModelName()},
+	}), c.Close, nil
+}
+
+// proxyChatOptions は、`gollm proxy` がリクエストを転送する Chat のオプションを返します。
+// API からはツールの実行を確認できないため、副作用のあるツールは常に拒否します（端末で確認すると標準入力を待ち続けます）。
+func proxyChatOptions(env *chatEnv, room string) chat.Options {
+	return chat.Options{
+		Room:    room,
+		Project: env.project.Root,
+		Output:  os.Stderr,
+		Confirm: func(string) bool { return false },
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kou12345/gollm/internal/config"
+)
+
+// TestProxyNeverConfirmsOnTerminal は、`gollm proxy` が副作用のあるツールを有効にしていても
+// 端末（標準入力）で確認せず、ツールを実行しないことを確認します。
+func TestProxyNeverConfirmsOnTerminal(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "config"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, "data"))
+
+	marker := filepath.Join(home, "ran")
+	script := filepath.Join(home, "script.toml")
+	if err := os.WriteFile(script, []byte(`
+[[rules]]
+match = "shell"
+call = { name = "run_shell", args = { command = "touch `+marker+`" } }
+reply = "Ran it."
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 端末で確認すると、標準入力の "y" で実行を許可してしまいます。
+	stdin, err := os.CreateTemp(home, "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	if _, err := stdin.WriteString("y\ny\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stdin.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	origStdin := os.Stdin
+	os.Stdin = stdin
+	t.Cleanup(func() { os.Stdin = origStdin })
+
+	cfg := config.Default()
+	cfg.Backend = "fake"
+	cfg.Backends["fake"] = config.Backend{Type: "fake", Model: "fake", Script: script}
+	cfg.DBPath = filepath.Join(home, "test.db")
+	cfg.Tools.RunShell = true
+
+	env, err := openChatEnv(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+	o := proxyChatOptions(env, proxyRoom)
+	if o.Confirm == nil || o.Confirm("Allow the model to run run_shell?") {
+		t.Fatal("the proxy chat would confirm tools on the terminal or allow them")
+	}
+
+	handler, closeProxy, err := newProxy(env, proxyRoom, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeProxy()
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	for _, content := range []string{"hello", "run the shell"} {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/chat/completions",
+			strings.NewReader(`{"messages":[{"role":"user","content":"`+content+`"}]}`))
+		req.Header.Set("Authorization", "Bearer token")
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if content == "hello" && resp.StatusCode != http.StatusOK {
+			t.Errorf("%q: status = %d: %s", content, resp.StatusCode, body)
+		}
+	}
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("run_shell ran without confirmation")
+	}
+	if offset, err := stdin.Seek(0, io.SeekCurrent); err != nil || offset != 0 {
+		t.Errorf("the proxy read %d bytes from stdin, want none", offset)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kou12345/gollm/internal/config"
+	"github.com/kou12345/gollm/internal/templates"
+)
+
+// varFlags は、--var name=value を繰り返し指定できるフラグです。
+type varFlags map[string]string
+
+func (v varFlags) String() string { return fmt.Sprint(map[string]string(v)) }
+
+func (v varFlags) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+	v[name] = value
+	return nil
+}
+
+// runRun は、`gollm run <template> [--var name=value]...` サブコマンドとして、
+// テンプレートから作成したプロンプトを1回だけ送信します。
+// テンプレート名を省略した場合は、利用できるテンプレートを一覧表示します。
+func runRun(cfg *config.Config, args []string) error {
+	dir, err := cfg.TemplatesPath()
+	if err != nil {
+		return err
+	}
+
+	vars := varFlags{}
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.Var(vars, "var", "set a template variable (name=value, repeatable)")
+	f := addOneShotFlags(flags)
+	// テンプレート名の前後どちらにもフラグを指定できるように、名前の後ろも解析します。
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	name := flags.Arg(0)
+	if flags.NArg() > 0 {
+		if err := flags.Parse(flags.Args()[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() > 0 {
+			return fmt.Errorf("unexpected argument %q", flags.Arg(0))
+		}
+	}
+
+	if name == "" {
+		return listTemplates(dir)
+	}
+
+	t, err := templates.Load(dir, name)
+	if err != nil {
+		return err
+	}
+	prompt, err := t.Render(vars, templates.RenderOptions{Stdin: os.Stdin, MaxIncludeSize: cfg.Attach.MaxFileSize})
+	if err != nil {
+		return err
+	}
+	return f.ask(cfg, prompt)
+}
+
+// listTemplates は、dir にあるテンプレートと変数を一覧表示します。
+func listTemplates(dir string) error {
+	list, err := templates.List(dir)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		fmt.Printf("No templates found in %s\n", dir)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, t := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", t.Name, t.Description, templateVars(t))
+	}
+	return w.Flush()
+}
+
+// templateVars は、テンプレートの変数を "lang focus=correctness" のような形式で返します。
+// 必須の変数は名前だけを、既定値のある変数は既定値と共に表示します。
+func templateVars(t *templates.Template) string {
+	names := make([]string, 0, len(t.Vars))
+	for name := range t.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		v := t.Vars[name]
+		switch {
+		case v.Required:
+			parts = append(parts, name)
+		default:
+			parts = append(parts, fmt.Sprintf("[%s=%s]", name, v.Default))
+		}
+	}
+	return strings.Join(parts, " ")
+}
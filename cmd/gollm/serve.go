@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kou12345/gollm/internal/atomicfile"
+	"github.com/kou12345/gollm/internal/chat"
+	"github.com/kou12345/gollm/internal/config"
+	"github.com/kou12345/gollm/internal/server"
+	"github.com/kou12345/gollm/pkg/utils"
+)
+
+// serveTokenEnv は、API のトークンを指定する環境変数です。設定されている場合はトークンファイルより優先します。
+const serveTokenEnv = "GOLLM_SERVE_TOKEN"
+
+// runServe は、`gollm serve` サブコマンドとしてチャットルームを操作する HTTP API を起動します。
+// リクエストの認証には、トークンファイル（存在しない場合は作成します）か GOLLM_SERVE_TOKEN のトークンを使用します。
+func runServe(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", "127.0.0.1:8080", "address to listen on")
+	tokenFile := flags.String("token-file", "", "file holding the API token (default: serve_token next to the user config file)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	token, source, err := serveToken(*tokenFile)
+	if err != nil {
+		return err
+	}
+
+	env, err := openChatEnv(cfg)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+
+	handler := server.New(server.Options{
+		Store:   env.store,
+		Project: env.project.Root,
+		Token:   token,
+		Open: func(room string) (server.Session, error) {
+			// API からはツールの実行を確認できないため、副作用のあるツールは常に拒否します。
+			return env.newChat(chat.Options{
+				Room:    room,
+				Project: env.project.Root,
+				Output:  io.Discard,
+				Confirm: func(string) bool { return false },
+			})
+		},
+	})
+	defer handler.Close()
+
+	return listenAndServe(*addr, handler, source)
+}
+
+// listenAndServe は、addr で handler を提供します。割り込みを受けると、処理中のリクエストを待ってから終了します。
+// tokenSource は、起動時に表示するトークンの取得元です。
+func listenAndServe(addr string, handler http.Handler, tokenSource string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	fmt.Fprintln(os.Stderr, utils.SuccessColor(fmt.Sprintf("Listening on http://%s (token from %s)", ln.Addr(), tokenSource)))
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// serveToken は、API のトークンとその取得元を返します。
+// GOLLM_SERVE_TOKEN が設定されていなければ path から読み込み、ファイルがなければ新しいトークンを作成して保存します。
+func serveToken(path string) (token, source string, err error) {
+	if token := os.Getenv(serveTokenEnv); token != "" {
+		return token, serveTokenEnv, nil
+	}
+	if path == "" {
+		configPath, err := config.UserConfigPath()
+		if err != nil {
+			return "", "", err
+		}
+		path = filepath.Join(filepath.Dir(configPath), "serve_token")
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, path, nil
+		}
+		return "", "", fmt.Errorf("token file %s is empty", path)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", "", err
+	}
+
+	// 同時に起動した他のプロセスが先に作成した場合は、そのトークンを使用します。
+	err = atomicfile.Update(path, 0600, func(old []byte) ([]byte, error) {
+		if token = strings.TrimSpace(string(old)); token != "" {
+			return old, nil
+		}
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		token = hex.EncodeToString(buf)
+		return []byte(token + "\n"), nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return token, path, nil
+}
@@ -0,0 +1,1462 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/atotto/clipboard"
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/kou12345/gollm/internal/attach"
+	"github.com/kou12345/gollm/internal/chat"
+	"github.com/kou12345/gollm/internal/config"
+	"github.com/kou12345/gollm/internal/history"
+	"github.com/kou12345/gollm/internal/project"
+	"github.com/kou12345/gollm/internal/render"
+)
+
+// 複雑なANSIエスケープシーケンスを処理する場合を除き、
+// 通常はこれを使用する必要はありません。
+// ちらつきに気づいた場合は有効にしてください。
+//
+// また、高性能レンダリングは端末の全サイズを使用するプログラムでのみ
+// 機能することに注意してください。以下でtea.EnterAltScreen()を使用して
+// これを有効にしています。
+const useHighPerformanceRenderer = false
+
+const (
+	maxSidebarWidth = 32 // サイドバーの最大の幅
+	composerHeight  = 3  // 入力欄の行数
+)
+
+var (
+	titleStyle = func() lipgloss.Style {
+		b := lipgloss.RoundedBorder()
+		b.Right = "├"
+		return lipgloss.NewStyle().BorderStyle(b).Padding(0, 1)
+	}()
+
+	infoStyle = func() lipgloss.Style {
+		b := lipgloss.RoundedBorder()
+		b.Left = "┤"
+		return titleStyle.BorderStyle(b)
+	}()
+
+	sidebarStyle = lipgloss.NewStyle().
+			BorderStyle(lipgloss.NormalBorder()).
+			BorderRight(true).
+			BorderForeground(lipgloss.Color("240"))
+	composerStyle = lipgloss.NewStyle().
+			BorderStyle(lipgloss.NormalBorder()).
+			BorderTop(true).
+			BorderForeground(lipgloss.Color("240"))
+	focusedColor = lipgloss.Color("205")
+
+	userLabelStyle      = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("12"))
+	assistantLabelStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("10"))
+	errorStyle          = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	branchStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+
+	helpOverlayStyle = lipgloss.NewStyle().
+				Border(lipgloss.RoundedBorder()).
+				BorderForeground(focusedColor).
+				Padding(1, 2)
+	helpTitleStyle = lipgloss.NewStyle().Bold(true)
+	helpKeyStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+	helpDescStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+)
+
+// writeClipboard は、text をクリップボードにコピーします。テストでは置き換えます。
+var writeClipboard = clipboard.WriteAll
+
+// ChatRoom は、リストに表示するチャットルームです。
+type ChatRoom struct {
+	history.ChatRoom
+	showProject bool // 全てのプロジェクトのルームを表示しているときに、名前の前にプロジェクトの名前を付けるかどうか
+}
+
+// ref は、メッセージを送信するときにルームを指す roomRef を返します。
+func (c ChatRoom) ref() roomRef {
+	return roomRef{project: c.Project, name: c.Name}
+}
+
+// Title は、フォルダーを前に付けたルームの名前を返します。ピン留めしたルームには印を付けます。
+func (c ChatRoom) Title() string {
+	title := c.Name
+	if c.Folder != "" {
+		title = c.Folder + "/" + title
+	}
+	if c.showProject && c.Project != "" {
+		title = filepath.Base(c.Project) + ": " + title
+	}
+	if c.Pinned {
+		title = "★ " + title
+	}
+	if c.Archived {
+		title += " (archived)"
+	}
+	return title
+}
+
+// Description は、メッセージの数、最後にメッセージを保存した日付、タグを返します。
+func (c ChatRoom) Description() string {
+	unit := "messages"
+	if c.MessageCount == 1 {
+		unit = "message"
+	}
+	desc := fmt.Sprintf("%d %s · %s", c.MessageCount, unit, c.UpdatedAt.Local().Format("Jan 2"))
+	for _, tag := range c.Tags {
+		desc += " #" + tag
+	}
+	return desc
+}
+
+// FilterValue は、ルームの名前、フォルダー、タグ（# を付ける）、プロジェクトの名前で絞り込めるようにします。
+func (c ChatRoom) FilterValue() string {
+	values := []string{c.Name}
+	if c.Project != "" {
+		values = append(values, filepath.Base(c.Project)+":")
+	}
+	if c.Folder != "" {
+		values = append(values, c.Folder+"/")
+	}
+	for _, tag := range c.Tags {
+		values = append(values, "#"+tag)
+	}
+	return strings.Join(values, " ")
+}
+
+// sortRooms は、ピン留めしたルーム、フォルダーごと（名前の順）のルーム、フォルダーのないルームの順に並べます。
+// それぞれの中では、最後にメッセージを保存したのが新しい順に並べます。
+func sortRooms(rooms []history.ChatRoom) {
+	group := func(r history.ChatRoom) (int, string) {
+		switch {
+		case r.Pinned:
+			return 0, ""
+		case r.Folder != "":
+			return 1, r.Folder
+		}
+		return 2, ""
+	}
+	sort.SliceStable(rooms, func(i, j int) bool {
+		gi, fi := group(rooms[i])
+		gj, fj := group(rooms[j])
+		if gi != gj {
+			return gi < gj
+		}
+		if fi != fj {
+			return fi < fj
+		}
+		return rooms[i].UpdatedAt.After(rooms[j].UpdatedAt)
+	})
+}
+
+// pane は、キー入力を受け取る画面の領域です。
+type pane int
+
+const (
+	paneRooms        pane = iota // チャットルームのサイドバー
+	paneConversation             // 会話のビューポート
+	paneComposer                 // メッセージの入力欄
+)
+
+// session は、1つのチャットルームでバックエンドと会話します。*chat.Chat が実装します。
+type session interface {
+	AskStream(input string, onText func(string)) (string, error)
+	Attach(patterns []string) ([]attach.File, []string, error)
+	Pending() []attach.File
+	ClearAttachments()
+	Checkout(head int64) error
+	ParseCandidates(spec string) ([]chat.CandidateOptions, error)
+	Compare(ctx context.Context, prompt string, opts []chat.CandidateOptions) (*chat.Comparison, error)
+	Keep(cmp *chat.Comparison, picked int) error
+	Close()
+}
+
+// roomRef は、メッセージを送信するルームを、プロジェクトと名前で指します。
+// 別のプロジェクトには同じ名前のルームがあるため、名前だけでは区別できません。
+type roomRef struct {
+	project string // プロジェクトのルートのパス（どのプロジェクトにも属さない場合は空）
+	name    string
+}
+
+// openFunc は、room のルームで会話する session を作成します。
+type openFunc func(room roomRef) (session, error)
+
+// streamMsg は、バックエンドから受け取った応答の断片です。
+type streamMsg string
+
+// replyMsg は、応答の受信が終わったことを伝えます。
+type replyMsg struct {
+	room roomRef
+	err  error
+}
+
+// compareMsg は、比較する応答の生成が終わったことを伝えます。
+type compareMsg struct {
+	room roomRef
+	cmp  *chat.Comparison
+	err  error
+}
+
+// comparison は、比較画面で応答を選んでいる、room に送信したプロンプトの応答です。
+type comparison struct {
+	room   roomRef
+	cmp    *chat.Comparison
+	picker compareModel
+}
+
+// pendingReply は、送信してから応答が完了するまでのメッセージです。
+type pendingReply struct {
+	room   roomRef
+	prompt string
+	reply  strings.Builder
+	stream <-chan tea.Msg
+}
+
+type model struct {
+	ready        bool                  // ビューポートが初期化されたかどうか
+	width        int                   // 端末の幅
+	height       int                   // 端末の高さ
+	viewport     viewport.Model        // ビューポートは、スクロール可能なビューを提供します
+	rooms        []history.ChatRoom    // アーカイブしたものを含む全てのチャットルーム（sortRooms の順）
+	showArchived bool                  // アーカイブしたルームもサイドバーに表示するかどうか
+	project      project.Project       // サイドバーに表示するルームのプロジェクト（プロジェクトに属さない場合はゼロ値）
+	allProjects  bool                  // 全てのプロジェクトのルームをサイドバーに表示するかどうか
+	chatRooms    list.Model            // チャットルームのリスト
+	composer     textarea.Model        // メッセージの入力欄
+	showSidebar  bool                  // チャットルームのサイドバーを表示するかどうか
+	focus        pane                  // キー入力を受け取る領域
+	store        *history.Store        // メッセージを読み込むデータベース
+	open         openFunc              // メッセージを送信する session を作成する関数（nil の場合は送信できない）
+	sessions     map[roomRef]session   // ルームごとに作成済みの session
+	selectedRoom *ChatRoom             // 表示中のチャットルーム（未選択の場合は nil）
+	messages     []history.ChatMessage // 表示中のチャットルームのメッセージ
+	branches     map[int64][]int64     // 表示中のチャットルームのメッセージのIDを親のIDごとにまとめたもの
+	pending      *pendingReply         // 応答を待っているメッセージ
+	loadErr      error                 // メッセージを読み込めなかった場合のエラー
+	sendErr      error                 // 最後に送信したメッセージのエラー
+	keys         keyMap                // 操作とキーの対応
+	help         help.Model            // フッターに表示する操作の説明
+	showHelp     bool                  // 全ての操作の一覧を表示しているかどうか
+	keySeq       string                // ノーマルモードで押しかけのキーの並び（"g" など）
+	starts       []int                 // 表示中の各メッセージが始まる行
+	lines        []string              // 会話のエスケープシーケンスを除いた各行
+	current      int                   // ノーマルモードで選択しているメッセージ（メッセージがない場合は -1）
+	search       textinput.Model       // ルーム内を検索する文字列の入力欄
+	searching    bool                  // 検索する文字列を入力しているかどうか
+	attachInput  textinput.Model       // 添付するファイルのパターンの入力欄
+	attaching    bool                  // 添付するファイルのパターンを入力しているかどうか
+	compareInput textinput.Model       // 比較する応答のモデルの入力欄
+	comparing    bool                  // 比較する応答のモデルを入力しているかどうか
+	picking      *comparison           // 比較画面で選んでいる応答（比較していない場合は nil）
+	query        string                // 最後に検索した文字列
+	matches      []int                 // query を含む行
+	match        int                   // 表示している matches の位置
+	status       string                // 次のキー入力まで、フッターの代わりに表示するメッセージ
+}
+
+// newModel は、rooms をサイドバーに一覧表示し、最初のルームの会話を表示するモデルを作成します。
+// ルームを開くと store からメッセージを読み込みます。入力欄のメッセージは open で作成した session に送信します。
+// サイドバーには proj のルームだけを表示し、アーカイブしたルームは表示しません。
+func newModel(store *history.Store, rooms []history.ChatRoom, proj project.Project, open openFunc, keys keyMap) model {
+	chatRooms := list.New(nil, list.NewDefaultDelegate(), 0, 0)
+	chatRooms.SetShowHelp(false)
+	// 終了とヘルプは keys で扱うため、リスト自体のキーは無効にします。
+	chatRooms.DisableQuitKeybindings()
+	chatRooms.KeyMap.ShowFullHelp.SetEnabled(false)
+	chatRooms.KeyMap.CursorUp = keys.Up
+	chatRooms.KeyMap.CursorDown = keys.Down
+	chatRooms.KeyMap.PrevPage = keys.PageUp
+	chatRooms.KeyMap.NextPage = keys.PageDown
+	chatRooms.KeyMap.Filter = keys.Filter
+
+	vp := viewport.New(0, 0)
+	vp.KeyMap.Up = keys.Up
+	vp.KeyMap.Down = keys.Down
+	vp.KeyMap.PageUp = keys.PageUp
+	vp.KeyMap.PageDown = keys.PageDown
+
+	composer := textarea.New()
+	composer.Placeholder = "Send a message..."
+	composer.ShowLineNumbers = false
+	composer.SetHeight(composerHeight)
+	// 送信と改行は keys で扱います。
+	composer.KeyMap.InsertNewline = keys.forMode(modeInsert).Newline
+
+	search := textinput.New()
+	search.Prompt = "/"
+
+	attachInput := textinput.New()
+	attachInput.Prompt = "Attach: "
+	attachInput.Placeholder = "files or globs (empty to clear)"
+
+	compareInput := textinput.New()
+	compareInput.Prompt = "Compare: "
+	compareInput.Placeholder = "number of responses or [backend:]model,... (empty for 2)"
+
+	m := model{
+		viewport:     vp,
+		chatRooms:    chatRooms,
+		composer:     composer,
+		showSidebar:  true,
+		focus:        paneRooms,
+		store:        store,
+		project:      proj,
+		open:         open,
+		sessions:     map[roomRef]session{},
+		keys:         keys,
+		help:         help.New(),
+		search:       search,
+		attachInput:  attachInput,
+		compareInput: compareInput,
+		current:      -1,
+	}
+	m.setRooms(rooms)
+	if room, ok := m.chatRooms.SelectedItem().(ChatRoom); ok {
+		m.openRoom(room)
+	}
+	return m
+}
+
+func (m model) Init() tea.Cmd {
+	return textarea.Blink
+}
+
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var (
+		cmd  tea.Cmd
+		cmds []tea.Cmd
+	)
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if m.showHelp {
+			switch {
+			case key.Matches(msg, m.keys.Help), key.Matches(msg, m.keys.Back):
+				m.showHelp = false
+			case key.Matches(msg, m.keys.Quit):
+				return m, tea.Quit
+			}
+			return m, nil
+		}
+		m.status = ""
+		if m.picking != nil {
+			return m, m.updatePicker(msg)
+		}
+		if m.searching {
+			if key.Matches(msg, m.keys.forMode(modeInsert).Quit) {
+				return m, tea.Quit
+			}
+			return m, m.updateSearch(msg)
+		}
+		if m.attaching {
+			if key.Matches(msg, m.keys.forMode(modeInsert).Quit) {
+				return m, tea.Quit
+			}
+			return m, m.updateAttach(msg)
+		}
+		if m.comparing {
+			if key.Matches(msg, m.keys.forMode(modeInsert).Quit) {
+				return m, tea.Quit
+			}
+			return m, m.updateCompare(msg)
+		}
+		// フィルターの入力中は、全てのキーをリストに渡します。
+		if m.focus == paneRooms && m.chatRooms.FilterState() == list.Filtering {
+			break
+		}
+
+		md := m.mode()
+		keys := m.keys.forMode(md)
+		pressed := msg.String()
+		if md == modeNormal {
+			if m.keySeq != "" {
+				pressed = m.keySeq + " " + pressed
+			}
+			m.keySeq = ""
+			if keys.hasPrefix(pressed) {
+				m.keySeq = pressed
+				return m, nil
+			}
+			// 割り当てのないキーの並びは、vim と同じように捨てます。
+			if isSequence(pressed) {
+				cmd, _ := m.normalKey(pressed, keys)
+				return m, cmd
+			}
+		}
+		switch {
+		case key.Matches(msg, keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, keys.Help):
+			m.showHelp = true
+			return m, nil
+		case key.Matches(msg, keys.NextPane):
+			return m, m.setFocus(m.nextPane(1))
+		case key.Matches(msg, keys.PrevPane):
+			return m, m.setFocus(m.nextPane(-1))
+		case key.Matches(msg, keys.ToggleSidebar):
+			m.showSidebar = !m.showSidebar
+			if !m.showSidebar && m.focus == paneRooms {
+				cmd = m.setFocus(paneConversation)
+			}
+			m.layout()
+			return m, cmd
+		}
+
+		switch md {
+		case modeList:
+			room, ok := m.chatRooms.SelectedItem().(ChatRoom)
+			switch {
+			case key.Matches(msg, keys.ToggleArchived):
+				m.showArchived = !m.showArchived
+				return m, m.setRooms(m.rooms)
+			case key.Matches(msg, keys.ToggleProjects):
+				m.allProjects = !m.allProjects
+				return m, m.setRooms(m.rooms)
+			case !ok:
+			case key.Matches(msg, keys.OpenRoom):
+				m.openRoom(room)
+				return m, m.setFocus(paneComposer)
+			case key.Matches(msg, keys.PinRoom):
+				done := "Pinned " + room.Name
+				if room.Pinned {
+					done = "Unpinned " + room.Name
+				}
+				return m, m.updateRoom(m.store.SetRoomPinned(room.ID, !room.Pinned), done)
+			case key.Matches(msg, keys.ArchiveRoom):
+				done := "Archived " + room.Name
+				if room.Archived {
+					done = "Unarchived " + room.Name
+				}
+				return m, m.updateRoom(m.store.SetRoomArchived(room.ID, !room.Archived), done)
+			}
+		case modeNormal:
+			if cmd, ok := m.normalKey(pressed, keys); ok {
+				return m, cmd
+			}
+		case modeInsert:
+			switch {
+			case key.Matches(msg, keys.Back):
+				return m, m.setFocus(paneConversation)
+			case key.Matches(msg, keys.Send):
+				return m, m.send()
+			case key.Matches(msg, keys.Attach):
+				return m, m.startAttach()
+			case key.Matches(msg, keys.Compare):
+				return m, m.startCompare()
+			}
+		}
+
+	case tea.MouseMsg:
+		if m.picking != nil {
+			return m, nil
+		}
+		return m, m.handleMouse(msg)
+
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.layout()
+		if m.picking != nil {
+			m.picking.picker = m.picking.picker.Update(msg)
+		}
+
+		if !m.ready {
+			// このプログラムはビューポートの全サイズを使用しているため、
+			// ビューポートを初期化する前にウィンドウの寸法を受け取る必要があります。
+			// 初期寸法は非同期ですが素早く到着するため、ここで待機しています。
+			m.ready = true
+			// 開いているルームは幅が分かるまで描画していないため、ここで最新のメッセージまでスクロールします。
+			m.refresh(true)
+		}
+
+		if useHighPerformanceRenderer {
+			// ビューポート全体をレンダリング（または再レンダリング）します。
+			// ビューポートの初期化時とウィンドウのサイズ変更時の両方で必要です。
+			//
+			// これは高性能レンダリングにのみ必要です。
+			cmds = append(cmds, viewport.Sync(m.viewport))
+		}
+		return m, tea.Batch(cmds...)
+
+	case streamMsg:
+		if m.pending == nil {
+			return m, nil
+		}
+		m.pending.reply.WriteString(string(msg))
+		m.refresh(true)
+		return m, waitStream(m.pending.stream)
+
+	case replyMsg:
+		m.pending = nil
+		m.sendErr = msg.err
+		return m, m.showReply(msg.room)
+
+	case compareMsg:
+		m.pending = nil
+		if msg.err == nil {
+			msg.err = msg.cmp.Err()
+		}
+		if msg.err != nil {
+			m.sendErr = msg.err
+			m.refresh(true)
+			return m, nil
+		}
+		picker := newCompareModel(msg.cmp.Candidates).Update(tea.WindowSizeMsg{Width: m.width, Height: m.height})
+		m.picking = &comparison{room: msg.room, cmp: msg.cmp, picker: picker}
+		return m, nil
+	}
+
+	switch {
+	case m.searching:
+		m.search, cmd = m.search.Update(msg)
+	case m.attaching:
+		m.attachInput, cmd = m.attachInput.Update(msg)
+	case m.comparing:
+		m.compareInput, cmd = m.compareInput.Update(msg)
+	case m.focus == paneRooms:
+		m.chatRooms, cmd = m.chatRooms.Update(msg)
+	case m.focus == paneConversation:
+		m.viewport, cmd = m.viewport.Update(msg)
+		m.syncCurrent()
+	case m.focus == paneComposer:
+		m.composer, cmd = m.composer.Update(msg)
+	}
+	cmds = append(cmds, cmd)
+
+	return m, tea.Batch(cmds...)
+}
+
+// normalKey は、ノーマルモードで押されたキー（またはキーの並び）の pressed の操作を実行します。
+// pressed に操作が割り当てられていない場合は false を返します。
+func (m *model) normalKey(pressed string, keys keyMap) (tea.Cmd, bool) {
+	switch {
+	case matches(pressed, keys.Back) && m.showSidebar:
+		return m.setFocus(paneRooms), true
+	case matches(pressed, keys.Insert):
+		return m.setFocus(paneComposer), true
+	case matches(pressed, keys.Search):
+		m.searching = true
+		m.search.Reset()
+		return m.search.Focus(), true
+	case matches(pressed, keys.Attach):
+		return m.startAttach(), true
+	case matches(pressed, keys.Top):
+		m.jumpMessage(0)
+	case matches(pressed, keys.Bottom):
+		m.viewport.GotoBottom()
+		m.current = len(m.starts) - 1
+	case matches(pressed, keys.PrevMessage):
+		// 選択しているメッセージの途中を表示している場合は、その先頭に戻ります。
+		if m.current >= 0 && m.starts[m.current] < m.viewport.YOffset {
+			m.jumpMessage(m.current)
+		} else {
+			m.jumpMessage(m.current - 1)
+		}
+	case matches(pressed, keys.NextMessage):
+		m.jumpMessage(m.current + 1)
+	case matches(pressed, keys.NextMatch):
+		m.jumpMatch(1)
+	case matches(pressed, keys.PrevMatch):
+		m.jumpMatch(-1)
+	case matches(pressed, keys.Yank):
+		m.yank()
+	case matches(pressed, keys.PrevBranch):
+		m.switchBranch(-1)
+	case matches(pressed, keys.NextBranch):
+		m.switchBranch(1)
+	default:
+		return nil, false
+	}
+	return nil, true
+}
+
+// jumpMessage は、i 番目のメッセージを選択し、その先頭までスクロールします。範囲外の場合は何もしません。
+func (m *model) jumpMessage(i int) {
+	if i < 0 || i >= len(m.starts) {
+		return
+	}
+	m.current = i
+	m.viewport.SetYOffset(m.starts[i])
+}
+
+// syncCurrent は、スクロールした位置に合わせて選択しているメッセージを更新します。
+// 末尾までスクロールしている場合は最後のメッセージを、それ以外は先頭の行を含むメッセージを選択します。
+func (m *model) syncCurrent() {
+	if m.viewport.AtBottom() {
+		m.current = len(m.starts) - 1
+		return
+	}
+	m.current = m.messageAt(m.viewport.YOffset)
+}
+
+// messageAt は、会話の line 行目を含むメッセージの位置を返します。メッセージより前の行の場合は -1 を返します。
+func (m model) messageAt(line int) int {
+	i := len(m.starts) - 1
+	for i >= 0 && m.starts[i] > line {
+		i--
+	}
+	return i
+}
+
+// updateSearch は、検索する文字列の入力中のキーを処理します。
+// Enter で表示している位置以降の最初の一致に移動し、Esc で検索をやめます。
+// 空のまま Enter を押した場合は、前回の文字列で検索し直します。
+func (m *model) updateSearch(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.searching = false
+		m.search.Blur()
+		return nil
+	case tea.KeyEnter:
+		m.searching = false
+		m.search.Blur()
+		if q := m.search.Value(); q != "" {
+			m.query = q
+		}
+		if m.query == "" {
+			return nil
+		}
+		m.findMatches()
+		m.match = 0
+		for i, line := range m.matches {
+			if line >= m.viewport.YOffset {
+				m.match = i
+				break
+			}
+		}
+		m.showMatch()
+		return nil
+	}
+	var cmd tea.Cmd
+	m.search, cmd = m.search.Update(msg)
+	return cmd
+}
+
+// startAttach は、次のメッセージに添付するファイルのパターンの入力を始めます。
+// 応答を待っている間は、送信中のメッセージと混ざらないように添付できません。
+func (m *model) startAttach() tea.Cmd {
+	if m.pending != nil {
+		m.status = errorStyle.Render("Wait for the reply before attaching files")
+		return nil
+	}
+	m.attaching = true
+	m.attachInput.Reset()
+	return m.attachInput.Focus()
+}
+
+// updateAttach は、添付するファイルのパターンの入力中のキーを処理します。
+// Enter で空白で区切ったパターンに一致するファイルを表示中のルームの次のメッセージに添付し、Esc で入力をやめます。
+// 空のまま Enter を押した場合は、添付する予定のファイルを全て取り消します。
+func (m *model) updateAttach(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.attaching = false
+		m.attachInput.Blur()
+		return nil
+	case tea.KeyEnter:
+		m.attaching = false
+		m.attachInput.Blur()
+		m.attach(strings.Fields(m.attachInput.Value()))
+		return nil
+	}
+	var cmd tea.Cmd
+	m.attachInput, cmd = m.attachInput.Update(msg)
+	return cmd
+}
+
+// attach は、patterns に一致するファイルを表示中のルームの次のメッセージに添付し、結果をフッターに表示します。
+// patterns が空の場合は、添付する予定のファイルを全て取り消します。
+func (m *model) attach(patterns []string) {
+	s, err := m.session(m.currentRoom())
+	if err != nil {
+		m.status = errorStyle.Render(fmt.Sprintf("Failed to attach files: %v", err))
+		return
+	}
+	if len(patterns) == 0 {
+		s.ClearAttachments()
+		m.status = "Cleared pending attachments"
+		return
+	}
+	files, skipped, err := s.Attach(patterns)
+	switch {
+	case err != nil:
+		m.status = errorStyle.Render(fmt.Sprintf("Failed to attach files: %v", err))
+	case len(skipped) > 0:
+		m.status = fmt.Sprintf("Attached %d file(s), skipped %s", len(files), strings.Join(skipped, ", "))
+	default:
+		m.status = fmt.Sprintf("Attached %d file(s) to the next message", len(files))
+	}
+}
+
+// startCompare は、入力欄のメッセージに対する応答を比較するモデルの入力を始めます。
+func (m *model) startCompare() tea.Cmd {
+	if m.pending != nil {
+		m.status = errorStyle.Render("Wait for the reply before comparing responses")
+		return nil
+	}
+	if strings.TrimSpace(m.composer.Value()) == "" {
+		m.status = errorStyle.Render("Write a message to compare responses to")
+		return nil
+	}
+	m.comparing = true
+	m.compareInput.Reset()
+	return m.compareInput.Focus()
+}
+
+// updateCompare は、比較する応答のモデルの入力中のキーを処理します。
+// Enter で入力欄のメッセージを表示中のルームに送信して応答を比較し、Esc で入力をやめます。
+func (m *model) updateCompare(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.comparing = false
+		m.compareInput.Blur()
+		return nil
+	case tea.KeyEnter:
+		m.comparing = false
+		m.compareInput.Blur()
+		return m.compare(strings.TrimSpace(m.compareInput.Value()))
+	}
+	var cmd tea.Cmd
+	m.compareInput, cmd = m.compareInput.Update(msg)
+	return cmd
+}
+
+// compare は、入力欄のメッセージを表示中のルームの spec のモデルに同時に送信し、応答を受け取るコマンドを返します。
+// spec が空の場合は、ルームのモデルで2つの応答を生成します。応答は履歴に保存せず、比較画面で選んだものだけを残します。
+func (m *model) compare(spec string) tea.Cmd {
+	if spec == "" {
+		spec = "2"
+	}
+	input := strings.TrimSpace(m.composer.Value())
+	room := m.currentRoom()
+
+	s, err := m.session(room)
+	var opts []chat.CandidateOptions
+	if err == nil {
+		opts, err = s.ParseCandidates(spec)
+	}
+	if err != nil {
+		m.status = errorStyle.Render(fmt.Sprintf("Failed to compare responses: %v", err))
+		return nil
+	}
+	m.composer.Reset()
+	m.sendErr = nil
+
+	stream := make(chan tea.Msg)
+	go func() {
+		cmp, err := s.Compare(context.Background(), input, opts)
+		stream <- compareMsg{room: room, cmp: cmp, err: err}
+	}()
+	m.pending = &pendingReply{room: room, prompt: input, stream: stream}
+	fmt.Fprintf(&m.pending.reply, "Generating %d responses", len(opts))
+	m.refresh(true)
+	return waitStream(stream)
+}
+
+// updatePicker は、比較画面のキーを処理します。
+// 応答を選ぶと、プロンプトと全ての応答を別の枝として保存し、選んだ応答の枝を表示します。
+// 全て取り消した場合は、プロンプトを入力欄に戻します。
+func (m *model) updatePicker(msg tea.KeyMsg) tea.Cmd {
+	p := m.picking
+	p.picker = p.picker.Update(msg)
+	if !p.picker.done {
+		return nil
+	}
+	m.picking = nil
+	if p.picker.picked < 0 {
+		m.composer.SetValue(p.cmp.Prompt)
+		m.status = "Discarded all responses"
+		return nil
+	}
+
+	s, err := m.session(p.room)
+	if err == nil {
+		err = s.Keep(p.cmp, p.picker.picked)
+	}
+	if err != nil {
+		m.status = errorStyle.Render(fmt.Sprintf("Failed to save message: %v", err))
+	}
+	return m.showReply(p.room)
+}
+
+// pendingFiles は、表示中のルームで次のメッセージに添付する予定のファイルを返します。
+func (m model) pendingFiles() []attach.File {
+	// 応答を待っている間は、session が添付するファイルを送信に使用しています。
+	if m.pending != nil {
+		return nil
+	}
+	if s, ok := m.sessions[m.currentRoom()]; ok {
+		return s.Pending()
+	}
+	return nil
+}
+
+// findMatches は、会話の中で query を含む行を探します。大文字と小文字は区別しません。
+func (m *model) findMatches() {
+	m.matches = nil
+	if m.query == "" {
+		return
+	}
+	q := strings.ToLower(m.query)
+	for i, line := range m.lines {
+		if strings.Contains(strings.ToLower(line), q) {
+			m.matches = append(m.matches, i)
+		}
+	}
+	if m.match >= len(m.matches) {
+		m.match = 0
+	}
+}
+
+// jumpMatch は、step だけ先（負の場合は前）の一致に移動します。末尾と先頭はつながっています。
+func (m *model) jumpMatch(step int) {
+	if m.query == "" {
+		return
+	}
+	if n := len(m.matches); n > 0 {
+		m.match = (m.match + step%n + n) % n
+	}
+	m.showMatch()
+}
+
+// showMatch は、表示している一致の行までスクロールし、何番目の一致かをフッターに表示します。
+func (m *model) showMatch() {
+	if len(m.matches) == 0 {
+		m.status = errorStyle.Render("Pattern not found: " + m.query)
+		return
+	}
+	line := m.matches[m.match]
+	m.viewport.SetYOffset(line)
+	m.current = m.messageAt(line)
+	m.status = fmt.Sprintf("/%s [%d/%d]", m.query, m.match+1, len(m.matches))
+}
+
+// yank は、選択しているメッセージの本文をクリップボードにコピーします。
+func (m *model) yank() {
+	shown := m.shownMessages()
+	if m.current < 0 || m.current >= len(shown) {
+		return
+	}
+	if err := writeClipboard(shown[m.current].Content); err != nil {
+		m.status = errorStyle.Render(fmt.Sprintf("Failed to copy message: %v", err))
+		return
+	}
+	m.status = fmt.Sprintf("Copied message %d/%d", m.current+1, len(shown))
+}
+
+// mode は、フォーカスを持つ領域に応じたキー入力の状態を返します。
+func (m model) mode() mode {
+	switch m.focus {
+	case paneRooms:
+		return modeList
+	case paneComposer:
+		return modeInsert
+	}
+	return modeNormal
+}
+
+// nextPane は、現在の領域から step だけ移動した領域を返します。非表示のサイドバーは飛ばします。
+func (m model) nextPane(step int) pane {
+	const panes = 3
+	next := m.focus
+	for {
+		next = (next + pane(step) + panes) % panes
+		if next != paneRooms || m.showSidebar {
+			return next
+		}
+	}
+}
+
+// setFocus は、キー入力を受け取る領域を p に切り替えます。
+func (m *model) setFocus(p pane) tea.Cmd {
+	m.focus = p
+	if p == paneComposer {
+		return m.composer.Focus()
+	}
+	m.composer.Blur()
+	return nil
+}
+
+// sidebarWidth は、サイドバーの幅（境界線を含む）を返します。非表示の場合は 0 を返します。
+func (m model) sidebarWidth() int {
+	if !m.showSidebar {
+		return 0
+	}
+	return min(maxSidebarWidth, m.width/3)
+}
+
+// layout は、端末の大きさに合わせて全ての領域の大きさを計算し直します。
+func (m *model) layout() {
+	m.help.Width = m.width
+	height := m.paneHeight()
+
+	sidebarWidth := m.sidebarWidth()
+	if sidebarWidth > 0 {
+		m.chatRooms.SetSize(sidebarWidth-sidebarStyle.GetHorizontalFrameSize(), height)
+	}
+
+	mainWidth := m.width - sidebarWidth
+	m.composer.SetWidth(mainWidth)
+
+	headerHeight := lipgloss.Height(m.headerView())
+	footerHeight := lipgloss.Height(m.footerView())
+	composerFrame := composerStyle.GetVerticalFrameSize() + composerHeight
+	m.viewport.Width = mainWidth
+	m.viewport.Height = max(0, height-headerHeight-footerHeight-composerFrame)
+	m.viewport.HighPerformanceRendering = useHighPerformanceRenderer
+	// これは高性能レンダリングにのみ必要で、
+	// ほとんどの場合は必要ありません。
+	//
+	// ビューポートをヘッダーの1行下にレンダリングします。
+	m.viewport.YPosition = headerHeight + 1
+
+	// メッセージは幅に合わせて折り返すため、描画し直します。
+	m.refresh(false)
+}
+
+// paneHeight は、フッターの操作の説明を除いた、各領域の高さを返します。
+func (m model) paneHeight() int {
+	return max(0, m.height-1)
+}
+
+// handleMouse は、クリックした領域に移動し、サイドバーのルームをクリックした場合はそのルームを開きます。
+// ホイールによるスクロールは、ポインターの下の領域に渡します。
+func (m *model) handleMouse(msg tea.MouseMsg) tea.Cmd {
+	inSidebar := msg.X < m.sidebarWidth()
+	if msg.Action != tea.MouseActionPress || msg.Button != tea.MouseButtonLeft {
+		var cmd tea.Cmd
+		if !inSidebar {
+			m.viewport, cmd = m.viewport.Update(msg)
+			m.syncCurrent()
+		}
+		return cmd
+	}
+
+	switch {
+	case inSidebar:
+		cmd := m.setFocus(paneRooms)
+		if i, ok := m.roomAt(msg.Y); ok {
+			m.chatRooms.Select(i)
+			m.openRoom(m.chatRooms.VisibleItems()[i].(ChatRoom))
+		}
+		return cmd
+	case msg.Y >= m.paneHeight():
+		return nil
+	case msg.Y >= m.paneHeight()-composerStyle.GetVerticalFrameSize()-composerHeight:
+		return m.setFocus(paneComposer)
+	default:
+		return m.setFocus(paneConversation)
+	}
+}
+
+// roomAt は、サイドバーの y 行目に表示しているルームの位置を返します。
+func (m model) roomAt(y int) (int, bool) {
+	if m.chatRooms.FilterState() == list.Filtering {
+		return 0, false
+	}
+	styles := m.chatRooms.Styles
+	top := lipgloss.Height(styles.TitleBar.Render(" ")) + lipgloss.Height(styles.StatusBar.Render(" "))
+	delegate := list.NewDefaultDelegate()
+	row := delegate.Height() + delegate.Spacing()
+	if y < top || (y-top)%row >= delegate.Height() {
+		return 0, false
+	}
+	p := m.chatRooms.Paginator
+	i := p.Page*p.PerPage + (y-top)/row
+	if i >= len(m.chatRooms.VisibleItems()) || (y-top)/row >= p.PerPage {
+		return 0, false
+	}
+	return i, true
+}
+
+// openRoom は、room のメッセージを読み込んで表示します。
+func (m *model) openRoom(room ChatRoom) {
+	m.selectedRoom = &room
+	m.sendErr = nil
+	m.loadMessages()
+	m.refresh(false)
+	m.viewport.GotoBottom()
+}
+
+// loadMessages は、表示中のチャットルームのメッセージを読み込み直します。
+func (m *model) loadMessages() {
+	m.messages, m.branches, m.loadErr = nil, nil, nil
+	h, err := m.store.Load(m.selectedRoom.ID)
+	if err == nil {
+		m.branches, err = m.store.Branches(m.selectedRoom.ID)
+	}
+	if err != nil {
+		m.loadErr = err
+		return
+	}
+	m.messages = h.Messages
+}
+
+// branchOf は、i 番目のメッセージが兄弟の枝の何番目か（1 から数える）と、兄弟の枝の数を返します。
+func (m model) branchOf(i int) (n, total int) {
+	msg := m.messages[i]
+	siblings := m.branches[msg.ParentID]
+	for j, id := range siblings {
+		if id == msg.ID {
+			return j + 1, len(siblings)
+		}
+	}
+	return 1, 1
+}
+
+// switchBranch は、選択しているメッセージを step だけ先（負の場合は前）の兄弟の枝に切り替え、その枝の最新のやり取りを表示します。
+// 表示中のルームの session があれば、次のメッセージも切り替えた枝に続けて送信します。
+func (m *model) switchBranch(step int) {
+	if m.pending != nil {
+		m.status = errorStyle.Render("Wait for the reply before switching branches")
+		return
+	}
+	if m.selectedRoom == nil || m.current < 0 || m.current >= len(m.messages) {
+		return
+	}
+	n, total := m.branchOf(m.current)
+	if total < 2 {
+		m.status = "This message has no other branches"
+		return
+	}
+	if n+step < 1 || n+step > total {
+		m.status = fmt.Sprintf("Already on branch %d/%d", n, total)
+		return
+	}
+
+	target := m.branches[m.messages[m.current].ParentID][n+step-1]
+	head, err := m.store.Leaf(target)
+	if err == nil {
+		if s, ok := m.sessions[m.selectedRoom.ref()]; ok {
+			err = s.Checkout(head)
+		} else {
+			err = m.store.SetHead(m.selectedRoom.ID, head)
+		}
+	}
+	if err != nil {
+		m.status = errorStyle.Render(fmt.Sprintf("Failed to switch branch: %v", err))
+		return
+	}
+
+	current := m.current
+	m.loadMessages()
+	m.refresh(false)
+	m.jumpMessage(min(current, len(m.starts)-1))
+	m.status = fmt.Sprintf("Branch %d/%d", n+step, total)
+}
+
+// setRooms は、rooms を並べ替えてサイドバーに表示します。
+// allProjects でない場合は表示中のプロジェクトのルームだけを、showArchived でない場合はアーカイブしていないルームだけを表示します。
+// カーソルは、並べ替える前と同じルームに置きます。
+func (m *model) setRooms(rooms []history.ChatRoom) tea.Cmd {
+	sortRooms(rooms)
+	m.rooms = rooms
+	prev, hadPrev := m.chatRooms.SelectedItem().(ChatRoom)
+
+	m.chatRooms.Title = "Chat Rooms"
+	switch {
+	case m.allProjects:
+		m.chatRooms.Title = "All Rooms"
+	case m.project.Root != "":
+		m.chatRooms.Title = "Rooms: " + m.project.Name()
+	}
+
+	items := make([]list.Item, 0, len(rooms))
+	cursor := 0
+	for _, room := range rooms {
+		if (room.Archived && !m.showArchived) || (room.Project != m.project.Root && !m.allProjects) {
+			continue
+		}
+		if hadPrev && room.ID == prev.ID {
+			cursor = len(items)
+		}
+		items = append(items, ChatRoom{ChatRoom: room, showProject: m.allProjects})
+	}
+	cmd := m.chatRooms.SetItems(items)
+	if m.chatRooms.FilterState() == list.Unfiltered {
+		m.chatRooms.Select(cursor)
+	}
+	return cmd
+}
+
+// reloadRooms は、データベースからルームを読み込み直してサイドバーに表示します。
+func (m *model) reloadRooms() tea.Cmd {
+	rooms, err := m.store.ChatRooms()
+	if err != nil {
+		m.loadErr = err
+		return nil
+	}
+	return m.setRooms(rooms)
+}
+
+// updateRoom は、ルームの設定を保存した結果の err を表示し、成功した場合はルームを読み込み直します。
+func (m *model) updateRoom(err error, done string) tea.Cmd {
+	if err != nil {
+		m.status = errorStyle.Render(fmt.Sprintf("Failed to update room: %v", err))
+		return nil
+	}
+	m.status = done
+	return m.reloadRooms()
+}
+
+// send は、入力欄のメッセージを表示中のルームに送信し、応答を受け取るコマンドを返します。
+// ルームを選択していない場合は、表示中のプロジェクトの defaultRoom に送信します。
+func (m *model) send() tea.Cmd {
+	input := strings.TrimSpace(m.composer.Value())
+	if input == "" || m.pending != nil {
+		return nil
+	}
+	room := m.currentRoom()
+
+	s, err := m.session(room)
+	if err != nil {
+		m.sendErr = err
+		m.refresh(true)
+		return nil
+	}
+	m.composer.Reset()
+	m.sendErr = nil
+
+	stream := make(chan tea.Msg)
+	go func() {
+		_, err := s.AskStream(input, func(text string) { stream <- streamMsg(text) })
+		stream <- replyMsg{room: room, err: err}
+	}()
+	m.pending = &pendingReply{room: room, prompt: input, stream: stream}
+	m.refresh(true)
+	return waitStream(stream)
+}
+
+// showReply は、room に保存した応答を表示します。
+// 送信で作成されたルームを加え、最後にメッセージを保存した日時の順に並べ直します。
+func (m *model) showReply(room roomRef) tea.Cmd {
+	cmd := m.reloadRooms()
+	if m.selectedRoom == nil {
+		for _, r := range m.rooms {
+			if (ChatRoom{ChatRoom: r}).ref() == room {
+				m.selectedRoom = &ChatRoom{ChatRoom: r}
+				break
+			}
+		}
+	}
+	if m.selectedRoom != nil && m.selectedRoom.ref() == room {
+		m.loadMessages()
+	}
+	m.refresh(true)
+	return cmd
+}
+
+// currentRoom は、表示中のルームを返します。ルームを選択していない場合は、表示中のプロジェクトの defaultRoom です。
+func (m model) currentRoom() roomRef {
+	if m.selectedRoom != nil {
+		return m.selectedRoom.ref()
+	}
+	return roomRef{project: m.project.Root, name: defaultRoom}
+}
+
+// waitStream は、stream から次のメッセージを受け取るコマンドを返します。
+func waitStream(stream <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-stream
+	}
+}
+
+// session は、room で会話する session を返します。初めて使用するルームでは作成します。
+func (m *model) session(room roomRef) (session, error) {
+	if s, ok := m.sessions[room]; ok {
+		return s, nil
+	}
+	if m.open == nil {
+		return nil, fmt.Errorf("sending messages is not available")
+	}
+	s, err := m.open(room)
+	if err != nil {
+		return nil, err
+	}
+	m.sessions[room] = s
+	return s, nil
+}
+
+// closeSessions は、作成した全ての session を終了します。
+func (m model) closeSessions() {
+	for _, s := range m.sessions {
+		s.Close()
+	}
+}
+
+// refresh は、会話を描画し直します。bottom が true の場合は末尾までスクロールします。
+func (m *model) refresh(bottom bool) {
+	// 端末の大きさを受け取るまでは幅が分からないため、描画しません。
+	if m.viewport.Width == 0 {
+		return
+	}
+	content, starts := m.messagesView()
+	m.starts = starts
+	m.lines = strings.Split(ansi.Strip(content), "\n")
+	m.viewport.SetContent(content)
+	if bottom {
+		m.viewport.GotoBottom()
+	}
+	m.syncCurrent()
+	m.findMatches()
+}
+
+// shownMessages は、表示中のチャットルームのメッセージを返します。
+// 応答を待っているメッセージとその応答（受信済みの部分）も、時刻のないメッセージとして含めます。
+func (m model) shownMessages() []history.ChatMessage {
+	if m.loadErr != nil {
+		return nil
+	}
+	shown := m.messages
+	if p := m.pending; p != nil && (m.selectedRoom == nil || p.room == m.selectedRoom.ref()) {
+		shown = append(shown[:len(shown):len(shown)],
+			history.ChatMessage{Role: "user", Content: p.prompt},
+			history.ChatMessage{Role: "assistant", Content: p.reply.String()},
+		)
+	}
+	return shown
+}
+
+// messagesView は、表示中のチャットルームのメッセージをビューポートの幅で描画し、各メッセージが始まる行と共に返します。
+func (m model) messagesView() (string, []int) {
+	if m.loadErr != nil {
+		return errorStyle.Render(fmt.Sprintf("Failed to load messages: %v", m.loadErr)), nil
+	}
+
+	var (
+		b      strings.Builder
+		starts []int
+		lines  int
+	)
+	width := max(1, m.viewport.Width)
+	for i, msg := range m.shownMessages() {
+		var s strings.Builder
+		if msg.Time.IsZero() {
+			content := msg.Content
+			if msg.Role != "user" {
+				content += "…"
+			}
+			writeMessage(&s, msg.Role, "", content, width)
+		} else {
+			info := msg.Time.Local().Format("2006-01-02 15:04")
+			// 兄弟の枝があるメッセージには、何番目の枝かを表示します。
+			if n, total := m.branchOf(i); total > 1 {
+				info += branchStyle.Render(fmt.Sprintf("  ‹%d/%d›", n, total))
+			}
+			writeMessage(&s, msg.Role, info, msg.Content, width)
+		}
+		starts = append(starts, lines)
+		lines += strings.Count(s.String(), "\n")
+		b.WriteString(s.String())
+	}
+	if m.sendErr != nil {
+		b.WriteString(errorStyle.Render(fmt.Sprintf("Failed to send message: %v", m.sendErr)))
+	}
+	if b.Len() == 0 {
+		return "No messages yet.", nil
+	}
+	return b.String(), starts
+}
+
+// writeMessage は、1つのメッセージを見出しと Markdown の本文で描画します。
+func writeMessage(w io.Writer, role, time, content string, width int) {
+	label := assistantLabelStyle.Render("Gemini")
+	if role == "user" {
+		label = userLabelStyle.Render("You")
+	}
+	fmt.Fprintf(w, "%s  %s\n", label, time)
+	io.WriteString(w, render.RenderMarkdownWidth(content, width))
+}
+
+func (m model) View() string {
+	if !m.ready {
+		return "\n  初期化中..."
+	}
+	if m.showHelp {
+		return m.helpView()
+	}
+	if m.picking != nil {
+		return m.picking.picker.View()
+	}
+
+	main := lipgloss.JoinVertical(lipgloss.Left,
+		m.headerView(),
+		m.viewport.View(),
+		m.footerView(),
+		m.paneStyle(composerStyle, paneComposer).Render(m.composer.View()),
+	)
+	if m.showSidebar {
+		height := m.paneHeight()
+		sidebar := m.paneStyle(sidebarStyle, paneRooms).
+			Width(m.sidebarWidth() - sidebarStyle.GetHorizontalFrameSize()).
+			Height(height).
+			MaxHeight(height).
+			Render(m.chatRooms.View())
+		main = lipgloss.JoinHorizontal(lipgloss.Top, sidebar, main)
+	}
+	return main + "\n" + m.statusView()
+}
+
+// statusView は、画面の最下行に、検索する文字列や添付するファイル、比較するモデルの入力欄、メッセージ、主な操作の説明のいずれかを表示します。
+func (m model) statusView() string {
+	switch {
+	case m.searching:
+		return m.search.View()
+	case m.attaching:
+		return m.attachInput.View()
+	case m.comparing:
+		return m.compareInput.View()
+	case m.status != "":
+		return m.status
+	}
+	return m.help.ShortHelpView(m.keys.shortHelp(m.mode()))
+}
+
+// helpView は、全ての操作とキーの一覧を画面の中央に表示します。
+// 端末の幅が足りる場合は、状態ごとの一覧を2列に並べます。
+func (m model) helpView() string {
+	var blocks []string
+	for _, section := range m.keys.fullHelp() {
+		if block := helpSectionView(section); block != "" {
+			blocks = append(blocks, block)
+		}
+	}
+
+	body := lipgloss.JoinVertical(lipgloss.Left, blocks...)
+	if len(blocks) > 1 {
+		half := (len(blocks) + 1) / 2
+		left := lipgloss.JoinVertical(lipgloss.Left, blocks[:half]...)
+		right := lipgloss.JoinVertical(lipgloss.Left, blocks[half:]...)
+		columns := lipgloss.JoinHorizontal(lipgloss.Top, left, "    ", right)
+		if lipgloss.Width(columns)+helpOverlayStyle.GetHorizontalFrameSize() <= m.width {
+			body = columns
+		}
+	}
+	box := helpOverlayStyle.Render(lipgloss.JoinVertical(lipgloss.Left,
+		helpTitleStyle.Render("Key bindings"),
+		"",
+		body,
+		helpDescStyle.Render("In insert mode, single-character keys are typed as text."),
+	))
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, box)
+}
+
+// helpSectionView は、section の見出しと有効な操作の一覧を描画します。有効な操作がない場合は空文字列を返します。
+func helpSectionView(section helpSection) string {
+	var bindings []key.Binding
+	keyWidth := 0
+	for _, binding := range section.bindings {
+		if binding.Enabled() {
+			bindings = append(bindings, binding)
+			keyWidth = max(keyWidth, lipgloss.Width(binding.Help().Key))
+		}
+	}
+	if len(bindings) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(helpTitleStyle.Render(section.title))
+	for _, binding := range bindings {
+		h := binding.Help()
+		fmt.Fprintf(&b, "\n  %s  %s", helpKeyStyle.Render(fmt.Sprintf("%-*s", keyWidth, h.Key)), helpDescStyle.Render(h.Desc))
+	}
+	return b.String() + "\n"
+}
+
+// paneStyle は、p がフォーカスを持っている場合は境界線を強調した style を返します。
+func (m model) paneStyle(style lipgloss.Style, p pane) lipgloss.Style {
+	if m.focus == p {
+		return style.BorderForeground(focusedColor)
+	}
+	return style
+}
+
+func (m model) headerView() string {
+	name := ""
+	if m.selectedRoom != nil {
+		name = m.selectedRoom.Name
+	}
+	title := titleStyle.Render("Chat Room: " + name)
+	line := strings.Repeat("─", max(0, m.viewport.Width-lipgloss.Width(title)))
+	return lipgloss.JoinHorizontal(lipgloss.Center, title, line)
+}
+
+// footerView は、キー入力の状態、添付する予定のファイルの数、選択しているメッセージの位置、スクロールした割合を表示します。
+func (m model) footerView() string {
+	info := fmt.Sprintf("%3.f%%", m.viewport.ScrollPercent()*100)
+	if n := len(m.starts); n > 0 {
+		info = fmt.Sprintf("%d/%d  %s", m.current+1, n, info)
+	}
+	if n := len(m.pendingFiles()); n > 0 {
+		info = fmt.Sprintf("%d attached  %s", n, info)
+	}
+	info = infoStyle.Render(strings.ToUpper(string(m.mode())) + "  " + info)
+	line := strings.Repeat("─", max(0, m.viewport.Width-lipgloss.Width(info)))
+	return lipgloss.JoinHorizontal(lipgloss.Center, line, info)
+}
+
+// runTUI は、チャットルームを閲覧し、メッセージを送信するためのTUIを起動します。
+// バックエンドを準備できない場合（API キーがない場合など）は、閲覧だけができます。
+func runTUI(cfg *config.Config) error {
+	keys, err := newKeyMap(cfg.Keybindings)
+	if err != nil {
+		return err
+	}
+
+	var (
+		store *history.Store
+		open  openFunc
+	)
+	env, err := openChatEnv(cfg)
+	if err == nil {
+		defer env.Close()
+		store = env.store
+		open = func(room roomRef) (session, error) {
+			// 端末はTUIが使用しているため、副作用のあるツールは常に拒否します。
+			// 応答の比較はTUIの比較画面で行うため、Pick は渡しません。
+			return env.newChat(chat.Options{
+				Room:    room.name,
+				Project: room.project,
+				Output:  io.Discard,
+				Confirm: func(string) bool { return false },
+			})
+		}
+	} else {
+		sendErr := err
+		if store, err = openStore(cfg); err != nil {
+			return err
+		}
+		defer store.Close()
+		open = func(roomRef) (session, error) { return nil, sendErr }
+	}
+
+	rooms, err := store.ChatRooms()
+	if err != nil {
+		return err
+	}
+
+	p := tea.NewProgram(
+		newModel(store, rooms, currentProject(cfg), open, keys),
+		tea.WithAltScreen(),       // 端末の「代替画面バッファ」のフルサイズを使用します
+		tea.WithMouseCellMotion(), // マウスホイールを追跡できるようにマウスサポートをオンにします
+	)
+
+	final, err := p.Run()
+	if err != nil {
+		return fmt.Errorf("プログラムを実行できませんでした: %w", err)
+	}
+	final.(model).closeSessions()
+	return nil
+}
@@ -0,0 +1,807 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/exp/golden"
+	"github.com/charmbracelet/x/exp/teatest"
+	"github.com/kou12345/gollm/internal/attach"
+	"github.com/kou12345/gollm/internal/chat"
+	"github.com/kou12345/gollm/internal/config"
+	"github.com/kou12345/gollm/internal/fake"
+	"github.com/kou12345/gollm/internal/history"
+	"github.com/kou12345/gollm/internal/project"
+	"github.com/kou12345/gollm/internal/render"
+	"github.com/muesli/termenv"
+	"google.golang.org/api/option"
+)
+
+func init() {
+	// 端末によって出力が変わらないように、色を使わずに描画します。
+	lipgloss.SetColorProfile(termenv.Ascii)
+	render.SetStyle("notty")
+}
+
+// testTime は、フィクスチャの全ての日時です。
+var testTime = time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestModel は、3つのチャットルームを持つデータベースを作成し、それを表示するモデルを返します。
+// 最初のルームには2つのメッセージがあります。メッセージは fake バックエンドに送信します。
+func newTestModel(t *testing.T) model {
+	t.Helper()
+	store, err := history.OpenStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	for _, name := range []string{"golang", "recipes", "travel"} {
+		if _, err := store.OpenRoom(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golang, _ := store.OpenRoom("golang")
+	user := history.ChatMessage{Role: "user", Content: "What is a goroutine?", Time: testTime}
+	if err := store.SaveMessage(golang.ID, &user); err != nil {
+		t.Fatal(err)
+	}
+	reply := history.ChatMessage{ParentID: user.ID, Role: "assistant", Content: "A lightweight thread managed by the Go runtime.", Time: testTime}
+	if err := store.SaveMessage(golang.ID, &reply); err != nil {
+		t.Fatal(err)
+	}
+	return newModel(store, testRooms(t, store), project.Project{}, fakeSessions(t, store), defaultKeys(t))
+}
+
+// testRooms は、store の全てのルームを、日時をフィクスチャの日時に置き換えて返します。
+// 最後にメッセージを保存した日時は、作成した順に1日ずつ古くします。
+func testRooms(t *testing.T, store *history.Store) []history.ChatRoom {
+	t.Helper()
+	rooms, err := store.ChatRooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range rooms {
+		rooms[i].CreatedAt = testTime
+		rooms[i].UpdatedAt = testTime.AddDate(0, 0, -i)
+	}
+	return rooms
+}
+
+// defaultKeys は、既定の設定のキーの対応を返します。
+func defaultKeys(t *testing.T) keyMap {
+	t.Helper()
+	keys, err := newKeyMap(config.Default().Keybindings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// fakeSessions は、fake バックエンドと会話する session を作成する関数を返します。
+func fakeSessions(t *testing.T, store *history.Store) openFunc {
+	return func(room roomRef) (session, error) {
+		fb, err := fake.New(&fake.Script{})
+		if err != nil {
+			return nil, err
+		}
+		return chat.NewChat(chat.Options{
+			Model:     "fake",
+			Strategy:  chat.StrategyKeepPinned,
+			Threshold: 0.8,
+			Store:     store,
+			Room:      room.name,
+			Project:   room.project,
+			Output:    io.Discard,
+			AttachLimits: attach.Limits{
+				MaxFileSize:  1 << 20,
+				MaxTotalSize: 1 << 20,
+				MaxMediaSize: 1 << 20,
+			},
+		}, option.WithHTTPClient(fb.Client()), option.WithAPIKey("fake"))
+	}
+}
+
+// startTUI は、width x height の端末で m を実行し、最初の画面が描画されるまで待ちます。
+func startTUI(t *testing.T, m model, width, height int) *teatest.TestModel {
+	t.Helper()
+	tm := teatest.NewTestModel(t, m, teatest.WithInitialTermSize(width, height))
+	waitFor(t, tm, "Chat Room: golang")
+	return tm
+}
+
+// waitFor は、出力に s が含まれるまで待ちます。
+func waitFor(t *testing.T, tm *teatest.TestModel, s string) {
+	t.Helper()
+	teatest.WaitFor(t, tm.Output(), func(out []byte) bool {
+		return bytes.Contains(out, []byte(s))
+	}, teatest.WithDuration(3*time.Second))
+}
+
+func keyMsg(k tea.KeyType) tea.KeyMsg {
+	return tea.KeyMsg{Type: k}
+}
+
+func runes(s string) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+// finalView は、プログラムを終了させ、最後のモデルの画面を返します。
+func finalView(t *testing.T, tm *teatest.TestModel) (model, []byte) {
+	t.Helper()
+	if err := tm.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	m := tm.FinalModel(t, teatest.WithFinalTimeout(3*time.Second)).(model)
+	return m, []byte(m.View())
+}
+
+func TestListNavigation(t *testing.T) {
+	tm := startTUI(t, newTestModel(t), 80, 24)
+	tm.Send(keyMsg(tea.KeyDown))
+	tm.Send(runes("j"))
+	tm.Send(keyMsg(tea.KeyUp))
+
+	m, view := finalView(t, tm)
+	if got := m.chatRooms.Index(); got != 1 {
+		t.Errorf("selected index = %d, want 1", got)
+	}
+	golden.RequireEqual(t, view)
+}
+
+func TestEnterRoom(t *testing.T) {
+	tm := startTUI(t, newTestModel(t), 80, 24)
+	tm.Send(keyMsg(tea.KeyDown))
+	tm.Send(keyMsg(tea.KeyEnter))
+	waitFor(t, tm, "Chat Room: recipes")
+
+	m, view := finalView(t, tm)
+	if m.selectedRoom == nil || m.selectedRoom.Name != "recipes" || m.focus != paneComposer {
+		t.Fatalf("room = %+v, focus = %d, want recipes with the composer focused", m.selectedRoom, m.focus)
+	}
+	golden.RequireEqual(t, view)
+}
+
+// TestEnterEmptyRoomAndBack は、メッセージのないルームを開くと空の会話の案内を表示し、
+// esc でサイドバーに戻ってもルームを開いたままにすることを確認します。
+func TestEnterEmptyRoomAndBack(t *testing.T) {
+	tm := startTUI(t, newTestModel(t), 80, 24)
+	tm.Send(keyMsg(tea.KeyDown))
+	tm.Send(keyMsg(tea.KeyDown))
+	tm.Send(keyMsg(tea.KeyEnter))
+	waitFor(t, tm, "No messages yet.")
+	tm.Send(keyMsg(tea.KeyEsc))
+	tm.Send(keyMsg(tea.KeyEsc))
+	waitFor(t, tm, "open room")
+
+	m, view := finalView(t, tm)
+	if m.selectedRoom == nil || m.selectedRoom.Name != "travel" || m.focus != paneRooms {
+		t.Fatalf("room = %+v, focus = %d, want travel still open with the sidebar focused", m.selectedRoom, m.focus)
+	}
+	if !bytes.Contains(view, []byte("No messages yet.")) {
+		t.Errorf("view lost the empty conversation after going back:\n%s", view)
+	}
+	golden.RequireEqual(t, view)
+}
+
+func TestFocusCycle(t *testing.T) {
+	m := newTestModel(t)
+	press := func(msg tea.Msg) {
+		updated, _ := m.Update(msg)
+		m = updated.(model)
+	}
+	var got []pane
+	for i := 0; i < 3; i++ {
+		press(keyMsg(tea.KeyTab))
+		got = append(got, m.focus)
+	}
+	press(keyMsg(tea.KeyShiftTab))
+	got = append(got, m.focus)
+	if want := []pane{paneConversation, paneComposer, paneRooms, paneComposer}; !slices.Equal(got, want) {
+		t.Errorf("focus = %v, want %v", got, want)
+	}
+
+	// サイドバーを閉じると、サイドバーにはフォーカスが移りません。
+	press(keyMsg(tea.KeyCtrlB))
+	press(keyMsg(tea.KeyTab))
+	press(keyMsg(tea.KeyTab))
+	if m.showSidebar || m.focus != paneComposer {
+		t.Errorf("showSidebar = %v, focus = %d, want the sidebar skipped", m.showSidebar, m.focus)
+	}
+}
+
+func TestResize(t *testing.T) {
+	tm := startTUI(t, newTestModel(t), 80, 24)
+	tm.Send(tea.WindowSizeMsg{Width: 60, Height: 16})
+	tm.Send(keyMsg(tea.KeyCtrlB))
+	tm.Send(tea.WindowSizeMsg{Width: 50, Height: 14})
+
+	m, view := finalView(t, tm)
+	if m.viewport.Width != 50 {
+		t.Errorf("viewport width = %d, want 50", m.viewport.Width)
+	}
+	lines := bytes.Split(view, []byte("\n"))
+	if len(lines) != 14 {
+		t.Errorf("view has %d lines, want 14", len(lines))
+	}
+	for i, line := range lines {
+		if w := lipgloss.Width(string(line)); w > 50 {
+			t.Errorf("line %d is %d columns wide: %q", i, w, line)
+		}
+	}
+	golden.RequireEqual(t, view)
+}
+
+func TestMouseClick(t *testing.T) {
+	tm := startTUI(t, newTestModel(t), 80, 24)
+	// サイドバーの3番目のルーム（タイトルとステータスの4行と、1つ3行のルーム2つの下）をクリックします。
+	tm.Send(tea.MouseMsg{X: 5, Y: 10, Action: tea.MouseActionPress, Button: tea.MouseButtonLeft})
+	waitFor(t, tm, "Chat Room: travel")
+	tm.Send(tea.MouseMsg{X: 50, Y: 22, Action: tea.MouseActionPress, Button: tea.MouseButtonLeft})
+
+	m, _ := finalView(t, tm)
+	if m.selectedRoom == nil || m.selectedRoom.Name != "travel" || m.chatRooms.Index() != 2 {
+		t.Errorf("room = %+v, index = %d, want travel", m.selectedRoom, m.chatRooms.Index())
+	}
+	if m.focus != paneComposer {
+		t.Errorf("focus = %d, want the composer", m.focus)
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	tm := startTUI(t, newTestModel(t), 80, 24)
+	tm.Send(keyMsg(tea.KeyEnter))
+	tm.Type("Is a goroutine a thread?")
+	tm.Send(keyMsg(tea.KeyEnter))
+	waitFor(t, tm, "Echo: Is a goroutine a thread?")
+
+	m, _ := finalView(t, tm)
+	defer m.closeSessions()
+	if m.pending != nil || m.sendErr != nil {
+		t.Fatalf("pending = %v, sendErr = %v", m.pending, m.sendErr)
+	}
+	if len(m.messages) != 4 || m.messages[3].Content != "Echo: Is a goroutine a thread?" {
+		t.Errorf("messages = %+v", m.messages)
+	}
+	if m.composer.Value() != "" {
+		t.Errorf("composer = %q, want it cleared after sending", m.composer.Value())
+	}
+}
+
+func TestQuit(t *testing.T) {
+	for _, k := range []tea.KeyMsg{runes("q"), keyMsg(tea.KeyCtrlC)} {
+		t.Run(k.String(), func(t *testing.T) {
+			tm := startTUI(t, newTestModel(t), 80, 24)
+			tm.Send(k)
+			tm.WaitFinished(t, teatest.WithFinalTimeout(3*time.Second))
+		})
+	}
+}
+
+// TestQuitKeyInComposer は、入力欄の q が終了ではなく文字として扱われることを確認します。
+func TestQuitKeyInComposer(t *testing.T) {
+	tm := startTUI(t, newTestModel(t), 80, 24)
+	tm.Send(keyMsg(tea.KeyShiftTab))
+	tm.Type("quit")
+
+	m, _ := finalView(t, tm)
+	if got := m.composer.Value(); got != "quit" {
+		t.Errorf("composer = %q, want %q", got, "quit")
+	}
+}
+
+// TestQuitKeyWhileFiltering は、フィルターの入力中の q が終了ではなく文字として扱われることを確認します。
+func TestQuitKeyWhileFiltering(t *testing.T) {
+	tm := startTUI(t, newTestModel(t), 80, 24)
+	tm.Send(runes("/"))
+	tm.Type("rq")
+
+	m, _ := finalView(t, tm)
+	if got := m.chatRooms.FilterValue(); got != "rq" {
+		t.Errorf("filter = %q, want %q", got, "rq")
+	}
+}
+
+// TestHeaderWithoutRoom は、ルームを開いていないときでもヘッダーを描画できることを確認します。
+// 端末の大きさを受け取るとヘッダーの高さを測るため、以前はここで nil を参照していました。
+func TestHeaderWithoutRoom(t *testing.T) {
+	m := newModel(nil, nil, project.Project{}, nil, defaultKeys(t))
+	if got := m.headerView(); !strings.Contains(got, "Chat Room:") {
+		t.Errorf("header = %q", got)
+	}
+}
+
+func TestHelpOverlay(t *testing.T) {
+	tm := startTUI(t, newTestModel(t), 80, 34)
+	tm.Send(runes("?"))
+	waitFor(t, tm, "Key bindings")
+
+	m, view := finalView(t, tm)
+	if !m.showHelp {
+		t.Fatal("help overlay is not shown")
+	}
+	golden.RequireEqual(t, view)
+
+	updated, _ := m.Update(keyMsg(tea.KeyEsc))
+	if updated.(model).showHelp {
+		t.Error("esc did not close the help overlay")
+	}
+}
+
+// TestCustomKeybindings は、設定したキーで操作でき、既定のキーが使えなくなることを確認します。
+func TestCustomKeybindings(t *testing.T) {
+	bindings := config.Default().Keybindings
+	bindings["quit"] = []string{"ctrl+q"}
+	bindings["next_pane"] = []string{"ctrl+n"}
+	keys, err := newKeyMap(bindings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newTestModel(t)
+	m = newModel(m.store, nil, project.Project{}, nil, keys)
+
+	updated, cmd := m.Update(runes("q"))
+	if cmd != nil {
+		if _, ok := cmd().(tea.QuitMsg); ok {
+			t.Error("q still quits")
+		}
+	}
+	updated, _ = updated.Update(keyMsg(tea.KeyCtrlN))
+	if got := updated.(model).focus; got != paneConversation {
+		t.Errorf("focus = %d after ctrl+n, want the conversation", got)
+	}
+	if _, cmd = updated.Update(tea.KeyMsg{Type: tea.KeyCtrlQ}); cmd == nil {
+		t.Fatal("ctrl+q did nothing")
+	}
+	if _, ok := cmd().(tea.QuitMsg); !ok {
+		t.Error("ctrl+q did not quit")
+	}
+}
+
+func TestUnknownKeybinding(t *testing.T) {
+	if _, err := newKeyMap(map[string][]string{"quit": {"q"}, "explode": {"x"}}); err == nil || !strings.Contains(err.Error(), "explode") {
+		t.Errorf("err = %v, want an unknown action error", err)
+	}
+}
+
+// newNormalModel は、width x height の端末で golang のルームの会話にフォーカスしたモデルと、キーを送る関数を返します。
+func newNormalModel(t *testing.T, width, height int) (*model, func(...tea.Msg)) {
+	m := newTestModel(t)
+	press := func(msgs ...tea.Msg) {
+		for _, msg := range msgs {
+			updated, _ := m.Update(msg)
+			m = updated.(model)
+		}
+	}
+	press(tea.WindowSizeMsg{Width: width, Height: height}, keyMsg(tea.KeyTab))
+	return &m, press
+}
+
+func TestMessageJumps(t *testing.T) {
+	m, press := newNormalModel(t, 80, 16)
+	if m.mode() != modeNormal || m.current != 1 {
+		t.Fatalf("mode = %s, current = %d, want normal mode on the last message", m.mode(), m.current)
+	}
+
+	press(runes("g"), runes("g"))
+	if m.current != 0 || m.viewport.YOffset != 0 {
+		t.Errorf("after gg: current = %d, offset = %d, want the first message", m.current, m.viewport.YOffset)
+	}
+	press(runes("}"))
+	if m.current != 1 || m.viewport.YOffset != min(m.starts[1], m.viewport.TotalLineCount()-m.viewport.Height) {
+		t.Errorf("after }: current = %d, offset = %d, want the second message", m.current, m.viewport.YOffset)
+	}
+	press(runes("}"))
+	if m.current != 1 {
+		t.Errorf("after } on the last message: current = %d, want 1", m.current)
+	}
+	press(runes("{"))
+	if m.current != 0 || m.viewport.YOffset != 0 {
+		t.Errorf("after {: current = %d, offset = %d, want the first message", m.current, m.viewport.YOffset)
+	}
+	press(runes("G"))
+	if m.current != 1 || !m.viewport.AtBottom() {
+		t.Errorf("after G: current = %d, at bottom = %v", m.current, m.viewport.AtBottom())
+	}
+	if footer := m.footerView(); !strings.Contains(footer, "NORMAL  2/2") {
+		t.Errorf("footer = %q, want the mode and message position", footer)
+	}
+
+	// 割り当てのないキーの並びは捨てられ、続くキーは通常どおり使えます。
+	press(runes("g"), runes("x"), runes("g"), runes("g"))
+	if m.current != 0 || m.keySeq != "" {
+		t.Errorf("after gxgg: current = %d, keySeq = %q", m.current, m.keySeq)
+	}
+}
+
+func TestSearchInRoom(t *testing.T) {
+	m, press := newNormalModel(t, 80, 16)
+	press(runes("/"))
+	if !m.searching {
+		t.Fatal("/ did not start a search")
+	}
+	press(runes("GOROUTINE"), keyMsg(tea.KeyEnter))
+	if m.searching || len(m.matches) != 1 || m.current != 0 {
+		t.Fatalf("searching = %v, matches = %v, current = %d", m.searching, m.matches, m.current)
+	}
+	if want := "/GOROUTINE [1/1]"; m.statusView() != want {
+		t.Errorf("status = %q, want %q", m.statusView(), want)
+	}
+
+	press(runes("/"), runes("a"), keyMsg(tea.KeyEnter))
+	if len(m.matches) < 2 {
+		t.Fatalf("matches = %v, want several", m.matches)
+	}
+	first := m.match
+	press(runes("n"))
+	if m.match != (first+1)%len(m.matches) {
+		t.Errorf("after n: match = %d, want %d", m.match, (first+1)%len(m.matches))
+	}
+	press(runes("N"), runes("N"))
+	if want := (first - 1 + len(m.matches)) % len(m.matches); m.match != want {
+		t.Errorf("after NN: match = %d, want %d", m.match, want)
+	}
+
+	press(runes("/"), runes("nowhere"), keyMsg(tea.KeyEnter))
+	if !strings.Contains(m.statusView(), "Pattern not found: nowhere") {
+		t.Errorf("status = %q", m.statusView())
+	}
+	// ステータスは次のキーで消えます。
+	press(runes("j"))
+	if m.status != "" {
+		t.Errorf("status = %q after a key, want it cleared", m.status)
+	}
+
+	press(runes("/"), runes("x"), keyMsg(tea.KeyEsc))
+	if m.searching || m.query != "nowhere" {
+		t.Errorf("after esc: searching = %v, query = %q", m.searching, m.query)
+	}
+}
+
+// TestSwitchBranch は、選択しているメッセージの兄弟の枝に切り替え、その枝を選択中としてルームに保存することを確認します。
+func TestSwitchBranch(t *testing.T) {
+	m, press := newNormalModel(t, 80, 16)
+	golang := m.selectedRoom.ID
+	// 最初の質問に対する2つ目の応答を作り、その続きを1往復追加します。
+	alt := history.ChatMessage{ParentID: m.messages[0].ID, Role: "assistant", Content: "A green thread.", Time: testTime}
+	if err := m.store.SaveMessage(golang, &alt); err != nil {
+		t.Fatal(err)
+	}
+	follow := history.ChatMessage{ParentID: alt.ID, Role: "user", Content: "Green?", Time: testTime}
+	if err := m.store.SaveMessage(golang, &follow); err != nil {
+		t.Fatal(err)
+	}
+	m.loadMessages()
+	m.refresh(true)
+	press(runes("{"))
+	if m.current != 1 || !strings.Contains(m.lines[m.starts[1]], "‹2/2›") {
+		t.Fatalf("current = %d, header = %q, want the second reply marked as branch 2/2", m.current, m.lines[m.starts[1]])
+	}
+
+	press(runes("h"))
+	if m.status != "Branch 1/2" || len(m.messages) != 2 || m.messages[1].Content != "A lightweight thread managed by the Go runtime." {
+		t.Fatalf("status = %q, messages = %+v", m.status, m.messages)
+	}
+	if m.current != 1 {
+		t.Errorf("current = %d, want the switched message selected", m.current)
+	}
+	if h, err := m.store.Load(golang); err != nil || len(h.Messages) != 2 {
+		t.Errorf("stored branch = %v, %v, want the first reply", h, err)
+	}
+	press(runes("h"))
+	if m.status != "Already on branch 1/2" {
+		t.Errorf("status = %q", m.status)
+	}
+
+	// 枝の最新のやり取りまで表示します。
+	press(keyMsg(tea.KeyRight))
+	if m.status != "Branch 2/2" || len(m.messages) != 3 || m.messages[2].Content != "Green?" {
+		t.Errorf("status = %q, messages = %+v", m.status, m.messages)
+	}
+
+	press(runes("g"), runes("g"), runes("l"))
+	if m.status != "This message has no other branches" {
+		t.Errorf("status = %q on the first message", m.status)
+	}
+}
+
+// TestSwitchBranchSession は、会話を始めたルームで枝を切り替えると、次のメッセージをその枝に続けて送信することを確認します。
+func TestSwitchBranchSession(t *testing.T) {
+	m, press := newNormalModel(t, 80, 16)
+	golang := m.selectedRoom.ID
+	alt := history.ChatMessage{ParentID: m.messages[0].ID, Role: "assistant", Content: "A green thread.", Time: testTime}
+	if err := m.store.SaveMessage(golang, &alt); err != nil {
+		t.Fatal(err)
+	}
+	m.loadMessages()
+	m.refresh(true)
+
+	s, err := m.session(m.selectedRoom.ref())
+	if err != nil {
+		t.Fatal(err)
+	}
+	press(runes("h"))
+	if m.status != "Branch 1/2" {
+		t.Fatalf("status = %q", m.status)
+	}
+	if _, err := s.AskStream("Tell me more", func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+	h, err := m.store.Load(golang)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Messages) != 4 || h.Messages[1].Content != "A lightweight thread managed by the Go runtime." {
+		t.Errorf("messages = %+v, want the new turn on the first branch", h.Messages)
+	}
+}
+
+func TestYankMessage(t *testing.T) {
+	var copied []string
+	defer func(orig func(string) error) { writeClipboard = orig }(writeClipboard)
+	writeClipboard = func(text string) error {
+		copied = append(copied, text)
+		return nil
+	}
+
+	m, press := newNormalModel(t, 80, 16)
+	press(runes("y"), runes("g"), runes("g"), runes("y"))
+	want := []string{"A lightweight thread managed by the Go runtime.", "What is a goroutine?"}
+	if !slices.Equal(copied, want) {
+		t.Errorf("copied = %q, want %q", copied, want)
+	}
+	if m.status != "Copied message 1/2" {
+		t.Errorf("status = %q", m.status)
+	}
+}
+
+func TestInsertKey(t *testing.T) {
+	m, press := newNormalModel(t, 80, 16)
+	press(runes("i"))
+	if m.focus != paneComposer || m.mode() != modeInsert {
+		t.Fatalf("focus = %d, want the composer", m.focus)
+	}
+	// 挿入モードでは、ノーマルモードのキーは文字として入力されます。
+	press(runes("g"), runes("g"), runes("i"), runes("y"))
+	if got := m.composer.Value(); got != "ggiy" {
+		t.Errorf("composer = %q, want %q", got, "ggiy")
+	}
+	press(keyMsg(tea.KeyEsc))
+	if m.mode() != modeNormal {
+		t.Errorf("mode = %s after esc, want normal", m.mode())
+	}
+}
+
+// TestAttachFiles は、入力欄から表示中のルームの次のメッセージにファイルを添付し、取り消せることを確認します。
+func TestAttachFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "b.go"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("package a\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, press := newNormalModel(t, 80, 16)
+	press(runes("i"), keyMsg(tea.KeyCtrlO))
+	if !m.attaching {
+		t.Fatal("ctrl+o did not start attaching")
+	}
+	press(runes(filepath.Join(dir, "*.go")), keyMsg(tea.KeyEnter))
+	if m.attaching || m.status != "Attached 2 file(s) to the next message" {
+		t.Fatalf("attaching = %v, status = %q", m.attaching, m.status)
+	}
+	if files := m.pendingFiles(); len(files) != 2 {
+		t.Errorf("pending = %v, want both files", files)
+	}
+	if !strings.Contains(m.footerView(), "2 attached") {
+		t.Errorf("footer = %q, want the number of attached files", m.footerView())
+	}
+	// 挿入モードのまま、入力欄の内容は変わりません。
+	if m.mode() != modeInsert || m.composer.Value() != "" {
+		t.Errorf("mode = %s, composer = %q", m.mode(), m.composer.Value())
+	}
+
+	press(keyMsg(tea.KeyCtrlO), runes(filepath.Join(dir, "missing.go")), keyMsg(tea.KeyEnter))
+	if !strings.Contains(m.status, "Failed to attach files") || len(m.pendingFiles()) != 2 {
+		t.Errorf("status = %q, pending = %d", m.status, len(m.pendingFiles()))
+	}
+
+	press(keyMsg(tea.KeyCtrlO), keyMsg(tea.KeyEnter))
+	if m.status != "Cleared pending attachments" || len(m.pendingFiles()) != 0 {
+		t.Errorf("status = %q, pending = %d after clearing", m.status, len(m.pendingFiles()))
+	}
+
+	press(keyMsg(tea.KeyEsc), keyMsg(tea.KeyCtrlO), runes("x"), keyMsg(tea.KeyEsc))
+	if m.attaching || m.mode() != modeNormal {
+		t.Errorf("after esc: attaching = %v, mode = %s", m.attaching, m.mode())
+	}
+}
+
+// TestCompareResponses は、入力欄のメッセージに対する応答を比較画面で選ぶと、全ての応答を枝として保存して選んだ枝を表示し、
+// 取り消した場合は何も保存せずにメッセージを入力欄に戻すことを確認します。
+func TestCompareResponses(t *testing.T) {
+	m, press := newNormalModel(t, 80, 16)
+	// 比較するモデルを入力し、応答の生成が終わるのを待って結果を渡します。
+	compare := func(spec string) {
+		t.Helper()
+		press(keyMsg(tea.KeyCtrlG))
+		if spec != "" {
+			press(runes(spec))
+		}
+		updated, cmd := m.Update(keyMsg(tea.KeyEnter))
+		*m = updated.(model)
+		if cmd != nil {
+			press(cmd())
+		}
+	}
+
+	press(runes("i"), keyMsg(tea.KeyCtrlG))
+	if m.comparing || m.status != errorStyle.Render("Write a message to compare responses to") {
+		t.Fatalf("comparing = %v, status = %q with an empty composer", m.comparing, m.status)
+	}
+
+	press(runes("Explain channels"))
+	compare("1")
+	if m.picking != nil || !strings.Contains(m.status, "Failed to compare responses") || m.composer.Value() != "Explain channels" {
+		t.Fatalf("status = %q, composer = %q after an invalid spec", m.status, m.composer.Value())
+	}
+
+	compare("")
+	if m.picking == nil {
+		t.Fatalf("sendErr = %v, want the comparison", m.sendErr)
+	}
+	if view := m.View(); !strings.Contains(view, "1. fake") || !strings.Contains(view, "2. fake") {
+		t.Errorf("view = %q, want both responses", view)
+	}
+	press(keyMsg(tea.KeyEsc))
+	if m.picking != nil || m.status != "Discarded all responses" || m.composer.Value() != "Explain channels" {
+		t.Errorf("after discarding: status = %q, composer = %q", m.status, m.composer.Value())
+	}
+	if len(m.messages) != 2 {
+		t.Errorf("messages = %d after discarding, want nothing saved", len(m.messages))
+	}
+
+	compare("3")
+	press(keyMsg(tea.KeyRight), keyMsg(tea.KeyEnter))
+	if m.picking != nil || m.composer.Value() != "" {
+		t.Fatalf("picking = %v, composer = %q after picking", m.picking, m.composer.Value())
+	}
+	if len(m.messages) != 4 || m.messages[2].Content != "Explain channels" || m.messages[3].Content != "Echo: Explain channels" {
+		t.Fatalf("messages = %+v", m.messages)
+	}
+	if n, total := m.branchOf(3); n != 3 || total != 3 {
+		t.Errorf("branch = %d/%d, want the picked response saved last", n, total)
+	}
+}
+
+// TestRoomOrganisation は、ルームがピン留め・フォルダー・最後の更新の順に並び、アーカイブしたルームが隠れることを確認します。
+func TestRoomOrganisation(t *testing.T) {
+	m := newTestModel(t)
+	rooms := map[string]history.ChatRoom{}
+	for _, name := range []string{"golang", "recipes", "travel", "work-a", "work-b", "old"} {
+		room, err := m.store.OpenRoom(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rooms[name] = room
+	}
+	for _, name := range []string{"work-a", "work-b"} {
+		if err := m.store.SetRoomFolder(rooms[name].ID, "work"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.store.SetRoomTags(rooms["recipes"].ID, []string{"food", "#home"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.store.SetRoomPinned(rooms["travel"].ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.store.SetRoomArchived(rooms["old"].ID, true); err != nil {
+		t.Fatal(err)
+	}
+	m = newModel(m.store, testRooms(t, m.store), project.Project{}, nil, defaultKeys(t))
+
+	titles := func() []string {
+		var titles []string
+		for _, item := range m.chatRooms.Items() {
+			titles = append(titles, item.(ChatRoom).Title())
+		}
+		return titles
+	}
+	if want := []string{"★ travel", "work/work-a", "work/work-b", "golang", "recipes"}; !slices.Equal(titles(), want) {
+		t.Errorf("rooms = %q, want %q", titles(), want)
+	}
+	recipes := m.chatRooms.Items()[4].(ChatRoom)
+	if got := recipes.Description(); got != "0 messages · Jul 31 #food #home" {
+		t.Errorf("description = %q", got)
+	}
+	if got := recipes.FilterValue(); !strings.Contains(got, "#food") {
+		t.Errorf("filter value = %q, want the tags", got)
+	}
+
+	press := func(msgs ...tea.Msg) {
+		for _, msg := range msgs {
+			updated, _ := m.Update(msg)
+			m = updated.(model)
+		}
+	}
+	// カーソルのあるルームは、並べ直しても選択されたままです。
+	press(runes("G"), runes("p"))
+	if got := titles()[1]; got != "★ recipes" || m.chatRooms.SelectedItem().(ChatRoom).Name != "recipes" {
+		t.Errorf("after p: rooms = %q, selected = %v", titles(), m.chatRooms.SelectedItem())
+	}
+	if m.status != "Pinned recipes" {
+		t.Errorf("status = %q", m.status)
+	}
+
+	press(runes("A"))
+	if got := titles(); len(got) != 6 || !slices.Contains(got, "old (archived)") {
+		t.Errorf("after A: rooms = %q, want the archived room shown", got)
+	}
+	press(runes("a"))
+	if room, _ := m.store.OpenRoom("recipes"); !room.Archived {
+		t.Error("a did not archive the room")
+	}
+	press(runes("A"))
+	if got := titles(); len(got) != 4 || slices.Contains(got, "★ recipes (archived)") {
+		t.Errorf("after A: rooms = %q, want the archived rooms hidden", got)
+	}
+}
+
+// TestProjectRooms は、サイドバーにプロジェクトのルームだけを表示し、送信したメッセージがプロジェクトのルームに保存されることを確認します。
+func TestProjectRooms(t *testing.T) {
+	const root = "/src/gollm"
+	m := newTestModel(t)
+	if _, err := m.store.OpenProjectRoom("/src/other", "notes"); err != nil {
+		t.Fatal(err)
+	}
+	m = newModel(m.store, testRooms(t, m.store), project.Project{Root: root}, fakeSessions(t, m.store), defaultKeys(t))
+	defer func() { m.closeSessions() }()
+	press := func(msgs ...tea.Msg) tea.Cmd {
+		var cmd tea.Cmd
+		for _, msg := range msgs {
+			var updated tea.Model
+			updated, cmd = m.Update(msg)
+			m = updated.(model)
+		}
+		return cmd
+	}
+
+	if len(m.chatRooms.Items()) != 0 || m.selectedRoom != nil || m.chatRooms.Title != "Rooms: gollm" {
+		t.Fatalf("rooms = %v, selected = %v, title = %q, want the empty project", m.chatRooms.Items(), m.selectedRoom, m.chatRooms.Title)
+	}
+
+	// ルームを選択していないときは、プロジェクトの default ルームに送信します。
+	press(tea.WindowSizeMsg{Width: 80, Height: 24}, keyMsg(tea.KeyShiftTab))
+	cmd := press(runes("hello"), keyMsg(tea.KeyEnter))
+	for cmd != nil {
+		msg := cmd()
+		cmd = press(msg)
+		if _, ok := msg.(replyMsg); ok {
+			break
+		}
+	}
+	if m.selectedRoom == nil || m.selectedRoom.Project != root || m.selectedRoom.Name != defaultRoom || len(m.messages) != 2 {
+		t.Fatalf("selected = %+v, messages = %d, want the project's default room", m.selectedRoom, len(m.messages))
+	}
+	if global, _ := m.store.OpenRoom(defaultRoom); global.MessageCount != 0 {
+		t.Errorf("the room outside the project has %d messages", global.MessageCount)
+	}
+
+	press(keyMsg(tea.KeyTab), runes("P"))
+	var titles []string
+	for _, item := range m.chatRooms.Items() {
+		titles = append(titles, item.(ChatRoom).Title())
+	}
+	if !slices.Contains(titles, "other: notes") || !slices.Contains(titles, "gollm: default") || !slices.Contains(titles, "golang") || m.chatRooms.Title != "All Rooms" {
+		t.Errorf("all rooms = %q, title = %q", titles, m.chatRooms.Title)
+	}
+}
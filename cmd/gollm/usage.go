@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kou12345/gollm/internal/config"
+	"github.com/kou12345/gollm/internal/history"
+	"github.com/kou12345/gollm/internal/project"
+)
+
+// usageTotal は、集計の単位ごとにまとめたトークン使用量と料金です。
+type usageTotal struct {
+	key              string
+	roomID           int64 // ルームごとに集計した場合のルームのID
+	requests         int
+	promptTokens     int64
+	completionTokens int64
+	cost             float64
+	unpriced         bool // 料金表にないモデルを含むかどうか
+}
+
+// runUsage は、`gollm usage` サブコマンドとしてトークン使用量と料金の集計を表示します。
+// 既定では今月の使用量を日ごとに表示します。
+func runUsage(cfg *config.Config, args []string) error {
+	now := time.Now()
+	flags := flag.NewFlagSet("usage", flag.ContinueOnError)
+	by := flags.String("by", string(history.UsageByDay), "group totals by day, room or model")
+	since := flags.String("since", now.Format("2006-01")+"-01", "only include usage on or after this date (YYYY-MM-DD)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	from, err := time.ParseInLocation("2006-01-02", *since, time.Local)
+	if err != nil {
+		return fmt.Errorf("invalid --since date: %w", err)
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	rows, err := store.UsageTotals(history.UsageGroup(*by), from)
+	if err != nil {
+		return err
+	}
+
+	var (
+		totals []*usageTotal
+		grand  = &usageTotal{key: "TOTAL"}
+	)
+	for _, row := range rows {
+		if last := len(totals) - 1; last < 0 || totals[last].key != usageKey(row) || totals[last].roomID != row.RoomID {
+			totals = append(totals, &usageTotal{key: usageKey(row), roomID: row.RoomID})
+		}
+		price, ok := cfg.PriceFor(row.Model)
+		cost := price.Cost(row.PromptTokens, row.CompletionTokens)
+		for _, t := range []*usageTotal{totals[len(totals)-1], grand} {
+			t.requests += row.Requests
+			t.promptTokens += row.PromptTokens
+			t.completionTokens += row.CompletionTokens
+			t.cost += cost
+			t.unpriced = t.unpriced || !ok
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "%s\tREQUESTS\tPROMPT\tCOMPLETION\tCOST (USD)\t\n", strings.ToUpper(*by))
+	for _, t := range append(totals, grand) {
+		cost := fmt.Sprintf("%.4f", t.cost)
+		if t.unpriced {
+			cost += "*"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t\n", t.key, t.requests, t.promptTokens, t.completionTokens, cost)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if grand.unpriced {
+		fmt.Println("* includes models without a price; add them under [pricing] in the config file")
+	}
+	return nil
+}
+
+// usageKey は、集計の単位の表示名を返します。プロジェクトに属するルームには、プロジェクトの名前を付けます。
+func usageKey(row history.UsageRow) string {
+	if row.Project == "" {
+		return row.Key
+	}
+	return fmt.Sprintf("%s (%s)", row.Key, project.Project{Root: row.Project}.Name())
+}
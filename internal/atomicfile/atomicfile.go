@@ -0,0 +1,120 @@
+// Package atomicfile は、複数のプロセスから同時に書き込まれても壊れないようにファイルを書き込みます。
+// 書き込みは同じディレクトリの一時ファイルに行ってから置き換えるため、読み込む側は常に完全なファイルを読み込みます。
+// 書き込む側どうしは、path に ".lock" を付けたファイルのアドバイザリロックで直列化します。
+package atomicfile
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile は、path を data の内容に置き換えます。path が存在しない場合は perm のパーミッションで作成します。
+func WriteFile(path string, data []byte, perm fs.FileMode) error {
+	return Update(path, perm, func([]byte) ([]byte, error) { return data, nil })
+}
+
+// Update は、ロックを取得してから path の内容を読み込み、update の結果で置き換えます。
+// path が存在しない場合、update には nil を渡します。読み込んでから書き込むまでの間に、他のプロセスが書き込むことはありません。
+// update がエラーを返した場合は、ファイルを変更せずにそのエラーを返します。
+func Update(path string, perm fs.FileMode, update func(old []byte) ([]byte, error)) error {
+	unlock, err := Lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	old, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	data, err := update(old)
+	if err != nil {
+		return err
+	}
+	return replace(path, data, perm)
+}
+
+// Lock は、path への書き込みを他のプロセスと直列化するためのロックを取得し、解放する関数を返します。
+// ロックは path に ".lock" を付けたファイルに対して取得します（path 自体は置き換えられるためです）。
+// 他のプロセスがロックを持っている場合は、解放されるまで待ちます。
+func Lock(path string) (unlock func() error, err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() error {
+		unlockFile(f)
+		return f.Close()
+	}, nil
+}
+
+// Move は、ロックを取得してから src を dst に移動します。dst が既に存在する場合は、何も変更せずに fs.ErrExist を返します。
+// 同じファイルシステムの中では名前の変更で移動します。別のファイルシステムへは一時ファイルにコピーしてから置き換え、src を削除します。
+// いずれの場合も、dst を読み込む側が書きかけのファイルを読み込むことはありません。
+func Move(src, dst string) error {
+	unlock, err := Lock(dst)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := os.Lstat(dst); err == nil {
+		return &fs.PathError{Op: "move", Path: dst, Err: fs.ErrExist}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	// 名前の変更に失敗した場合（別のファイルシステムへの移動など）は、コピーしてから削除します。
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := replace(dst, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// replace は、同じディレクトリに書き込んだ一時ファイルで path を置き換えます。
+func replace(path string, data []byte, perm fs.FileMode) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
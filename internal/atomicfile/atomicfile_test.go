@@ -0,0 +1,139 @@
+package atomicfile
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "data.json")
+
+	for _, content := range []string{"first", "second"} {
+		if err := WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("content = %q, want %q", got, content)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("perm = %v, want 0600", perm)
+	}
+
+	// 一時ファイルが残っていないことを確認します。
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "data.json" && e.Name() != "data.json.lock" {
+			t.Errorf("unexpected file %s", e.Name())
+		}
+	}
+}
+
+func TestUpdateConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counter")
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := Update(path, 0600, func(old []byte) ([]byte, error) {
+				count := 0
+				if len(old) > 0 {
+					var err error
+					if count, err = strconv.Atoi(string(old)); err != nil {
+						return nil, err
+					}
+				}
+				return []byte(strconv.Itoa(count + 1)), nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != strconv.Itoa(n) {
+		t.Errorf("counter = %s, want %d (updates were lost)", got, n)
+	}
+}
+
+func TestUpdateError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	if err := WriteFile(path, []byte("kept"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	want := os.ErrInvalid
+	err := Update(path, 0600, func([]byte) ([]byte, error) { return nil, want })
+	if err != want {
+		t.Errorf("err = %v, want %v", err, want)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "kept" {
+		t.Errorf("content = %q, want %q", got, "kept")
+	}
+}
+
+func TestMove(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "old.db")
+	dst := filepath.Join(dir, "data", "new.db")
+	if err := os.WriteFile(src, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Move(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(dst); err != nil || string(got) != "first" {
+		t.Errorf("dst = %q, %v, want %q", got, err, "first")
+	}
+	if _, err := os.Stat(src); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("src still exists: %v", err)
+	}
+
+	// 移動先が既に存在する場合は、どちらのファイルも変更しません。
+	if err := os.WriteFile(src, []byte("second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Move(src, dst); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("err = %v, want fs.ErrExist", err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "first" {
+		t.Errorf("dst = %q, want it unchanged", got)
+	}
+	if got, _ := os.ReadFile(src); string(got) != "second" {
+		t.Errorf("src = %q, want it unchanged", got)
+	}
+
+	if err := Move(filepath.Join(dir, "missing"), filepath.Join(dir, "other")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("err = %v, want fs.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package atomicfile
+
+import "os"
+
+// lockFile は、このプラットフォームではロックを取得しません。
+// 書き込みは一時ファイルからの置き換えで行うため、ファイルが壊れることはありませんが、同時に書き込んだ内容の一方は失われます。
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile は、このプラットフォームでは何もしません。
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,238 @@
+// Package attach は、ローカルのファイルやディレクトリを読み込み、
+// プロンプトに添付するための機能を提供します。
+package attach
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Limits は、添付できるファイルのサイズの上限を表現する構造体です。
+type Limits struct {
+	MaxFileSize  int64 // テキストファイル1つあたりの上限（バイト）
+	MaxTotalSize int64 // 1つのメッセージに添付する合計の上限（バイト）
+	MaxMediaSize int64 // 画像やPDF1つあたりの上限（バイト）
+}
+
+// mediaTypes は、テキストではなくデータのまま送信できる MIME タイプです。
+var mediaTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// File は、添付するファイルを表現する構造体です。
+type File struct {
+	Path     string // ファイルの絶対パス
+	MIMEType string // ファイルの内容から判定した MIME タイプ
+	Content  []byte // ファイルの内容
+}
+
+// IsMedia は、ファイルが画像やPDFなど、テキストではなくデータとして送信するものかどうかを返します。
+func (f File) IsMedia() bool {
+	return mediaTypes[f.MIMEType]
+}
+
+// Name は、表示に使用するファイルのパスを返します。
+// カレントディレクトリ以下のファイルは相対パスになります。
+func (f File) Name() string {
+	return displayPath(f.Path)
+}
+
+// Text は、テキストファイルをプロンプトに含めるためのテキストに変換します。
+// 画像やPDFの場合は、ファイル名だけを示すテキストを返します。
+func (f File) Text() string {
+	if f.IsMedia() {
+		return fmt.Sprintf("File: %s (%s)", f.Name(), f.MIMEType)
+	}
+	return fmt.Sprintf("File: %s\n```\n%s\n```", f.Name(), f.Content)
+}
+
+// TotalSize は、files の内容の合計サイズを返します。
+func TotalSize(files []File) int64 {
+	var total int64
+	for _, f := range files {
+		total += int64(len(f.Content))
+	}
+	return total
+}
+
+// TotalExceeded は、合計サイズの上限 limit を超えるために name のファイルを添付しなかった理由を説明します。
+func TotalExceeded(name string, limit int64) string {
+	return fmt.Sprintf("%s: total attachment size would exceed %d bytes", name, limit)
+}
+
+// Collect は、patterns に一致するファイルを読み込みます。
+// パターンにはグロブを使用でき、ディレクトリは再帰的に走査されます。
+// ディレクトリの走査では .git と .gitignore で無視されるファイルを除外します。
+// PNG・JPEG・WebP の画像とPDFはデータのまま読み込みます。
+// 上限を超えたファイルやそれ以外のバイナリファイルは読み込まず、その理由を skipped に含めます。
+func Collect(patterns []string, limits Limits) (files []File, skipped []string, err error) {
+	c := &collector{limits: limits, ig: newIgnorer(), seen: map[string]bool{}}
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, nil, fmt.Errorf("no files match %q", pattern)
+		}
+
+		for _, match := range matches {
+			path, err := filepath.Abs(match)
+			if err != nil {
+				return nil, nil, err
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			if info.IsDir() {
+				err = c.walk(path)
+			} else {
+				err = c.add(path, info.Size())
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return c.files, c.skipped, nil
+}
+
+// Load は、以前に添付したテキストファイルを読み込み直します。
+// 会話履歴を再構築するときに使用し、上限を超えたファイルはエラーになります。
+func Load(path string, limits Limits) (File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return File{}, err
+	}
+	if info.Size() > limits.MaxFileSize {
+		return File{}, fmt.Errorf("%s is larger than %d bytes", displayPath(path), limits.MaxFileSize)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Path: path, MIMEType: "text/plain", Content: content}, nil
+}
+
+// collector は、Collect の途中経過を保持します。
+type collector struct {
+	limits  Limits
+	ig      *ignorer
+	seen    map[string]bool
+	total   int64
+	files   []File
+	skipped []string
+}
+
+// walk は、dir 以下のファイルを .gitignore に従って追加します。
+func (c *collector) walk(dir string) error {
+	c.ig.loadAncestors(dir)
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && (d.Name() == ".git" || c.ig.ignored(path, true)) {
+				return filepath.SkipDir
+			}
+			c.ig.load(path)
+			return nil
+		}
+		if !d.Type().IsRegular() || c.ig.ignored(path, false) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return c.add(path, info.Size())
+	})
+}
+
+// add は、path のファイルを上限の範囲内で読み込みます。
+func (c *collector) add(path string, size int64) error {
+	if c.seen[path] {
+		return nil
+	}
+	c.seen[path] = true
+
+	mimeType, err := detect(path)
+	if err != nil {
+		return err
+	}
+	limit := c.limits.MaxFileSize
+	if mediaTypes[mimeType] {
+		limit = c.limits.MaxMediaSize
+	}
+
+	if size > limit {
+		c.skipped = append(c.skipped, fmt.Sprintf("%s: larger than %d bytes", displayPath(path), limit))
+		return nil
+	}
+	if c.total+size > c.limits.MaxTotalSize {
+		c.skipped = append(c.skipped, TotalExceeded(displayPath(path), c.limits.MaxTotalSize))
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !mediaTypes[mimeType] {
+		if isBinary(content) {
+			c.skipped = append(c.skipped, fmt.Sprintf("%s: unsupported binary file (%s)", displayPath(path), mimeType))
+			return nil
+		}
+		mimeType = "text/plain"
+	}
+
+	c.total += size
+	c.files = append(c.files, File{Path: path, MIMEType: mimeType, Content: content})
+	return nil
+}
+
+// detect は、ファイルの先頭512バイトから MIME タイプを判定します。
+func detect(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	mimeType, _, _ := strings.Cut(http.DetectContentType(head[:n]), ";")
+	return mimeType, nil
+}
+
+// isBinary は、先頭8KBにNULバイトを含む内容をバイナリとみなします。
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8<<10)], 0) >= 0
+}
+
+// displayPath は、カレントディレクトリ以下のパスを相対パスで表示します。
+func displayPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
@@ -0,0 +1,131 @@
+package attach
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pngHeader は、PNG として判定される内容の先頭です。
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+// writeFiles は、dir に files（相対パスと内容の対応）を作成します。
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCollect(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string][]byte{
+		"a.go":         []byte("package a\n"),
+		"b.go":         []byte("package b\n"),
+		"sub/c.go":     []byte("package c\n"),
+		"big.txt":      bytes.Repeat([]byte("x"), 101),
+		"bin.dat":      []byte("ELF\x00\x01\x02"),
+		"image.png":    append(pngHeader, bytes.Repeat([]byte{1}, 492)...),
+		"huge.png":     append(pngHeader, bytes.Repeat([]byte{1}, 2000)...),
+		"sub/notes.md": []byte("# notes\n"),
+	})
+	limits := Limits{MaxFileSize: 100, MaxTotalSize: 1 << 20, MaxMediaSize: 1000}
+
+	tests := []struct {
+		name        string
+		patterns    []string
+		limits      Limits
+		want        []string // 読み込んだファイルの dir からの相対パス
+		wantSkipped []string // 飛ばした理由に含まれる文字列
+		wantErr     string
+	}{
+		{name: "glob", patterns: []string{"*.go"}, want: []string{"a.go", "b.go"}},
+		{name: "glob in a directory", patterns: []string{"sub/*.go"}, want: []string{"sub/c.go"}},
+		{name: "directory", patterns: []string{"sub"}, want: []string{"sub/c.go", "sub/notes.md"}},
+		{name: "duplicates", patterns: []string{"a.go", "*.go"}, want: []string{"a.go", "b.go"}},
+		{name: "file size", patterns: []string{"big.txt", "a.go"}, want: []string{"a.go"}, wantSkipped: []string{"big.txt: larger than 100 bytes"}},
+		{name: "media size", patterns: []string{"*.png"}, want: []string{"image.png"}, wantSkipped: []string{"huge.png: larger than 1000 bytes"}},
+		{name: "binary", patterns: []string{"bin.dat"}, wantSkipped: []string{"bin.dat: unsupported binary file"}},
+		{
+			name:        "total size",
+			patterns:    []string{"a.go", "b.go", "sub/c.go"},
+			limits:      Limits{MaxFileSize: 100, MaxTotalSize: 25, MaxMediaSize: 1000},
+			want:        []string{"a.go", "b.go"},
+			wantSkipped: []string{"c.go: total attachment size would exceed 25 bytes"},
+		},
+		{name: "no match", patterns: []string{"*.rs"}, wantErr: "no files match"},
+		{name: "invalid pattern", patterns: []string{"[a"}, wantErr: "invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := tt.limits
+			if l == (Limits{}) {
+				l = limits
+			}
+			patterns := make([]string, len(tt.patterns))
+			for i, p := range tt.patterns {
+				patterns[i] = filepath.Join(dir, p)
+			}
+
+			files, skipped, err := Collect(patterns, l)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, f := range files {
+				rel, _ := filepath.Rel(dir, f.Path)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("files = %v, want %v", got, tt.want)
+			}
+			if len(skipped) != len(tt.wantSkipped) {
+				t.Fatalf("skipped = %q, want %q", skipped, tt.wantSkipped)
+			}
+			for i, want := range tt.wantSkipped {
+				if !strings.Contains(skipped[i], want) {
+					t.Errorf("skipped[%d] = %q, want %q", i, skipped[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectTypes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string][]byte{
+		"a.go":      []byte("package a\n"),
+		"image.png": append(pngHeader, 1, 2, 3),
+	})
+	files, _, err := Collect([]string{filepath.Join(dir, "*")}, Limits{MaxFileSize: 100, MaxTotalSize: 100, MaxMediaSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("files = %+v", files)
+	}
+	if f := files[0]; f.MIMEType != "text/plain" || f.IsMedia() || !strings.Contains(f.Text(), "```\npackage a\n") {
+		t.Errorf("a.go = %s, %q", f.MIMEType, f.Text())
+	}
+	if f := files[1]; f.MIMEType != "image/png" || !f.IsMedia() || !strings.HasSuffix(f.Text(), "(image/png)") {
+		t.Errorf("image.png = %s, %q", f.MIMEType, f.Text())
+	}
+	if got := TotalSize(files); got != int64(len("package a\n")+len(pngHeader)+3) {
+		t.Errorf("TotalSize = %d", got)
+	}
+}
@@ -0,0 +1,141 @@
+package attach
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule は、.gitignore の1行を表現する構造体です。
+type ignoreRule struct {
+	base    string         // .gitignore が置かれたディレクトリ
+	re      *regexp.Regexp // base からの相対パスに対する正規表現
+	negate  bool           // ! で始まる行かどうか
+	dirOnly bool           // / で終わる行かどうか
+}
+
+// ignorer は、複数の .gitignore から読み込んだ規則を保持します。
+// 後に読み込んだ規則ほど優先されます。
+type ignorer struct {
+	rules  []ignoreRule
+	loaded map[string]bool
+}
+
+func newIgnorer() *ignorer {
+	return &ignorer{loaded: map[string]bool{}}
+}
+
+// loadAncestors は、dir からGitリポジトリのルートまでの各ディレクトリにある .gitignore を読み込みます。
+// dir がリポジトリの外にある場合は、dir 自身の .gitignore だけを読み込みます。
+func (ig *ignorer) loadAncestors(dir string) {
+	var dirs []string
+	for d := dir; ; d = filepath.Dir(d) {
+		dirs = append(dirs, d)
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			break
+		}
+		if filepath.Dir(d) == d {
+			dirs = dirs[:1]
+			break
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		ig.load(dirs[i])
+	}
+}
+
+// load は、dir にある .gitignore を読み込みます。ファイルがなければ何もしません。
+func (ig *ignorer) load(dir string) {
+	if ig.loaded[dir] {
+		return
+	}
+	ig.loaded[dir] = true
+
+	f, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: dir}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+
+		// 途中に / を含むパターンは .gitignore の位置を基準にし、
+		// 含まないパターンは任意の深さの名前に一致します。
+		prefix := "^(.*/)?"
+		if strings.Contains(line, "/") {
+			prefix = "^"
+			line = strings.TrimPrefix(line, "/")
+		}
+		re, err := regexp.Compile(prefix + globToRegexp(line) + "$")
+		if err != nil {
+			continue
+		}
+		rule.re = re
+		ig.rules = append(ig.rules, rule)
+	}
+}
+
+// ignored は、path が読み込み済みの規則によって無視されるかどうかを返します。
+func (ig *ignorer) ignored(path string, isDir bool) bool {
+	ignored := false
+	for _, r := range ig.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(r.base, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if r.re.MatchString(filepath.ToSlash(rel)) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// globToRegexp は、.gitignore のグロブパターンを正規表現に変換します。
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			b.WriteString(strings.Replace(glob[i:i+end+1], "[!", "[^", 1))
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
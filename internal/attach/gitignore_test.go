@@ -0,0 +1,192 @@
+package attach
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeGitignore は、dir に content の .gitignore を作成します。
+func writeGitignore(t *testing.T, dir, content string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	type check struct {
+		path  string // .gitignore を置いたディレクトリからの相対パス
+		isDir bool
+		want  bool
+	}
+	tests := []struct {
+		name      string
+		gitignore string
+		checks    []check
+	}{
+		{
+			name:      "name at any depth",
+			gitignore: "*.log\n",
+			checks: []check{
+				{path: "a.log", want: true},
+				{path: "sub/deep/a.log", want: true},
+				{path: "a.go"},
+				{path: "a.log.go"},
+			},
+		},
+		{
+			name:      "comments and blank lines",
+			gitignore: "# *.go\n\n   \nsecret.txt   \n",
+			checks: []check{
+				{path: "main.go"},
+				{path: "secret.txt", want: true},
+			},
+		},
+		{
+			name:      "negation",
+			gitignore: "*.log\n!keep.log\n",
+			checks: []check{
+				{path: "other.log", want: true},
+				{path: "keep.log"},
+				{path: "sub/keep.log"},
+			},
+		},
+		{
+			name:      "later rules win",
+			gitignore: "!keep.log\n*.log\n",
+			checks: []check{
+				{path: "keep.log", want: true},
+			},
+		},
+		{
+			name:      "directory only",
+			gitignore: "build/\n",
+			checks: []check{
+				{path: "build", isDir: true, want: true},
+				{path: "sub/build", isDir: true, want: true},
+				{path: "build"},
+			},
+		},
+		{
+			name:      "anchored with a leading slash",
+			gitignore: "/todo.txt\n",
+			checks: []check{
+				{path: "todo.txt", want: true},
+				{path: "sub/todo.txt"},
+			},
+		},
+		{
+			name:      "anchored with a slash in the middle",
+			gitignore: "src/gen\n",
+			checks: []check{
+				{path: "src/gen", isDir: true, want: true},
+				{path: "lib/src/gen", isDir: true},
+			},
+		},
+		{
+			name:      "leading double star",
+			gitignore: "**/tmp\n",
+			checks: []check{
+				{path: "tmp", isDir: true, want: true},
+				{path: "a/b/tmp", isDir: true, want: true},
+				{path: "a/tmpx", isDir: true},
+			},
+		},
+		{
+			name:      "double star in the middle",
+			gitignore: "docs/**/*.md\n",
+			checks: []check{
+				{path: "docs/a.md", want: true},
+				{path: "docs/x/y/a.md", want: true},
+				{path: "a.md"},
+				{path: "other/docs/a.md"},
+			},
+		},
+		{
+			name:      "trailing double star",
+			gitignore: "logs/**\n",
+			checks: []check{
+				{path: "logs/a.txt", want: true},
+				{path: "logs/x/y/a.txt", want: true},
+				{path: "logs", isDir: true},
+			},
+		},
+		{
+			name:      "single character and classes",
+			gitignore: "file?.txt\nlog[0-9].txt\nout[!a].txt\n",
+			checks: []check{
+				{path: "file1.txt", want: true},
+				{path: "file12.txt"},
+				{path: "log3.txt", want: true},
+				{path: "logx.txt"},
+				{path: "outb.txt", want: true},
+				{path: "outa.txt"},
+			},
+		},
+		{
+			name:      "special characters are literal",
+			gitignore: "a+b.txt\n",
+			checks: []check{
+				{path: "a+b.txt", want: true},
+				{path: "aab.txt"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeGitignore(t, dir, tt.gitignore)
+			ig := newIgnorer()
+			ig.load(dir)
+			for _, c := range tt.checks {
+				if got := ig.ignored(filepath.Join(dir, filepath.FromSlash(c.path)), c.isDir); got != c.want {
+					t.Errorf("ignored(%q, dir=%v) = %v, want %v", c.path, c.isDir, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+// TestLoadAncestors は、リポジトリのルートから下の .gitignore を順に読み込み、
+// 深いディレクトリの規則が優先され、規則は置かれたディレクトリの外には及ばないことを確認します。
+func TestLoadAncestors(t *testing.T) {
+	outside := t.TempDir()
+	writeGitignore(t, outside, "*.go\n")
+	root := filepath.Join(outside, "repo")
+	if err := os.MkdirAll(filepath.Join(root, ".git"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeGitignore(t, root, "*.tmp\n")
+	sub := filepath.Join(root, "sub")
+	writeGitignore(t, sub, "!keep.tmp\n/local.txt\n")
+
+	ig := newIgnorer()
+	ig.loadAncestors(sub)
+	// 二度読み込んでも規則は増えません。
+	ig.loadAncestors(sub)
+	if len(ig.rules) != 3 {
+		t.Errorf("loaded %d rules, want 3 (the .gitignore above the repository is not read)", len(ig.rules))
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{filepath.Join(root, "a.tmp"), true},
+		{filepath.Join(sub, "a.tmp"), true},
+		{filepath.Join(sub, "keep.tmp"), false},
+		{filepath.Join(root, "keep.tmp"), true},
+		{filepath.Join(sub, "local.txt"), true},
+		{filepath.Join(root, "local.txt"), false},
+		{filepath.Join(sub, "main.go"), false},
+	}
+	for _, tt := range tests {
+		if got := ig.ignored(tt.path, false); got != tt.want {
+			t.Errorf("ignored(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
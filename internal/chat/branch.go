@@ -0,0 +1,189 @@
+package chat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kou12345/gollm/internal/history"
+	"github.com/kou12345/gollm/pkg/utils"
+)
+
+// retryLast は、直前のユーザーのメッセージに対する応答を生成し直します。
+// 以前の応答は削除せず、同じメッセージに対する別の枝として残します。
+func (c *Chat) retryLast() {
+	msgs := c.history.Messages
+	i := lastUserIndex(msgs)
+	if i < 0 {
+		fmt.Fprintln(c.out, utils.ErrorColor("There is nothing to retry yet."))
+		return
+	}
+	c.resend(msgs, i, msgs[i])
+}
+
+// editMessage は、現在の枝の n 番目のユーザーのメッセージを text に置き換えて送信し直します。
+// 元のメッセージとそれ以降のやり取りは、別の枝として残ります。
+// args は "<番号> <新しいメッセージ>" の形式で、空の場合は編集できるメッセージを番号付きで一覧表示します。
+func (c *Chat) editMessage(args string) {
+	msgs := c.history.Messages
+	var users []int
+	for i, msg := range msgs {
+		if msg.Role == "user" {
+			users = append(users, i)
+		}
+	}
+	if len(users) == 0 {
+		fmt.Fprintln(c.out, utils.ErrorColor("There is nothing to edit yet."))
+		return
+	}
+
+	if args == "" {
+		for n, i := range users {
+			fmt.Fprintf(c.out, "  %d: %s\n", n+1, snippet(msgs[i].Content))
+		}
+		fmt.Fprintln(c.out, "Use /edit <number> <new message> to edit and resend a message.")
+		return
+	}
+
+	number, text, _ := strings.Cut(args, " ")
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > len(users) {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Message number must be between 1 and %d.", len(users))))
+		return
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		fmt.Fprintln(c.out, utils.ErrorColor("Usage: /edit <number> <new message>"))
+		return
+	}
+
+	i := users[n-1]
+	edited := msgs[i]
+	edited.ID = 0
+	edited.Content = text
+	c.resend(msgs, i, edited)
+}
+
+// resend は、msgs[i] の代わりに user を送信し、得られた応答と共に msgs[i-1] の子として保存します。
+// user が msgs[i] と同じメッセージの場合は、応答だけを msgs[i] の新しい子として保存します。
+// 失敗した場合は、履歴を元の枝に戻します。
+func (c *Chat) resend(msgs []history.ChatMessage, i int, user history.ChatMessage) {
+	parent := msgs[:i:i]
+	c.history.Messages = parent
+	r, err := c.generate(c.toContents([]history.ChatMessage{user})[0].Parts...)
+	if err != nil {
+		c.history.Messages = msgs
+		c.showError(err)
+		return
+	}
+
+	if user.ID != 0 {
+		c.history.Messages = append(parent, user)
+	} else if err := c.saveMessage("user", user.Content, r.promptTokens, history.Usage{}, user.Attachments); err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to save message: %v", err)))
+	}
+	if err := c.appendMessage("assistant", r.text, r.tokens, c.usage(r.usage)); err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to save message: %v", err)))
+	}
+	c.show(r.text)
+}
+
+// switchBranch は、現在の枝で最も新しい分岐点にある兄弟の枝を一覧表示するか、args[0] 番目の枝に切り替えます。
+func (c *Chat) switchBranch(args []string) {
+	msgs := c.history.Messages
+	var siblings []history.ChatMessage
+	for i := len(msgs) - 1; i >= 0 && len(siblings) < 2; i-- {
+		var err error
+		if siblings, err = c.store.Siblings(c.history.RoomID, msgs[i]); err != nil {
+			fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to load branches: %v", err)))
+			return
+		}
+	}
+	if len(siblings) < 2 {
+		fmt.Fprintln(c.out, "This conversation has no other branches.")
+		return
+	}
+
+	if len(args) == 0 {
+		for n, s := range siblings {
+			marker := " "
+			if onPath(msgs, s.ID) {
+				marker = "*"
+			}
+			fmt.Fprintf(c.out, "%s %d: [%s] %s\n", marker, n+1, s.Role, snippet(s.Content))
+		}
+		fmt.Fprintln(c.out, "Use /branch <number> to switch to another branch.")
+		return
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(siblings) {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Branch number must be between 1 and %d.", len(siblings))))
+		return
+	}
+	head, err := c.store.Leaf(siblings[n-1].ID)
+	if err == nil {
+		err = c.Checkout(head)
+	}
+	if err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to switch branch: %v", err)))
+		return
+	}
+	fmt.Fprintln(c.out, utils.SuccessColor(fmt.Sprintf("Switched to branch %d.", n)))
+	if last := c.history.Messages; len(last) > 0 && last[len(last)-1].Role == "assistant" {
+		c.show(last[len(last)-1].Content)
+	}
+}
+
+// Checkout は、head を末端とする枝を読み込み、チャットルームで選択中の枝として保存します。
+// 以降のメッセージは、この枝の続きとして送信します。
+func (c *Chat) Checkout(head int64) error {
+	roomID := c.history.RoomID
+	h, err := c.store.LoadPath(roomID, head)
+	if err != nil {
+		return err
+	}
+	summary, err := c.store.LatestSummary(roomID, head)
+	if err != nil {
+		return err
+	}
+	if err := c.store.SetHead(roomID, head); err != nil {
+		return err
+	}
+
+	c.history, c.summary = h, summary
+	c.usedTokens = 0
+	for _, msg := range h.Messages {
+		c.usedTokens += msg.Tokens
+	}
+	return nil
+}
+
+// lastUserIndex は、msgs の最後のユーザーのメッセージの位置を返します。見つからない場合は -1 を返します。
+func lastUserIndex(msgs []history.ChatMessage) int {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == "user" {
+			return i
+		}
+	}
+	return -1
+}
+
+// onPath は、id のメッセージが msgs に含まれるかを返します。
+func onPath(msgs []history.ChatMessage, id int64) bool {
+	for _, msg := range msgs {
+		if msg.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// snippet は、一覧表示のためにメッセージの最初の行を短くしたものを返します。
+func snippet(content string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	if r := []rune(line); len(r) > 60 {
+		return string(r[:60]) + "…"
+	}
+	return line
+}
@@ -0,0 +1,121 @@
+package chat
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kou12345/gollm/internal/history"
+)
+
+// pathContents は、現在の枝のメッセージの内容を " | " で区切って返します。
+func pathContents(h *history.ChatHistory) string {
+	var c []string
+	for _, msg := range h.Messages {
+		c = append(c, msg.Content)
+	}
+	return strings.Join(c, " | ")
+}
+
+// TestRetryEditBranch は、/retry と /edit が元のやり取りを別の枝として残し、/branch で枝を切り替えられ、
+// 選択中の枝がルームを開き直しても保たれることを確認します。
+func TestRetryEditBranch(t *testing.T) {
+	store := openStore(t)
+	c, _ := newFakeChat(t, store, "default", StrategyKeepPinned)
+	var out bytes.Buffer
+	c.out = &out
+
+	for _, cmd := range []string{"/retry", "/edit", "/branch"} {
+		out.Reset()
+		c.handleCommand(cmd)
+		if !strings.Contains(out.String(), "nothing to") && !strings.Contains(out.String(), "no other branches") {
+			t.Errorf("%s in an empty room printed %q", cmd, out.String())
+		}
+	}
+
+	for _, input := range []string{"one", "two"} {
+		if _, err := c.Ask(input); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := c.history.Messages[3]
+
+	c.handleCommand("/retry")
+	msgs := c.history.Messages
+	if len(msgs) != 4 || msgs[3].ID == first.ID || msgs[3].ParentID != first.ParentID {
+		t.Fatalf("after /retry: %+v", msgs)
+	}
+	if siblings, err := store.Siblings(c.history.RoomID, msgs[3]); err != nil || len(siblings) != 2 {
+		t.Errorf("replies to the retried message = %v, %v", siblings, err)
+	}
+
+	out.Reset()
+	c.handleCommand("/edit")
+	if got := out.String(); !strings.Contains(got, "1: one") || !strings.Contains(got, "2: two") {
+		t.Errorf("/edit printed %q", got)
+	}
+	out.Reset()
+	c.handleCommand("/edit 3 three")
+	if !strings.Contains(out.String(), "Message number must be between 1 and 2.") {
+		t.Errorf("/edit 3 printed %q", out.String())
+	}
+	c.handleCommand("/edit 1 uno")
+	if got := pathContents(c.history); got != "uno | Echo: uno" {
+		t.Fatalf("after /edit: %s", got)
+	}
+
+	out.Reset()
+	c.handleCommand("/branch")
+	if got := out.String(); !strings.Contains(got, "  1: [user] one") || !strings.Contains(got, "* 2: [user] uno") {
+		t.Errorf("/branch printed %q", got)
+	}
+	out.Reset()
+	c.handleCommand("/branch 3")
+	if !strings.Contains(out.String(), "Branch number must be between 1 and 2.") {
+		t.Errorf("/branch 3 printed %q", out.String())
+	}
+
+	// 元の枝に戻ると、その枝の最新のやり取り（/retry の応答）を選択します。
+	c.handleCommand("/branch 1")
+	if got := pathContents(c.history); got != "one | Echo: one | two | Echo: two" || c.history.Head() != msgs[3].ID {
+		t.Errorf("after /branch 1: %s (head %d, want %d)", got, c.history.Head(), msgs[3].ID)
+	}
+	var used int32
+	for _, msg := range c.history.Messages {
+		used += msg.Tokens
+	}
+	if c.usedTokens != used {
+		t.Errorf("usedTokens = %d, want %d", c.usedTokens, used)
+	}
+
+	reopened, _ := newFakeChat(t, store, "default", StrategyKeepPinned)
+	if got := pathContents(reopened.history); got != "one | Echo: one | two | Echo: two" {
+		t.Errorf("reopened branch = %s", got)
+	}
+}
+
+// TestCheckout は、Checkout で切り替えた枝に次のメッセージを続けることを確認します。
+func TestCheckout(t *testing.T) {
+	store := openStore(t)
+	c, _ := newFakeChat(t, store, "default", StrategyKeepPinned)
+	for _, input := range []string{"one", "two"} {
+		if _, err := c.Ask(input); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reply := c.history.Messages[1]
+
+	if err := c.Checkout(reply.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Ask("deux"); err != nil {
+		t.Fatal(err)
+	}
+	if got := pathContents(c.history); got != "one | Echo: one | deux | Echo: deux" {
+		t.Errorf("after Checkout: %s", got)
+	}
+	siblings, err := store.Siblings(c.history.RoomID, c.history.Messages[2])
+	if err != nil || len(siblings) != 2 || siblings[0].Content != "two" {
+		t.Errorf("siblings = %v, %v, want the original turn kept", siblings, err)
+	}
+}
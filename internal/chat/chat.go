@@ -4,56 +4,237 @@ package chat
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/google/generative-ai-go/genai"
+	"github.com/kou12345/gollm/internal/attach"
 	"github.com/kou12345/gollm/internal/history"
+	"github.com/kou12345/gollm/internal/jsonschema"
 	"github.com/kou12345/gollm/internal/render"
+	"github.com/kou12345/gollm/internal/secret"
+	"github.com/kou12345/gollm/internal/tools"
 	"github.com/kou12345/gollm/pkg/utils"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
+// Options は、Chat の動作を設定する構造体です。
+type Options struct {
+	Model     string   // 使用するモデル名
+	Strategy  Strategy // コンテキストウィンドウの上限に近づいたときの戦略
+	MaxTokens int32    // 入力トークン数の上限（0 の場合はモデルの上限を使用）
+	Threshold float64  // 上限に対してこの割合を超えたら戦略を適用する
+
+	Store   *history.Store // 履歴の保存先
+	Room    string         // 会話するチャットルームの名前
+	Project string         // チャットルームが属するプロジェクトのルートのパス（空の場合はどのプロジェクトにも属さない）
+
+	Instructions string // 全てのリクエストでモデルに渡す指示（プロジェクトの GOLLM.md など。空の場合は渡さない）
+
+	AttachLimits attach.Limits // 添付ファイルのサイズの上限
+
+	Tools   *tools.Registry // モデルから呼び出せるツール（nil の場合はツールを使用しない）
+	Confirm ConfirmFunc     // 副作用のあるツールの実行を確認する関数（nil の場合は端末で確認する）
+
+	Backends BackendFunc // /compare で別のバックエンドに接続する関数（nil の場合はセッションのバックエンドだけを使用）
+	Pick     PickFunc    // /compare で応答を選ばせる関数（nil の場合は /compare を使用できない）
+
+	TemplatesDir string // /template で使用するテンプレートのディレクトリ
+
+	JSONSchema map[string]any // 応答を JSON に限定するスキーマ（nil の場合はルームの設定を使用）
+	Fresh      bool           // ルームの過去のメッセージをコンテキストに含めないかどうか
+	Output     io.Writer      // 応答以外の表示の出力先（nil の場合は標準出力）
+}
+
 // Chat は、AIとのチャットセッションを管理する構造体です。
 type Chat struct {
-	client  *genai.Client
-	model   *genai.GenerativeModel
-	cs      *genai.ChatSession
-	history *history.ChatHistory
-	scanner *bufio.Scanner
+	client     *genai.Client
+	model      *genai.GenerativeModel
+	cs         *genai.ChatSession
+	history    *history.ChatHistory
+	store      *history.Store
+	summary    *history.Summary // StrategySummarise で作成した最新の要約
+	pending    []attach.File    // 次のメッセージに添付するファイル
+	confirm    ConfirmFunc
+	decls      []*genai.FunctionDeclaration // モデルに渡すツールの宣言
+	schema     map[string]any               // 応答の JSON Schema（nil の場合は通常の応答）
+	out        io.Writer
+	onText     func(string) // 応答のテキストを受信するたびに呼び出す関数（AskStream を参照）
+	scanner    *bufio.Scanner
+	opts       Options
+	tokenLimit int32 // 入力トークン数の上限
+	usedTokens int32 // 直前のやり取りで使用したトークン数
+
+	mu sync.Mutex // Exchange の記録を直列化します
 }
 
 // NewChat は、新しいChatインスタンスを作成し、初期化します。
-// opts は、genai.NewClientに渡されるオプションです。
+// opts は genai.NewClient に渡されるオプションです。
 // エラーが発生した場合は、nilとエラーを返します。
-func NewChat(opts ...option.ClientOption) (*Chat, error) {
+func NewChat(o Options, opts ...option.ClientOption) (*Chat, error) {
+	switch o.Strategy {
+	case StrategyDropOldest, StrategyKeepPinned, StrategySummarise:
+	default:
+		return nil, fmt.Errorf("unknown context strategy %q", o.Strategy)
+	}
+	if o.Threshold <= 0 || o.Threshold > 1 {
+		return nil, fmt.Errorf("context threshold %v must be greater than 0 and at most 1", o.Threshold)
+	}
+
 	ctx := context.Background()
 	client, err := genai.NewClient(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	model := client.GenerativeModel("gemini-1.5-flash")
-	cs := model.StartChat()
-	history := history.LoadChatHistory()
+	room, err := o.Store.OpenProjectRoom(o.Project, o.Room)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	h := &history.ChatHistory{RoomID: room.ID, Messages: []history.ChatMessage{}}
+	var summary *history.Summary
+	if !o.Fresh {
+		if h, err = o.Store.Load(room.ID); err != nil {
+			client.Close()
+			return nil, err
+		}
+		if summary, err = o.Store.LatestSummary(room.ID, h.Head()); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	model := client.GenerativeModel(o.Model)
+	c := &Chat{
+		client:     client,
+		model:      model,
+		cs:         model.StartChat(),
+		history:    h,
+		store:      o.Store,
+		summary:    summary,
+		out:        o.Output,
+		scanner:    bufio.NewScanner(os.Stdin),
+		opts:       o,
+		tokenLimit: o.MaxTokens,
+		confirm:    o.Confirm,
+	}
+	if c.out == nil {
+		c.out = os.Stdout
+	}
+	if c.confirm == nil {
+		c.confirm = confirmOnTerminal(c.out, c.scanner)
+	}
+	if o.Tools != nil {
+		c.decls = functionDeclarations(c.out, o.Tools)
+	}
+
+	schema := o.JSONSchema
+	if schema == nil && room.JSONSchema != "" {
+		if schema, err = jsonschema.Parse([]byte(room.JSONSchema)); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("room %s: %w", room.Name, err)
+		}
+	}
+	c.setSchema(schema)
+
+	if c.tokenLimit == 0 {
+		c.tokenLimit = defaultTokenLimit
+		if info, err := model.Info(ctx); err == nil && info.InputTokenLimit > 0 {
+			c.tokenLimit = info.InputTokenLimit
+		}
+	}
+
+	if err := c.backfillTokens(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	for _, msg := range c.history.Messages {
+		c.usedTokens += msg.Tokens
+	}
+
+	return c, nil
+}
 
-	for _, msg := range history.Messages {
-		if msg.Role == "user" {
-			cs.History = append(cs.History, &genai.Content{Role: "user", Parts: []genai.Part{genai.Text(msg.Content)}})
-		} else {
-			cs.History = append(cs.History, &genai.Content{Role: "model", Parts: []genai.Part{genai.Text(msg.Content)}})
+// backfillTokens は、トークン数が記録されていないメッセージのトークン数を数えて保存します。
+// 以前のバージョンで保存された履歴や、使用量を返さないバックエンドの応答が対象です。
+// メッセージごとに数えるとルームを開くたびに多くのリクエストを送信するため、まとめて1回で数え、
+// 文字数の比で各メッセージに割り振ります。保存する値は1以上にして、次に開いたときに数え直さないようにします。
+func (c *Chat) backfillTokens(ctx context.Context) error {
+	var (
+		missing []*history.ChatMessage
+		parts   []genai.Part
+		chars   int
+	)
+	for i := range c.history.Messages {
+		msg := &c.history.Messages[i]
+		if msg.Tokens != 0 {
+			continue
+		}
+		missing = append(missing, msg)
+		if msg.Content != "" {
+			parts = append(parts, genai.Text(msg.Content))
+			chars += utf8.RuneCountInString(msg.Content)
 		}
 	}
+	if len(missing) == 0 {
+		return nil
+	}
 
-	return &Chat{
-		client:  client,
-		model:   model,
-		cs:      cs,
-		history: history,
-		scanner: bufio.NewScanner(os.Stdin),
-	}, nil
+	var total int32
+	if len(parts) > 0 {
+		total = c.countTokens(ctx, parts...)
+	}
+	for _, msg := range missing {
+		msg.Tokens = 1
+		if chars > 0 {
+			msg.Tokens = max(1, int32(int64(total)*int64(utf8.RuneCountInString(msg.Content))/int64(chars)))
+		}
+		if err := c.store.UpdateMessage(*msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendMessage は、メッセージを履歴に追加してデータベースに保存します。
+// usage はメッセージを生成したリクエストのトークン使用量、files はメッセージに添付したファイルです。
+// テキストの添付ファイルはパスだけを、画像やPDFは内容も保存します。
+func (c *Chat) appendMessage(role, content string, tokens int32, usage history.Usage, files ...attach.File) error {
+	var attachments []history.Attachment
+	for _, f := range files {
+		a := history.Attachment{Path: f.Path, MIMEType: f.MIMEType}
+		if f.IsMedia() {
+			a.Data = f.Content
+		}
+		attachments = append(attachments, a)
+	}
+	return c.saveMessage(role, content, tokens, usage, attachments)
+}
+
+// saveMessage は、保存済みの形式の添付ファイルと共にメッセージを履歴に追加してデータベースに保存します。
+func (c *Chat) saveMessage(role, content string, tokens int32, usage history.Usage, attachments []history.Attachment) error {
+	msg := c.history.AddMessage(role, content, tokens)
+	msg.Usage = usage
+	msg.Attachments = attachments
+	return c.store.SaveMessage(c.history.RoomID, msg)
+}
+
+// usage は、バックエンドから返されたトークン使用量を履歴に記録する形式に変換します。
+func (c *Chat) usage(m *genai.UsageMetadata) history.Usage {
+	u := history.Usage{Model: c.opts.Model}
+	if m != nil {
+		u.PromptTokens = m.PromptTokenCount
+		u.CompletionTokens = m.CandidatesTokenCount
+	}
+	return u
 }
 
 // Close は、Chatインスタンスに関連するリソースを解放します。
@@ -63,70 +244,273 @@ func (c *Chat) Close() {
 
 // Run は、チャットセッションを開始し、ユーザーの入力を処理します。
 // ユーザーが "exit" と入力するまで、または入力エラーが発生するまで継続します。
+// "/" で始まる入力はコマンドとして扱います（handleCommand を参照）。
 func (c *Chat) Run() {
 	for {
-		fmt.Print(utils.UserColor("You: "))
+		fmt.Fprint(c.out, utils.UserColor(fmt.Sprintf("You [%s/%s]: ", formatTokens(c.usedTokens), formatTokens(c.tokenLimit))))
 		if !c.scanner.Scan() {
 			break
 		}
 		userInput := c.scanner.Text()
 
 		if strings.ToLower(userInput) == "exit" {
-			fmt.Println(utils.SuccessColor("Exiting chat..."))
+			fmt.Fprintln(c.out, utils.SuccessColor("Exiting chat..."))
 			break
 		}
+		if strings.HasPrefix(userInput, "/") {
+			c.handleCommand(userInput)
+			continue
+		}
 
-		c.history.AddMessage("user", userInput)
+		c.respond(userInput)
+	}
 
-		response := c.sendMessage(userInput)
+	if err := c.scanner.Err(); err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Error occurred while reading input: %v\nExiting program.", err)))
+	}
+}
 
-		if response != "" {
-			fmt.Print(utils.AIColor("Gemini: "))
-			fmt.Println(render.RenderMarkdown(response))
+// respond は、input をモデルに送信し、応答を表示します。
+func (c *Chat) respond(input string) {
+	response, err := c.Ask(input)
+	if err != nil {
+		c.showError(err)
+		return
+	}
+	c.show(response)
+}
+
+// show は、モデルの応答を表示します。JSON Schema が設定されている場合は、Markdown として描画しません。
+func (c *Chat) show(response string) {
+	fmt.Fprint(c.out, utils.AIColor("Gemini: "))
+	if c.schema != nil {
+		fmt.Fprintln(c.out, response)
+	} else {
+		fmt.Fprintln(c.out, render.RenderMarkdown(response))
+	}
+}
+
+// showError は、バックエンドの呼び出しに失敗した理由を表示します。
+func (c *Chat) showError(err error) {
+	fmt.Fprintln(c.out, utils.ErrorColor(secret.Redact(fmt.Sprintf("Gemini: %v", err))))
+}
+
+// Ask は、input と添付予定のファイルをモデルに送信し、やり取りを履歴に保存して応答を返します。
+// JSON Schema が設定されている場合は、検証に成功した応答を余分な空白のない JSON として返します。
+// バックエンドの呼び出しに失敗した場合は、理由を分類した *BackendError を返します。
+func (c *Chat) Ask(input string) (string, error) {
+	files := c.pending
+	r, err := c.generate(append(attachmentParts(files), genai.Text(input))...)
+	if err != nil {
+		return "", err
+	}
+
+	c.pending = nil
+	if err := c.appendMessage("user", input, r.promptTokens, history.Usage{}, files...); err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to save message: %v", err)))
+	}
+	if err := c.appendMessage("assistant", r.text, r.tokens, c.usage(r.usage)); err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to save message: %v", err)))
+	}
+	return r.text, nil
+}
+
+// AskStream は、Ask と同様に input を送信しますが、応答のテキストを受信するたびに onText を呼び出します。
+// テキストを渡した後は再試行しません。JSON Schema を満たさずに再送信した場合は onText に破棄された応答も渡されるため、
+// 最終的な応答には戻り値を使用してください。
+func (c *Chat) AskStream(input string, onText func(string)) (string, error) {
+	c.onText = onText
+	defer func() { c.onText = nil }()
+	return c.Ask(input)
+}
 
-			c.history.AddMessage("assistant", response)
-			history.SaveChatHistory(*c.history)
-		} else {
-			fmt.Println(utils.ErrorColor("Gemini: No response received. The AI model might be experiencing issues."))
+// reply は、generate で得られた応答です。
+type reply struct {
+	text         string
+	promptTokens int32 // 送信したメッセージのトークン数
+	tokens       int32 // 応答のトークン数
+	usage        *genai.UsageMetadata
+}
+
+// generate は、現在の履歴をコンテキストとして parts を送信し、応答を返します。
+// 履歴への追加は呼び出し側で行います。
+func (c *Chat) generate(parts ...genai.Part) (*reply, error) {
+	ctx := context.Background()
+	r := &reply{promptTokens: c.countTokens(ctx, parts...)}
+	c.cs.History = c.contextHistory(ctx, r.promptTokens)
+
+	var err error
+	if r.text, r.usage, err = c.sendMessage(parts...); err != nil {
+		return nil, err
+	}
+	if c.schema != nil {
+		if r.text, r.usage, err = c.conform(r.text, r.usage); err != nil {
+			return nil, err
 		}
 	}
 
-	if err := c.scanner.Err(); err != nil {
-		fmt.Println(utils.ErrorColor(fmt.Sprintf("Error occurred while reading input: %v\nExiting program.", err)))
+	r.tokens = c.countTokens(ctx, genai.Text(r.text))
+	if r.usage != nil {
+		c.usedTokens = r.usage.TotalTokenCount
+		r.tokens = r.usage.CandidatesTokenCount
 	}
+	return r, nil
 }
 
 // sendMessage は、指定されたメッセージをAIモデルに送信し、応答を取得します。
 // 応答はストリーミング形式で受信され、全ての応答を結合して返します。
-// エラーが発生した場合や応答が空の場合は、空文字列を返します。
-func (c *Chat) sendMessage(message string) string {
+// モデルが関数呼び出しを要求した場合はツールを実行して結果を返し、テキストの応答が得られるまで続けます。
+// 応答にトークンの使用量が含まれていた場合は、全てのリクエストの合計を返します。
+// 応答が途中で打ち切られた場合は理由を表示し、応答が空の場合はその理由をエラーとして返します。
+func (c *Chat) sendMessage(parts ...genai.Part) (string, *genai.UsageMetadata, error) {
 	ctx := context.Background()
-	prompt := genai.Text(message)
-	iter := c.cs.SendMessageStream(ctx, prompt)
 
-	var fullResponse string
-	fmt.Print(utils.AIColor("Gemini: "))
+	var (
+		fullResponse string
+		usage        *genai.UsageMetadata
+	)
+	fmt.Fprint(c.out, utils.AIColor("Gemini: "))
+	defer fmt.Fprintln(c.out)
 
-	for {
-		resp, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
+	for round := 0; ; round++ {
+		r, err := c.stream(ctx, parts...)
 		if err != nil {
-			fmt.Println(utils.ErrorColor(fmt.Sprintf("Error occurred while receiving response: %v", err)))
-			break
+			return "", usage, err
 		}
+		fullResponse += r.text
+		usage = addUsage(usage, r.usage)
 
-		if len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
-			for _, part := range resp.Candidates[0].Content.Parts {
-				partContent := fmt.Sprint(part)
-				fullResponse += partContent
-				// Uncomment the following line to enable streaming output
-				// fmt.Print(partContent)
+		if len(r.calls) == 0 {
+			if fullResponse == "" {
+				return "", usage, emptyResponseError(r)
 			}
+			if r.finish != genai.FinishReasonStop && r.finish != genai.FinishReasonUnspecified {
+				fmt.Fprintln(c.out, utils.ErrorColor("The response was cut off: finish reason "+enumName(r.finish.String(), "FinishReason")))
+			}
+			return fullResponse, usage, nil
+		}
+		if round == maxToolRounds {
+			fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Stopped after %d rounds of tool calls.", maxToolRounds)))
+			return fullResponse, usage, nil
 		}
+		fmt.Fprintln(c.out)
+		parts = c.runTools(ctx, r.calls)
 	}
+}
 
-	fmt.Println()
-	return fullResponse
+// streamResult は、1回のリクエストに対するストリーミングの応答をまとめたものです。
+type streamResult struct {
+	text    string
+	calls   []genai.FunctionCall
+	usage   *genai.UsageMetadata
+	finish  genai.FinishReason
+	ratings []*genai.SafetyRating
+}
+
+// stream は、parts をチャットセッションに送信し、ストリーミングで受信した応答をまとめて返します。
+func (c *Chat) stream(ctx context.Context, parts ...genai.Part) (streamResult, error) {
+	return c.streamSession(ctx, c.cs, c.onText, parts...)
+}
+
+// streamSession は、parts を cs に送信し、ストリーミングで受信した応答をまとめて返します。
+// onText が nil でない場合は、テキストを受信するたびに呼び出します。
+// 再試行できるエラーの場合は、セッションの履歴を送信前に戻してからリクエスト全体をやり直します。
+// ただし、onText に渡したテキストは取り消せないため、その後のエラーでは再試行しません。
+func (c *Chat) streamSession(ctx context.Context, cs *genai.ChatSession, onText func(string), parts ...genai.Part) (streamResult, error) {
+	n := len(cs.History)
+	var (
+		r         streamResult
+		streamErr error
+	)
+	err := c.withRetry(ctx, func() error {
+		cs.History = cs.History[:n]
+		r = streamResult{}
+
+		iter := cs.SendMessageStream(ctx, parts...)
+		for received := false; ; received = true {
+			resp, err := iter.Next()
+			if err == iterator.Done || (received && isStreamEnd(err)) {
+				return nil
+			}
+			if err != nil {
+				if r.text != "" && onText != nil {
+					streamErr = err
+					return nil
+				}
+				return err
+			}
+
+			if resp.UsageMetadata != nil {
+				r.usage = resp.UsageMetadata
+			}
+			if len(resp.Candidates) == 0 {
+				continue
+			}
+			cand := resp.Candidates[0]
+			if cand.FinishReason != genai.FinishReasonUnspecified {
+				r.finish = cand.FinishReason
+			}
+			if len(cand.SafetyRatings) > 0 {
+				r.ratings = cand.SafetyRatings
+			}
+			if cand.Content == nil {
+				continue
+			}
+			for _, part := range cand.Content.Parts {
+				switch p := part.(type) {
+				case genai.Text:
+					r.text += string(p)
+					if onText != nil {
+						onText(string(p))
+					}
+				case genai.FunctionCall:
+					r.calls = append(r.calls, p)
+				}
+			}
+		}
+	})
+	if err == nil && streamErr != nil {
+		err = classify(streamErr)
+	}
+	if err != nil {
+		cs.History = cs.History[:n]
+	}
+	return r, err
+}
+
+// isStreamEnd は、err が応答のストリームの終わりを構文エラーとして報告したものかを返します。
+// gax-go は応答の配列の閉じ括弧で io.EOF を得るために encoding/json の v1 の実装の挙動に頼っており、
+// v2 の実装で動作する encoding/json（GOEXPERIMENT=jsonv2）では最後の応答の後に構文エラーになります。
+func isStreamEnd(err error) bool {
+	var se *json.SyntaxError
+	return errors.As(err, &se) && strings.Contains(se.Error(), "']'")
+}
+
+// emptyResponseError は、モデルがテキストを返さなかった理由を説明するエラーを作成します。
+func emptyResponseError(r streamResult) error {
+	msg := "the model returned an empty response"
+	if r.finish != genai.FinishReasonUnspecified {
+		msg += " (finish reason: " + enumName(r.finish.String(), "FinishReason")
+		if ratings := formatRatings(r.ratings); ratings != "" {
+			msg += "; " + ratings
+		}
+		msg += ")"
+	}
+	return errors.New(msg)
+}
+
+// addUsage は、複数のリクエストのトークン使用量を合計します。
+// TotalTokenCount は、コンテキストの使用量を表すため最後のリクエストの値を使用します。
+func addUsage(total, u *genai.UsageMetadata) *genai.UsageMetadata {
+	if u == nil {
+		return total
+	}
+	if total == nil {
+		copied := *u
+		return &copied
+	}
+	total.PromptTokenCount += u.PromptTokenCount
+	total.CandidatesTokenCount += u.CandidatesTokenCount
+	total.TotalTokenCount = u.TotalTokenCount
+	return total
 }
@@ -0,0 +1,295 @@
+package chat
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kou12345/gollm/internal/attach"
+	"github.com/kou12345/gollm/internal/history"
+	"github.com/kou12345/gollm/internal/httprecord"
+	"google.golang.org/api/option"
+)
+
+// testModel は、フィクスチャを記録したモデルです。
+const testModel = "gemini-1.5-flash"
+
+// recordEnv が設定されている場合、テストは GEMINI_API_KEY で実際の API に送信してフィクスチャを記録し直します。
+const recordEnv = "GOLLM_RECORD"
+
+// apiKeyTransport は、記録するリクエストに API キーを付けて送信します。
+// option.WithHTTPClient を使用すると、genai はリクエストに API キーを付けないためです。
+type apiKeyTransport struct{}
+
+func (apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("x-goog-api-key", os.Getenv("GEMINI_API_KEY"))
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// recordBase は、フィクスチャを記録するときにリクエストを送信する Transport です。
+var recordBase http.RoundTripper = apiKeyTransport{}
+
+// newRecorder は、testdata/<name>.json を再生する Recorder を作成します。
+// テストの終了時に、使われなかった記録があれば失敗にします。
+func newRecorder(t *testing.T, name string) *httprecord.Recorder {
+	t.Helper()
+	mode := httprecord.ModeReplay
+	if os.Getenv(recordEnv) != "" {
+		mode = httprecord.ModeRecord
+	}
+	rec, err := httprecord.New(filepath.Join("testdata", name+".json"), mode, recordBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := rec.Close(); err != nil {
+			t.Error(err)
+		}
+		for _, req := range rec.Unused() {
+			t.Errorf("recorded request was not sent: %s %s %s", req.Method, req.URL, req.Body)
+		}
+	})
+	return rec
+}
+
+// openStore は、一時的なデータベースを開きます。
+func openStore(t *testing.T) *history.Store {
+	t.Helper()
+	store, err := history.OpenStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// newTestChat は、rec を通してバックエンドと通信する Chat を作成します。
+func newTestChat(t *testing.T, rec *httprecord.Recorder, store *history.Store, room string) (*Chat, *bytes.Buffer) {
+	t.Helper()
+	var out bytes.Buffer
+	c, err := NewChat(Options{
+		Model:     testModel,
+		Strategy:  StrategyKeepPinned,
+		Threshold: 0.8,
+		Store:     store,
+		Room:      room,
+		Output:    &out,
+	}, option.WithHTTPClient(rec.Client()), option.WithAPIKey("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c, &out
+}
+
+func TestNewChat(t *testing.T) {
+	rec := newRecorder(t, "new_chat")
+	c, _ := newTestChat(t, rec, openStore(t), "default")
+
+	if c.tokenLimit != 1048576 {
+		t.Errorf("tokenLimit = %d, want the model's input token limit", c.tokenLimit)
+	}
+	if len(c.history.Messages) != 0 {
+		t.Errorf("history = %+v, want empty", c.history.Messages)
+	}
+}
+
+func TestAskStreaming(t *testing.T) {
+	rec := newRecorder(t, "ask_streaming")
+	store := openStore(t)
+	c, _ := newTestChat(t, rec, store, "default")
+
+	var streamed []string
+	reply, err := c.AskStream("Say hello.", func(text string) { streamed = append(streamed, text) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "Hello! How can I help you today?" {
+		t.Errorf("reply = %q", reply)
+	}
+	if len(streamed) < 2 || strings.Join(streamed, "") != reply {
+		t.Errorf("streamed chunks = %q", streamed)
+	}
+
+	msgs := c.history.Messages
+	if len(msgs) != 2 || msgs[0].Role != "user" || msgs[1].Role != "assistant" || msgs[1].ParentID != msgs[0].ID {
+		t.Fatalf("history = %+v", msgs)
+	}
+	u := msgs[1].Usage
+	if u.Model != testModel || u.PromptTokens != 3 || u.CompletionTokens != 9 || msgs[1].Tokens != 9 {
+		t.Errorf("assistant message = %+v", msgs[1])
+	}
+	if c.usedTokens != 12 {
+		t.Errorf("usedTokens = %d, want 12", c.usedTokens)
+	}
+}
+
+// TestHistoryReconstruction は、保存された履歴を読み込んだ Chat が、以前のやり取りをコンテキストとして送信することを確認します。
+// 送信した本文がフィクスチャと一致しない場合、再生は失敗します。
+func TestHistoryReconstruction(t *testing.T) {
+	rec := newRecorder(t, "history_reconstruction")
+	store := openStore(t)
+
+	first, _ := newTestChat(t, rec, store, "names")
+	if _, err := first.Ask("My name is Kou."); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	second, _ := newTestChat(t, rec, store, "names")
+	if got := len(second.history.Messages); got != 2 {
+		t.Fatalf("reloaded %d messages, want 2", got)
+	}
+	reply, err := second.Ask("What is my name?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(reply, "Kou") {
+		t.Errorf("reply = %q, want it to mention the name from the earlier exchange", reply)
+	}
+
+	h, err := store.Load(second.history.RoomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roles []string
+	for _, m := range h.Messages {
+		roles = append(roles, m.Role)
+	}
+	if got := strings.Join(roles, ","); got != "user,assistant,user,assistant" {
+		t.Errorf("stored roles = %s", got)
+	}
+}
+
+func TestAskRetriesRateLimit(t *testing.T) {
+	rec := newRecorder(t, "retry_rate_limit")
+	c, out := newTestChat(t, rec, openStore(t), "default")
+	waits := stubSleep(t)
+
+	reply, err := c.Ask("Say hi.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "Hello!" {
+		t.Errorf("reply = %q", reply)
+	}
+	if !strings.Contains(out.String(), "Retrying in") || len(*waits) != 1 {
+		t.Errorf("output = %q, waits = %v, want one retry", out.String(), *waits)
+	}
+}
+
+func TestRoomCommand(t *testing.T) {
+	rec := newRecorder(t, "new_chat")
+	store := openStore(t)
+	c, out := newTestChat(t, rec, store, "default")
+
+	for _, cmd := range []string{"/room folder work notes", "/room tag go #cli go", "/room pin", "/room archive"} {
+		c.handleCommand(cmd)
+	}
+	room, err := store.OpenRoom("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.Folder != "work notes" || strings.Join(room.Tags, ",") != "cli,go" || !room.Pinned || !room.Archived {
+		t.Errorf("room = %+v", room)
+	}
+
+	out.Reset()
+	c.handleCommand("/room")
+	if got := out.String(); !strings.Contains(got, "Folder:   work notes") || !strings.Contains(got, "Tags:     cli go") {
+		t.Errorf("/room printed %q", got)
+	}
+
+	for _, cmd := range []string{"/room folder", "/room tag", "/room unpin", "/room unarchive"} {
+		c.handleCommand(cmd)
+	}
+	if room, _ = store.OpenRoom("default"); room.Folder != "" || len(room.Tags) != 0 || room.Pinned || room.Archived {
+		t.Errorf("room = %+v, want the organisation cleared", room)
+	}
+
+	out.Reset()
+	c.handleCommand("/room rename")
+	if !strings.Contains(out.String(), "Usage: /room") {
+		t.Errorf("/room rename printed %q", out.String())
+	}
+}
+
+// TestProjectRoom は、プロジェクトごとに同じ名前の別のルームを使用し、指示をシステム指示として渡すことを確認します。
+func TestProjectRoom(t *testing.T) {
+	rec := newRecorder(t, "new_chat")
+	store := openStore(t)
+	global, err := store.OpenRoom("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	c, err := NewChat(Options{
+		Model:        testModel,
+		Strategy:     StrategyKeepPinned,
+		Threshold:    0.8,
+		Store:        store,
+		Room:         "default",
+		Project:      "/src/gollm",
+		Instructions: "Use tabs for indentation.",
+		Output:       &out,
+	}, option.WithHTTPClient(rec.Client()), option.WithAPIKey("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	if c.history.RoomID == global.ID {
+		t.Error("the project chat uses the room outside the project")
+	}
+	room, err := store.OpenProjectRoom("/src/gollm", "default")
+	if err != nil || room.ID != c.history.RoomID {
+		t.Errorf("project room = %+v, %v, want the chat's room %d", room, err, c.history.RoomID)
+	}
+	if si := c.model.SystemInstruction; si == nil || !strings.Contains(fmt.Sprint(si.Parts), "Use tabs") {
+		t.Errorf("system instruction = %v", si)
+	}
+
+	// JSON Schema を設定しても、指示は残ります。
+	c.setSchema(map[string]any{"type": "object"})
+	c.setSchema(nil)
+	if si := c.model.SystemInstruction; si == nil || !strings.Contains(fmt.Sprint(si.Parts), "Use tabs") {
+		t.Errorf("system instruction after resetting the schema = %v", si)
+	}
+}
+
+// TestAttachTotalLimit は、添付する予定のファイルも含めて合計サイズの上限を確認することを確認します。
+func TestAttachTotalLimit(t *testing.T) {
+	c, _ := newFakeChat(t, openStore(t), "default", StrategyKeepPinned)
+	c.opts.AttachLimits = attach.Limits{MaxFileSize: 100, MaxTotalSize: 25, MaxMediaSize: 100}
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "b.go", "c.go"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("package x\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"a.go", "b.go"} {
+		files, skipped, err := c.Attach([]string{filepath.Join(dir, name)})
+		if err != nil || len(files) != 1 || len(skipped) != 0 {
+			t.Fatalf("Attach(%s) = %v, %q, %v", name, files, skipped, err)
+		}
+	}
+	files, skipped, err := c.Attach([]string{filepath.Join(dir, "c.go")})
+	if err != nil || len(files) != 0 || len(skipped) != 1 || !strings.Contains(skipped[0], "total attachment size would exceed 25 bytes") {
+		t.Errorf("third Attach = %v, %q, %v, want c.go skipped for the total", files, skipped, err)
+	}
+	if len(c.Pending()) != 2 {
+		t.Errorf("pending = %d files, want 2", len(c.Pending()))
+	}
+
+	c.ClearAttachments()
+	if files, _, err := c.Attach([]string{filepath.Join(dir, "c.go")}); err != nil || len(files) != 1 {
+		t.Errorf("Attach after clearing = %v, %v", files, err)
+	}
+}
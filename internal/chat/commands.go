@@ -0,0 +1,286 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/kou12345/gollm/internal/attach"
+	"github.com/kou12345/gollm/internal/jsonschema"
+	"github.com/kou12345/gollm/internal/templates"
+	"github.com/kou12345/gollm/pkg/utils"
+)
+
+// handleCommand は、"/" で始まるユーザーの入力をコマンドとして実行します。
+//
+//	/pin            直前のやり取りをピン留めする
+//	/attach path... ファイルやディレクトリを次のメッセージに添付する（引数なしで一覧を表示）
+//	/detach         添付予定のファイルを全て取り消す
+//	/schema [file]  応答を JSON Schema に従う JSON に限定する（off で解除、引数なしで表示）
+//	/template name [var=value...] テンプレートから作成したプロンプトを送信する（引数なしで一覧を表示）
+//	/retry          直前の応答を生成し直す（以前の応答は別の枝として残る）
+//	/edit [n text]  n 番目のメッセージを編集して送信し直す（引数なしで一覧を表示）
+//	/branch [n]     最も新しい分岐点の枝を一覧表示するか、n 番目の枝に切り替える
+//	/compare n|[backend:]model,... prompt 複数の応答を並べて比較し、選んだものを履歴に残す
+//	/room [folder|tag|pin|unpin|archive|unarchive ...] ルームを整理する（引数なしで設定を表示）
+func (c *Chat) handleCommand(input string) {
+	fields := strings.Fields(input)
+	switch fields[0] {
+	case "/pin":
+		c.pinLastTurn()
+	case "/attach":
+		c.attachFiles(fields[1:])
+	case "/detach":
+		c.ClearAttachments()
+		fmt.Fprintln(c.out, utils.SuccessColor("Cleared pending attachments."))
+	case "/schema":
+		c.schemaCommand(fields[1:])
+	case "/template":
+		c.templateCommand(fields[1:])
+	case "/retry":
+		c.retryLast()
+	case "/edit":
+		c.editMessage(strings.TrimSpace(strings.TrimPrefix(input, "/edit")))
+	case "/branch":
+		c.switchBranch(fields[1:])
+	case "/compare":
+		c.compare(strings.TrimSpace(strings.TrimPrefix(input, "/compare")))
+	case "/room":
+		c.roomCommand(fields[1:])
+	default:
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Unknown command %s", fields[0])))
+	}
+}
+
+// pinLastTurn は、直前のユーザーのメッセージとそれに対する応答をピン留めして保存します。
+func (c *Chat) pinLastTurn() {
+	msgs := c.history.Messages
+	for i := len(msgs) - 1; i >= 0; i-- {
+		msgs[i].Pinned = true
+		if err := c.store.UpdateMessage(msgs[i]); err != nil {
+			fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to pin message: %v", err)))
+			return
+		}
+		if msgs[i].Role == "user" {
+			fmt.Fprintln(c.out, utils.SuccessColor("Pinned the last exchange."))
+			return
+		}
+	}
+	fmt.Fprintln(c.out, utils.ErrorColor("There is nothing to pin yet."))
+}
+
+// attachFiles は、patterns に一致するファイルを次のメッセージに添付する予定として追加します。
+// patterns が空の場合は、添付予定のファイルを一覧表示します。
+func (c *Chat) attachFiles(patterns []string) {
+	if len(patterns) == 0 {
+		if len(c.pending) == 0 {
+			fmt.Fprintln(c.out, "No pending attachments.")
+		}
+		for _, f := range c.pending {
+			fmt.Fprintf(c.out, "  %s (%d bytes)\n", f.Name(), len(f.Content))
+		}
+		return
+	}
+
+	files, skipped, err := c.Attach(patterns)
+	for _, s := range skipped {
+		fmt.Fprintln(c.out, utils.ErrorColor("Skipped "+s))
+	}
+	if err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to attach files: %v", err)))
+		return
+	}
+	fmt.Fprintln(c.out, utils.SuccessColor(fmt.Sprintf("Attached %d file(s). They will be sent with your next message.", len(files))))
+}
+
+// Attach は、patterns に一致するファイルを次のメッセージに添付する予定として追加し、追加したファイルと、
+// 大きすぎるなどの理由で飛ばしたファイルの説明を返します。
+// 合計サイズの上限は、既に添付する予定のファイルも含めて確認します。
+// モデルが画像やPDFに対応していない場合は、何も追加せずにエラーを返します。
+func (c *Chat) Attach(patterns []string) (files []attach.File, skipped []string, err error) {
+	collected, skipped, err := attach.Collect(patterns, c.opts.AttachLimits)
+	if err != nil {
+		return nil, skipped, err
+	}
+	if !supportsMedia(c.opts.Model) {
+		for _, f := range collected {
+			if f.IsMedia() {
+				return nil, skipped, fmt.Errorf("cannot attach %s: model %s does not accept image or PDF input", f.Name(), c.opts.Model)
+			}
+		}
+	}
+
+	limit := c.opts.AttachLimits.MaxTotalSize
+	total := attach.TotalSize(c.pending)
+	for _, f := range collected {
+		size := int64(len(f.Content))
+		if total+size > limit {
+			skipped = append(skipped, attach.TotalExceeded(f.Name(), limit))
+			continue
+		}
+		total += size
+		files = append(files, f)
+	}
+	c.pending = append(c.pending, files...)
+	return files, skipped, nil
+}
+
+// Pending は、次のメッセージに添付する予定のファイルを返します。
+func (c *Chat) Pending() []attach.File {
+	return c.pending
+}
+
+// ClearAttachments は、次のメッセージに添付する予定のファイルを全て取り消します。
+func (c *Chat) ClearAttachments() {
+	c.pending = nil
+}
+
+// schemaCommand は、ルームの応答に使用する JSON Schema を表示・設定・解除します。
+// 設定はルームに保存され、次回以降もこのルームで使用されます。
+func (c *Chat) schemaCommand(args []string) {
+	if len(args) == 0 {
+		if c.schema == nil {
+			fmt.Fprintln(c.out, "No JSON schema is set for this room.")
+			return
+		}
+		data, _ := json.MarshalIndent(c.schema, "", "  ")
+		fmt.Fprintln(c.out, string(data))
+		return
+	}
+
+	var (
+		schema map[string]any
+		stored string
+	)
+	if args[0] != "off" {
+		var err error
+		if schema, err = jsonschema.Load(args[0]); err != nil {
+			fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to load JSON schema: %v", err)))
+			return
+		}
+		data, _ := json.Marshal(schema)
+		stored = string(data)
+	}
+	if err := c.store.SetRoomSchema(c.history.RoomID, stored); err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to save JSON schema: %v", err)))
+		return
+	}
+
+	c.setSchema(schema)
+	if schema == nil {
+		fmt.Fprintln(c.out, utils.SuccessColor("Responses in this room are no longer restricted to JSON."))
+	} else {
+		fmt.Fprintln(c.out, utils.SuccessColor("Responses in this room will be JSON matching "+args[0]+"."))
+	}
+}
+
+// templateCommand は、テンプレートから作成したプロンプトをモデルに送信します。
+// REPL では標準入力を会話に使用しているため、stdin を参照するテンプレートは使用できません。
+func (c *Chat) templateCommand(args []string) {
+	if len(args) == 0 {
+		list, err := templates.List(c.opts.TemplatesDir)
+		if err != nil {
+			fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to list templates: %v", err)))
+			return
+		}
+		if len(list) == 0 {
+			fmt.Fprintf(c.out, "No templates found in %s\n", c.opts.TemplatesDir)
+		}
+		for _, t := range list {
+			fmt.Fprintf(c.out, "  %s\t%s\n", t.Name, t.Description)
+		}
+		return
+	}
+
+	vars := map[string]string{}
+	for _, arg := range args[1:] {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Expected name=value, got %q", arg)))
+			return
+		}
+		vars[name] = value
+	}
+
+	t, err := templates.Load(c.opts.TemplatesDir, args[0])
+	if err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to load template: %v", err)))
+		return
+	}
+	prompt, err := t.Render(vars, templates.RenderOptions{MaxIncludeSize: c.opts.AttachLimits.MaxFileSize})
+	if err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to render template: %v", err)))
+		return
+	}
+	c.respond(prompt)
+}
+
+// roomCommand は、ルームのフォルダー・タグ・ピン留め・アーカイブを表示・設定します。
+//
+//	/room folder [name]  フォルダーに入れる（名前なしでフォルダーから外す）
+//	/room tag [tag...]   タグを置き換える（タグなしで全て外す）
+//	/room pin | unpin    ルームの一覧の先頭に表示する・やめる
+//	/room archive | unarchive ルームの一覧に既定では表示しない・元に戻す
+func (c *Chat) roomCommand(args []string) {
+	room, err := c.store.OpenProjectRoom(c.opts.Project, c.opts.Room)
+	if err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to load room: %v", err)))
+		return
+	}
+	if len(args) == 0 {
+		fmt.Fprintf(c.out, "Room:     %s\n", room.Name)
+		fmt.Fprintf(c.out, "Project:  %s\n", orNone(room.Project))
+		fmt.Fprintf(c.out, "Folder:   %s\n", orNone(room.Folder))
+		fmt.Fprintf(c.out, "Tags:     %s\n", orNone(strings.Join(room.Tags, " ")))
+		fmt.Fprintf(c.out, "Pinned:   %t\n", room.Pinned)
+		fmt.Fprintf(c.out, "Archived: %t\n", room.Archived)
+		fmt.Fprintf(c.out, "Messages: %d (last activity %s)\n", room.MessageCount, room.UpdatedAt.Local().Format("2006-01-02 15:04"))
+		return
+	}
+
+	var done string
+	switch args[0] {
+	case "folder":
+		folder := strings.Join(args[1:], " ")
+		err = c.store.SetRoomFolder(room.ID, folder)
+		done = "Moved the room to folder " + folder + "."
+		if folder == "" {
+			done = "Removed the room from its folder."
+		}
+	case "tag":
+		var tags []string
+		tags, err = c.store.SetRoomTags(room.ID, args[1:])
+		done = "Tagged the room with " + strings.Join(tags, " ") + "."
+		if len(tags) == 0 {
+			done = "Removed all tags from the room."
+		}
+	case "pin", "unpin":
+		err = c.store.SetRoomPinned(room.ID, args[0] == "pin")
+		done = "Pinned the room."
+		if args[0] == "unpin" {
+			done = "Unpinned the room."
+		}
+	case "archive", "unarchive":
+		err = c.store.SetRoomArchived(room.ID, args[0] == "archive")
+		done = "Archived the room."
+		if args[0] == "unarchive" {
+			done = "Unarchived the room."
+		}
+	default:
+		fmt.Fprintln(c.out, utils.ErrorColor("Usage: /room [folder [name] | tag [tag...] | pin | unpin | archive | unarchive]"))
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to update room: %v", err)))
+		return
+	}
+	fmt.Fprintln(c.out, utils.SuccessColor(done))
+}
+
+// orNone は、s が空の場合は "(none)" を返します。
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
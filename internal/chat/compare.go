@@ -0,0 +1,273 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/kou12345/gollm/internal/attach"
+	"github.com/kou12345/gollm/internal/history"
+	"github.com/kou12345/gollm/internal/jsonschema"
+	"github.com/kou12345/gollm/pkg/utils"
+	"google.golang.org/api/option"
+)
+
+// maxCandidates は、/compare で一度に生成できる応答の数の上限です。
+const maxCandidates = 4
+
+// CandidateOptions は、比較する1つの応答を生成するバックエンドとモデルです。
+type CandidateOptions struct {
+	Backend string                // バックエンドの名前（表示用。空の場合はセッションのバックエンド）
+	Model   string                // 使用するモデル名
+	Client  []option.ClientOption // バックエンドに接続するオプション（nil の場合はセッションのクライアントを使用）
+}
+
+// Label は、応答を見分けるための "バックエンド:モデル" の名前を返します。セッションのバックエンドではモデル名だけです。
+func (o CandidateOptions) Label() string {
+	if o.Backend == "" {
+		return o.Model
+	}
+	return o.Backend + ":" + o.Model
+}
+
+// BackendFunc は、name のバックエンドに接続するオプションと、そのバックエンドの既定のモデルを返す関数です。
+type BackendFunc func(name string) (CandidateOptions, error)
+
+// PickFunc は、比較する応答を利用者に示し、選ばれた応答の位置を返す関数です。取り消された場合は -1 を返します。
+type PickFunc func(cands []Candidate) (int, error)
+
+// Candidate は、比較のために生成した1つの応答です。
+type Candidate struct {
+	CandidateOptions
+	Text  string
+	Usage *genai.UsageMetadata
+	Err   error // 応答を得られなかった場合のエラー
+}
+
+// Comparison は、同じプロンプトに対して生成した複数の応答です。Keep で1つを選んで履歴に残します。
+type Comparison struct {
+	Prompt     string
+	Candidates []Candidate
+
+	files        []attach.File // プロンプトに添付したファイル
+	promptTokens int32
+}
+
+// Err は、全ての応答の生成に失敗した場合に、それぞれのエラーをまとめて返します。1つでも成功した場合は nil を返します。
+func (cmp *Comparison) Err() error {
+	var errs []error
+	for _, cand := range cmp.Candidates {
+		if cand.Err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", cand.Label(), cand.Err))
+	}
+	return errors.Join(errs...)
+}
+
+// compare は、/compare の引数のモデルで応答を生成し、利用者が選んだものを履歴に残します。
+//
+// args は "<数> <プロンプト>" か "[<バックエンド>:]<モデル>,... <プロンプト>" の形式です。
+func (c *Chat) compare(args string) {
+	spec, prompt, _ := strings.Cut(args, " ")
+	prompt = strings.TrimSpace(prompt)
+	opts, err := c.ParseCandidates(spec)
+	if err == nil && prompt == "" {
+		err = fmt.Errorf("usage: /compare <count>|[<backend>:]<model>,... <prompt>")
+	}
+	if err == nil && c.opts.Pick == nil {
+		err = fmt.Errorf("comparing responses is not available here")
+	}
+	if err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(err.Error()))
+		return
+	}
+
+	fmt.Fprintln(c.out, utils.AIColor(fmt.Sprintf("Generating %d responses...", len(opts))))
+	cmp, err := c.Compare(context.Background(), prompt, opts)
+	if err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(err.Error()))
+		return
+	}
+	if cmp.Err() != nil {
+		for _, cand := range cmp.Candidates {
+			c.showError(fmt.Errorf("%s: %w", cand.Label(), cand.Err))
+		}
+		return
+	}
+
+	picked, err := c.opts.Pick(cmp.Candidates)
+	if err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to show the comparison: %v", err)))
+		return
+	}
+	if picked < 0 {
+		fmt.Fprintln(c.out, "Discarded all responses.")
+		return
+	}
+	if err := c.Keep(cmp, picked); err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to save message: %v", err)))
+		return
+	}
+	c.show(cmp.Candidates[picked].Text)
+}
+
+// ParseCandidates は、比較する応答の指定から、それぞれの応答を生成するバックエンドとモデルを作成します。
+//
+// spec は、セッションのモデルで生成する応答の数か、カンマで区切ったモデルの一覧です。
+// モデルは "<バックエンド>:<モデル>" のように別のバックエンドでも指定でき、モデルを省略するとそのバックエンドの既定のモデルを使用します。
+func (c *Chat) ParseCandidates(spec string) ([]CandidateOptions, error) {
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n < 2 || n > maxCandidates {
+			return nil, fmt.Errorf("the number of responses must be between 2 and %d", maxCandidates)
+		}
+		opts := make([]CandidateOptions, n)
+		for i := range opts {
+			opts[i].Model = c.opts.Model
+		}
+		return opts, nil
+	}
+
+	items := strings.Split(spec, ",")
+	if len(items) < 2 || len(items) > maxCandidates || slices.Contains(items, "") {
+		return nil, fmt.Errorf("give between 2 and %d comma-separated models, or a number of responses", maxCandidates)
+	}
+	opts := make([]CandidateOptions, len(items))
+	for i, item := range items {
+		backend, model, ok := strings.Cut(item, ":")
+		if !ok {
+			opts[i].Model = item
+			continue
+		}
+		if c.opts.Backends == nil {
+			return nil, fmt.Errorf("comparing other backends is not available here")
+		}
+		o, err := c.opts.Backends(backend)
+		if err != nil {
+			return nil, err
+		}
+		if model != "" {
+			o.Model = model
+		}
+		if o.Model == "" {
+			return nil, fmt.Errorf("backend %q has no model configured; give one as %s:<model>", backend, backend)
+		}
+		opts[i] = o
+	}
+	return opts, nil
+}
+
+// Compare は、prompt と添付予定のファイルを opts のそれぞれのバックエンドとモデルに同時に送信し、応答を返します。
+// 履歴には何も保存しません。応答の生成に失敗した候補は、Candidate の Err に理由を記録します。
+func (c *Chat) Compare(ctx context.Context, prompt string, opts []CandidateOptions) (*Comparison, error) {
+	if len(opts) < 2 || len(opts) > maxCandidates {
+		return nil, fmt.Errorf("the number of responses must be between 2 and %d", maxCandidates)
+	}
+	files := c.pending
+	parts := append(attachmentParts(files), genai.Text(prompt))
+	promptTokens := c.countTokens(ctx, parts...)
+	contents := c.contextHistory(ctx, promptTokens)
+	return &Comparison{
+		Prompt:       prompt,
+		Candidates:   c.generateCandidates(ctx, opts, contents, parts),
+		files:        files,
+		promptTokens: promptTokens,
+	}, nil
+}
+
+// Keep は、cmp のプロンプトと picked 番目の応答を履歴に残します。
+// 選ばれなかった応答も、同じメッセージに対する別の枝として保存します。
+func (c *Chat) Keep(cmp *Comparison, picked int) error {
+	if picked < 0 || picked >= len(cmp.Candidates) || cmp.Candidates[picked].Err != nil {
+		return fmt.Errorf("response %d cannot be kept", picked+1)
+	}
+
+	c.pending = nil
+	if err := c.appendMessage("user", cmp.Prompt, cmp.promptTokens, history.Usage{}, cmp.files...); err != nil {
+		return err
+	}
+	// 選ばれた応答を最後に保存して、選択中の枝の末端にします。
+	ctx := context.Background()
+	userPath := c.history.Messages
+	order := append(slices.Delete(seq(len(cmp.Candidates)), picked, picked+1), picked)
+	var errs []error
+	for _, i := range order {
+		cand := cmp.Candidates[i]
+		if cand.Err != nil {
+			continue
+		}
+		c.history.Messages = userPath[:len(userPath):len(userPath)]
+		tokens := c.countTokens(ctx, genai.Text(cand.Text))
+		usage := history.Usage{Model: cand.Model}
+		if cand.Usage != nil {
+			tokens = cand.Usage.CandidatesTokenCount
+			usage.PromptTokens = cand.Usage.PromptTokenCount
+			usage.CompletionTokens = cand.Usage.CandidatesTokenCount
+		}
+		if err := c.appendMessage("assistant", cand.Text, tokens, usage); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if u := cmp.Candidates[picked].Usage; u != nil {
+		c.usedTokens = u.TotalTokenCount
+	}
+	return errors.Join(errs...)
+}
+
+// generateCandidates は、opts のそれぞれに contents を履歴として parts を同時に送信します。
+// 応答はストリーミングで受信します（SendMessage は isStreamEnd の問題を避けられないため使用しません）。
+// 比較のための応答ではツールを使用しません。JSON Schema が設定されている場合は、各応答を検証します。
+func (c *Chat) generateCandidates(ctx context.Context, opts []CandidateOptions, contents []*genai.Content, parts []genai.Part) []Candidate {
+	cands := make([]Candidate, len(opts))
+	var wg sync.WaitGroup
+	for i, o := range opts {
+		cands[i].CandidateOptions = o
+		wg.Add(1)
+		go func(cand *Candidate) {
+			defer wg.Done()
+
+			client := c.client
+			if cand.Client != nil {
+				if client, cand.Err = genai.NewClient(ctx, cand.Client...); cand.Err != nil {
+					return
+				}
+				defer client.Close()
+			}
+			m := client.GenerativeModel(cand.Model)
+			m.ResponseMIMEType = c.model.ResponseMIMEType
+			m.ResponseSchema = c.model.ResponseSchema
+			m.SystemInstruction = c.model.SystemInstruction
+			cs := m.StartChat()
+			cs.History = slices.Clone(contents)
+
+			r, err := c.streamSession(ctx, cs, nil, parts...)
+			cand.Text, cand.Usage, cand.Err = r.text, r.usage, err
+			if err == nil && r.text == "" {
+				cand.Err = emptyResponseError(r)
+			}
+			if cand.Err == nil && c.schema != nil {
+				var data []byte
+				if data, cand.Err = jsonschema.ValidateJSON(c.schema, []byte(cand.Text)); cand.Err == nil {
+					cand.Text = string(data)
+				}
+			}
+		}(&cands[i])
+	}
+	wg.Wait()
+	return cands
+}
+
+// seq は、0 から n-1 までの整数のスライスを返します。
+func seq(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
@@ -0,0 +1,163 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kou12345/gollm/internal/fake"
+	"google.golang.org/api/option"
+)
+
+// fakeBackends は、"other" と "broken" のバックエンドに接続する BackendFunc を返します。
+// other は常に同じ応答を返し、broken は常に認証のエラーを返します。
+func fakeBackends(t *testing.T) BackendFunc {
+	scripts := map[string]*fake.Script{
+		"other":  {Rules: []fake.Rule{{Reply: "From the other backend"}}},
+		"broken": {Rules: []fake.Rule{{Error: "auth"}}},
+	}
+	return func(name string) (CandidateOptions, error) {
+		script, ok := scripts[name]
+		if !ok {
+			return CandidateOptions{}, fmt.Errorf("backend %q is not configured", name)
+		}
+		fb, err := fake.New(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := []option.ClientOption{option.WithHTTPClient(fb.Client()), option.WithAPIKey("fake")}
+		return CandidateOptions{Backend: name, Model: name + "-model", Client: client}, nil
+	}
+}
+
+func TestParseCandidates(t *testing.T) {
+	store := openStore(t)
+	c, _ := newFakeChat(t, store, "default", StrategyKeepPinned)
+	c.opts.Backends = fakeBackends(t)
+
+	tests := []struct {
+		spec    string
+		want    []string
+		wantErr string
+	}{
+		{spec: "3", want: []string{"fake", "fake", "fake"}},
+		{spec: "1", wantErr: "between 2 and 4"},
+		{spec: "5", wantErr: "between 2 and 4"},
+		{spec: "a,b", want: []string{"a", "b"}},
+		{spec: "a,", wantErr: "comma-separated models"},
+		{spec: "a,b,c,d,e", wantErr: "comma-separated models"},
+		{spec: "other:,fake", want: []string{"other:other-model", "fake"}},
+		{spec: "other:pro,other:flash", want: []string{"other:pro", "other:flash"}},
+		{spec: "missing:x,fake", wantErr: `backend "missing" is not configured`},
+	}
+	for _, tt := range tests {
+		opts, err := c.ParseCandidates(tt.spec)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseCandidates(%q): err = %v, want %q", tt.spec, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCandidates(%q): %v", tt.spec, err)
+			continue
+		}
+		var labels []string
+		for _, o := range opts {
+			labels = append(labels, o.Label())
+		}
+		if strings.Join(labels, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("ParseCandidates(%q) = %v, want %v", tt.spec, labels, tt.want)
+		}
+	}
+
+	c.opts.Backends = nil
+	if _, err := c.ParseCandidates("other:,fake"); err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Errorf("ParseCandidates without backends: err = %v", err)
+	}
+}
+
+// TestCompareKeep は、別のバックエンドを含む応答を比較しても履歴に何も保存せず、
+// Keep で選んだ応答の枝を選択し、選ばなかった応答を別の枝として残すことを確認します。
+func TestCompareKeep(t *testing.T) {
+	store := openStore(t)
+	c, _ := newFakeChat(t, store, "default", StrategyKeepPinned)
+	c.opts.Backends = fakeBackends(t)
+
+	opts, err := c.ParseCandidates("fake,other:,broken:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmp, err := c.Compare(context.Background(), "hello", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmp.Err() != nil {
+		t.Fatalf("Err = %v, want nil while some responses succeeded", cmp.Err())
+	}
+	cands := cmp.Candidates
+	if cands[0].Text != "Echo: hello" || cands[1].Text != "From the other backend" || cands[2].Err == nil {
+		t.Fatalf("candidates = %+v", cands)
+	}
+	if h, _ := store.Load(c.history.RoomID); len(h.Messages) != 0 {
+		t.Fatalf("Compare saved %d messages", len(h.Messages))
+	}
+
+	if err := c.Keep(cmp, 2); err == nil {
+		t.Error("Keep kept a failed response")
+	}
+	if err := c.Keep(cmp, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := pathContents(c.history); got != "hello | From the other backend" {
+		t.Errorf("after Keep: %s", got)
+	}
+	if model := c.history.Messages[1].Usage.Model; model != "other-model" {
+		t.Errorf("usage model = %q, want the candidate's model", model)
+	}
+	siblings, err := store.Siblings(c.history.RoomID, c.history.Messages[1])
+	if err != nil || len(siblings) != 2 || siblings[0].Content != "Echo: hello" {
+		t.Errorf("siblings = %v, %v, want the unpicked response kept", siblings, err)
+	}
+
+	opts, _ = c.ParseCandidates("broken:,broken:")
+	cmp, err = c.Compare(context.Background(), "again", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmp.Err(); err == nil || !strings.Contains(err.Error(), "broken:broken-model") {
+		t.Errorf("Err = %v, want the errors of all responses", err)
+	}
+}
+
+// TestCompareCommand は、/compare が Pick で選んだ応答を履歴に残し、取り消した場合は何も保存しないことを確認します。
+func TestCompareCommand(t *testing.T) {
+	store := openStore(t)
+	c, _ := newFakeChat(t, store, "default", StrategyKeepPinned)
+	var out bytes.Buffer
+	c.out = &out
+
+	c.handleCommand("/compare 2 hello")
+	if !strings.Contains(out.String(), "comparing responses is not available here") {
+		t.Errorf("/compare without Pick printed %q", out.String())
+	}
+
+	var shown int
+	c.opts.Pick = func(cands []Candidate) (int, error) {
+		shown = len(cands)
+		return -1, nil
+	}
+	out.Reset()
+	c.handleCommand("/compare 3 hello")
+	if shown != 3 || !strings.Contains(out.String(), "Discarded all responses.") || len(c.history.Messages) != 0 {
+		t.Errorf("after discarding: shown %d, printed %q, history %d", shown, out.String(), len(c.history.Messages))
+	}
+
+	c.opts.Pick = func([]Candidate) (int, error) { return 1, nil }
+	c.handleCommand("/compare 2 hello")
+	if got := pathContents(c.history); got != "hello | Echo: hello" {
+		t.Errorf("after picking: %s", got)
+	}
+}
@@ -0,0 +1,239 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/kou12345/gollm/internal/attach"
+	"github.com/kou12345/gollm/internal/history"
+	"github.com/kou12345/gollm/pkg/utils"
+)
+
+// Strategy は、会話履歴がコンテキストウィンドウの上限に近づいたときの扱い方を表します。
+type Strategy string
+
+const (
+	StrategyDropOldest Strategy = "drop_oldest" // 古いターンから順に送信対象から外す
+	StrategyKeepPinned Strategy = "keep_pinned" // ピン留めされたターンを残して古いターンから外す
+	StrategySummarise  Strategy = "summarise"   // 外したターンをモデルに要約させ、要約を保存して代わりに送信する
+)
+
+// defaultTokenLimit は、モデルの入力トークン上限を取得できなかった場合に使用する値です。
+const defaultTokenLimit = 32768
+
+// turn は、ユーザーのメッセージとそれに続くモデルの応答をまとめたものです。
+// 履歴は user/model が交互になる必要があるため、ターン単位で送信対象から外します。
+type turn struct {
+	messages []history.ChatMessage
+	tokens   int32
+	pinned   bool
+}
+
+// groupTurns は、メッセージの列をターンの列に分割します。
+func groupTurns(msgs []history.ChatMessage) []turn {
+	var turns []turn
+	for _, msg := range msgs {
+		if msg.Role == "user" || len(turns) == 0 {
+			turns = append(turns, turn{})
+		}
+		t := &turns[len(turns)-1]
+		t.messages = append(t.messages, msg)
+		t.tokens += msg.Tokens
+		t.pinned = t.pinned || msg.Pinned
+	}
+	return turns
+}
+
+// toContents は、メッセージを genai.Content の列に変換します。
+// 画像やPDFは保存された内容を使用し、テキストの添付ファイルは保存されたパスから読み込み直します。
+// 読み込めない場合はその旨を伝えるテキストに置き換えます。
+func (c *Chat) toContents(msgs []history.ChatMessage) []*genai.Content {
+	contents := make([]*genai.Content, 0, len(msgs))
+	for _, msg := range msgs {
+		role := "model"
+		if msg.Role == "user" {
+			role = "user"
+		}
+
+		var parts []genai.Part
+		for _, a := range msg.Attachments {
+			f := attach.File{Path: a.Path, MIMEType: a.MIMEType, Content: a.Data}
+			if a.Data == nil {
+				var err error
+				if f, err = attach.Load(a.Path, c.opts.AttachLimits); err != nil {
+					parts = append(parts, genai.Text(fmt.Sprintf("[attached file %s is no longer available: %v]", a.Path, err)))
+					continue
+				}
+			}
+			parts = append(parts, attachmentParts([]attach.File{f})...)
+		}
+		parts = append(parts, genai.Text(msg.Content))
+		contents = append(contents, &genai.Content{Role: role, Parts: parts})
+	}
+	return contents
+}
+
+// attachmentParts は、添付ファイルをプロンプトに含める Part に変換します。
+// 画像やPDFは、ファイル名を示すテキストに続けてインラインデータとして送信します。
+func attachmentParts(files []attach.File) []genai.Part {
+	parts := make([]genai.Part, 0, len(files))
+	for _, f := range files {
+		parts = append(parts, genai.Text(f.Text()))
+		if f.IsMedia() {
+			parts = append(parts, genai.Blob{MIMEType: f.MIMEType, Data: f.Content})
+		}
+	}
+	return parts
+}
+
+// textOnlyModels は、画像やPDFの入力に対応していないモデル名の接頭辞です。
+var textOnlyModels = []string{"gemini-1.0-pro", "gemini-pro"}
+
+// supportsMedia は、model が画像やPDFの入力に対応しているかどうかを返します。
+func supportsMedia(model string) bool {
+	model = strings.TrimPrefix(model, "models/")
+	for _, prefix := range textOnlyModels {
+		if strings.HasPrefix(model, prefix) && !strings.Contains(model, "vision") {
+			return false
+		}
+	}
+	return true
+}
+
+// countTokens は、parts のトークン数を数えます。
+// カウントに失敗した場合は、文字数からおおよその値を見積もります。
+func (c *Chat) countTokens(ctx context.Context, parts ...genai.Part) int32 {
+	resp, err := c.model.CountTokens(ctx, parts...)
+	if err != nil {
+		var n int
+		for _, p := range parts {
+			n += len(fmt.Sprint(p))
+		}
+		return int32(n/4 + 1)
+	}
+	return resp.TotalTokens
+}
+
+// contextHistory は、promptTokens のプロンプトと一緒に送信する履歴を組み立てます。
+// 履歴とプロンプトの合計が上限の Threshold 割合を超える場合は、設定された戦略に従って古いターンを外します。
+// 最新のターンは常に残します。
+//
+// StrategySummarise では、保存済みの要約がそれに含まれるメッセージの代わりに送信されます。
+// ルームのメッセージ自体は変更されません。
+func (c *Chat) contextHistory(ctx context.Context, promptTokens int32) []*genai.Content {
+	msgs := c.history.Messages
+	var prefix []*genai.Content
+	if c.opts.Strategy == StrategySummarise && c.summary != nil {
+		msgs = messagesAfter(msgs, c.summary.UpToID)
+		prefix = summaryContents(c.summary.Content)
+		promptTokens += c.summary.Tokens
+	}
+
+	turns := groupTurns(msgs)
+	total := promptTokens
+	for _, t := range turns {
+		total += t.tokens
+	}
+	budget := int32(float64(c.tokenLimit) * c.opts.Threshold)
+	if total <= budget {
+		return append(prefix, c.toContents(msgs)...)
+	}
+
+	var kept, dropped []history.ChatMessage
+	for i, t := range turns {
+		keep := i == len(turns)-1 || total <= budget || (c.opts.Strategy == StrategyKeepPinned && t.pinned)
+		if keep {
+			kept = append(kept, t.messages...)
+			continue
+		}
+		dropped = append(dropped, t.messages...)
+		total -= t.tokens
+	}
+
+	if c.opts.Strategy == StrategySummarise && len(dropped) > 0 {
+		if err := c.summarise(ctx, dropped); err != nil {
+			fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to summarise older messages: %v\nThey will be omitted from this request.", err)))
+		} else {
+			prefix = summaryContents(c.summary.Content)
+		}
+	}
+	return append(prefix, c.toContents(kept)...)
+}
+
+// messagesAfter は、msgs のうち id より後に保存されたメッセージを返します。
+func messagesAfter(msgs []history.ChatMessage, id int64) []history.ChatMessage {
+	for i, msg := range msgs {
+		if msg.ID > id {
+			return msgs[i:]
+		}
+	}
+	return nil
+}
+
+// summaryContents は、会話の要約をピン留めされたやり取りとして送信するための Content を返します。
+func summaryContents(summary string) []*genai.Content {
+	return []*genai.Content{
+		{Role: "user", Parts: []genai.Part{genai.Text("Conversation summary of our earlier messages:\n" + summary)}},
+		{Role: "model", Parts: []genai.Part{genai.Text("Understood. I will keep this summary in mind.")}},
+	}
+}
+
+// summarise は、既存の要約と msgs をまとめた新しい要約をモデルに作成させ、データベースに保存します。
+// msgs は、既存の要約に含まれるメッセージより後に続く古いメッセージです。
+func (c *Chat) summarise(ctx context.Context, msgs []history.ChatMessage) error {
+	var b strings.Builder
+	b.WriteString("Summarise the following conversation concisely, keeping facts, decisions and open questions.\n\n")
+	if c.summary != nil {
+		fmt.Fprintf(&b, "Summary of the conversation so far:\n%s\n\n", c.summary.Content)
+	}
+	for _, msg := range msgs {
+		fmt.Fprintf(&b, "%s: %s\n\n", msg.Role, msg.Content)
+	}
+
+	// 会話用のモデルにはツールや JSON の応答形式が設定されている場合があるため、設定のないモデルで要約します。
+	var resp *genai.GenerateContentResponse
+	err := c.withRetry(ctx, func() (err error) {
+		resp, err = c.client.GenerativeModel(c.opts.Model).GenerateContent(ctx, genai.Text(b.String()))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return fmt.Errorf("the model returned no summary")
+	}
+
+	var text strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		text.WriteString(fmt.Sprint(part))
+	}
+
+	summary := &history.Summary{
+		RoomID:    c.history.RoomID,
+		Content:   text.String(),
+		UpToID:    msgs[len(msgs)-1].ID,
+		Tokens:    c.countTokens(ctx, genai.Text(text.String())),
+		Usage:     c.usage(resp.UsageMetadata),
+		CreatedAt: time.Now(),
+	}
+	if err := c.store.SaveSummary(summary); err != nil {
+		return err
+	}
+	c.summary = summary
+	return nil
+}
+
+// formatTokens は、トークン数を 12.3k のような短い表記に変換します。
+func formatTokens(n int32) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
+	case n >= 1_000:
+		return fmt.Sprintf("%.1fk", float64(n)/1_000)
+	default:
+		return fmt.Sprint(n)
+	}
+}
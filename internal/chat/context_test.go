@@ -0,0 +1,216 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/kou12345/gollm/internal/fake"
+	"github.com/kou12345/gollm/internal/history"
+	"google.golang.org/api/option"
+)
+
+// countingTransport は、fake バックエンドに送信したリクエストをメソッド（countTokens など）ごとに数えます。
+type countingTransport struct {
+	base http.RoundTripper
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, method, _ := strings.Cut(req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:], ":")
+	t.mu.Lock()
+	t.calls[method]++
+	t.mu.Unlock()
+	return t.base.RoundTrip(req)
+}
+
+// count は、method のリクエストを送信した回数を返します。
+func (t *countingTransport) count(method string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.calls[method]
+}
+
+// newFakeChat は、入力をそのまま返す fake バックエンドと通信する Chat を作成します。
+func newFakeChat(t *testing.T, store *history.Store, room string, strategy Strategy) (*Chat, *countingTransport) {
+	t.Helper()
+	fb, err := fake.New(&fake.Script{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &countingTransport{base: fb, calls: map[string]int{}}
+	c, err := NewChat(Options{
+		Model:     "fake",
+		Strategy:  strategy,
+		Threshold: 0.5,
+		Store:     store,
+		Room:      room,
+		Output:    io.Discard,
+	}, option.WithHTTPClient(&http.Client{Transport: transport}), option.WithAPIKey("fake"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c, transport
+}
+
+// contentTexts は、Content の列をテキストの列に変換します。
+func contentTexts(contents []*genai.Content) []string {
+	var texts []string
+	for _, content := range contents {
+		var b strings.Builder
+		for _, p := range content.Parts {
+			b.WriteString(fmt.Sprint(p))
+		}
+		texts = append(texts, b.String())
+	}
+	return texts
+}
+
+// addTurns は、それぞれ10トークンのユーザーのメッセージと応答からなるターンを n 個追加します。
+// pinned に含まれるターン（1 から数える）のユーザーのメッセージはピン留めします。
+func addTurns(t *testing.T, c *Chat, n int, pinned ...int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		if err := c.appendMessage("user", fmt.Sprintf("u%d", i), 10, history.Usage{}); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range pinned {
+			if p == i {
+				msg := &c.history.Messages[len(c.history.Messages)-1]
+				msg.Pinned = true
+				if err := c.store.UpdateMessage(*msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := c.appendMessage("assistant", fmt.Sprintf("a%d", i), 10, history.Usage{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestContextHistory(t *testing.T) {
+	// 上限 100 トークンの半分を超えたら古いターンを外します。4つのターン（80）と5トークンのプロンプトで85になります。
+	tests := []struct {
+		name      string
+		strategy  Strategy
+		threshold float64
+		pinned    []int
+		want      string
+	}{
+		{name: "under budget", strategy: StrategyDropOldest, threshold: 1, want: "u1 a1 u2 a2 u3 a3 u4 a4"},
+		{name: "drop oldest", strategy: StrategyDropOldest, want: "u3 a3 u4 a4"},
+		{name: "keep pinned", strategy: StrategyKeepPinned, pinned: []int{1}, want: "u1 a1 u4 a4"},
+		{name: "pinned ignored by drop oldest", strategy: StrategyDropOldest, pinned: []int{1}, want: "u3 a3 u4 a4"},
+		{name: "latest turn always kept", strategy: StrategyKeepPinned, threshold: 0.01, want: "u4 a4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newFakeChat(t, openStore(t), "default", tt.strategy)
+			c.tokenLimit = 100
+			if tt.threshold != 0 {
+				c.opts.Threshold = tt.threshold
+			}
+			addTurns(t, c, 4, tt.pinned...)
+
+			got := strings.Join(contentTexts(c.contextHistory(context.Background(), 5)), " ")
+			if got != tt.want {
+				t.Errorf("context = %q, want %q", got, tt.want)
+			}
+			if len(c.history.Messages) != 8 {
+				t.Errorf("history has %d messages, want the room unchanged", len(c.history.Messages))
+			}
+		})
+	}
+}
+
+func TestContextHistorySummarise(t *testing.T) {
+	store := openStore(t)
+	c, transport := newFakeChat(t, store, "default", StrategySummarise)
+	c.tokenLimit = 100
+	addTurns(t, c, 4)
+
+	got := contentTexts(c.contextHistory(context.Background(), 5))
+	if len(got) != 6 || !strings.HasPrefix(got[0], "Conversation summary of our earlier messages:") || strings.Join(got[2:], " ") != "u3 a3 u4 a4" {
+		t.Fatalf("context = %q, want the summary followed by the last two turns", got)
+	}
+	// fake バックエンドは入力をそのまま返すため、要約には外したターンが含まれます。
+	if !strings.Contains(got[0], "u2") || strings.Contains(got[0], "u3") {
+		t.Errorf("summary = %q, want it to cover the first two turns", got[0])
+	}
+
+	summary, err := store.LatestSummary(c.history.RoomID, c.history.Head())
+	if err != nil || summary == nil {
+		t.Fatalf("LatestSummary = %v, %v", summary, err)
+	}
+	if summary.UpToID != c.history.Messages[3].ID {
+		t.Errorf("summary covers up to %d, want %d", summary.UpToID, c.history.Messages[3].ID)
+	}
+
+	// 保存済みの要約は、それに含まれるメッセージの代わりに送信され、上限を超えなければ要約し直しません。
+	c.opts.Threshold = 1
+	generated := transport.count("generateContent")
+	got = contentTexts(c.contextHistory(context.Background(), 5))
+	if len(got) != 6 || strings.Join(got[2:], " ") != "u3 a3 u4 a4" {
+		t.Errorf("context = %q", got)
+	}
+	if transport.count("generateContent") != generated {
+		t.Error("the conversation was summarised again")
+	}
+}
+
+// TestBackfillTokens は、トークン数が記録されていないメッセージを1回のリクエストで数えて保存し、
+// 次に開いたときには数え直さないことを確認します。
+func TestBackfillTokens(t *testing.T) {
+	store := openStore(t)
+	room, err := store.OpenRoom("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &history.ChatHistory{RoomID: room.ID}
+	for _, content := range []string{"one two three four", "five six", "", "seven eight nine ten eleven twelve"} {
+		if err := store.SaveMessage(room.ID, h.AddMessage("user", content, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, transport := newFakeChat(t, store, "legacy", StrategyKeepPinned)
+	if n := transport.count("countTokens"); n != 1 {
+		t.Errorf("sent %d countTokens requests, want 1", n)
+	}
+	var total int32
+	for _, msg := range c.history.Messages {
+		if msg.Tokens <= 0 {
+			t.Errorf("message %q has %d tokens", msg.Content, msg.Tokens)
+		}
+		total += msg.Tokens
+	}
+	if c.usedTokens != total {
+		t.Errorf("usedTokens = %d, want %d", c.usedTokens, total)
+	}
+	if m := c.history.Messages; m[3].Tokens <= m[1].Tokens {
+		t.Errorf("tokens = %d for the long message and %d for the short one", m[3].Tokens, m[1].Tokens)
+	}
+
+	_, transport = newFakeChat(t, store, "legacy", StrategyKeepPinned)
+	if n := transport.count("countTokens"); n != 0 {
+		t.Errorf("reopening sent %d countTokens requests, want 0", n)
+	}
+}
+
+func TestNewChatRejectsThreshold(t *testing.T) {
+	for _, threshold := range []float64{0, -0.5, 1.5} {
+		_, err := NewChat(Options{Strategy: StrategyKeepPinned, Threshold: threshold, Store: openStore(t), Room: "default"}, option.WithAPIKey("fake"))
+		if err == nil || !strings.Contains(err.Error(), "context threshold") {
+			t.Errorf("Threshold %v: err = %v", threshold, err)
+		}
+	}
+}
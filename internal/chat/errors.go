@@ -0,0 +1,255 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/kou12345/gollm/internal/secret"
+	"github.com/kou12345/gollm/pkg/utils"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrorKind は、バックエンドの呼び出しが失敗した理由の分類です。
+type ErrorKind string
+
+const (
+	ErrorRateLimit ErrorKind = "rate_limit" // 短時間のリクエストが多すぎる（待てば成功する）
+	ErrorQuota     ErrorKind = "quota"      // 日ごとなどの割り当てを使い切った
+	ErrorSafety    ErrorKind = "safety"     // プロンプトか応答が安全性の設定によりブロックされた
+	ErrorNetwork   ErrorKind = "network"    // 接続の失敗やタイムアウト
+	ErrorAuth      ErrorKind = "auth"       // API キーが無効か権限がない
+	ErrorServer    ErrorKind = "server"     // バックエンドの一時的な障害
+	ErrorRequest   ErrorKind = "request"    // リクエストの内容が不正
+	ErrorUnknown   ErrorKind = "unknown"
+)
+
+// 再試行の設定です。
+const (
+	maxRetries     = 4
+	baseBackoff    = time.Second
+	maxBackoff     = 30 * time.Second
+	maxRetryAfter  = 2 * time.Minute // これより長く待つよう指示された場合は再試行しません
+	retryAfterSlop = 250 * time.Millisecond
+)
+
+// BackendError は、分類されたバックエンドのエラーです。
+type BackendError struct {
+	Kind       ErrorKind
+	RetryAfter time.Duration // バックエンドが指定した再試行までの待ち時間（0 の場合は指定なし）
+	Detail     string        // 利用者に表示する補足（安全性の評価など）
+	Err        error
+}
+
+func (e *BackendError) Error() string {
+	var msg string
+	switch e.Kind {
+	case ErrorRateLimit:
+		msg = "rate limited by the backend"
+	case ErrorQuota:
+		msg = "quota exhausted"
+	case ErrorSafety:
+		msg = "blocked by safety settings"
+	case ErrorNetwork:
+		msg = "network error"
+	case ErrorAuth:
+		msg = "authentication failed (check the API key)"
+	case ErrorServer:
+		msg = "backend is temporarily unavailable"
+	case ErrorRequest:
+		msg = "request rejected by the backend"
+	default:
+		msg = "backend error"
+	}
+	if e.Detail != "" {
+		msg += " (" + e.Detail + ")"
+	}
+	return fmt.Sprintf("%s: %v", msg, e.Err)
+}
+
+func (e *BackendError) Unwrap() error { return e.Err }
+
+// Retryable は、同じリクエストを再試行すれば成功する可能性があるかを返します。
+func (e *BackendError) Retryable() bool {
+	switch e.Kind {
+	case ErrorRateLimit, ErrorNetwork, ErrorServer:
+		return e.RetryAfter <= maxRetryAfter
+	}
+	return false
+}
+
+// classify は、genai や HTTP クライアントが返したエラーを分類します。
+func classify(err error) *BackendError {
+	var be *BackendError
+	if errors.As(err, &be) {
+		return be
+	}
+	be = &BackendError{Kind: ErrorUnknown, Err: err}
+
+	var blocked *genai.BlockedError
+	var apiErr *googleapi.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &blocked):
+		be.Kind = ErrorSafety
+		be.Detail = blockDetail(blocked)
+	case errors.As(err, &apiErr):
+		be.Kind = apiErrorKind(apiErr)
+		be.RetryAfter = retryAfter(apiErr)
+	case errors.Is(err, context.Canceled):
+		// 利用者による中断は再試行しません。
+	case errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, context.DeadlineExceeded):
+		be.Kind = ErrorNetwork
+	}
+	return be
+}
+
+// apiErrorKind は、HTTP ステータスとエラーの詳細から分類を決めます。
+func apiErrorKind(e *googleapi.Error) ErrorKind {
+	for _, d := range e.Details {
+		m, _ := d.(map[string]any)
+		switch m["reason"] {
+		case "API_KEY_INVALID", "API_KEY_SERVICE_BLOCKED":
+			return ErrorAuth
+		}
+		// 日単位の割り当てを使い切った場合は、待っても当日中は成功しません。
+		if violations, ok := m["violations"].([]any); ok {
+			for _, v := range violations {
+				vm, _ := v.(map[string]any)
+				if id, _ := vm["quotaId"].(string); strings.Contains(id, "PerDay") {
+					return ErrorQuota
+				}
+			}
+		}
+	}
+
+	switch {
+	case e.Code == http.StatusTooManyRequests:
+		return ErrorRateLimit
+	case e.Code == http.StatusUnauthorized || e.Code == http.StatusForbidden:
+		return ErrorAuth
+	case e.Code >= 500:
+		return ErrorServer
+	case e.Code >= 400:
+		return ErrorRequest
+	}
+	return ErrorUnknown
+}
+
+// retryAfter は、Retry-After ヘッダーか RetryInfo の詳細で指定された待ち時間を返します。
+func retryAfter(e *googleapi.Error) time.Duration {
+	if v := e.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return time.Until(t)
+		}
+	}
+	for _, d := range e.Details {
+		m, _ := d.(map[string]any)
+		if delay, ok := m["retryDelay"].(string); ok {
+			if dur, err := time.ParseDuration(delay); err == nil {
+				return dur
+			}
+		}
+	}
+	return 0
+}
+
+// blockDetail は、ブロックされた理由と、問題があると評価された安全性のカテゴリを説明します。
+func blockDetail(e *genai.BlockedError) string {
+	var parts []string
+	var ratings []*genai.SafetyRating
+	if e.PromptFeedback != nil {
+		parts = append(parts, "prompt blocked: "+enumName(e.PromptFeedback.BlockReason.String(), "BlockReason"))
+		ratings = append(ratings, e.PromptFeedback.SafetyRatings...)
+	}
+	if e.Candidate != nil {
+		parts = append(parts, "finish reason: "+enumName(e.Candidate.FinishReason.String(), "FinishReason"))
+		ratings = append(ratings, e.Candidate.SafetyRatings...)
+	}
+	if r := formatRatings(ratings); r != "" {
+		parts = append(parts, r)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// formatRatings は、ブロックの原因になったか、中程度以上と評価された安全性のカテゴリを列挙します。
+func formatRatings(ratings []*genai.SafetyRating) string {
+	var flagged []string
+	for _, r := range ratings {
+		if r.Blocked || r.Probability >= genai.HarmProbabilityMedium {
+			flagged = append(flagged, fmt.Sprintf("%s=%s",
+				enumName(r.Category.String(), "HarmCategory"), enumName(r.Probability.String(), "HarmProbability")))
+		}
+	}
+	if len(flagged) == 0 {
+		return ""
+	}
+	return "safety ratings: " + strings.Join(flagged, ", ")
+}
+
+// enumName は、genai の列挙型の名前から型名の接頭辞を取り除きます（FinishReasonMaxTokens → MaxTokens）。
+func enumName(s, prefix string) string {
+	return strings.TrimPrefix(s, prefix)
+}
+
+// withRetry は、op を実行し、再試行できるエラーの場合は指数的に間隔を空けて再試行します。
+// バックエンドが待ち時間を指定した場合はそれに従います。
+// 最終的に失敗した場合は、分類された *BackendError を返します。
+func (c *Chat) withRetry(ctx context.Context, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+		be := classify(err)
+		if !be.Retryable() || attempt > maxRetries {
+			return be
+		}
+
+		wait := backoff(attempt)
+		if be.RetryAfter > 0 {
+			wait = be.RetryAfter + retryAfterSlop
+		}
+		fmt.Fprintln(c.out, utils.ErrorColor(secret.Redact(fmt.Sprintf("%v. Retrying in %s (%d/%d)...",
+			be, wait.Round(100*time.Millisecond), attempt, maxRetries))))
+
+		if err := sleep(ctx, wait); err != nil {
+			return classify(err)
+		}
+	}
+}
+
+// sleep は、d だけ待ちます。待っている間に ctx が終了した場合は、その理由を返します。
+// テストでは、実際に待たずに済むように置き換えます。
+var sleep = func(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// jitter は、0 以上 n 未満の乱数を返します。テストでは、待ち時間が決まるように置き換えます。
+var jitter = rand.Int63n
+
+// backoff は、attempt 回目の失敗の後に待つ時間を返します。
+// 待ち時間は試行ごとに倍になり、同時に失敗したクライアントが集中しないよう 50〜100% の範囲でばらつかせます。
+func backoff(attempt int) time.Duration {
+	d := baseBackoff << (attempt - 1)
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(jitter(int64(d/2)+1))
+}
@@ -0,0 +1,242 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/googleapi"
+)
+
+// stubSleep は、withRetry が実際に待たずに、待とうとした時間を記録するようにします。
+func stubSleep(t *testing.T) *[]time.Duration {
+	t.Helper()
+	var waits []time.Duration
+	orig := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return ctx.Err()
+	}
+	t.Cleanup(func() { sleep = orig })
+	return &waits
+}
+
+// stubJitter は、backoff のばらつきを常に n を返す関数に置き換えます（上限を超える値は上限にします）。
+func stubJitter(t *testing.T, n int64) {
+	t.Helper()
+	orig := jitter
+	jitter = func(max int64) int64 { return min(n, max-1) }
+	t.Cleanup(func() { jitter = orig })
+}
+
+// apiError は、status のステータスコードと header, details を持つ googleapi.Error を作成します。
+func apiError(status int, header http.Header, details ...any) error {
+	return fmt.Errorf("wrapped: %w", &googleapi.Error{Code: status, Message: "failed", Header: header, Details: details})
+}
+
+// quotaFailure は、quotaId の割り当てを超えたことを示すエラーの詳細です。
+func quotaFailure(quotaID string) map[string]any {
+	return map[string]any{
+		"@type":      "type.googleapis.com/google.rpc.QuotaFailure",
+		"violations": []any{map[string]any{"quotaMetric": "generate_content_requests", "quotaId": quotaID}},
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		kind       ErrorKind
+		retryAfter time.Duration
+		retryable  bool
+	}{
+		{name: "rate limit", err: apiError(429, nil), kind: ErrorRateLimit, retryable: true},
+		{name: "per-minute quota", err: apiError(429, nil, quotaFailure("GenerateRequestsPerMinutePerProjectPerModel")), kind: ErrorRateLimit, retryable: true},
+		{name: "per-day quota", err: apiError(429, nil, quotaFailure("GenerateRequestsPerDayPerProjectPerModel-FreeTier")), kind: ErrorQuota},
+		{name: "unauthorized", err: apiError(401, nil), kind: ErrorAuth},
+		{name: "forbidden", err: apiError(403, nil), kind: ErrorAuth},
+		{name: "invalid key", err: apiError(400, nil, map[string]any{"reason": "API_KEY_INVALID"}), kind: ErrorAuth},
+		{name: "bad request", err: apiError(400, nil), kind: ErrorRequest},
+		{name: "internal", err: apiError(500, nil), kind: ErrorServer, retryable: true},
+		{name: "unavailable", err: apiError(503, nil), kind: ErrorServer, retryable: true},
+		{name: "Retry-After seconds", err: apiError(429, http.Header{"Retry-After": {"7"}}), kind: ErrorRateLimit, retryAfter: 7 * time.Second, retryable: true},
+		{
+			name:       "RetryInfo",
+			err:        apiError(429, nil, map[string]any{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "12s"}),
+			kind:       ErrorRateLimit,
+			retryAfter: 12 * time.Second,
+			retryable:  true,
+		},
+		{
+			name:       "Retry-After over the cap",
+			err:        apiError(503, http.Header{"Retry-After": {"180"}}),
+			kind:       ErrorServer,
+			retryAfter: 3 * time.Minute,
+		},
+		{name: "network", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, kind: ErrorNetwork, retryable: true},
+		{name: "unexpected EOF", err: fmt.Errorf("reading: %w", io.ErrUnexpectedEOF), kind: ErrorNetwork, retryable: true},
+		{name: "deadline", err: context.DeadlineExceeded, kind: ErrorNetwork, retryable: true},
+		{name: "canceled", err: context.Canceled, kind: ErrorUnknown},
+		{name: "already classified", err: &BackendError{Kind: ErrorQuota, Err: errors.New("x")}, kind: ErrorQuota},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			be := classify(tt.err)
+			if be.Kind != tt.kind || be.RetryAfter != tt.retryAfter || be.Retryable() != tt.retryable {
+				t.Errorf("classify = {Kind: %s, RetryAfter: %s, Retryable: %v}, want {%s, %s, %v}",
+					be.Kind, be.RetryAfter, be.Retryable(), tt.kind, tt.retryAfter, tt.retryable)
+			}
+			if !errors.Is(be, tt.err) && !errors.Is(tt.err, be) {
+				t.Errorf("classify(%v) does not wrap the original error", tt.err)
+			}
+		})
+	}
+}
+
+func TestRetryAfterDate(t *testing.T) {
+	date := time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat)
+	be := classify(apiError(429, http.Header{"Retry-After": {date}}))
+	// HTTP の日時は秒単位のため、切り捨てた分だけ短くなります。
+	if be.RetryAfter <= 88*time.Second || be.RetryAfter > 90*time.Second || !be.Retryable() {
+		t.Errorf("RetryAfter = %s, Retryable = %v, want about 90s", be.RetryAfter, be.Retryable())
+	}
+
+	date = time.Now().Add(10 * time.Minute).UTC().Format(http.TimeFormat)
+	if be := classify(apiError(429, http.Header{"Retry-After": {date}})); be.Retryable() {
+		t.Errorf("RetryAfter = %s is retryable, want it over the cap", be.RetryAfter)
+	}
+}
+
+func TestSafetyDetail(t *testing.T) {
+	blocked := &genai.BlockedError{
+		PromptFeedback: &genai.PromptFeedback{
+			BlockReason: genai.BlockReasonSafety,
+			SafetyRatings: []*genai.SafetyRating{
+				{Category: genai.HarmCategoryHarassment, Probability: genai.HarmProbabilityHigh, Blocked: true},
+				{Category: genai.HarmCategoryHateSpeech, Probability: genai.HarmProbabilityLow},
+				{Category: genai.HarmCategoryDangerousContent, Probability: genai.HarmProbabilityMedium},
+			},
+		},
+	}
+	be := classify(blocked)
+	want := "prompt blocked: Safety; safety ratings: Harassment=High, DangerousContent=Medium"
+	if be.Kind != ErrorSafety || be.Detail != want || be.Retryable() {
+		t.Errorf("classify = {Kind: %s, Detail: %q}, want {%s, %q}", be.Kind, be.Detail, ErrorSafety, want)
+	}
+	if !strings.HasPrefix(be.Error(), "blocked by safety settings ("+want+")") {
+		t.Errorf("Error() = %q", be.Error())
+	}
+
+	blocked = &genai.BlockedError{Candidate: &genai.Candidate{FinishReason: genai.FinishReasonSafety}}
+	if be := classify(blocked); be.Detail != "finish reason: Safety" {
+		t.Errorf("Detail = %q", be.Detail)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 500 * time.Millisecond, time.Second},
+		{2, time.Second, 2 * time.Second},
+		{3, 2 * time.Second, 4 * time.Second},
+		{6, 15 * time.Second, 30 * time.Second},
+		{100, 15 * time.Second, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		stubJitter(t, 0)
+		if got := backoff(tt.attempt); got != tt.min {
+			t.Errorf("backoff(%d) with no jitter = %s, want %s", tt.attempt, got, tt.min)
+		}
+		stubJitter(t, 1<<62)
+		if got := backoff(tt.attempt); got != tt.max {
+			t.Errorf("backoff(%d) with full jitter = %s, want %s", tt.attempt, got, tt.max)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	stubJitter(t, 0)
+	tests := []struct {
+		name      string
+		errs      []error // op が順に返すエラー（使い切ると成功）
+		wantCalls int
+		wantWaits []time.Duration
+		wantKind  ErrorKind // 空の場合は成功
+	}{
+		{
+			name:      "Retry-After",
+			errs:      []error{apiError(429, http.Header{"Retry-After": {"3"}}), apiError(429, http.Header{"Retry-After": {"3"}})},
+			wantCalls: 3,
+			wantWaits: []time.Duration{3*time.Second + retryAfterSlop, 3*time.Second + retryAfterSlop},
+		},
+		{
+			name:      "backoff",
+			errs:      []error{apiError(503, nil), apiError(503, nil)},
+			wantCalls: 3,
+			wantWaits: []time.Duration{500 * time.Millisecond, time.Second},
+		},
+		{name: "quota", errs: []error{apiError(429, nil, quotaFailure("PerDay"))}, wantCalls: 1, wantKind: ErrorQuota},
+		{name: "over the cap", errs: []error{apiError(429, http.Header{"Retry-After": {"600"}})}, wantCalls: 1, wantKind: ErrorRateLimit},
+		{
+			name:      "gives up",
+			errs:      []error{apiError(500, nil), apiError(500, nil), apiError(500, nil), apiError(500, nil), apiError(500, nil)},
+			wantCalls: maxRetries + 1,
+			wantWaits: []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second},
+			wantKind:  ErrorServer,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waits := stubSleep(t)
+			var out bytes.Buffer
+			c := &Chat{out: &out}
+			calls := 0
+			err := c.withRetry(context.Background(), func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+
+			var be *BackendError
+			switch {
+			case tt.wantKind == "" && err != nil:
+				t.Errorf("err = %v, want success", err)
+			case tt.wantKind != "" && (!errors.As(err, &be) || be.Kind != tt.wantKind):
+				t.Errorf("err = %v, want %s", err, tt.wantKind)
+			}
+			if calls != tt.wantCalls || fmt.Sprint(*waits) != fmt.Sprint(tt.wantWaits) {
+				t.Errorf("calls = %d, waits = %v, want %d and %v", calls, *waits, tt.wantCalls, tt.wantWaits)
+			}
+			if n := strings.Count(out.String(), "Retrying in"); n != len(tt.wantWaits) {
+				t.Errorf("printed %d retry notices, want %d:\n%s", n, len(tt.wantWaits), out.String())
+			}
+		})
+	}
+}
+
+func TestWithRetryCanceled(t *testing.T) {
+	stubSleep(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := (&Chat{out: io.Discard}).withRetry(ctx, func() error {
+		calls++
+		return apiError(503, nil)
+	})
+	var be *BackendError
+	if calls != 1 || !errors.As(err, &be) || !errors.Is(err, context.Canceled) {
+		t.Errorf("calls = %d, err = %v, want one call and the cancellation", calls, err)
+	}
+}
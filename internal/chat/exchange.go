@@ -0,0 +1,95 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/kou12345/gollm/internal/history"
+	"github.com/kou12345/gollm/pkg/utils"
+)
+
+// Request は、ルームの履歴を使わずに送信する会話です。
+// OpenAI 互換のプロキシのように、会話の全体を呼び出し側が管理する場合に使用します。
+type Request struct {
+	Model       string                // 使用するモデル名（空の場合は Options.Model）
+	System      string                // システム指示
+	Messages    []history.ChatMessage // user と assistant のメッセージ（最後は user）
+	Temperature *float32
+	TopP        *float32
+	MaxTokens   *int32 // 応答のトークン数の上限
+	Stop        []string
+	JSON        bool // 応答を JSON に限定するかどうか
+}
+
+// Response は、Exchange で得られた応答です。
+type Response struct {
+	Text         string
+	FinishReason string        // 生成が終了した理由（Stop, MaxTokens, Safety など）
+	Usage        history.Usage // リクエストに使用したモデルとトークン数
+}
+
+// Exchange は、req の会話をそのままモデルに送信し、応答を返します。ツールは使用しません。
+// onText が nil でない場合は、応答のテキストを受信するたびに呼び出します。
+// 最後のメッセージと応答は記録としてルームに保存します。保存に失敗しても応答は返します。
+// Exchange は複数の goroutine から同時に呼び出せます。
+func (c *Chat) Exchange(ctx context.Context, req Request, onText func(string)) (*Response, error) {
+	if len(req.Messages) == 0 || req.Messages[len(req.Messages)-1].Role != "user" {
+		return nil, errors.New("the last message must be from the user")
+	}
+	last := req.Messages[len(req.Messages)-1]
+
+	name := req.Model
+	if name == "" {
+		name = c.opts.Model
+	}
+	m := c.client.GenerativeModel(name)
+	m.Temperature = req.Temperature
+	m.TopP = req.TopP
+	m.MaxOutputTokens = req.MaxTokens
+	m.StopSequences = req.Stop
+	if req.System != "" {
+		m.SystemInstruction = genai.NewUserContent(genai.Text(req.System))
+	}
+	if req.JSON {
+		m.ResponseMIMEType = "application/json"
+	}
+	cs := m.StartChat()
+	cs.History = c.toContents(req.Messages[:len(req.Messages)-1])
+
+	r, err := c.streamSession(ctx, cs, onText, genai.Text(last.Content))
+	if err != nil {
+		return nil, err
+	}
+	if r.text == "" {
+		return nil, emptyResponseError(r)
+	}
+
+	resp := &Response{
+		Text:         r.text,
+		FinishReason: enumName(r.finish.String(), "FinishReason"),
+		Usage:        history.Usage{Model: name},
+	}
+	if r.usage != nil {
+		resp.Usage.PromptTokens = r.usage.PromptTokenCount
+		resp.Usage.CompletionTokens = r.usage.CandidatesTokenCount
+	}
+	c.record(ctx, last.Content, resp)
+	return resp, nil
+}
+
+// record は、Exchange のやり取りをルームに保存します。
+func (c *Chat) record(ctx context.Context, input string, resp *Response) {
+	tokens := c.countTokens(ctx, genai.Text(input))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.appendMessage("user", input, tokens, history.Usage{})
+	if err == nil {
+		err = c.appendMessage("assistant", resp.Text, resp.Usage.CompletionTokens, resp.Usage)
+	}
+	if err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Failed to save message: %v", err)))
+	}
+}
@@ -0,0 +1,142 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/kou12345/gollm/internal/jsonschema"
+	"github.com/kou12345/gollm/pkg/utils"
+)
+
+// maxSchemaRetries は、応答がスキーマを満たさなかったときに再送信する回数の上限です。
+const maxSchemaRetries = 2
+
+// setSchema は、応答を schema に従う JSON に限定します。schema が nil の場合は通常の応答に戻します。
+// Gemini は JSON の応答と関数呼び出しを同時に使用できないため、スキーマを設定している間はツールを宣言しません。
+// genai.Schema で表現できないスキーマは、システム指示としてモデルに渡します。
+func (c *Chat) setSchema(schema map[string]any) {
+	c.schema = schema
+	c.model.ResponseMIMEType = ""
+	c.model.ResponseSchema = nil
+	c.model.SystemInstruction = nil
+	c.model.Tools = nil
+	if c.opts.Instructions != "" {
+		c.model.SystemInstruction = genai.NewUserContent(genai.Text(c.opts.Instructions))
+	}
+
+	if schema == nil {
+		if len(c.decls) > 0 {
+			c.model.Tools = []*genai.Tool{{FunctionDeclarations: c.decls}}
+		}
+		return
+	}
+
+	c.model.ResponseMIMEType = "application/json"
+	if s, err := toGenaiSchema(schema); err == nil {
+		c.model.ResponseSchema = s
+	} else {
+		data, _ := json.Marshal(schema)
+		text := "Respond only with JSON that matches this JSON schema:\n" + string(data)
+		if c.opts.Instructions != "" {
+			text = c.opts.Instructions + "\n\n" + text
+		}
+		c.model.SystemInstruction = genai.NewUserContent(genai.Text(text))
+	}
+}
+
+// conform は、応答がスキーマを満たしているかを検証し、満たしていない場合は検証エラーを伝えて再送信します。
+// 成功した場合は余分な空白のない JSON と、再送信を含めたトークン使用量を返します。
+func (c *Chat) conform(response string, usage *genai.UsageMetadata) (string, *genai.UsageMetadata, error) {
+	for retry := 0; ; retry++ {
+		data, err := jsonschema.ValidateJSON(c.schema, []byte(response))
+		if err == nil {
+			return string(data), usage, nil
+		}
+		if retry == maxSchemaRetries {
+			return "", usage, fmt.Errorf("response did not match the JSON schema after %d retries: %w", maxSchemaRetries, err)
+		}
+
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Response did not match the JSON schema (%v). Retrying...", err)))
+		var u *genai.UsageMetadata
+		response, u, err = c.sendMessage(genai.Text(fmt.Sprintf(
+			"Your previous response did not match the required JSON schema: %v\nReply again with only a JSON value that satisfies the schema.", err)))
+		usage = addUsage(usage, u)
+		if err != nil {
+			return "", usage, err
+		}
+	}
+}
+
+// schemaTypes は、JSON Schema の型名と genai.Type の対応です。
+var schemaTypes = map[string]genai.Type{
+	"string":  genai.TypeString,
+	"number":  genai.TypeNumber,
+	"integer": genai.TypeInteger,
+	"boolean": genai.TypeBoolean,
+	"array":   genai.TypeArray,
+	"object":  genai.TypeObject,
+}
+
+// toGenaiSchema は、JSON Schema を Gemini が受け付ける genai.Schema に変換します。
+// genai.Schema が表現できるのは OpenAPI のサブセットのため、対応していないキーワードは無視します。
+// "type": ["string", "null"] のような指定は Nullable として扱います。
+func toGenaiSchema(s map[string]any) (*genai.Schema, error) {
+	out := &genai.Schema{}
+
+	switch t := s["type"].(type) {
+	case string:
+		out.Type = schemaTypes[t]
+	case []any:
+		for _, v := range t {
+			name, _ := v.(string)
+			if name == "null" {
+				out.Nullable = true
+			} else if typ, ok := schemaTypes[name]; ok {
+				out.Type = typ
+			}
+		}
+	}
+	if out.Type == genai.TypeUnspecified {
+		return nil, fmt.Errorf("unsupported schema type %v", s["type"])
+	}
+
+	out.Description, _ = s["description"].(string)
+	out.Format, _ = s["format"].(string)
+	if nullable, ok := s["nullable"].(bool); ok {
+		out.Nullable = nullable
+	}
+	if enum, ok := s["enum"].([]any); ok {
+		for _, v := range enum {
+			out.Enum = append(out.Enum, fmt.Sprint(v))
+		}
+	}
+	if required, ok := s["required"].([]any); ok {
+		for _, v := range required {
+			out.Required = append(out.Required, fmt.Sprint(v))
+		}
+	}
+
+	if items, ok := s["items"].(map[string]any); ok {
+		schema, err := toGenaiSchema(items)
+		if err != nil {
+			return nil, fmt.Errorf("items: %w", err)
+		}
+		out.Items = schema
+	}
+	if props, ok := s["properties"].(map[string]any); ok {
+		out.Properties = make(map[string]*genai.Schema, len(props))
+		for name, v := range props {
+			prop, ok := v.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("property %q: schema must be an object", name)
+			}
+			schema, err := toGenaiSchema(prop)
+			if err != nil {
+				return nil, fmt.Errorf("property %q: %w", name, err)
+			}
+			out.Properties[name] = schema
+		}
+	}
+	return out, nil
+}
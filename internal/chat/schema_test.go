@@ -0,0 +1,80 @@
+package chat
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSchemaCommand は、/schema で設定したスキーマがルームに保存され、ルームを開き直しても使用され、
+// /schema off で解除されることを確認します。
+func TestSchemaCommand(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "person.json")
+	schema := `{"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]}`
+	if err := os.WriteFile(path, []byte(schema), 0600); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(broken, []byte(`{"type": `), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := openStore(t)
+	c, _ := newFakeChat(t, store, "default", StrategyKeepPinned)
+	var out bytes.Buffer
+	c.out = &out
+
+	c.handleCommand("/schema " + path)
+	if !strings.Contains(out.String(), "Responses in this room will be JSON matching "+path) {
+		t.Fatalf("/schema printed %q", out.String())
+	}
+	room, err := store.OpenRoom("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.JSONSchema != `{"properties":{"name":{"type":"string"}},"required":["name"],"type":"object"}` {
+		t.Errorf("stored schema = %s", room.JSONSchema)
+	}
+
+	// 読み込めないスキーマは保存せず、設定済みのスキーマを残します。
+	out.Reset()
+	c.handleCommand("/schema " + broken)
+	if !strings.Contains(out.String(), "Failed to load JSON schema") {
+		t.Errorf("/schema with a broken file printed %q", out.String())
+	}
+	if room, _ := store.OpenRoom("default"); room.JSONSchema == "" {
+		t.Error("a broken schema cleared the stored one")
+	}
+
+	// 開き直したルームでも、保存したスキーマで応答を JSON に限定します。
+	reopened, _ := newFakeChat(t, store, "default", StrategyKeepPinned)
+	if reopened.schema == nil || reopened.schema["required"].([]any)[0] != "name" {
+		t.Fatalf("reopened schema = %v", reopened.schema)
+	}
+	if reopened.model.ResponseMIMEType != "application/json" || reopened.model.ResponseSchema == nil {
+		t.Errorf("MIME type = %q, schema = %v", reopened.model.ResponseMIMEType, reopened.model.ResponseSchema)
+	}
+	out.Reset()
+	reopened.out = &out
+	reopened.handleCommand("/schema")
+	if !strings.Contains(out.String(), `"required": [`) {
+		t.Errorf("/schema printed %q", out.String())
+	}
+
+	// 他のルームには影響しません。
+	if other, _ := newFakeChat(t, store, "other", StrategyKeepPinned); other.schema != nil {
+		t.Errorf("other room schema = %v", other.schema)
+	}
+
+	reopened.handleCommand("/schema off")
+	if room, _ := store.OpenRoom("default"); room.JSONSchema != "" {
+		t.Errorf("stored schema = %s after /schema off", room.JSONSchema)
+	}
+	again, _ := newFakeChat(t, store, "default", StrategyKeepPinned)
+	if again.schema != nil || again.model.ResponseMIMEType != "" {
+		t.Errorf("schema = %v, MIME type = %q after /schema off", again.schema, again.model.ResponseMIMEType)
+	}
+}
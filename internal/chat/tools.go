@@ -0,0 +1,88 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/kou12345/gollm/internal/secret"
+	"github.com/kou12345/gollm/internal/tools"
+	"github.com/kou12345/gollm/pkg/utils"
+)
+
+// maxToolRounds は、1つのメッセージに対してツールを呼び出せる回数の上限です。
+const maxToolRounds = 10
+
+// ConfirmFunc は、副作用のあるツールを実行してよいかをユーザーに確認する関数です。
+type ConfirmFunc func(prompt string) bool
+
+// functionDeclarations は、登録されたツールを Gemini に渡す宣言に変換します。
+// MCP サーバーのツールのように Gemini で表現できないスキーマを持つツールは、警告を表示して除外します。
+func functionDeclarations(w io.Writer, r *tools.Registry) []*genai.FunctionDeclaration {
+	var decls []*genai.FunctionDeclaration
+	for _, t := range r.All() {
+		decl := &genai.FunctionDeclaration{Name: t.Name, Description: t.Description}
+		if len(t.Parameters) > 0 {
+			schema, err := toGenaiSchema(t.Parameters)
+			if err != nil {
+				fmt.Fprintln(w, utils.ErrorColor(fmt.Sprintf("Skipping tool %s: %v", t.Name, err)))
+				continue
+			}
+			// 引数のないツールは properties が空になるため、パラメータを宣言しません。
+			if schema.Type != genai.TypeObject || len(schema.Properties) > 0 {
+				decl.Parameters = schema
+			}
+		}
+		decls = append(decls, decl)
+	}
+	return decls
+}
+
+// runTools は、モデルが要求した関数呼び出しを実行し、結果をモデルに返す Part を作成します。
+// 未登録のツール、ユーザーが拒否した呼び出し、失敗した呼び出しは error を含む結果になります。
+func (c *Chat) runTools(ctx context.Context, calls []genai.FunctionCall) []genai.Part {
+	parts := make([]genai.Part, 0, len(calls))
+	for _, call := range calls {
+		parts = append(parts, genai.FunctionResponse{Name: call.Name, Response: c.runTool(ctx, call)})
+	}
+	return parts
+}
+
+// runTool は、単一の関数呼び出しを実行します。
+func (c *Chat) runTool(ctx context.Context, call genai.FunctionCall) map[string]any {
+	args, _ := json.Marshal(call.Args)
+	desc := secret.Redact(fmt.Sprintf("%s(%s)", call.Name, args))
+
+	t, ok := c.opts.Tools.Lookup(call.Name)
+	if !ok {
+		fmt.Fprintln(c.out, utils.ErrorColor("Model requested unknown tool "+desc))
+		return map[string]any{"error": fmt.Sprintf("unknown tool %q", call.Name)}
+	}
+	if t.SideEffects && !c.confirm(fmt.Sprintf("Allow the model to run %s?", desc)) {
+		return map[string]any{"error": "the user declined to run this tool"}
+	}
+
+	fmt.Fprintln(c.out, utils.AIColor("Running tool "+desc))
+	result, err := t.Run(ctx, call.Args)
+	if err != nil {
+		fmt.Fprintln(c.out, utils.ErrorColor(fmt.Sprintf("Tool %s failed: %v", call.Name, err)))
+		return map[string]any{"error": secret.Redact(err.Error())}
+	}
+	return result
+}
+
+// confirmOnTerminal は、w に質問を表示し、scanner から y/N の回答を読み取る ConfirmFunc を返します。
+func confirmOnTerminal(w io.Writer, scanner *bufio.Scanner) ConfirmFunc {
+	return func(prompt string) bool {
+		fmt.Fprint(w, utils.ErrorColor(prompt+" [y/N]: "))
+		if !scanner.Scan() {
+			return false
+		}
+		answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		return answer == "y" || answer == "yes"
+	}
+}
@@ -0,0 +1,650 @@
+// Package config は、gollm の設定ファイル・環境変数・コマンドラインフラグを
+// 統合した実行時設定を提供します。
+//
+// 設定は以下の順に読み込まれ、後のものが前のものを上書きします。
+//
+//  1. 組み込みの既定値
+//  2. ユーザー設定ファイル ($XDG_CONFIG_HOME/gollm/config.toml)
+//  3. プロジェクトローカル設定ファイル (カレントディレクトリの .gollm.toml。コマンドを実行する設定は書けません)
+//  4. 環境変数 (GOLLM_*)
+//  5. コマンドラインフラグ
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/kou12345/gollm/internal/atomicfile"
+	"github.com/kou12345/gollm/internal/secret"
+)
+
+// ProjectFile は、プロジェクトローカル設定ファイルの名前を定義します。
+// 作業ディレクトリのファイルはクローンしたリポジトリに含まれている可能性があるため、
+// コマンドを実行する設定や秘密情報を読み込む設定（userOnlyKeys）は書けません。
+const ProjectFile = ".gollm.toml"
+
+// userOnlyKeys は、ユーザー設定ファイルでのみ指定できる項目です。* は任意の名前に一致し、
+// 項目の下にある全ての項目（mcp_servers.*.command など）も含みます。
+var userOnlyKeys = []string{
+	"mcp_servers",             // MCP サーバーのコマンドを起動する
+	"backends.*.api_key_cmd",  // sh -c で実行する
+	"backends.*.api_key_file", // 任意のファイルを API キーとして送信する
+	"tools.run_shell",         // モデルにシェルのコマンドを実行させる
+}
+
+// themes は、theme に指定できる Markdown 描画のスタイル名です。
+var themes = []string{"auto", "ascii", "dark", "dracula", "light", "notty", "pink"}
+
+// strategies は、context.strategy に指定できる戦略の名前です。
+var strategies = []string{"drop_oldest", "keep_pinned", "summarise"}
+
+// Config は、gollm の実行時設定を表現する構造体です。
+type Config struct {
+	Backend      string               `toml:"backend"`       // 使用するバックエンドの名前（Backends のキー）
+	Model        string               `toml:"model"`         // 既定のモデル名（空の場合はバックエンドの設定を使用）
+	DBPath       string               `toml:"db_path"`       // SQLite データベースファイルのパス（空の場合はデータディレクトリの gollm.db）
+	Theme        string               `toml:"theme"`         // Markdown 描画のスタイル（auto, dark, light, notty など）
+	TemplatesDir string               `toml:"templates_dir"` // プロンプトテンプレートのディレクトリ（空の場合は設定ディレクトリの templates）
+	Backends     map[string]Backend   `toml:"backends"`      // 名前付きバックエンドの設定
+	Keybindings  map[string][]string  `toml:"keybindings"`   // TUI のアクション名とキーの対応
+	Context      Context              `toml:"context"`       // コンテキストウィンドウの管理方法
+	Pricing      map[string]Price     `toml:"pricing"`       // モデル名（の接頭辞）ごとの料金
+	Attach       Attach               `toml:"attach"`        // ファイル添付の上限
+	Tools        Tools                `toml:"tools"`         // モデルから呼び出せるツール
+	MCPServers   map[string]MCPServer `toml:"mcp_servers"`   // 接続する MCP サーバー
+	Project      Project              `toml:"project"`       // git リポジトリ内で起動したときの動作
+
+	// Sources は、実際に読み込まれた設定ファイルのパスです。
+	Sources []string `toml:"-"`
+}
+
+// Backend は、単一のバックエンドの設定を表現する構造体です。
+// API キーの取得方法は ResolveAPIKey を参照してください。
+type Backend struct {
+	Type       string `toml:"type"`         // バックエンドの種類（例：gemini）
+	Model      string `toml:"model"`        // このバックエンドで使用するモデル名
+	APIKey     string `toml:"api_key"`      // API キー（平文での保存は推奨しません）
+	APIKeyEnv  string `toml:"api_key_env"`  // API キーを読み込む環境変数名
+	APIKeyFile string `toml:"api_key_file"` // API キーを保存したファイルのパス（パーミッション 600 が必要）
+	APIKeyCmd  string `toml:"api_key_cmd"`  // API キーを出力するコマンド（例：pass show gemini）
+	Script     string `toml:"script"`       // fake バックエンドの応答を定義するスクリプト（空の場合は入力をそのまま返す）
+}
+
+// Context は、会話履歴がモデルのコンテキストウィンドウに近づいたときの扱いを設定します。
+type Context struct {
+	Strategy  string  `toml:"strategy"`   // drop_oldest, keep_pinned, summarise のいずれか
+	MaxTokens int32   `toml:"max_tokens"` // 入力トークン数の上限（0 の場合はモデルの上限を使用）
+	Threshold float64 `toml:"threshold"`  // 上限に対してこの割合を超えたら戦略を適用する（0〜1）
+}
+
+// Attach は、プロンプトに添付するファイルのサイズの上限を設定します。
+type Attach struct {
+	MaxFileSize  int64 `toml:"max_file_size"`  // テキストファイル1つあたりの上限（バイト）
+	MaxTotalSize int64 `toml:"max_total_size"` // 1回の添付の合計の上限（バイト）
+	MaxMediaSize int64 `toml:"max_media_size"` // 画像やPDF1つあたりの上限（バイト）
+}
+
+// Tools は、モデルから呼び出せる組み込みツールを設定します。
+type Tools struct {
+	Enabled  *bool `toml:"enabled"`   // read_file, list_dir, grep を有効にするかどうか（既定は有効）
+	RunShell bool  `toml:"run_shell"` // run_shell を有効にするかどうか（実行前に確認します）
+}
+
+// Project は、git リポジトリ内で起動したときに、ルームをリポジトリごとに分ける動作を設定します。
+type Project struct {
+	Scoped        *bool  `toml:"scoped"`         // リポジトリごとにルームを分けるかどうか（既定は有効）
+	ContextFile   string `toml:"context_file"`   // リポジトリのルートから読み込んでモデルに渡すファイルの名前
+	AttachContext *bool  `toml:"attach_context"` // ContextFile が存在する場合にモデルに渡すかどうか（既定は有効）
+}
+
+// MCPServer は、stdio で接続する Model Context Protocol サーバーの起動方法を設定します。
+type MCPServer struct {
+	Command string            `toml:"command"` // 実行するコマンド
+	Args    []string          `toml:"args"`    // コマンドの引数
+	Env     map[string]string `toml:"env"`     // 追加する環境変数
+	Dir     string            `toml:"dir"`     // 作業ディレクトリ（空の場合はカレントディレクトリ）
+}
+
+// Price は、モデルの料金を100万トークンあたりの米ドルで表現する構造体です。
+type Price struct {
+	Input  float64 `toml:"input"`  // 入力トークンの料金
+	Output float64 `toml:"output"` // 出力トークンの料金
+}
+
+// Cost は、指定されたトークン数に対する料金を米ドルで返します。
+func (p Price) Cost(promptTokens, completionTokens int64) float64 {
+	return (float64(promptTokens)*p.Input + float64(completionTokens)*p.Output) / 1_000_000
+}
+
+// Overrides は、コマンドラインフラグで指定された設定値を保持します。
+// 空文字列のフィールドは指定されなかったものとして扱われます。
+type Overrides struct {
+	Backend string
+	Model   string
+	DBPath  string
+	Theme   string
+}
+
+// Default は、組み込みの既定値で初期化された Config を返します。
+func Default() *Config {
+	return &Config{
+		Backend: "gemini",
+		Theme:   "auto",
+		Backends: map[string]Backend{
+			"gemini": {Type: "gemini", Model: "gemini-1.5-flash", APIKeyEnv: "GEMINI_API_KEY"},
+			"fake":   {Type: "fake", Model: "fake"},
+		},
+		Keybindings: map[string][]string{
+			"quit":            {"ctrl+c", "q"},
+			"help":            {"?"},
+			"next_pane":       {"tab"},
+			"prev_pane":       {"shift+tab"},
+			"toggle_sidebar":  {"ctrl+b"},
+			"back":            {"esc"},
+			"open_room":       {"enter"},
+			"filter":          {"/"},
+			"pin_room":        {"p"},
+			"archive_room":    {"a"},
+			"toggle_archived": {"A"},
+			"toggle_projects": {"P"},
+			"up":              {"up", "k"},
+			"down":            {"down", "j"},
+			"page_up":         {"pgup", "b"},
+			"page_down":       {"pgdown", "f", "space"},
+			"top":             {"g g", "home"},
+			"bottom":          {"G", "end"},
+			"prev_message":    {"{"},
+			"next_message":    {"}"},
+			"search":          {"/"},
+			"next_match":      {"n"},
+			"prev_match":      {"N"},
+			"yank":            {"y"},
+			"prev_branch":     {"h", "left"},
+			"next_branch":     {"l", "right"},
+			"insert":          {"i"},
+			"attach":          {"ctrl+o"},
+			"compare":         {"ctrl+g"},
+			"send":            {"enter"},
+			"newline":         {"alt+enter", "ctrl+j"},
+		},
+		Project: Project{
+			ContextFile: "GOLLM.md",
+		},
+		Context: Context{
+			Strategy:  "keep_pinned",
+			Threshold: 0.8,
+		},
+		Attach: Attach{
+			MaxFileSize:  256 << 10,
+			MaxTotalSize: 16 << 20,
+			MaxMediaSize: 15 << 20,
+		},
+		// 128k トークン以下のプロンプトに対する公開料金です。
+		Pricing: map[string]Price{
+			"gemini-1.5-flash": {Input: 0.075, Output: 0.30},
+			"gemini-1.5-pro":   {Input: 3.50, Output: 10.50},
+			"gemini-1.0-pro":   {Input: 0.50, Output: 1.50},
+		},
+	}
+}
+
+// UserConfigPath は、ユーザー設定ファイルのパスを返します。
+// XDG_CONFIG_HOME が設定されていればそれを、なければ ~/.config を基準にします。
+func UserConfigPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "gollm", "config.toml"), nil
+}
+
+// UserDataDir は、データベースなどのデータを保存するディレクトリのパスを返します。
+// XDG_DATA_HOME が設定されていればそれを、なければ ~/.local/share を基準にします。
+func UserDataDir() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "gollm"), nil
+}
+
+// DatabasePath は、SQLite データベースファイルのパスを返します。
+// db_path が設定されていなければ、データディレクトリの gollm.db を使用します。
+func (c *Config) DatabasePath() (string, error) {
+	if c.DBPath != "" {
+		return expandHome(c.DBPath), nil
+	}
+	dir, err := UserDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gollm.db"), nil
+}
+
+// HistoryPath は、以前のバージョンがチャット履歴を保存していた JSON ファイルのパスを返します。
+// このファイルがあれば、起動時にルームへ取り込んでから名前を変更します（history.Store.ImportChatHistory を参照）。
+func (c *Config) HistoryPath() (string, error) {
+	dir, err := UserDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "chat_history.json"), nil
+}
+
+// TemplatesPath は、プロンプトテンプレートを置くディレクトリのパスを返します。
+// templates_dir が設定されていなければ、ユーザー設定ファイルと同じディレクトリの templates を使用します。
+func (c *Config) TemplatesPath() (string, error) {
+	if c.TemplatesDir != "" {
+		return expandHome(c.TemplatesDir), nil
+	}
+	path, err := UserConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "templates"), nil
+}
+
+// Load は、既定値・設定ファイル・環境変数・フラグを順に適用した Config を返します。
+// 存在しない設定ファイルは無視されますが、構文エラーはエラーとして返します。
+func Load(flags Overrides) (*Config, error) {
+	cfg := Default()
+
+	userPath, err := UserConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.mergeFile(userPath, false); err != nil {
+		return nil, err
+	}
+	if err := cfg.mergeFile(ProjectFile, true); err != nil {
+		return nil, err
+	}
+
+	cfg.mergeEnv()
+	cfg.merge(Config{
+		Backend: flags.Backend,
+		Model:   flags.Model,
+		DBPath:  flags.DBPath,
+		Theme:   flags.Theme,
+	})
+
+	if err := cfg.validate(allDefined); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validate は、決まった値の中から選ぶ設定や範囲が決まっている設定に、不正な値が指定されていないかを検証します。
+// defined が false を返す項目は、指定されなかったものとして検証しません。
+func (c *Config) validate(defined func(key ...string) bool) error {
+	if defined("theme") && !slices.Contains(themes, c.Theme) {
+		return fmt.Errorf("unknown theme %q (expected one of %s)", c.Theme, strings.Join(themes, ", "))
+	}
+	if defined("context", "strategy") && !slices.Contains(strategies, c.Context.Strategy) {
+		return fmt.Errorf("unknown context strategy %q (expected one of %s)", c.Context.Strategy, strings.Join(strategies, ", "))
+	}
+	if defined("context", "threshold") && (c.Context.Threshold <= 0 || c.Context.Threshold > 1) {
+		return fmt.Errorf("context threshold %v must be greater than 0 and at most 1", c.Context.Threshold)
+	}
+	return nil
+}
+
+// allDefined は、全ての項目が指定されたものとして validate に検証させます。
+func allDefined(...string) bool { return true }
+
+// ActiveBackend は、現在選択されているバックエンドの名前と設定を返します。
+func (c *Config) ActiveBackend() (string, Backend, error) {
+	b, err := c.BackendNamed(c.Backend)
+	if err != nil {
+		return "", Backend{}, err
+	}
+	return c.Backend, b, nil
+}
+
+// BackendNamed は、name のバックエンドの設定を返します。種類が省略されている場合は name を種類とします。
+func (c *Config) BackendNamed(name string) (Backend, error) {
+	b, ok := c.Backends[name]
+	if !ok {
+		return Backend{}, fmt.Errorf("backend %q is not configured", name)
+	}
+	if b.Type == "" {
+		b.Type = name
+	}
+	return b, nil
+}
+
+// ModelName は、使用するモデル名を返します。
+// トップレベルの model が設定されていればそれを優先し、なければバックエンドの model を使用します。
+func (c *Config) ModelName() string {
+	if c.Model != "" {
+		return c.Model
+	}
+	if b, ok := c.Backends[c.Backend]; ok {
+		return b.Model
+	}
+	return ""
+}
+
+// PriceFor は、model に最も長く一致する接頭辞を持つ料金を返します。
+// 例えば gemini-1.5-flash-001 には gemini-1.5-flash の料金が使用されます。
+func (c *Config) PriceFor(model string) (Price, bool) {
+	var (
+		best    Price
+		bestLen = -1
+	)
+	for prefix, p := range c.Pricing {
+		if strings.HasPrefix(model, prefix) && len(prefix) > bestLen {
+			best, bestLen = p, len(prefix)
+		}
+	}
+	return best, bestLen >= 0
+}
+
+// ResolveAPIKey は、バックエンドの API キーを取得します。
+// api_key_file、api_key_cmd、api_key_env の環境変数、api_key の順に参照し、最初に見つかった値を返します。
+// ファイルやコマンドを明示的に設定した場合は、既定の api_key_env（GEMINI_API_KEY など）が設定されていてもそちらを使用します。
+// 取得したキーは secret.Register で伏せ字の対象になります。
+func (b Backend) ResolveAPIKey() (string, error) {
+	key, err := b.lookupAPIKey()
+	if err != nil {
+		return "", err
+	}
+	secret.Register(key)
+	return key, nil
+}
+
+// lookupAPIKey は、ResolveAPIKey の優先順位に従って API キーを探します。
+func (b Backend) lookupAPIKey() (string, error) {
+	if b.APIKeyFile != "" {
+		return secret.FromFile(expandHome(b.APIKeyFile))
+	}
+	if b.APIKeyCmd != "" {
+		return secret.FromCommand(b.APIKeyCmd)
+	}
+	if b.APIKeyEnv != "" {
+		if key := secret.FromEnv(b.APIKeyEnv); key != "" {
+			return key, nil
+		}
+	}
+	return b.APIKey, nil
+}
+
+// ScriptPath は、fake バックエンドのスクリプトのパスを返します。設定されていない場合は空文字列を返します。
+func (b Backend) ScriptPath() string {
+	return expandHome(b.Script)
+}
+
+// expandHome は、先頭の ~/ をホームディレクトリに置き換えます。
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// mergeFile は、指定された TOML ファイルを読み込み、現在の設定に重ねます。
+// project が true の場合は、userOnlyKeys の項目を含むファイルをエラーにします。
+func (c *Config) mergeFile(path string, project bool) error {
+	var layer Config
+	md, err := toml.DecodeFile(path, &layer)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return fmt.Errorf("unknown key %q in config file %s", undecoded[0].String(), path)
+	}
+	if project {
+		for _, key := range md.Keys() {
+			if userOnly(key) {
+				return fmt.Errorf("%q cannot be set in the project config file %s because it runs commands or reads secrets; set it in the user config file instead", key.String(), path)
+			}
+		}
+	}
+	if err := layer.validate(md.IsDefined); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	c.merge(layer)
+	c.Sources = append(c.Sources, path)
+	return nil
+}
+
+// userOnly は、key が userOnlyKeys のいずれか、またはその下の項目かどうかを返します。
+func userOnly(key toml.Key) bool {
+	for _, pattern := range userOnlyKeys {
+		parts := strings.Split(pattern, ".")
+		if len(key) < len(parts) {
+			continue
+		}
+		matched := true
+		for i, part := range parts {
+			if part != "*" && part != key[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeEnv は、環境変数で指定された設定値を現在の設定に重ねます。
+func (c *Config) mergeEnv() {
+	c.merge(Config{
+		Backend: os.Getenv("GOLLM_BACKEND"),
+		Model:   os.Getenv("GOLLM_MODEL"),
+		DBPath:  os.Getenv("GOLLM_DB_PATH"),
+		Theme:   os.Getenv("GOLLM_THEME"),
+	})
+}
+
+// merge は、layer の空でない値で現在の設定を上書きします。
+// バックエンドはフィールド単位、キーバインドはアクション単位で上書きされます。
+func (c *Config) merge(layer Config) {
+	if layer.Backend != "" {
+		c.Backend = layer.Backend
+	}
+	if layer.Model != "" {
+		c.Model = layer.Model
+	}
+	if layer.DBPath != "" {
+		c.DBPath = layer.DBPath
+	}
+	if layer.Theme != "" {
+		c.Theme = layer.Theme
+	}
+	if layer.TemplatesDir != "" {
+		c.TemplatesDir = layer.TemplatesDir
+	}
+
+	for name, lb := range layer.Backends {
+		b := c.Backends[name]
+		if lb.Type != "" {
+			b.Type = lb.Type
+		}
+		if lb.Model != "" {
+			b.Model = lb.Model
+		}
+		if lb.APIKey != "" {
+			b.APIKey = lb.APIKey
+		}
+		if lb.APIKeyEnv != "" {
+			b.APIKeyEnv = lb.APIKeyEnv
+		}
+		if lb.APIKeyFile != "" {
+			b.APIKeyFile = lb.APIKeyFile
+		}
+		if lb.APIKeyCmd != "" {
+			b.APIKeyCmd = lb.APIKeyCmd
+		}
+		if lb.Script != "" {
+			b.Script = lb.Script
+		}
+		c.Backends[name] = b
+	}
+
+	for action, keys := range layer.Keybindings {
+		c.Keybindings[action] = keys
+	}
+
+	if layer.Context.Strategy != "" {
+		c.Context.Strategy = layer.Context.Strategy
+	}
+	if layer.Context.MaxTokens != 0 {
+		c.Context.MaxTokens = layer.Context.MaxTokens
+	}
+	if layer.Context.Threshold != 0 {
+		c.Context.Threshold = layer.Context.Threshold
+	}
+
+	if layer.Tools.Enabled != nil {
+		c.Tools.Enabled = layer.Tools.Enabled
+	}
+	if layer.Tools.RunShell {
+		c.Tools.RunShell = true
+	}
+
+	if layer.Project.Scoped != nil {
+		c.Project.Scoped = layer.Project.Scoped
+	}
+	if layer.Project.ContextFile != "" {
+		c.Project.ContextFile = layer.Project.ContextFile
+	}
+	if layer.Project.AttachContext != nil {
+		c.Project.AttachContext = layer.Project.AttachContext
+	}
+
+	// MCP サーバーは後から読み込んだ設定でサーバー単位に置き換えます。
+	for name, srv := range layer.MCPServers {
+		if c.MCPServers == nil {
+			c.MCPServers = map[string]MCPServer{}
+		}
+		c.MCPServers[name] = srv
+	}
+
+	if layer.Attach.MaxFileSize != 0 {
+		c.Attach.MaxFileSize = layer.Attach.MaxFileSize
+	}
+	if layer.Attach.MaxTotalSize != 0 {
+		c.Attach.MaxTotalSize = layer.Attach.MaxTotalSize
+	}
+	if layer.Attach.MaxMediaSize != 0 {
+		c.Attach.MaxMediaSize = layer.Attach.MaxMediaSize
+	}
+
+	for model, p := range layer.Pricing {
+		c.Pricing[model] = p
+	}
+}
+
+// Set は、ユーザー設定ファイルの key に value を書き込みます。
+// key は "model" や "backends.gemini.model" のようにドットで区切って指定します。
+// keybindings.* の値はカンマ区切りでキーのリストとして解釈されます。
+// ファイルはロックを取得してから読み込んで書き換えるため、他のプロセスが同時に書き込んだ値を失いません。
+func Set(key, value string) error {
+	path, err := UserConfigPath()
+	if err != nil {
+		return err
+	}
+	return atomicfile.Update(path, 0600, func(old []byte) ([]byte, error) {
+		return setKey(path, old, key, value)
+	})
+}
+
+// setKey は、path から読み込んだ設定ファイルの内容 data の key に value を書き込んだ結果を返します。
+func setKey(path string, data []byte, key, value string) ([]byte, error) {
+	tree := map[string]any{}
+	if _, err := toml.Decode(string(data), &tree); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	parts := strings.Split(key, ".")
+	node := tree
+	for _, p := range parts[:len(parts)-1] {
+		child, ok := node[p].(map[string]any)
+		if !ok {
+			child = map[string]any{}
+			node[p] = child
+		}
+		node = child
+	}
+
+	// 値の型はキーによって異なるため、文字列から順に解釈を試し、
+	// 既知のキーだけで構成された設定として読み込めたものを採用します。
+	var (
+		buf     strings.Builder
+		lastErr error
+	)
+	leaf := parts[len(parts)-1]
+	for _, v := range candidates(parts[0], value) {
+		node[leaf] = v
+
+		buf.Reset()
+		if err := toml.NewEncoder(&buf).Encode(tree); err != nil {
+			return nil, err
+		}
+
+		var check Config
+		md, err := toml.Decode(buf.String(), &check)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown config key %q", key)
+		}
+		if err := check.validate(md.IsDefined); err != nil {
+			return nil, err
+		}
+		lastErr = nil
+		break
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("invalid value for %s: %w", key, lastErr)
+	}
+	return []byte(buf.String()), nil
+}
+
+// candidates は、コマンドラインで与えられた value を TOML の値として解釈した候補を返します。
+// keybindings.* の値はカンマ区切りのリストとして扱います。
+func candidates(section, value string) []any {
+	if section == "keybindings" {
+		keys := strings.Split(value, ",")
+		for i := range keys {
+			keys[i] = strings.TrimSpace(keys[i])
+		}
+		return []any{keys}
+	}
+
+	vs := []any{value}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		vs = append(vs, n)
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		vs = append(vs, f)
+	}
+	if b, err := strconv.ParseBool(value); err == nil {
+		vs = append(vs, b)
+	}
+	return vs
+}
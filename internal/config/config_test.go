@@ -0,0 +1,317 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/kou12345/gollm/internal/secret"
+)
+
+// setup は、ユーザー設定ファイルとプロジェクトローカル設定ファイルを書き込み、
+// そのプロジェクトのディレクトリに移動します。空の内容のファイルは作成しません。
+func setup(t *testing.T, user, project string) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "config"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, "data"))
+	for _, name := range []string{"GOLLM_BACKEND", "GOLLM_MODEL", "GOLLM_DB_PATH", "GOLLM_THEME"} {
+		t.Setenv(name, "")
+	}
+
+	if user != "" {
+		path, err := UserConfigPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(user), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := filepath.Join(home, "project")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if project != "" {
+		if err := os.WriteFile(filepath.Join(dir, ProjectFile), []byte(project), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    string
+		project string
+		env     map[string]string
+		flags   Overrides
+		check   func(t *testing.T, c *Config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, c *Config) {
+				if c.Backend != "gemini" || c.Theme != "auto" || c.ModelName() != "gemini-1.5-flash" {
+					t.Errorf("backend = %q, theme = %q, model = %q", c.Backend, c.Theme, c.ModelName())
+				}
+				if len(c.Sources) != 0 {
+					t.Errorf("Sources = %v, want none", c.Sources)
+				}
+			},
+		},
+		{
+			name: "user file overrides defaults",
+			user: "model = \"gemini-1.5-pro\"\ntheme = \"dark\"\n",
+			check: func(t *testing.T, c *Config) {
+				if c.ModelName() != "gemini-1.5-pro" || c.Theme != "dark" {
+					t.Errorf("model = %q, theme = %q", c.ModelName(), c.Theme)
+				}
+				if len(c.Sources) != 1 {
+					t.Errorf("Sources = %v, want the user file", c.Sources)
+				}
+			},
+		},
+		{
+			name:    "project file overrides user file",
+			user:    "model = \"gemini-1.5-pro\"\ntheme = \"dark\"\n",
+			project: "model = \"gemini-1.0-pro\"\n",
+			check: func(t *testing.T, c *Config) {
+				if c.ModelName() != "gemini-1.0-pro" || c.Theme != "dark" {
+					t.Errorf("model = %q, theme = %q", c.ModelName(), c.Theme)
+				}
+			},
+		},
+		{
+			name:    "environment overrides files",
+			user:    "model = \"gemini-1.5-pro\"\n",
+			project: "theme = \"light\"\n",
+			env:     map[string]string{"GOLLM_MODEL": "env-model", "GOLLM_THEME": "notty"},
+			check: func(t *testing.T, c *Config) {
+				if c.ModelName() != "env-model" || c.Theme != "notty" {
+					t.Errorf("model = %q, theme = %q", c.ModelName(), c.Theme)
+				}
+			},
+		},
+		{
+			name:  "flags override environment",
+			env:   map[string]string{"GOLLM_MODEL": "env-model", "GOLLM_BACKEND": "fake"},
+			flags: Overrides{Model: "flag-model", Theme: "ascii"},
+			check: func(t *testing.T, c *Config) {
+				if c.ModelName() != "flag-model" || c.Backend != "fake" || c.Theme != "ascii" {
+					t.Errorf("model = %q, backend = %q, theme = %q", c.ModelName(), c.Backend, c.Theme)
+				}
+			},
+		},
+		{
+			name: "backends merge field by field",
+			user: "[backends.gemini]\napi_key_env = \"MY_KEY\"\n",
+			check: func(t *testing.T, c *Config) {
+				b := c.Backends["gemini"]
+				if b.Type != "gemini" || b.Model != "gemini-1.5-flash" || b.APIKeyEnv != "MY_KEY" {
+					t.Errorf("gemini backend = %+v", b)
+				}
+			},
+		},
+		{
+			name: "keybindings merge by action",
+			user: "[keybindings]\nquit = [\"ctrl+q\"]\n",
+			check: func(t *testing.T, c *Config) {
+				if got := c.Keybindings["quit"]; len(got) != 1 || got[0] != "ctrl+q" {
+					t.Errorf("quit = %v", got)
+				}
+				if len(c.Keybindings["open_room"]) == 0 {
+					t.Error("open_room lost its default keys")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t, tt.user, tt.project)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c, err := Load(tt.flags)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    string
+		project string
+		flags   Overrides
+		want    string
+	}{
+		{name: "unknown key", user: "modle = \"x\"\n", want: `unknown key "modle"`},
+		{name: "unknown nested key", project: "[context]\nstrategi = \"x\"\n", want: `unknown key "context.strategi"`},
+		{name: "syntax error", user: "model = \n", want: "failed to parse config file"},
+		{name: "unknown theme in file", user: "theme = \"bogus\"\n", want: `unknown theme "bogus"`},
+		{name: "unknown theme flag", flags: Overrides{Theme: "bogus"}, want: `unknown theme "bogus"`},
+		{name: "unknown strategy", user: "[context]\nstrategy = \"forget\"\n", want: `unknown context strategy "forget"`},
+		{name: "zero threshold", project: "[context]\nthreshold = 0.0\n", want: "context threshold 0 must be greater than 0"},
+		{name: "threshold above one", user: "[context]\nthreshold = 1.5\n", want: "context threshold 1.5 must be greater than 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t, tt.user, tt.project)
+			_, err := Load(tt.flags)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestProjectFileUserOnlyKeys は、作業ディレクトリの設定ファイルでは、コマンドを実行する設定や
+// 秘密情報を読み込む設定を指定できず、ユーザー設定ファイルでは指定できることを確認します。
+func TestProjectFileUserOnlyKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		key  string
+	}{
+		{name: "mcp server", toml: "[mcp_servers.evil]\ncommand = \"sh\"\nargs = [\"-c\", \"touch pwned\"]\n", key: "mcp_servers"},
+		{name: "api key command", toml: "[backends.gemini]\napi_key_cmd = \"touch pwned\"\n", key: "backends.gemini.api_key_cmd"},
+		{name: "api key command of a new backend", toml: "[backends.other]\ntype = \"gemini\"\napi_key_cmd = \"touch pwned\"\n", key: "backends.other.api_key_cmd"},
+		{name: "api key file", toml: "[backends.gemini]\napi_key_file = \"~/.ssh/id_ed25519\"\n", key: "backends.gemini.api_key_file"},
+		{name: "run shell", toml: "[tools]\nrun_shell = true\n", key: "tools.run_shell"},
+		{name: "inline tables", toml: "tools = { run_shell = true }\n", key: "tools.run_shell"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t, "", tt.toml)
+			_, err := Load(Overrides{})
+			if err == nil || !strings.Contains(err.Error(), "cannot be set in the project config file") || !strings.Contains(err.Error(), tt.key) {
+				t.Errorf("err = %v, want %s refused", err, tt.key)
+			}
+
+			setup(t, tt.toml, "")
+			if _, err := Load(Overrides{}); err != nil {
+				t.Errorf("user config file: %v", err)
+			}
+		})
+	}
+
+	// コマンドを実行しない項目は、作業ディレクトリの設定ファイルでも指定できます。
+	setup(t, "", "model = \"gemini-1.5-pro\"\n[backends.gemini]\napi_key_env = \"PROJECT_KEY\"\n[tools]\nenabled = false\n[project]\ncontext_file = \"AGENTS.md\"\n")
+	c, err := Load(Overrides{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ModelName() != "gemini-1.5-pro" || c.Backends["gemini"].APIKeyEnv != "PROJECT_KEY" || *c.Tools.Enabled || c.Project.ContextFile != "AGENTS.md" {
+		t.Errorf("config = %+v", c)
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		key, value string
+		want       string // 書き込まれたファイルに含まれる行（空の場合はエラーになること）
+		err        string
+	}{
+		{key: "model", value: "gemini-1.5-pro", want: `model = "gemini-1.5-pro"`},
+		{key: "theme", value: "dracula", want: `theme = "dracula"`},
+		{key: "backends.local.type", value: "fake", want: `type = "fake"`},
+		{key: "context.max_tokens", value: "8000", want: "max_tokens = 8000"},
+		{key: "context.threshold", value: "0.5", want: "threshold = 0.5"},
+		{key: "tools.run_shell", value: "true", want: "run_shell = true"},
+		{key: "keybindings.quit", value: "q, ctrl+c", want: `quit = ["q", "ctrl+c"]`},
+		{key: "theme", value: "bogus", err: `unknown theme "bogus"`},
+		{key: "context.strategy", value: "forget", err: `unknown context strategy "forget"`},
+		{key: "modle", value: "x", err: `unknown config key "modle"`},
+		{key: "backends.gemini.apikey", value: "x", err: `unknown config key "backends.gemini.apikey"`},
+		{key: "context.max_tokens", value: "many", err: "invalid value for context.max_tokens"},
+		{key: "context.threshold", value: "0", err: "context threshold 0 must be greater than 0"},
+		{key: "context.threshold", value: "2", err: "context threshold 2 must be greater than 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			setup(t, "model = \"kept\"\n", "")
+			path, err := UserConfigPath()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Set(tt.key, tt.value)
+			data, readErr := os.ReadFile(path)
+			if readErr != nil {
+				t.Fatal(readErr)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("err = %v, want it to contain %q", err, tt.err)
+				}
+				if string(data) != "model = \"kept\"\n" {
+					t.Errorf("file was changed on error:\n%s", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), tt.want) {
+				t.Errorf("file does not contain %q:\n%s", tt.want, data)
+			}
+			if _, err := Load(Overrides{}); err != nil {
+				t.Errorf("Load after Set: %v", err)
+			}
+		})
+	}
+}
+
+func TestResolveAPIKey(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the key command uses sh")
+	}
+	file := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	const env = "GOLLM_TEST_API_KEY"
+
+	tests := []struct {
+		name    string
+		envKey  string
+		backend Backend
+		want    string
+	}{
+		{name: "env", envKey: "from-env", backend: Backend{APIKeyEnv: env, APIKey: "plain"}, want: "from-env"},
+		{name: "file over env", envKey: "from-env", backend: Backend{APIKeyEnv: env, APIKeyFile: file}, want: "from-file"},
+		{name: "cmd over env", envKey: "from-env", backend: Backend{APIKeyEnv: env, APIKeyCmd: "echo from-cmd"}, want: "from-cmd"},
+		{name: "file over cmd", backend: Backend{APIKeyFile: file, APIKeyCmd: "echo from-cmd"}, want: "from-file"},
+		{name: "unset env", backend: Backend{APIKeyEnv: env, APIKey: "plain"}, want: "plain"},
+		{name: "nothing", backend: Backend{APIKeyEnv: env}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(env, tt.envKey)
+			got, err := tt.backend.ResolveAPIKey()
+			if err != nil || got != tt.want {
+				t.Errorf("ResolveAPIKey = %q, %v, want %q", got, err, tt.want)
+			}
+			if got != "" && secret.Redact(got) == got {
+				t.Errorf("the key %q was not registered for redaction", got)
+			}
+		})
+	}
+}
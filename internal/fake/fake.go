@@ -0,0 +1,374 @@
+// Package fake は、API キーなしで動作する偽のバックエンドを提供します。
+// Gemini の REST API を模倣する http.RoundTripper として実装しているため、
+// option.WithHTTPClient で genai のクライアントに渡すと、ストリーミング・再試行・ツールの呼び出しを含む
+// チャットの全ての機能をネットワークを使わずに動かせます。UI の開発やデモ、テストに使用します。
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// inputTokenLimit は、偽のモデルの入力トークン数の上限です。
+const inputTokenLimit = 1 << 20
+
+// Backend は、Script に従って応答する偽の Gemini API です。
+type Backend struct {
+	script *Script
+
+	mu   sync.Mutex
+	used []int // ルールごとの使用回数
+}
+
+// New は、s に従って応答する Backend を作成します。s が nil の場合は DefaultScript を使用します。
+func New(s *Script) (*Backend, error) {
+	if s == nil {
+		s = DefaultScript()
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return &Backend{script: s, used: make([]int, len(s.Rules))}, nil
+}
+
+// Client は、b に送信する http.Client を返します。
+func (b *Backend) Client() *http.Client {
+	return &http.Client{Transport: b}
+}
+
+// RoundTrip は、Gemini API のリクエストに偽の応答を返します。
+func (b *Backend) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	path := req.URL.Path
+	model, method, _ := strings.Cut(path[strings.LastIndex(path, "/")+1:], ":")
+	switch {
+	case req.Method == http.MethodGet && method == "":
+		return jsonResponse(req, http.StatusOK, map[string]any{
+			"name":                       "models/" + model,
+			"displayName":                "Fake " + model,
+			"inputTokenLimit":            inputTokenLimit,
+			"outputTokenLimit":           8192,
+			"supportedGenerationMethods": []string{"generateContent", "countTokens"},
+		}), nil
+	case method == "countTokens":
+		return jsonResponse(req, http.StatusOK, map[string]any{"totalTokens": countTokens(body)}), nil
+	case method == "generateContent" || method == "streamGenerateContent":
+		var r request
+		if err := json.Unmarshal(body, &r); err != nil {
+			return errorResponse(req, "request", "invalid request body: "+err.Error()), nil
+		}
+		return b.generate(req, r, method == "streamGenerateContent")
+	}
+	return jsonResponse(req, http.StatusNotFound, apiError(http.StatusNotFound, "NOT_FOUND", "fake backend does not implement "+path, nil)), nil
+}
+
+// request は、generateContent のリクエストのうち、偽の応答に使用する項目です。
+type request struct {
+	Contents         []content `json:"contents"`
+	GenerationConfig struct {
+		ResponseMIMEType string `json:"responseMimeType"`
+	} `json:"generationConfig"`
+}
+
+type content struct {
+	Role  string `json:"role"`
+	Parts []part `json:"parts"`
+}
+
+type part struct {
+	Text             string          `json:"text,omitempty"`
+	FunctionCall     *functionCall   `json:"functionCall,omitempty"`
+	FunctionResponse *functionResult `json:"functionResponse,omitempty"`
+}
+
+type functionCall struct {
+	Name string         `json:"name"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+type functionResult struct {
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+// generate は、会話の最後のメッセージに対する応答を作成します。
+func (b *Backend) generate(req *http.Request, r request, stream bool) (*http.Response, error) {
+	if len(r.Contents) == 0 {
+		return errorResponse(req, "request", "contents must not be empty"), nil
+	}
+	last := r.Contents[len(r.Contents)-1]
+	input := lastUserText(r.Contents)
+
+	var (
+		reply string
+		call  *functionCall
+	)
+	if result := functionResponse(last); result != nil {
+		// ツールの実行結果には、呼び出しを要求したルールの応答を返します。
+		if rule := b.peek(input); rule != nil && rule.Reply != "" {
+			reply = rule.Reply
+		} else {
+			data, _ := json.Marshal(result.Response)
+			reply = fmt.Sprintf("The %s tool returned: %s", result.Name, truncate(string(data), 200))
+		}
+	} else {
+		rule := b.match(input)
+		switch {
+		case rule == nil:
+			reply = "Echo: " + input
+		case rule.Error == "network":
+			return nil, &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer (simulated)")}
+		case rule.Error != "":
+			return errorResponse(req, rule.Error, "simulated "+rule.Error+" error"), nil
+		case rule.Call != nil:
+			call = &functionCall{Name: rule.Call.Name, Args: rule.Call.Args}
+		default:
+			reply = rule.Reply
+		}
+	}
+	if r.GenerationConfig.ResponseMIMEType == "application/json" && call == nil && !json.Valid([]byte(reply)) {
+		data, _ := json.Marshal(reply)
+		reply = string(data)
+	}
+
+	var chunks []map[string]any
+	if call != nil {
+		chunks = append(chunks, candidate(part{FunctionCall: call}))
+	} else {
+		words := strings.SplitAfter(reply, " ")
+		if !stream {
+			words = []string{reply}
+		}
+		for _, w := range words {
+			chunks = append(chunks, candidate(part{Text: w}))
+		}
+	}
+	final := chunks[len(chunks)-1]
+	final["candidates"].([]map[string]any)[0]["finishReason"] = 1 // STOP
+	prompt := countTokens(mustMarshal(r.Contents))
+	completion := int32(len(strings.Fields(reply)) + 1)
+	final["usageMetadata"] = map[string]any{
+		"promptTokenCount":     prompt,
+		"candidatesTokenCount": completion,
+		"totalTokenCount":      prompt + completion,
+	}
+
+	if !stream {
+		return jsonResponse(req, http.StatusOK, final), nil
+	}
+	return streamResponse(req, chunks, time.Duration(b.script.Delay)), nil
+}
+
+// match は、input に一致する最初のルールを使用済みにして返します。一致するルールがない場合は nil を返します。
+func (b *Backend) match(input string) *Rule {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.script.Rules {
+		r := &b.script.Rules[i]
+		if (r.Times == 0 || b.used[i] < r.Times) && r.re.MatchString(input) {
+			b.used[i]++
+			return r
+		}
+	}
+	return nil
+}
+
+// peek は、input に一致する関数呼び出しのルールを、使用済みにせずに返します。
+func (b *Backend) peek(input string) *Rule {
+	for i := range b.script.Rules {
+		if r := &b.script.Rules[i]; r.Call != nil && r.re.MatchString(input) {
+			return r
+		}
+	}
+	return nil
+}
+
+// lastUserText は、ユーザーが最後に送信したテキストを返します。
+func lastUserText(contents []content) string {
+	for i := len(contents) - 1; i >= 0; i-- {
+		if contents[i].Role != "user" {
+			continue
+		}
+		var texts []string
+		for _, p := range contents[i].Parts {
+			if p.Text != "" {
+				texts = append(texts, p.Text)
+			}
+		}
+		if len(texts) > 0 {
+			return texts[len(texts)-1]
+		}
+	}
+	return ""
+}
+
+// functionResponse は、c に含まれるツールの実行結果を返します。含まれない場合は nil を返します。
+func functionResponse(c content) *functionResult {
+	for _, p := range c.Parts {
+		if p.FunctionResponse != nil {
+			return p.FunctionResponse
+		}
+	}
+	return nil
+}
+
+// candidate は、p を含む1つの応答の断片を作成します。
+func candidate(p part) map[string]any {
+	return map[string]any{
+		"candidates": []map[string]any{{
+			"content": content{Role: "model", Parts: []part{p}},
+			"index":   0,
+		}},
+	}
+}
+
+// countTokens は、本文に含まれるテキストのおおよそのトークン数を数えます。
+func countTokens(body []byte) int32 {
+	var v any
+	json.Unmarshal(body, &v)
+	n := 0
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, child := range v {
+				if s, ok := child.(string); ok && k == "text" {
+					n += utf8.RuneCountInString(s)/4 + 1
+				} else {
+					walk(child)
+				}
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(v)
+	return int32(n)
+}
+
+// errorResponse は、kind の種類のエラーを Gemini API と同じ形式で返します。
+func errorResponse(req *http.Request, kind, msg string) *http.Response {
+	switch kind {
+	case "rate_limit":
+		return jsonResponse(req, http.StatusTooManyRequests, apiError(http.StatusTooManyRequests, "RESOURCE_EXHAUSTED", msg, []any{
+			map[string]any{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "1s"},
+		}))
+	case "quota":
+		return jsonResponse(req, http.StatusTooManyRequests, apiError(http.StatusTooManyRequests, "RESOURCE_EXHAUSTED", msg, []any{
+			map[string]any{"@type": "type.googleapis.com/google.rpc.QuotaFailure", "violations": []any{
+				map[string]any{"quotaId": "GenerateRequestsPerDayPerProjectPerModel-FreeTier"},
+			}},
+		}))
+	case "auth":
+		return jsonResponse(req, http.StatusBadRequest, apiError(http.StatusBadRequest, "INVALID_ARGUMENT", msg, []any{
+			map[string]any{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "API_KEY_INVALID"},
+		}))
+	case "server":
+		return jsonResponse(req, http.StatusServiceUnavailable, apiError(http.StatusServiceUnavailable, "UNAVAILABLE", msg, nil))
+	case "safety":
+		return jsonResponse(req, http.StatusOK, map[string]any{"promptFeedback": map[string]any{"blockReason": 1}})
+	}
+	return jsonResponse(req, http.StatusBadRequest, apiError(http.StatusBadRequest, "INVALID_ARGUMENT", msg, nil))
+}
+
+func apiError(code int, status, msg string, details []any) map[string]any {
+	e := map[string]any{"code": code, "status": status, "message": msg}
+	if details != nil {
+		e["details"] = details
+	}
+	return map[string]any{"error": e}
+}
+
+func jsonResponse(req *http.Request, status int, v any) *http.Response {
+	data := mustMarshal(v)
+	resp := newResponse(req, status, io.NopCloser(strings.NewReader(string(data))))
+	resp.ContentLength = int64(len(data))
+	return resp
+}
+
+// streamResponse は、chunks を streamGenerateContent と同じ JSON の配列として、delay の間隔で少しずつ返します。
+func streamResponse(req *http.Request, chunks []map[string]any, delay time.Duration) *http.Response {
+	pr, pw := io.Pipe()
+	go func() {
+		ctx := req.Context()
+		for i, c := range chunks {
+			sep := ",\r\n"
+			if i == 0 {
+				sep = "["
+			}
+			if _, err := io.WriteString(pw, sep+string(mustMarshal(c))); err != nil {
+				return
+			}
+			if err := sleep(ctx, delay); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		io.WriteString(pw, "]")
+		pw.Close()
+	}()
+	return newResponse(req, http.StatusOK, pr)
+}
+
+func newResponse(req *http.Request, status int, body io.ReadCloser) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json; charset=UTF-8"}},
+		Body:          body,
+		ContentLength: -1,
+		Request:       req,
+	}
+}
+
+// sleep は、d だけ待ちます。ctx が先に終了した場合はそのエラーを返します。
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+func mustMarshal(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
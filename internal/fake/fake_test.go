@@ -0,0 +1,89 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+func newModel(t *testing.T, s *Script) *genai.GenerativeModel {
+	t.Helper()
+	b, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := genai.NewClient(context.Background(), option.WithHTTPClient(b.Client()), option.WithAPIKey("fake"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client.GenerativeModel("fake")
+}
+
+func TestEchoStreaming(t *testing.T) {
+	model := newModel(t, &Script{})
+	iter := model.GenerateContentStream(context.Background(), genai.Text("one two three"))
+	var chunks []string
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			// encoding/json の実装によっては、配列の終わりがエラーとして報告されます。
+			if len(chunks) > 0 && strings.Contains(err.Error(), "']'") {
+				break
+			}
+			t.Fatal(err)
+		}
+		chunks = append(chunks, string(resp.Candidates[0].Content.Parts[0].(genai.Text)))
+	}
+	if got := strings.Join(chunks, ""); got != "Echo: one two three" || len(chunks) != 4 {
+		t.Errorf("chunks = %q", chunks)
+	}
+}
+
+func TestRules(t *testing.T) {
+	s := &Script{Rules: []Rule{
+		{Match: "busy", Error: "rate_limit", Times: 1},
+		{Match: "busy", Reply: "Now I'm free."},
+		{Match: "files", Call: &Call{Name: "list_dir", Args: map[string]any{"path": "."}}},
+	}}
+	model := newModel(t, s)
+	ctx := context.Background()
+
+	_, err := model.GenerateContent(ctx, genai.Text("are you busy?"))
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != 429 {
+		t.Fatalf("first request error = %v, want a 429", err)
+	}
+	resp, err := model.GenerateContent(ctx, genai.Text("are you busy?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Candidates[0].Content.Parts[0]; got != genai.Text("Now I'm free.") {
+		t.Errorf("second reply = %v", got)
+	}
+
+	resp, err = model.GenerateContent(ctx, genai.Text("list files"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	call, ok := resp.Candidates[0].Content.Parts[0].(genai.FunctionCall)
+	if !ok || call.Name != "list_dir" || call.Args["path"] != "." {
+		t.Errorf("reply = %#v, want a list_dir call", resp.Candidates[0].Content.Parts[0])
+	}
+}
+
+func TestNewRejectsUnknownError(t *testing.T) {
+	s := &Script{Rules: []Rule{{Error: "teapot"}}}
+	if _, err := New(s); err == nil {
+		t.Error("New accepted an unknown error kind")
+	}
+}
@@ -0,0 +1,104 @@
+package fake
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Script は、fake バックエンドの応答を定義します。TOML ファイルから読み込めます。
+//
+//	delay = "40ms"
+//
+//	[[rules]]
+//	match = "(?i)hello"
+//	reply = "Hi! I'm the fake backend."
+//
+//	[[rules]]
+//	match = "(?i)busy"
+//	error = "rate_limit"
+//	times = 1
+//
+//	[[rules]]
+//	match = "(?i)files"
+//	call = { name = "list_dir", args = { path = "." } }
+//	reply = "Those are the files."
+//
+// 最後のユーザーのメッセージに最初に一致したルールで応答します。一致するルールがない場合は入力をそのまま返します。
+type Script struct {
+	Delay Duration `toml:"delay"` // ストリーミングで単語ごとに待つ時間
+	Rules []Rule   `toml:"rules"`
+}
+
+// Rule は、メッセージに対する1つの応答です。
+type Rule struct {
+	Match string `toml:"match"` // メッセージに一致させる正規表現（空の場合は全てのメッセージに一致する）
+	Reply string `toml:"reply"` // 応答のテキスト（Call がある場合はツールの実行後の応答）
+	Error string `toml:"error"` // 返すエラーの種類（rate_limit, quota, auth, server, request, safety, network）
+	Call  *Call  `toml:"call"`  // 応答の代わりに要求する関数呼び出し
+	Times int    `toml:"times"` // ルールを使用する回数（0 の場合は無制限。使い切ると次のルールに進む）
+
+	re *regexp.Regexp
+}
+
+// Call は、モデルが要求する関数呼び出しです。
+type Call struct {
+	Name string         `toml:"name"`
+	Args map[string]any `toml:"args"`
+}
+
+// Duration は、"40ms" のような文字列で表した時間です。
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	*d = Duration(v)
+	return err
+}
+
+// errorKinds は、Rule.Error に指定できる値です。
+var errorKinds = map[string]bool{
+	"rate_limit": true, "quota": true, "auth": true, "server": true, "request": true, "safety": true, "network": true,
+}
+
+// DefaultScript は、入力をそのまま返すスクリプトです。ストリーミングが分かるように単語ごとに少し待ちます。
+func DefaultScript() *Script {
+	return &Script{Delay: Duration(30 * time.Millisecond)}
+}
+
+// LoadScript は、path の TOML ファイルからスクリプトを読み込みます。
+func LoadScript(path string) (*Script, error) {
+	var s Script
+	md, err := toml.DecodeFile(path, &s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse fake backend script %s: %w", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("fake backend script %s: unknown key %q", path, undecoded[0].String())
+	}
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("fake backend script %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// compile は、ルールの正規表現を準備し、値を検証します。
+func (s *Script) compile() error {
+	for i := range s.Rules {
+		r := &s.Rules[i]
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		r.re = re
+		if r.Error != "" && !errorKinds[r.Error] {
+			return fmt.Errorf("rules[%d]: unknown error %q", i, r.Error)
+		}
+		if r.Call != nil && r.Call.Name == "" {
+			return fmt.Errorf("rules[%d]: call needs a name", i)
+		}
+	}
+	return nil
+}
@@ -1,76 +1,139 @@
 // Package history は、チャット履歴の管理機能を提供します。
+// 履歴はチャットルームごとにSQLiteデータベース（Store）へ保存されます。
+// 以前のバージョンが使用していたJSONファイルの履歴は、読み込んでルームへ取り込むことができます。
 package history
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"time"
-)
 
-// historyFile は、チャット履歴を保存するファイルの名前を定義します。
-const historyFile = "chat_history.json"
+	"github.com/kou12345/gollm/internal/atomicfile"
+	"github.com/kou12345/gollm/internal/secret"
+)
 
 // ChatMessage は、単一のチャットメッセージを表現する構造体です。
 type ChatMessage struct {
-	Role    string    `json:"role"`    // メッセージの送信者の役割（例：user, assistant）
-	Content string    `json:"content"` // メッセージの内容
-	Time    time.Time `json:"time"`    // メッセージが送信された時刻
+	ID       int64     `json:"-"`                // データベース上のID（未保存の場合は0）
+	ParentID int64     `json:"-"`                // 直前のメッセージのID（会話の最初のメッセージでは0）
+	Role     string    `json:"role"`             // メッセージの送信者の役割（例：user, assistant）
+	Content  string    `json:"content"`          // メッセージの内容
+	Time     time.Time `json:"time"`             // メッセージが送信された時刻
+	Tokens   int32     `json:"tokens,omitempty"` // メッセージのトークン数（未計測の場合は0）
+	Pinned   bool      `json:"pinned,omitempty"` // コンテキストの上限に近づいても削除しないかどうか
+	Usage    Usage     `json:"-"`                // このメッセージを生成したリクエストのトークン使用量
+
+	Attachments []Attachment `json:"-"` // メッセージと一緒に送信したファイル
+}
+
+// Attachment は、メッセージに添付したファイルを表現する構造体です。
+// テキストファイルはパスだけを保存して送信のたびに読み込み直し、
+// 画像やPDFは元のファイルがなくても会話を再開できるように内容も保存します。
+type Attachment struct {
+	Path     string // 添付したときのファイルの絶対パス
+	MIMEType string // ファイルの MIME タイプ
+	Data     []byte // 画像やPDFの内容（テキストファイルでは nil）
+}
+
+// Usage は、バックエンドへの1回のリクエストで使用したトークン数を表現する構造体です。
+// ユーザーのメッセージなど、リクエストの結果ではないものはゼロ値になります。
+type Usage struct {
+	Model            string // リクエストに使用したモデル名
+	PromptTokens     int32  // 入力（履歴とプロンプト）のトークン数
+	CompletionTokens int32  // 生成された応答のトークン数
 }
 
 // ChatHistory は、複数のChatMessageを含むチャット履歴を表現する構造体です。
+// ルームのメッセージは木構造で保存され、Messages は選択中の枝の根から末端までの経路です。
 type ChatHistory struct {
+	RoomID   int64         `json:"-"`        // 履歴が属するチャットルームのID
 	Messages []ChatMessage `json:"messages"` // チャットメッセージのスライス
 }
 
-// AddMessage は、新しいメッセージをチャット履歴に追加します。
+// Head は、履歴の最後のメッセージのIDを返します。履歴が空の場合は0を返します。
+func (h *ChatHistory) Head() int64 {
+	if len(h.Messages) == 0 {
+		return 0
+	}
+	return h.Messages[len(h.Messages)-1].ID
+}
+
+// AddMessage は、新しいメッセージをチャット履歴に追加し、追加したメッセージを返します。
 // role はメッセージの送信者の役割、content はメッセージの内容です。
-func (h *ChatHistory) AddMessage(role, content string) {
+// tokens はメッセージのトークン数で、未計測の場合は0を指定します。
+// content に含まれる登録済みの秘密情報は伏せ字に置き換えられます。
+// 追加したメッセージの親は、履歴の最後のメッセージになります。
+// 返されるポインタは、次に AddMessage を呼び出すまで有効です。
+func (h *ChatHistory) AddMessage(role, content string, tokens int32) *ChatMessage {
 	h.Messages = append(h.Messages, ChatMessage{
-		Role:    role,
-		Content: content,
-		Time:    time.Now(),
+		ParentID: h.Head(),
+		Role:     role,
+		Content:  secret.Redact(content),
+		Time:     time.Now(),
+		Tokens:   tokens,
 	})
+	return &h.Messages[len(h.Messages)-1]
 }
 
-// LoadChatHistory は、ファイルからチャット履歴を読み込みます。
-// 履歴ファイルが存在しない場合や読み込みエラーが発生した場合は、
-// 新しい空のChatHistoryを返します。
-func LoadChatHistory() *ChatHistory {
-	var history ChatHistory
-	data, err := os.ReadFile(historyFile)
+// LoadChatHistory は、以前のバージョンが path に保存した JSON 形式のチャット履歴を読み込みます。
+func LoadChatHistory(path string) (*ChatHistory, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			fmt.Println("No existing chat history found. Starting a new conversation.")
-			return &ChatHistory{Messages: []ChatMessage{}}
-		}
-		fmt.Printf("Failed to read chat history file: %v\nStarting with an empty history.\n", err)
-		return &ChatHistory{Messages: []ChatMessage{}}
+		return nil, err
+	}
+	var history ChatHistory
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("failed to parse chat history %s: %w", path, err)
 	}
+	return &history, nil
+}
 
-	err = json.Unmarshal(data, &history)
+// importedSuffix は、取り込んだ履歴ファイルの名前に付ける接尾辞です。
+const importedSuffix = ".imported"
+
+// ImportChatHistory は、以前のバージョンが path に保存した JSON 形式の履歴を、どのプロジェクトにも属さない
+// name という名前のルームの末尾に取り込み、取り込んだメッセージの数と名前を変更したファイルのパスを返します。
+// 同じ履歴を二度取り込まないように、取り込んだファイルは path に ".imported" を付けた名前に変更します。
+// path が存在しない場合（他のプロセスが先に取り込んだ場合を含む）は何もせず、0 を返します。
+func (s *Store) ImportChatHistory(path, name string) (n int, renamed string, err error) {
+	unlock, err := atomicfile.Lock(path)
 	if err != nil {
-		fmt.Printf("Error occurred while parsing chat history: %v\nStarting with an empty history.\n", err)
-		return &ChatHistory{Messages: []ChatMessage{}}
+		return 0, "", err
 	}
+	defer unlock()
 
-	fmt.Printf("Successfully loaded chat history with %d messages.\n", len(history.Messages))
-	return &history
-}
-
-// SaveChatHistory は、指定されたChatHistoryをJSONファイルに保存します。
-// 保存に失敗した場合はエラーメッセージを出力します。
-func SaveChatHistory(history ChatHistory) {
-	data, err := json.MarshalIndent(history, "", "  ")
+	legacy, err := LoadChatHistory(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, "", nil
+	}
 	if err != nil {
-		fmt.Printf("Failed to convert chat history to JSON: %v\nChat history not saved.\n", err)
-		return
+		return 0, "", err
 	}
 
-	err = os.WriteFile(historyFile, data, 0644)
+	room, err := s.OpenRoom(name)
 	if err != nil {
-		fmt.Printf("Failed to save chat history to file: %v\nPlease check file permissions or disk space.\n", err)
-	} else {
-		fmt.Println("Chat history successfully saved.")
+		return 0, "", err
+	}
+	h, err := s.Load(room.ID)
+	if err != nil {
+		return 0, "", err
+	}
+	for _, m := range legacy.Messages {
+		msg := h.AddMessage(m.Role, m.Content, m.Tokens)
+		if !m.Time.IsZero() {
+			msg.Time = m.Time
+		}
+		if err := s.SaveMessage(room.ID, msg); err != nil {
+			return 0, "", err
+		}
+	}
+
+	renamed = path + importedSuffix
+	if err := os.Rename(path, renamed); err != nil {
+		return 0, "", err
 	}
+	return len(legacy.Messages), renamed, nil
 }
@@ -0,0 +1,83 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestStore は、一時的なデータベースを開きます。
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := OpenStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestImportChatHistory(t *testing.T) {
+	s := openTestStore(t)
+	path := filepath.Join(t.TempDir(), "chat_history.json")
+	legacy := `{"messages": [
+		{"role": "user", "content": "LEGACY Q", "time": "2024-01-02T03:04:05Z"},
+		{"role": "assistant", "content": "LEGACY A", "time": "2024-01-02T03:04:06Z", "tokens": 4}
+	]}`
+	if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	n, renamed, err := s.ImportChatHistory(path, "default")
+	if err != nil || n != 2 || renamed != path+".imported" {
+		t.Fatalf("ImportChatHistory = %d, %q, %v", n, renamed, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the history file still exists after the import: %v", err)
+	}
+	if _, err := os.Stat(renamed); err != nil {
+		t.Errorf("the imported file was not kept: %v", err)
+	}
+
+	room, err := s.OpenRoom("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := s.Load(room.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Messages) != 2 || h.Messages[0].Content != "LEGACY Q" || h.Messages[1].ParentID != h.Messages[0].ID || h.Messages[1].Tokens != 4 {
+		t.Fatalf("imported messages = %+v", h.Messages)
+	}
+	if want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC); !h.Messages[0].Time.Equal(want) {
+		t.Errorf("time = %v, want the original %v", h.Messages[0].Time, want)
+	}
+
+	// 取り込んだファイルは二度取り込みません。
+	if n, _, err := s.ImportChatHistory(path, "other"); err != nil || n != 0 {
+		t.Errorf("second import = %d, %v, want nothing imported", n, err)
+	}
+	rooms, err := s.ChatRooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 {
+		t.Errorf("rooms = %+v, want only the default room", rooms)
+	}
+}
+
+func TestImportChatHistoryInvalid(t *testing.T) {
+	s := openTestStore(t)
+	path := filepath.Join(t.TempDir(), "chat_history.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.ImportChatHistory(path, "default"); err == nil {
+		t.Error("ImportChatHistory accepted a broken file")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("the broken file was renamed: %v", err)
+	}
+}
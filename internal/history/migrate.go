@@ -0,0 +1,134 @@
+package history
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations は、データベーススキーマの変更を順番に並べたものです。
+// i 番目の要素を適用すると PRAGMA user_version が i+1 になります。
+// 適用済みの要素は変更せず、新しい変更は末尾に追加してください。
+var migrations = []string{
+	// 1: db/01_create_table.sql と同じ初期スキーマ
+	`CREATE TABLE IF NOT EXISTS chat_rooms (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_room_id INTEGER NOT NULL,
+		role TEXT NOT NULL,
+		message TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (chat_room_id) REFERENCES chat_rooms(id)
+	);`,
+
+	// 2: トークン数・ピン留めと会話の要約
+	`ALTER TABLE messages ADD COLUMN tokens INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE messages ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT 0;
+	CREATE TABLE summaries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_room_id INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		up_to_message_id INTEGER NOT NULL,
+		tokens INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (chat_room_id) REFERENCES chat_rooms(id)
+	);`,
+
+	// 3: リクエストごとのトークン使用量
+	`ALTER TABLE messages ADD COLUMN model TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN prompt_tokens INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE messages ADD COLUMN completion_tokens INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE summaries ADD COLUMN model TEXT NOT NULL DEFAULT '';
+	ALTER TABLE summaries ADD COLUMN prompt_tokens INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE summaries ADD COLUMN completion_tokens INTEGER NOT NULL DEFAULT 0;`,
+
+	// 4: 添付ファイルへの参照（パスのJSON配列）
+	`ALTER TABLE messages ADD COLUMN attachments TEXT NOT NULL DEFAULT '[]';`,
+
+	// 5: 画像やPDFの内容も保存できる添付ファイルのテーブル
+	`CREATE TABLE attachments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		mime_type TEXT NOT NULL,
+		data BLOB,
+		FOREIGN KEY (message_id) REFERENCES messages(id)
+	);
+	CREATE INDEX attachments_message_id ON attachments(message_id);
+	INSERT INTO attachments (message_id, path, mime_type)
+		SELECT m.id, j.value, 'text/plain' FROM messages m, json_each(m.attachments) j;
+	ALTER TABLE messages DROP COLUMN attachments;`,
+
+	// 6: ルームごとの構造化出力のスキーマ
+	`ALTER TABLE chat_rooms ADD COLUMN json_schema TEXT NOT NULL DEFAULT '';`,
+
+	// 7: メッセージの木構造（既存のメッセージはルーム内で直前のメッセージを親にする）と、選択中の枝の末端
+	`ALTER TABLE messages ADD COLUMN parent_id INTEGER REFERENCES messages(id);
+	UPDATE messages SET parent_id = (
+		SELECT MAX(p.id) FROM messages p WHERE p.chat_room_id = messages.chat_room_id AND p.id < messages.id
+	);
+	CREATE INDEX messages_parent_id ON messages(parent_id);
+	ALTER TABLE chat_rooms ADD COLUMN head_id INTEGER REFERENCES messages(id);
+	UPDATE chat_rooms SET head_id = (SELECT MAX(id) FROM messages WHERE chat_room_id = chat_rooms.id);`,
+
+	// 8: ルームの整理（フォルダー・ピン留め・アーカイブ・タグ）と最終更新日時
+	`ALTER TABLE chat_rooms ADD COLUMN folder TEXT NOT NULL DEFAULT '';
+	ALTER TABLE chat_rooms ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT 0;
+	ALTER TABLE chat_rooms ADD COLUMN archived BOOLEAN NOT NULL DEFAULT 0;
+	ALTER TABLE chat_rooms ADD COLUMN updated_at TIMESTAMP;
+	UPDATE chat_rooms SET updated_at = COALESCE(
+		(SELECT created_at FROM messages WHERE chat_room_id = chat_rooms.id ORDER BY id DESC LIMIT 1),
+		created_at
+	);
+	CREATE TABLE room_tags (
+		chat_room_id INTEGER NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (chat_room_id, tag),
+		FOREIGN KEY (chat_room_id) REFERENCES chat_rooms(id)
+	);`,
+
+	// 9: ルームが属するプロジェクト（git リポジトリのルート。既存のルームはどのプロジェクトにも属さない）
+	`ALTER TABLE chat_rooms ADD COLUMN project TEXT NOT NULL DEFAULT '';
+	CREATE INDEX chat_rooms_project_name ON chat_rooms(project, name);`,
+}
+
+// migrate は、未適用のマイグレーションをトランザクション内で順に適用します。
+// 複数のプロセスが同時に開いた場合に同じマイグレーションを二重に適用しないように、
+// 書き込みロックを取得したトランザクションの中でスキーマのバージョンを読み直します。
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for version < len(migrations) {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := tx.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to read schema version: %w", err)
+		}
+		if version >= len(migrations) {
+			tx.Rollback()
+			break
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		version++
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// createVersion は、最初の version 個のマイグレーションだけを適用したデータベースを作成し、setup の SQL を実行します。
+func createVersion(t *testing.T, version int, setup string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i, m := range migrations[:version] {
+		if _, err := db.Exec(m); err != nil {
+			t.Fatalf("migration %d: %v", i+1, err)
+		}
+	}
+	if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(setup); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// columnExists は、table に column の列があるかどうかを返します。
+func columnExists(t *testing.T, db *sql.DB, table, column string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+// TestMigrateAttachments は、パスの JSON 配列で保存していた添付ファイルが、添付ファイルのテーブルに移されることを確認します。
+func TestMigrateAttachments(t *testing.T) {
+	path := createVersion(t, 4, `INSERT INTO chat_rooms (name) VALUES ('old');
+		INSERT INTO messages (chat_room_id, role, message, attachments) VALUES
+			(1, 'user', 'hello', '["/src/a.go","/src/b.go"]'),
+			(1, 'assistant', 'hi', '[]');`)
+
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if columnExists(t, s.db, "messages", "attachments") {
+		t.Error("messages still has the attachments column")
+	}
+	for _, column := range []string{"message_id", "path", "mime_type", "data"} {
+		if !columnExists(t, s.db, "attachments", column) {
+			t.Errorf("attachments has no %s column", column)
+		}
+	}
+
+	h, err := s.Load(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Messages) != 2 || h.Messages[0].Content != "hello" || len(h.Messages[1].Attachments) != 0 {
+		t.Fatalf("messages = %+v", h.Messages)
+	}
+	var paths []string
+	for _, a := range h.Messages[0].Attachments {
+		if a.MIMEType != "text/plain" {
+			t.Errorf("%s: MIME type = %q, want text/plain", a.Path, a.MIMEType)
+		}
+		paths = append(paths, a.Path)
+	}
+	if strings.Join(paths, " ") != "/src/a.go /src/b.go" {
+		t.Errorf("attachments = %v", paths)
+	}
+}
+
+// TestMigrateTree は、木構造にする前の一直線の履歴で、ルームごとに直前のメッセージが親になり、
+// 最後のメッセージが選択中の枝の末端になることを確認します。
+func TestMigrateTree(t *testing.T) {
+	path := createVersion(t, 6, `INSERT INTO chat_rooms (name) VALUES ('a'), ('b'), ('empty');
+		INSERT INTO messages (chat_room_id, role, message) VALUES
+			(1, 'user', 'a1'), (2, 'user', 'b1'), (1, 'assistant', 'a2'),
+			(1, 'user', 'a3'), (2, 'assistant', 'b2'), (1, 'assistant', 'a4');`)
+
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		roomID int64
+		want   []string
+	}{
+		{1, []string{"a1", "a2", "a3", "a4"}},
+		{2, []string{"b1", "b2"}},
+		{3, nil},
+	}
+	for _, tt := range tests {
+		h, err := s.Load(tt.roomID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		var parent int64
+		for _, msg := range h.Messages {
+			got = append(got, msg.Content)
+			if msg.ParentID != parent {
+				t.Errorf("room %d: %s has parent %d, want %d", tt.roomID, msg.Content, msg.ParentID, parent)
+			}
+			parent = msg.ID
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("room %d: messages = %v, want %v", tt.roomID, got, tt.want)
+		}
+		if head, err := s.Head(tt.roomID); err != nil || head != parent {
+			t.Errorf("room %d: head = %d, %v, want %d", tt.roomID, head, err, parent)
+		}
+	}
+
+	// 移行した履歴の続きは、末端の子として保存します。
+	h, err := s.Load(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := h.AddMessage("user", "a5", 1)
+	if err := s.SaveMessage(1, msg); err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := s.Load(1); len(h.Messages) != 5 || h.Messages[4].ParentID != h.Messages[3].ID {
+		t.Errorf("messages after the migration = %+v", h.Messages)
+	}
+}
@@ -0,0 +1,37 @@
+package history
+
+import "strings"
+
+// SearchResult は、Search で見つかったメッセージとそのチャットルームの名前です。
+type SearchResult struct {
+	Room    string
+	Message ChatMessage
+}
+
+// Search は、本文に query を含むメッセージを新しい順に最大 limit 件返します。
+// 大文字と小文字は（ASCII の範囲で）区別しません。検索するのは project に属するチャットルームだけで、
+// roomID が0の場合はそれらの全てのチャットルームを検索します。
+func (s *Store) Search(query, project string, roomID int64, limit int) ([]SearchResult, error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query) + "%"
+	// messageColumns の列名が chat_rooms の列名と重ならないように、チャットルームの列には別名を付けて結合します。
+	rows, err := s.db.Query(`SELECT room_name, `+messageColumns+`
+		FROM messages JOIN (SELECT id AS room_id, name AS room_name, project AS room_project FROM chat_rooms) ON room_id = chat_room_id
+		WHERE message LIKE ? ESCAPE '\' AND room_project = ? AND (? = 0 OR chat_room_id = ?)
+		ORDER BY id DESC
+		LIMIT ?`, pattern, project, roomID, roomID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		msg := &r.Message
+		if err := rows.Scan(&r.Room, &msg.ID, &msg.ParentID, &msg.Role, &msg.Content, &msg.Tokens, &msg.Pinned, &msg.Usage.Model, &msg.Usage.PromptTokens, &msg.Usage.CompletionTokens, &msg.Time); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
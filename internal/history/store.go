@@ -0,0 +1,424 @@
+package history
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/kou12345/gollm/internal/secret"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ChatRoom は、メッセージをまとめるチャットルームを表現する構造体です。
+type ChatRoom struct {
+	ID           int64
+	Name         string
+	Project      string   // ルームが属するプロジェクトのルートのパス（空の場合はどのプロジェクトにも属さない）
+	JSONSchema   string   // 応答を JSON に限定する場合のスキーマ（空の場合は通常の応答）
+	Folder       string   // ルームをまとめるフォルダー（プロジェクト）の名前（空の場合はフォルダーなし）
+	Tags         []string // ルームに付けたタグ（名前の順）
+	Pinned       bool     // ルームの一覧で先頭に表示するかどうか
+	Archived     bool     // ルームの一覧で既定では表示しないかどうか
+	MessageCount int      // ルームの全ての枝のメッセージの数
+	CreatedAt    time.Time
+	UpdatedAt    time.Time // 最後にメッセージを保存した日時（メッセージがない場合は作成日時）
+}
+
+// Summary は、チャットルームの古いメッセージをモデルが要約したものです。
+// UpToID 以下のIDを持つメッセージの代わりにプロンプトへ含めます。
+type Summary struct {
+	ID        int64
+	RoomID    int64
+	Content   string
+	UpToID    int64 // 要約に含まれる最後のメッセージのID
+	Tokens    int32
+	Usage     Usage // 要約を作成したリクエストのトークン使用量
+	CreatedAt time.Time
+}
+
+// Store は、SQLiteデータベースに保存されたチャットルームとメッセージを管理します。
+// 日時は、作成した端末のタイムゾーンによらずに比較できるように UTC で保存します。
+type Store struct {
+	db *sql.DB
+}
+
+// storeParams は、データベースを開くときの go-sqlite3 のパラメータです。
+// 複数の端末やサーバーから同時に使用できるように、WAL モードで読み込みと書き込みを並行させ、
+// ロックの解放を待つ時間を設定します。トランザクションは開始時に書き込みロックを取得し、
+// 読み込みから書き込みへの昇格でデッドロックしないようにします。
+const storeParams = "_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+
+// OpenStore は、path のSQLiteデータベースを開き、スキーマを最新の状態に移行します。
+func OpenStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path+"?"+storeParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close は、データベースへの接続を閉じます。
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// roomColumns は、scanRoom で読み込むチャットルームの列です。タグは空白で区切って1つの列にまとめます。
+const roomColumns = `id, name, project, json_schema, folder, pinned, archived, created_at, updated_at,
+	(SELECT COUNT(*) FROM messages WHERE chat_room_id = chat_rooms.id),
+	(SELECT COALESCE(group_concat(tag, ' '), '') FROM (SELECT tag FROM room_tags WHERE chat_room_id = chat_rooms.id ORDER BY tag))`
+
+// scanRoom は、roomColumns の順に並んだ行をチャットルームとして読み込みます。
+func scanRoom(row interface{ Scan(...any) error }) (ChatRoom, error) {
+	var (
+		r       ChatRoom
+		updated sql.NullTime
+		tags    string
+	)
+	if err := row.Scan(&r.ID, &r.Name, &r.Project, &r.JSONSchema, &r.Folder, &r.Pinned, &r.Archived, &r.CreatedAt, &updated, &r.MessageCount, &tags); err != nil {
+		return r, err
+	}
+	r.UpdatedAt = r.CreatedAt
+	if updated.Valid {
+		r.UpdatedAt = updated.Time
+	}
+	r.Tags = strings.Fields(tags)
+	return r, nil
+}
+
+// ChatRooms は、アーカイブしたものを含む全てのチャットルームを作成日時の順に返します。
+func (s *Store) ChatRooms() ([]ChatRoom, error) {
+	rows, err := s.db.Query(`SELECT ` + roomColumns + ` FROM chat_rooms ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []ChatRoom
+	for rows.Next() {
+		r, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, r)
+	}
+	return rooms, rows.Err()
+}
+
+// OpenRoom は、どのプロジェクトにも属さない name という名前のチャットルームを返します。存在しない場合は作成します。
+func (s *Store) OpenRoom(name string) (ChatRoom, error) {
+	return s.OpenProjectRoom("", name)
+}
+
+// OpenProjectRoom は、project に属する name という名前のチャットルームを返します。存在しない場合は作成します。
+// 同じ名前のルームでも、プロジェクトが異なれば別のルームです。
+func (s *Store) OpenProjectRoom(project, name string) (ChatRoom, error) {
+	// 他のプロセスが同時に同じルームを作成しないように、書き込みロックを取得したトランザクションの中で探します。
+	tx, err := s.db.Begin()
+	if err != nil {
+		return ChatRoom{}, err
+	}
+	defer tx.Rollback()
+
+	r, err := scanRoom(tx.QueryRow(`SELECT `+roomColumns+` FROM chat_rooms WHERE project = ? AND name = ? ORDER BY id LIMIT 1`, project, name))
+	if err == nil {
+		return r, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return r, err
+	}
+
+	now := time.Now().UTC()
+	r = ChatRoom{Name: name, Project: project, CreatedAt: now, UpdatedAt: now}
+	res, err := tx.Exec(`INSERT INTO chat_rooms (name, project, created_at, updated_at) VALUES (?, ?, ?, ?)`, r.Name, r.Project, r.CreatedAt, r.UpdatedAt)
+	if err != nil {
+		return r, err
+	}
+	if r.ID, err = res.LastInsertId(); err != nil {
+		return r, err
+	}
+	return r, tx.Commit()
+}
+
+// SetRoomSchema は、チャットルームの応答に使用する JSON Schema を保存します。
+// schema が空の場合は、通常の応答に戻します。
+func (s *Store) SetRoomSchema(roomID int64, schema string) error {
+	_, err := s.db.Exec(`UPDATE chat_rooms SET json_schema = ? WHERE id = ?`, schema, roomID)
+	return err
+}
+
+// SetRoomFolder は、チャットルームをまとめるフォルダーを保存します。folder が空の場合は、フォルダーから外します。
+func (s *Store) SetRoomFolder(roomID int64, folder string) error {
+	_, err := s.db.Exec(`UPDATE chat_rooms SET folder = ? WHERE id = ?`, strings.TrimSpace(folder), roomID)
+	return err
+}
+
+// SetRoomPinned は、チャットルームをルームの一覧の先頭に表示するかどうかを保存します。
+func (s *Store) SetRoomPinned(roomID int64, pinned bool) error {
+	_, err := s.db.Exec(`UPDATE chat_rooms SET pinned = ? WHERE id = ?`, pinned, roomID)
+	return err
+}
+
+// SetRoomArchived は、チャットルームをアーカイブするかどうかを保存します。
+// アーカイブしたルームも、名前を指定すれば今までどおり使用できます。
+func (s *Store) SetRoomArchived(roomID int64, archived bool) error {
+	_, err := s.db.Exec(`UPDATE chat_rooms SET archived = ? WHERE id = ?`, archived, roomID)
+	return err
+}
+
+// SetRoomTags は、チャットルームのタグを tags に置き換え、保存したタグを名前の順に返します。
+// 重複するタグと空のタグは取り除きます。タグに空白を含めることはできません。
+func (s *Store) SetRoomTags(roomID int64, tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	var saved []string
+	for _, tag := range tags {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if strings.ContainsFunc(tag, unicode.IsSpace) {
+			return nil, fmt.Errorf("tag %q must not contain spaces", tag)
+		}
+		seen[tag] = true
+		saved = append(saved, tag)
+	}
+	sort.Strings(saved)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM room_tags WHERE chat_room_id = ?`, roomID); err != nil {
+		return nil, err
+	}
+	for _, tag := range saved {
+		if _, err := tx.Exec(`INSERT INTO room_tags (chat_room_id, tag) VALUES (?, ?)`, roomID, tag); err != nil {
+			return nil, err
+		}
+	}
+	return saved, tx.Commit()
+}
+
+// pathCTE は、? で指定したメッセージとその全ての祖先を path(id) として列挙する共通テーブル式です。
+const pathCTE = `WITH RECURSIVE path(id) AS (
+	SELECT id FROM messages WHERE id = ?
+	UNION ALL
+	SELECT m.parent_id FROM messages m JOIN path ON m.id = path.id WHERE m.parent_id IS NOT NULL
+)`
+
+// messageColumns は、scanMessage で読み込むメッセージの列です。
+const messageColumns = `id, COALESCE(parent_id, 0), role, message, tokens, pinned, model, prompt_tokens, completion_tokens, created_at`
+
+// scanMessage は、messageColumns の順に並んだ行をメッセージとして読み込みます。
+func scanMessage(rows *sql.Rows) (ChatMessage, error) {
+	var msg ChatMessage
+	err := rows.Scan(&msg.ID, &msg.ParentID, &msg.Role, &msg.Content, &msg.Tokens, &msg.Pinned, &msg.Usage.Model, &msg.Usage.PromptTokens, &msg.Usage.CompletionTokens, &msg.Time)
+	return msg, err
+}
+
+// Head は、チャットルームで選択中の枝の末端のメッセージのIDを返します。メッセージがない場合は0を返します。
+func (s *Store) Head(roomID int64) (int64, error) {
+	var head int64
+	err := s.db.QueryRow(`SELECT COALESCE(head_id, 0) FROM chat_rooms WHERE id = ?`, roomID).Scan(&head)
+	return head, err
+}
+
+// SetHead は、チャットルームで選択する枝の末端を headID に変更します。
+func (s *Store) SetHead(roomID, headID int64) error {
+	_, err := s.db.Exec(`UPDATE chat_rooms SET head_id = ? WHERE id = ?`, headID, roomID)
+	return err
+}
+
+// Load は、チャットルームで選択中の枝のメッセージを添付ファイルと共に古い順に読み込みます。
+func (s *Store) Load(roomID int64) (*ChatHistory, error) {
+	head, err := s.Head(roomID)
+	if err != nil {
+		return nil, err
+	}
+	return s.LoadPath(roomID, head)
+}
+
+// LoadPath は、根から headID までのメッセージを添付ファイルと共に古い順に読み込みます。
+// headID が0の場合は、空の履歴を返します。
+func (s *Store) LoadPath(roomID, headID int64) (*ChatHistory, error) {
+	rows, err := s.db.Query(pathCTE+` SELECT `+messageColumns+` FROM messages WHERE id IN (SELECT id FROM path) ORDER BY id`, headID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	h := &ChatHistory{RoomID: roomID, Messages: []ChatMessage{}}
+	index := map[int64]int{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		index[msg.ID] = len(h.Messages)
+		h.Messages = append(h.Messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	arows, err := s.db.Query(pathCTE+` SELECT message_id, path, mime_type, data FROM attachments WHERE message_id IN (SELECT id FROM path) ORDER BY id`, headID)
+	if err != nil {
+		return nil, err
+	}
+	defer arows.Close()
+
+	for arows.Next() {
+		var (
+			messageID int64
+			a         Attachment
+		)
+		if err := arows.Scan(&messageID, &a.Path, &a.MIMEType, &a.Data); err != nil {
+			return nil, err
+		}
+		if i, ok := index[messageID]; ok {
+			h.Messages[i].Attachments = append(h.Messages[i].Attachments, a)
+		}
+	}
+	return h, arows.Err()
+}
+
+// Siblings は、msg と同じ親を持つメッセージ（msg 自身を含む）を古い順に返します。
+// 返すメッセージには添付ファイルを含みません。
+func (s *Store) Siblings(roomID int64, msg ChatMessage) ([]ChatMessage, error) {
+	var parent any
+	if msg.ParentID != 0 {
+		parent = msg.ParentID
+	}
+	rows, err := s.db.Query(`SELECT `+messageColumns+` FROM messages WHERE chat_room_id = ? AND parent_id IS ? ORDER BY id`, roomID, parent)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var siblings []ChatMessage
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		siblings = append(siblings, msg)
+	}
+	return siblings, rows.Err()
+}
+
+// Branches は、チャットルームの全てのメッセージのIDを、親のメッセージのID（最初のメッセージは0）ごとに古い順に返します。
+// 2つ以上のIDを持つ親は、そこから会話が枝分かれしています。
+func (s *Store) Branches(roomID int64) (map[int64][]int64, error) {
+	rows, err := s.db.Query(`SELECT id, COALESCE(parent_id, 0) FROM messages WHERE chat_room_id = ? ORDER BY id`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	branches := map[int64][]int64{}
+	for rows.Next() {
+		var id, parent int64
+		if err := rows.Scan(&id, &parent); err != nil {
+			return nil, err
+		}
+		branches[parent] = append(branches[parent], id)
+	}
+	return branches, rows.Err()
+}
+
+// Leaf は、id のメッセージから最も新しい子をたどった末端のメッセージのIDを返します。
+// 別の枝に切り替えるときに、その枝の最新のやり取りを選択するために使用します。
+func (s *Store) Leaf(id int64) (int64, error) {
+	for {
+		var child sql.NullInt64
+		if err := s.db.QueryRow(`SELECT MAX(id) FROM messages WHERE parent_id = ?`, id).Scan(&child); err != nil {
+			return 0, err
+		}
+		if !child.Valid {
+			return id, nil
+		}
+		id = child.Int64
+	}
+}
+
+// SaveMessage は、msg をチャットルームの新しいメッセージとして添付ファイルと共に保存し、msg.ID を設定します。
+// 保存したメッセージは、チャットルームで選択中の枝の末端になります。
+// 内容に含まれる登録済みの秘密情報は伏せ字に置き換えられます。
+func (s *Store) SaveMessage(roomID int64, msg *ChatMessage) error {
+	msg.Content = secret.Redact(msg.Content)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var parent any
+	if msg.ParentID != 0 {
+		parent = msg.ParentID
+	}
+	res, err := tx.Exec(`INSERT INTO messages (chat_room_id, parent_id, role, message, tokens, pinned, model, prompt_tokens, completion_tokens, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		roomID, parent, msg.Role, msg.Content, msg.Tokens, msg.Pinned, msg.Usage.Model, msg.Usage.PromptTokens, msg.Usage.CompletionTokens, msg.Time.UTC())
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for _, a := range msg.Attachments {
+		if _, err := tx.Exec(`INSERT INTO attachments (message_id, path, mime_type, data) VALUES (?, ?, ?, ?)`, id, a.Path, a.MIMEType, a.Data); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE chat_rooms SET head_id = ?, updated_at = ? WHERE id = ?`, id, msg.Time.UTC(), roomID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	msg.ID = id
+	return nil
+}
+
+// UpdateMessage は、保存済みのメッセージのトークン数とピン留めの状態を更新します。
+func (s *Store) UpdateMessage(msg ChatMessage) error {
+	_, err := s.db.Exec(`UPDATE messages SET tokens = ?, pinned = ? WHERE id = ?`, msg.Tokens, msg.Pinned, msg.ID)
+	return err
+}
+
+// LatestSummary は、根から headID までの枝に含まれるメッセージを要約したもののうち、最新の要約を返します。
+// 要約がない場合は nil を返します。
+func (s *Store) LatestSummary(roomID, headID int64) (*Summary, error) {
+	sum := &Summary{}
+	err := s.db.QueryRow(pathCTE+` SELECT id, chat_room_id, content, up_to_message_id, tokens, model, prompt_tokens, completion_tokens, created_at FROM summaries WHERE chat_room_id = ? AND up_to_message_id IN (SELECT id FROM path) ORDER BY up_to_message_id DESC, id DESC LIMIT 1`, headID, roomID).
+		Scan(&sum.ID, &sum.RoomID, &sum.Content, &sum.UpToID, &sum.Tokens, &sum.Usage.Model, &sum.Usage.PromptTokens, &sum.Usage.CompletionTokens, &sum.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sum, nil
+}
+
+// SaveSummary は、sum を新しい要約として保存し、sum.ID を設定します。
+func (s *Store) SaveSummary(sum *Summary) error {
+	res, err := s.db.Exec(`INSERT INTO summaries (chat_room_id, content, up_to_message_id, tokens, model, prompt_tokens, completion_tokens, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		sum.RoomID, sum.Content, sum.UpToID, sum.Tokens, sum.Usage.Model, sum.Usage.PromptTokens, sum.Usage.CompletionTokens, sum.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	sum.ID, err = res.LastInsertId()
+	return err
+}
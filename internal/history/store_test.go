@@ -0,0 +1,160 @@
+package history
+
+import (
+	"reflect"
+	"testing"
+)
+
+// saveTree は、room に次の木構造のメッセージを保存し、名前とIDの対応を返します。
+// 最後に保存した b3 が選択中の枝の末端になります。
+//
+//	u1 ─┬─ a1 ── u2 ── a2
+//	    └─ b1 ── b2 ── b3
+func saveTree(t *testing.T, s *Store, roomID int64) map[string]int64 {
+	t.Helper()
+	ids := map[string]int64{}
+	for _, m := range []struct{ name, parent, role string }{
+		{"u1", "", "user"},
+		{"a1", "u1", "assistant"},
+		{"u2", "a1", "user"},
+		{"a2", "u2", "assistant"},
+		{"b1", "u1", "assistant"},
+		{"b2", "b1", "user"},
+		{"b3", "b2", "assistant"},
+	} {
+		msg := ChatMessage{ParentID: ids[m.parent], Role: m.role, Content: m.name}
+		if m.name == "u2" {
+			msg.Attachments = []Attachment{{Path: "notes.txt", MIMEType: "text/plain"}}
+		}
+		if err := s.SaveMessage(roomID, &msg); err != nil {
+			t.Fatal(err)
+		}
+		ids[m.name] = msg.ID
+	}
+	return ids
+}
+
+// contents は、メッセージの内容の列を返します。
+func contents(msgs []ChatMessage) []string {
+	var c []string
+	for _, msg := range msgs {
+		c = append(c, msg.Content)
+	}
+	return c
+}
+
+func TestStoreTree(t *testing.T) {
+	s := openTestStore(t)
+	room, err := s.OpenRoom("tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 別のルームのメッセージが混ざらないことも確認します。
+	other, err := s.OpenRoom("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := saveTree(t, s, room.ID)
+	otherIDs := saveTree(t, s, other.ID)
+
+	h, err := s.Load(room.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(h.Messages); !reflect.DeepEqual(got, []string{"u1", "b1", "b2", "b3"}) {
+		t.Errorf("Load = %v, want the last saved branch", got)
+	}
+
+	t.Run("LoadPath", func(t *testing.T) {
+		h, err := s.LoadPath(room.ID, ids["a2"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := contents(h.Messages); !reflect.DeepEqual(got, []string{"u1", "a1", "u2", "a2"}) {
+			t.Errorf("LoadPath(a2) = %v", got)
+		}
+		if a := h.Messages[2].Attachments; len(a) != 1 || a[0].Path != "notes.txt" {
+			t.Errorf("attachments of u2 = %+v", a)
+		}
+		if h, err := s.LoadPath(room.ID, 0); err != nil || len(h.Messages) != 0 {
+			t.Errorf("LoadPath(0) = %v, %v, want an empty history", h, err)
+		}
+	})
+
+	t.Run("Siblings", func(t *testing.T) {
+		tests := []struct {
+			msg, parent string
+			want        []string
+		}{
+			{"a1", "u1", []string{"a1", "b1"}},
+			{"b1", "u1", []string{"a1", "b1"}},
+			{"u2", "a1", []string{"u2"}},
+			{"u1", "", []string{"u1"}},
+		}
+		for _, tt := range tests {
+			msg := ChatMessage{ID: ids[tt.msg], ParentID: ids[tt.parent]}
+			siblings, err := s.Siblings(room.ID, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := contents(siblings); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Siblings(%s) = %v, want %v", tt.msg, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("Leaf", func(t *testing.T) {
+		tests := []struct{ from, want string }{
+			{"u1", "b3"}, // 最も新しい子をたどります
+			{"a1", "a2"},
+			{"a2", "a2"},
+		}
+		for _, tt := range tests {
+			if leaf, err := s.Leaf(ids[tt.from]); err != nil || leaf != ids[tt.want] {
+				t.Errorf("Leaf(%s) = %d, %v, want %s (%d)", tt.from, leaf, err, tt.want, ids[tt.want])
+			}
+		}
+	})
+
+	t.Run("Branches", func(t *testing.T) {
+		branches, err := s.Branches(room.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[int64][]int64{
+			0:         {ids["u1"]},
+			ids["u1"]: {ids["a1"], ids["b1"]},
+			ids["a1"]: {ids["u2"]},
+			ids["u2"]: {ids["a2"]},
+			ids["b1"]: {ids["b2"]},
+			ids["b2"]: {ids["b3"]},
+		}
+		if !reflect.DeepEqual(branches, want) {
+			t.Errorf("Branches = %v, want %v", branches, want)
+		}
+	})
+
+	t.Run("SetHead", func(t *testing.T) {
+		if err := s.SetHead(room.ID, ids["a2"]); err != nil {
+			t.Fatal(err)
+		}
+		h, err := s.Load(room.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := contents(h.Messages); !reflect.DeepEqual(got, []string{"u1", "a1", "u2", "a2"}) {
+			t.Errorf("Load after SetHead = %v", got)
+		}
+		// 選択中の枝に保存したメッセージは、その枝の続きになります。
+		msg := h.AddMessage("user", "u3", 1)
+		if err := s.SaveMessage(room.ID, msg); err != nil {
+			t.Fatal(err)
+		}
+		if head, err := s.Head(room.ID); err != nil || head != msg.ID || msg.ParentID != ids["a2"] {
+			t.Errorf("head = %d, %v, parent = %d", head, err, msg.ParentID)
+		}
+		if head, _ := s.Head(other.ID); head != otherIDs["b3"] {
+			t.Errorf("other room head = %d, want it unchanged", head)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package history
+
+import (
+	"fmt"
+	"time"
+)
+
+// UsageGroup は、トークン使用量を集計する単位を表します。
+type UsageGroup string
+
+const (
+	UsageByDay   UsageGroup = "day"   // 日付（YYYY-MM-DD）ごと
+	UsageByRoom  UsageGroup = "room"  // チャットルームごと
+	UsageByModel UsageGroup = "model" // モデルごと
+)
+
+// UsageRow は、集計したトークン使用量の1行を表現する構造体です。
+// 料金はモデルごとに異なるため、行は Key と Model の組ごとに分かれます。
+type UsageRow struct {
+	Key              string // 集計の単位の値（日付、ルーム名、モデル名）
+	RoomID           int64  // ルームごとに集計した場合のルームのID（同じ名前のルームを区別します）
+	Project          string // ルームごとに集計した場合のルームが属するプロジェクト
+	Model            string
+	Requests         int
+	PromptTokens     int64
+	CompletionTokens int64
+}
+
+// UsageTotals は、since 以降に記録されたメッセージと要約のトークン使用量を by ごとに集計します。
+// 日付は、実行している端末のタイムゾーンで区切ります。
+// ルームはIDごとに集計するため、プロジェクトが異なる同じ名前のルームは別の行になります。
+func (s *Store) UsageTotals(by UsageGroup, since time.Time) ([]UsageRow, error) {
+	var key, room, project string
+	switch by {
+	case UsageByDay:
+		key, room, project = "date(u.created_at, 'localtime')", "0", "''"
+	case UsageByRoom:
+		key, room, project = "r.name", "r.id", "r.project"
+	case UsageByModel:
+		key, room, project = "u.model", "0", "''"
+	default:
+		return nil, fmt.Errorf("unknown usage grouping %q", by)
+	}
+
+	rows, err := s.db.Query(`SELECT `+key+`, `+room+`, `+project+`, u.model, COUNT(*), SUM(u.prompt_tokens), SUM(u.completion_tokens)
+		FROM (
+			SELECT chat_room_id, model, prompt_tokens, completion_tokens, created_at FROM messages WHERE model != ''
+			UNION ALL
+			SELECT chat_room_id, model, prompt_tokens, completion_tokens, created_at FROM summaries WHERE model != ''
+		) u
+		JOIN chat_rooms r ON r.id = u.chat_room_id
+		WHERE julianday(u.created_at) >= julianday(?)
+		GROUP BY 1, 2, 3, 4
+		ORDER BY 1, 3, 2, 4`, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []UsageRow
+	for rows.Next() {
+		var row UsageRow
+		if err := rows.Scan(&row.Key, &row.RoomID, &row.Project, &row.Model, &row.Requests, &row.PromptTokens, &row.CompletionTokens); err != nil {
+			return nil, err
+		}
+		totals = append(totals, row)
+	}
+	return totals, rows.Err()
+}
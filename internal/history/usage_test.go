@@ -0,0 +1,81 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+// saveUsage は、room に model の応答として使用量を記録したメッセージを at の時刻で保存します。
+func saveUsage(t *testing.T, s *Store, room ChatRoom, model string, at time.Time) {
+	t.Helper()
+	h, err := s.Load(room.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := h.AddMessage("assistant", "reply", 5)
+	msg.Time = at
+	msg.Usage = Usage{Model: model, PromptTokens: 10, CompletionTokens: 5}
+	if err := s.SaveMessage(room.ID, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUsageTotalsByRoom(t *testing.T) {
+	s := openTestStore(t)
+	now := time.Now()
+	a, err := s.OpenProjectRoom("/src/a", "notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.OpenProjectRoom("/src/b", "notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveUsage(t, s, a, "gemini-1.5-flash", now)
+	saveUsage(t, s, a, "gemini-1.5-flash", now)
+	saveUsage(t, s, b, "gemini-1.5-flash", now)
+
+	rows, err := s.UsageTotals(UsageByRoom, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("rows = %+v, want one row per room", rows)
+	}
+	if rows[0].RoomID != a.ID || rows[0].Project != "/src/a" || rows[0].Requests != 2 || rows[0].PromptTokens != 20 {
+		t.Errorf("rows[0] = %+v", rows[0])
+	}
+	if rows[1].RoomID != b.ID || rows[1].Project != "/src/b" || rows[1].Requests != 1 {
+		t.Errorf("rows[1] = %+v", rows[1])
+	}
+}
+
+// TestUsageTotalsSince は、異なるタイムゾーンで記録した時刻を、日時として比較して絞り込むことを確認します。
+func TestUsageTotalsSince(t *testing.T) {
+	s := openTestStore(t)
+	room, err := s.OpenRoom("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	since := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	tokyo := time.FixedZone("JST", 9*60*60)
+	newYork := time.FixedZone("EST", -5*60*60)
+	saveUsage(t, s, room, "before", time.Date(2024, 1, 2, 8, 0, 0, 0, tokyo))   // 2024-01-01 23:00 UTC
+	saveUsage(t, s, room, "after", time.Date(2024, 1, 1, 20, 0, 0, 0, newYork)) // 2024-01-02 01:00 UTC
+
+	rows, err := s.UsageTotals(UsageByModel, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Key != "after" {
+		t.Errorf("rows = %+v, want only the message after %v", rows, since)
+	}
+
+	var stored string
+	if err := s.db.QueryRow(`SELECT substr(created_at, 1, 19) FROM messages WHERE model = 'before'`).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != "2024-01-01 23:00:00" {
+		t.Errorf("created_at = %q, want it stored in UTC", stored)
+	}
+}
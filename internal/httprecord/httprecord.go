@@ -0,0 +1,227 @@
+// Package httprecord は、HTTP のリクエストと応答の組をファイルに記録し、後からネットワークを使わずに再生する
+// http.RoundTripper を提供します。
+//
+// genai.NewClient には option.WithHTTPClient で、それ以外のバックエンドには http.Client として渡せます。
+// 記録したファイルには API キーを含めません。
+package httprecord
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/kou12345/gollm/internal/atomicfile"
+)
+
+// Mode は、Recorder の動作です。
+type Mode string
+
+const (
+	ModeReplay Mode = "replay" // 記録済みの応答を返す（ネットワークを使用しない）
+	ModeRecord Mode = "record" // 実際に送信した結果を記録する
+)
+
+// secretParams は、記録する前に URL から取り除くクエリパラメータです。
+var secretParams = []string{"key", "access_token"}
+
+// keptHeaders は、記録する応答のヘッダーです。日時などの再生に関係しないヘッダーは記録しません。
+var keptHeaders = []string{"Content-Type", "Retry-After"}
+
+// Interaction は、1回のリクエストとその応答です。
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request は、記録したリクエストです。
+type Request struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+// Response は、記録した応答です。
+type Response struct {
+	Status int               `json:"status"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   string            `json:"body"`
+}
+
+// Recorder は、リクエストを記録または再生する http.RoundTripper です。
+// 再生では、メソッド・URL・本文が一致する未使用の記録を、記録した順に返します。
+type Recorder struct {
+	mode Mode
+	path string
+	base http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// New は、path のファイルを使用する Recorder を作成します。
+// ModeReplay では path を読み込みます。ModeRecord では base でリクエストを送信し、Close で path に書き込みます。
+// base が nil の場合は http.DefaultTransport を使用します。
+func New(path string, mode Mode, base http.RoundTripper) (*Recorder, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	r := &Recorder{mode: mode, path: path, base: base}
+	switch mode {
+	case ModeReplay:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixture: %w", err)
+		}
+		if err := json.Unmarshal(data, &r.interactions); err != nil {
+			return nil, fmt.Errorf("fixture %s: %w", path, err)
+		}
+		r.used = make([]bool, len(r.interactions))
+	case ModeRecord:
+	default:
+		return nil, fmt.Errorf("unknown mode %q", mode)
+	}
+	return r, nil
+}
+
+// Client は、r を使用する http.Client を返します。
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// RoundTrip は、リクエストを記録するか、記録済みの応答を返します。
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	recorded := Request{Method: req.Method, URL: redactURL(req.URL), Body: normalizeBody(body)}
+
+	if r.mode == ModeReplay {
+		resp, ok := r.replay(recorded)
+		if !ok {
+			return nil, fmt.Errorf("httprecord: no recorded response for %s %s", recorded.Method, recorded.URL)
+		}
+		return resp.toHTTP(req), nil
+	}
+
+	httpResp, err := r.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(httpResp.Body)
+	httpResp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	httpResp.Body = io.NopCloser(bytes.NewReader(data))
+
+	resp := Response{Status: httpResp.StatusCode, Body: string(data)}
+	for _, name := range keptHeaders {
+		if v := httpResp.Header.Get(name); v != "" {
+			if resp.Header == nil {
+				resp.Header = map[string]string{}
+			}
+			resp.Header[name] = v
+		}
+	}
+	r.mu.Lock()
+	r.interactions = append(r.interactions, Interaction{Request: recorded, Response: resp})
+	r.mu.Unlock()
+	return httpResp, nil
+}
+
+// replay は、req に一致する最初の未使用の記録を返します。
+func (r *Recorder) replay(req Request) (Response, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.interactions {
+		if !r.used[i] && in.Request == req {
+			r.used[i] = true
+			return in.Response, true
+		}
+	}
+	return Response{}, false
+}
+
+// Unused は、再生で使われなかった記録のリクエストを返します。
+func (r *Recorder) Unused() []Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var reqs []Request
+	for i, in := range r.interactions {
+		if i < len(r.used) && !r.used[i] {
+			reqs = append(reqs, in.Request)
+		}
+	}
+	return reqs
+}
+
+// Close は、ModeRecord で記録したリクエストをファイルに書き込みます。ModeReplay では何もしません。
+func (r *Recorder) Close() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.interactions, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(r.path, append(data, '\n'), 0644)
+}
+
+// toHTTP は、記録した応答を req に対する http.Response に変換します。
+func (resp Response) toHTTP(req *http.Request) *http.Response {
+	header := http.Header{}
+	for k, v := range resp.Header {
+		header.Set(k, v)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.Status, http.StatusText(resp.Status)),
+		StatusCode:    resp.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(resp.Body)),
+		ContentLength: int64(len(resp.Body)),
+		Request:       req,
+	}
+}
+
+// redactURL は、API キーなどの秘密のクエリパラメータを取り除いた URL を返します。
+func redactURL(u *url.URL) string {
+	copied := *u
+	q := copied.Query()
+	for _, name := range secretParams {
+		q.Del(name)
+	}
+	copied.RawQuery = q.Encode()
+	return copied.String()
+}
+
+// normalizeBody は、JSON の本文から余分な空白を取り除きます。
+// protojson は出力の空白を意図的にばらつかせるため、そのままでは同じリクエストでも一致しません。
+func normalizeBody(body []byte) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, body); err == nil {
+		return buf.String()
+	}
+	return string(body)
+}
@@ -0,0 +1,80 @@
+package httprecord
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Date", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, `{"echo":`+string(body)+`}`)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	send := func(rec *Recorder, body string) (int, string) {
+		t.Helper()
+		resp, err := rec.Client().Post(srv.URL+"/v1/echo?key=secret&alt=json", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	rec, err := New(path, ModeRecord, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body := send(rec, `{"n": 1}`)
+	if status != http.StatusTeapot || body != `{"echo":{"n": 1}}` {
+		t.Fatalf("recorded response = %d %s", status, body)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") || strings.Contains(string(data), "Date") {
+		t.Errorf("fixture contains the API key or unneeded headers:\n%s", data)
+	}
+
+	rec, err = New(path, ModeReplay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 空白の違いは無視して一致させます。
+	status, body = send(rec, `{"n":1}`)
+	if status != http.StatusTeapot || body != `{"echo":{"n": 1}}` {
+		t.Errorf("replayed response = %d %s", status, body)
+	}
+	if calls != 1 {
+		t.Errorf("server was called %d times, want 1", calls)
+	}
+
+	// 記録は一度だけ使用します。
+	if _, err := rec.Client().Post(srv.URL+"/v1/echo?alt=json", "application/json", strings.NewReader(`{"n":1}`)); err == nil {
+		t.Error("replaying the same interaction twice succeeded")
+	}
+	if _, err := rec.Client().Post(srv.URL+"/v1/echo?alt=json", "application/json", strings.NewReader(`{"n":2}`)); err == nil {
+		t.Error("replaying an unrecorded request succeeded")
+	}
+	if len(rec.Unused()) != 0 {
+		t.Errorf("Unused = %v", rec.Unused())
+	}
+}
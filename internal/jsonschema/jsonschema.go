@@ -0,0 +1,289 @@
+// Package jsonschema は、JSON Schema の読み込みと、構造化出力の検証に必要な範囲の検証機能を提供します。
+//
+// 対応しているキーワードは type, enum, const, properties, required, additionalProperties,
+// items, minItems, maxItems, minLength, maxLength, pattern, minimum, maximum, nullable,
+// anyOf, oneOf, allOf です。それ以外のキーワードは無視します。
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Load は、path の JSON Schema を読み込みます。
+func Load(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse は、JSON Schema を表す JSON を解析します。
+func Parse(data []byte) (map[string]any, error) {
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	return schema, nil
+}
+
+// ValidateJSON は、data が JSON として正しく、schema を満たしているかを検証します。
+// 成功した場合は、余分な空白を取り除いた JSON を返します。
+func ValidateJSON(schema map[string]any, data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	var value any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return nil, fmt.Errorf("response is not valid JSON: %w", err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("response contains more than one JSON value")
+	}
+	if err := Validate(schema, value); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Validate は、json.Decoder で UseNumber を指定して復元した value が schema を満たしているかを検証します。
+// エラーには、条件を満たさなかった値の位置を JSON Pointer で含めます。
+func Validate(schema map[string]any, value any) error {
+	return validate(schema, value, "")
+}
+
+func validate(schema map[string]any, value any, path string) error {
+	at := path
+	if at == "" {
+		at = "/"
+	}
+
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+	}
+	if t, ok := schema["type"]; ok {
+		if err := checkType(t, value); err != nil {
+			return fmt.Errorf("%s: %w", at, err)
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok && !contains(enum, value) {
+		return fmt.Errorf("%s: value must be one of %s", at, compact(enum))
+	}
+	if c, ok := schema["const"]; ok && !equal(c, value) {
+		return fmt.Errorf("%s: value must be %s", at, compact(c))
+	}
+
+	for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, ok := schema[key].([]any)
+		if !ok {
+			continue
+		}
+		matched := 0
+		var firstErr error
+		for _, s := range subs {
+			sub, _ := s.(map[string]any)
+			if err := validate(sub, value, path); err != nil {
+				if key == "allOf" {
+					return err
+				}
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			matched++
+		}
+		switch {
+		case key == "anyOf" && matched == 0:
+			return fmt.Errorf("%s: value does not match any schema in anyOf (first error: %v)", at, firstErr)
+		case key == "oneOf" && matched != 1:
+			return fmt.Errorf("%s: value matches %d schemas in oneOf, want exactly 1", at, matched)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		return validateObject(schema, v, path)
+	case []any:
+		if n, ok := number(schema["minItems"]); ok && float64(len(v)) < n {
+			return fmt.Errorf("%s: array must have at least %v items", at, n)
+		}
+		if n, ok := number(schema["maxItems"]); ok && float64(len(v)) > n {
+			return fmt.Errorf("%s: array must have at most %v items", at, n)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validate(items, item, fmt.Sprintf("%s/%d", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if n, ok := number(schema["minLength"]); ok && length < n {
+			return fmt.Errorf("%s: string must be at least %v characters", at, n)
+		}
+		if n, ok := number(schema["maxLength"]); ok && length > n {
+			return fmt.Errorf("%s: string must be at most %v characters", at, n)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("%s: invalid pattern in schema: %w", at, err)
+			}
+			if !re.MatchString(v) {
+				return fmt.Errorf("%s: string must match %q", at, pattern)
+			}
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if n, ok := number(schema["minimum"]); ok && f < n {
+			return fmt.Errorf("%s: number must be at least %v", at, n)
+		}
+		if n, ok := number(schema["maximum"]); ok && f > n {
+			return fmt.Errorf("%s: number must be at most %v", at, n)
+		}
+	}
+	return nil
+}
+
+// validateObject は、オブジェクトのプロパティを検証します。
+func validateObject(schema map[string]any, v map[string]any, path string) error {
+	at := path
+	if at == "" {
+		at = "/"
+	}
+
+	if required, ok := schema["required"].([]any); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", at, name)
+			}
+		}
+	}
+
+	props, _ := schema["properties"].(map[string]any)
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		child := path + "/" + escape(k)
+		if prop, ok := props[k].(map[string]any); ok {
+			if err := validate(prop, v[k], child); err != nil {
+				return err
+			}
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				return fmt.Errorf("%s: unexpected property %q", at, k)
+			}
+		case map[string]any:
+			if err := validate(extra, v[k], child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkType は、value が t で指定された型（または型のリスト）のいずれかであるかを確認します。
+func checkType(t any, value any) error {
+	var names []string
+	switch t := t.(type) {
+	case string:
+		names = []string{t}
+	case []any:
+		for _, n := range t {
+			if s, ok := n.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+	for _, name := range names {
+		if typeOf(value) == name || (name == "number" && typeOf(value) == "integer") {
+			return nil
+		}
+	}
+	return fmt.Errorf("expected %s, got %s", strings.Join(names, " or "), typeOf(value))
+}
+
+// typeOf は、value の JSON Schema での型名を返します。
+func typeOf(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// number は、スキーマ内の数値を float64 として取り出します。
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// equal は、スキーマ内の値と検証する値が JSON として等しいかを比較します。
+// スキーマは float64、検証する値は json.Number で数値を表すため、JSON に戻して比較します。
+func equal(a, b any) bool {
+	var x, y any
+	json.Unmarshal([]byte(compact(a)), &x)
+	json.Unmarshal([]byte(compact(b)), &y)
+	return reflect.DeepEqual(x, y)
+}
+
+func contains(list []any, value any) bool {
+	for _, v := range list {
+		if equal(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func compact(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// escape は、プロパティ名を JSON Pointer の形式にエスケープします。
+func escape(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
@@ -0,0 +1,143 @@
+package jsonschema
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		value   string
+		wantErr string // 空の場合は成功
+	}{
+		{name: "type string", schema: `{"type": "string"}`, value: `"a"`},
+		{name: "type mismatch", schema: `{"type": "string"}`, value: `1`, wantErr: "/: expected string, got integer"},
+		{name: "integer is a number", schema: `{"type": "number"}`, value: `3`},
+		{name: "number is not an integer", schema: `{"type": "integer"}`, value: `3.5`, wantErr: "expected integer, got number"},
+		{name: "type list", schema: `{"type": ["string", "null"]}`, value: `null`},
+		{name: "type list mismatch", schema: `{"type": ["string", "null"]}`, value: `true`, wantErr: "expected string or null, got boolean"},
+		{name: "nullable", schema: `{"type": "string", "nullable": true}`, value: `null`},
+		{name: "not nullable", schema: `{"type": "string"}`, value: `null`, wantErr: "expected string, got null"},
+
+		{name: "enum", schema: `{"enum": ["red", 1]}`, value: `1`},
+		{name: "enum mismatch", schema: `{"enum": ["red", 1]}`, value: `"blue"`, wantErr: `value must be one of ["red",1]`},
+		{name: "const object", schema: `{"const": {"a": [1, 2]}}`, value: `{"a": [1, 2.0]}`},
+		{name: "const mismatch", schema: `{"const": 1}`, value: `2`, wantErr: "value must be 1"},
+
+		{name: "properties", schema: `{"properties": {"a": {"type": "integer"}}}`, value: `{"a": 1, "b": "x"}`},
+		{name: "property mismatch", schema: `{"properties": {"a": {"type": "integer"}}}`, value: `{"a": "x"}`, wantErr: "/a: expected integer, got string"},
+		{name: "pointer escapes", schema: `{"properties": {"a/b~c": {"type": "integer"}}}`, value: `{"a/b~c": "x"}`, wantErr: "/a~1b~0c: expected integer"},
+		{name: "required", schema: `{"required": ["a", "b"]}`, value: `{"a": 1, "b": null}`},
+		{name: "missing required", schema: `{"required": ["a", "b"]}`, value: `{"a": 1}`, wantErr: `/: missing required property "b"`},
+		{name: "additional properties allowed", schema: `{"properties": {"a": {}}, "additionalProperties": true}`, value: `{"b": 1}`},
+		{name: "additional properties refused", schema: `{"properties": {"a": {}}, "additionalProperties": false}`, value: `{"a": 1, "b": 1}`, wantErr: `unexpected property "b"`},
+		{name: "additional properties schema", schema: `{"additionalProperties": {"type": "string"}}`, value: `{"b": 1}`, wantErr: "/b: expected string"},
+
+		{name: "items", schema: `{"items": {"type": "integer"}}`, value: `[1, 2]`},
+		{name: "item mismatch", schema: `{"items": {"type": "integer"}}`, value: `[1, "x"]`, wantErr: "/1: expected integer"},
+		{name: "nested pointer", schema: `{"properties": {"list": {"items": {"required": ["id"]}}}}`, value: `{"list": [{"id": 1}, {}]}`, wantErr: `/list/1: missing required property "id"`},
+		{name: "min items", schema: `{"minItems": 2}`, value: `[1]`, wantErr: "array must have at least 2 items"},
+		{name: "max items", schema: `{"maxItems": 1}`, value: `[1, 2]`, wantErr: "array must have at most 1 items"},
+
+		{name: "min length counts characters", schema: `{"minLength": 2}`, value: `"日本"`},
+		{name: "min length", schema: `{"minLength": 2}`, value: `"a"`, wantErr: "string must be at least 2 characters"},
+		{name: "max length", schema: `{"maxLength": 1}`, value: `"日本"`, wantErr: "string must be at most 1 characters"},
+		{name: "pattern", schema: `{"pattern": "^[a-z]+$"}`, value: `"abc"`},
+		{name: "pattern mismatch", schema: `{"pattern": "^[a-z]+$"}`, value: `"ABC"`, wantErr: `string must match "^[a-z]+$"`},
+		{name: "invalid pattern", schema: `{"pattern": "("}`, value: `"a"`, wantErr: "invalid pattern in schema"},
+
+		{name: "minimum", schema: `{"minimum": 1.5}`, value: `1.5`},
+		{name: "below minimum", schema: `{"minimum": 1.5}`, value: `1`, wantErr: "number must be at least 1.5"},
+		{name: "above maximum", schema: `{"maximum": 10}`, value: `10.1`, wantErr: "number must be at most 10"},
+
+		{name: "anyOf", schema: `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, value: `1`},
+		{name: "anyOf mismatch", schema: `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, value: `true`, wantErr: "value does not match any schema in anyOf (first error: /: expected string"},
+		{name: "oneOf", schema: `{"oneOf": [{"type": "string"}, {"type": "integer"}]}`, value: `"a"`},
+		{name: "oneOf matches two", schema: `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, value: `1`, wantErr: "value matches 2 schemas in oneOf, want exactly 1"},
+		{name: "oneOf matches none", schema: `{"oneOf": [{"type": "string"}]}`, value: `1`, wantErr: "value matches 0 schemas in oneOf"},
+		{name: "allOf", schema: `{"allOf": [{"type": "integer"}, {"minimum": 1}]}`, value: `2`},
+		{name: "allOf mismatch", schema: `{"allOf": [{"type": "integer"}, {"minimum": 1}]}`, value: `0`, wantErr: "number must be at least 1"},
+
+		// 対応していないキーワードは無視します。
+		{name: "format is ignored", schema: `{"type": "string", "format": "email"}`, value: `"not an email"`},
+		{name: "multipleOf is ignored", schema: `{"multipleOf": 3}`, value: `4`},
+		{name: "uniqueItems is ignored", schema: `{"uniqueItems": true}`, value: `[1, 1]`},
+		{name: "exclusiveMinimum is ignored", schema: `{"exclusiveMinimum": 5}`, value: `1`},
+		{name: "$ref is ignored", schema: `{"$ref": "#/definitions/x", "definitions": {"x": {"type": "string"}}}`, value: `1`},
+		{name: "not is ignored", schema: `{"not": {"type": "integer"}}`, value: `1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := Parse([]byte(tt.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ValidateJSON(schema, []byte(tt.value))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateJSON(%s) = %v, want success", tt.value, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateJSON(%s) = %v, want an error containing %q", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	schema := map[string]any{"type": "object"}
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr string
+	}{
+		{name: "compacts", data: "\n  {\"a\": [1, 2],\n \"b\": \"x y\"}\n", want: `{"a":[1,2],"b":"x y"}`},
+		{name: "keeps large integers", data: `{"id": 12345678901234567890}`, want: `{"id":12345678901234567890}`},
+		{name: "not JSON", data: "Sure! Here is the JSON:", wantErr: "response is not valid JSON"},
+		{name: "two values", data: `{} {}`, wantErr: "more than one JSON value"},
+		{name: "schema mismatch", data: `[]`, wantErr: "expected object, got array"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateJSON(schema, []byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Errorf("ValidateJSON = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "schema.json")
+	if err := os.WriteFile(path, []byte(`{"type": "object", "required": ["a"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := Load(path)
+	if err != nil || schema["type"] != "object" {
+		t.Errorf("Load = %v, %v", schema, err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"type": `), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "invalid JSON schema") {
+		t.Errorf("Load(broken) = %v", err)
+	}
+	if _, err := Load(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Load(missing) succeeded")
+	}
+}
@@ -0,0 +1,294 @@
+// Package mcp は、Model Context Protocol (MCP) のサーバーに stdio で接続するクライアントを提供します。
+// サーバーはサブプロセスとして起動し、改行区切りの JSON-RPC 2.0 メッセージで通信します。
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// protocolVersion は、クライアントが要求する MCP のバージョンです。
+const protocolVersion = "2024-11-05"
+
+// closeTimeout は、Close が標準入力を閉じてからサーバーの終了を待つ時間です。
+const closeTimeout = 2 * time.Second
+
+// Server は、起動する MCP サーバーのコマンドを表現する構造体です。
+type Server struct {
+	Command string            // 実行するコマンド
+	Args    []string          // コマンドの引数
+	Env     map[string]string // 追加する環境変数
+	Dir     string            // 作業ディレクトリ（空の場合はカレントディレクトリ）
+}
+
+// Client は、単一の MCP サーバーとの接続を管理する構造体です。
+type Client struct {
+	Name         string       // 設定ファイルでのサーバーの名前
+	Capabilities Capabilities // サーバーが対応している機能
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *tailBuffer
+
+	writeMu sync.Mutex
+	nextID  atomic.Int64
+
+	mu      sync.Mutex
+	pending map[int64]chan *message
+	done    chan struct{}
+	err     error // 接続が終了した理由
+}
+
+// Capabilities は、initialize でサーバーが返した機能の一覧です。
+type Capabilities struct {
+	Tools     *struct{} `json:"tools,omitempty"`
+	Resources *struct{} `json:"resources,omitempty"`
+}
+
+// message は、送受信する JSON-RPC 2.0 のメッセージです。
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError は、JSON-RPC 2.0 のエラーオブジェクトです。
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// Start は、サーバーを起動して初期化のハンドシェイクを行います。
+// ctx は初期化が完了するまでの待ち時間に使用され、起動したプロセスの寿命には影響しません。
+func Start(ctx context.Context, name string, s Server) (*Client, error) {
+	cmd := exec.Command(s.Command, s.Args...)
+	cmd.Dir = s.Dir
+	if len(s.Env) > 0 {
+		cmd.Env = os.Environ()
+		for k, v := range s.Env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
+
+	c := &Client{
+		Name:    name,
+		cmd:     cmd,
+		stderr:  &tailBuffer{max: 4 << 10},
+		pending: map[int64]chan *message{},
+		done:    make(chan struct{}),
+	}
+	cmd.Stderr = c.stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start MCP server %s: %w", name, err)
+	}
+	c.stdin = stdin
+	go c.readLoop(stdout)
+
+	if err := c.initialize(ctx); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to initialize MCP server %s: %w", name, err)
+	}
+	return c, nil
+}
+
+// initialize は、initialize リクエストと initialized 通知を送信します。
+func (c *Client) initialize(ctx context.Context) error {
+	params := map[string]any{
+		"protocolVersion": protocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": "gollm", "version": "0.1.0"},
+	}
+	var result struct {
+		ProtocolVersion string       `json:"protocolVersion"`
+		Capabilities    Capabilities `json:"capabilities"`
+	}
+	if err := c.call(ctx, "initialize", params, &result); err != nil {
+		return err
+	}
+	c.Capabilities = result.Capabilities
+	return c.write(&message{JSONRPC: "2.0", Method: "notifications/initialized"})
+}
+
+// Close は、サーバーの標準入力を閉じて終了を待ちます。
+// 一定時間内に終了しない場合はプロセスを強制終了します。
+func (c *Client) Close() error {
+	c.stdin.Close()
+	select {
+	case <-c.done:
+	case <-time.After(closeTimeout):
+		c.cmd.Process.Kill()
+		<-c.done
+	}
+	return nil
+}
+
+// call は、method のリクエストを送信して応答を待ち、結果を result に読み込みます。
+func (c *Client) call(ctx context.Context, method string, params, result any) error {
+	id := c.nextID.Add(1)
+	ch := make(chan *message, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	rawID, _ := json.Marshal(id)
+	if err := c.write(&message{JSONRPC: "2.0", ID: rawID, Method: method, Params: params}); err != nil {
+		return err
+	}
+
+	var resp *message
+	select {
+	case resp = <-ch:
+	case <-c.done:
+		// 応答の直後にサーバーが終了した場合も、届いた応答を優先します。
+		select {
+		case resp = <-ch:
+		default:
+			return c.err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if resp.Error != nil {
+		return fmt.Errorf("%s: %w", method, resp.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+// write は、メッセージを1行の JSON として送信します。
+func (c *Client) write(m *message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = c.stdin.Write(append(data, '\n'))
+	return err
+}
+
+// readLoop は、サーバーの標準出力からメッセージを読み取り、対応するリクエストに応答を届けます。
+// サーバーからのリクエストには ping にのみ応答し、通知は無視します。
+// 標準出力が閉じられると、プロセスの終了を待って接続を終了します。
+func (c *Client) readLoop(r io.Reader) {
+	br := bufio.NewReader(r)
+	var err error
+	for {
+		var line []byte
+		line, err = br.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			c.dispatch(line)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if errors.Is(err, io.EOF) {
+		err = errors.New("server closed the connection")
+	}
+	// 標準エラー出力の読み込みが終わるのを待ってから、その末尾をエラーに含めます。
+	c.cmd.Wait()
+	if tail := strings.TrimSpace(c.stderr.String()); tail != "" {
+		err = fmt.Errorf("%w: %s", err, tail)
+	}
+	c.mu.Lock()
+	c.err = err
+	c.mu.Unlock()
+	close(c.done)
+}
+
+// dispatch は、受信した1つのメッセージを処理します。
+func (c *Client) dispatch(line []byte) {
+	var m message
+	if err := json.Unmarshal(line, &m); err != nil {
+		return
+	}
+
+	switch {
+	case m.Method != "" && m.ID != nil:
+		reply := &message{JSONRPC: "2.0", ID: m.ID}
+		if m.Method == "ping" {
+			reply.Result = json.RawMessage("{}")
+		} else {
+			reply.Error = &rpcError{Code: -32601, Message: "method not found: " + m.Method}
+		}
+		c.write(reply)
+	case m.Method != "":
+		// 通知は使用しません。
+	default:
+		var id int64
+		if err := json.Unmarshal(m.ID, &id); err != nil {
+			return
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		c.mu.Unlock()
+		if ok {
+			ch <- &m
+		}
+	}
+}
+
+// tailBuffer は、書き込まれたデータの末尾 max バイトだけを保持する io.Writer です。
+// サーバーの標準エラー出力をエラーメッセージに含めるために使用します。
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
@@ -0,0 +1,178 @@
+package mcp
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kou12345/gollm/internal/tools"
+)
+
+// stubServer は、TestMain でビルドしたスタブサーバーのパスです。
+var stubServer string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "gollm-mcp")
+	if err != nil {
+		panic(err)
+	}
+	stubServer = filepath.Join(dir, "stubserver")
+	build := exec.Command("go", "build", "-o", stubServer, "./testdata/stubserver")
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func startStub(t *testing.T, env map[string]string) (*Client, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return Start(ctx, "stub", Server{Command: stubServer, Env: env})
+}
+
+func TestClient(t *testing.T) {
+	c, err := startStub(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	list, err := c.ListTools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "echo" || list[1].Name != "fail" {
+		t.Fatalf("ListTools = %+v, want echo and fail across two pages", list)
+	}
+
+	result, err := c.CallTool(ctx, "echo", map[string]any{"text": "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.IsError || len(result.Content) != 1 || result.Content[0].Text != "hello" {
+		t.Errorf("CallTool(echo) = %+v", result)
+	}
+
+	if _, err := c.CallTool(ctx, "missing", nil); err == nil || !strings.Contains(err.Error(), "unknown tool missing") {
+		t.Errorf("CallTool(missing) error = %v, want the server's error", err)
+	}
+
+	contents, err := c.ReadResource(ctx, "stub://notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 1 || contents[0].Text != "remember the milk" {
+		t.Errorf("ReadResource = %+v", contents)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	c, err := startStub(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	r := tools.NewRegistry()
+	if err := c.Register(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, tool := range r.All() {
+		names = append(names, tool.Name)
+	}
+	want := "stub__echo stub__fail stub__list_resources stub__read_resource"
+	if got := strings.Join(names, " "); got != want {
+		t.Fatalf("registered tools = %s, want %s", got, want)
+	}
+
+	echo, _ := r.Lookup("stub__echo")
+	if echo.SideEffects {
+		t.Error("echo is read-only but was registered with side effects")
+	}
+	out, err := echo.Run(ctx, map[string]any{"text": "hi"})
+	if err != nil || out["content"] != "hi" {
+		t.Errorf("echo.Run = %v, %v", out, err)
+	}
+
+	fail, _ := r.Lookup("stub__fail")
+	if !fail.SideEffects {
+		t.Error("fail has no readOnlyHint but was registered without side effects")
+	}
+	if _, err := fail.Run(ctx, nil); err == nil || err.Error() != "something went wrong" {
+		t.Errorf("fail.Run error = %v", err)
+	}
+
+	read, _ := r.Lookup("stub__read_resource")
+	out, err = read.Run(ctx, map[string]any{"uri": "stub://notes"})
+	if err != nil || out["content"] != "remember the milk" {
+		t.Errorf("read_resource.Run = %v, %v", out, err)
+	}
+}
+
+// TestRegisterInvalidTool は、登録できないツールがある場合に、それより前のツールも登録しないことを確認します。
+func TestRegisterInvalidTool(t *testing.T) {
+	c, err := startStub(t, map[string]string{"STUB_INVALID_TOOL": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	r := tools.NewRegistry()
+	err = c.Register(context.Background(), r)
+	if err == nil || !strings.Contains(err.Error(), `tool "fail"`) {
+		t.Errorf("Register error = %v, want the invalid tool reported", err)
+	}
+	if r.Len() != 0 {
+		t.Errorf("registered %d tools, want none after the error", r.Len())
+	}
+
+	// 名前が既存のツールと重なる場合も、どのツールも登録しません。
+	c, err = startStub(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r = tools.NewRegistry()
+	if err := r.Register(tools.Tool{Name: "stub__fail"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Register(context.Background(), r); err == nil || !strings.Contains(err.Error(), "already registered") {
+		t.Errorf("Register error = %v, want the duplicate reported", err)
+	}
+	if r.Len() != 1 {
+		t.Errorf("registered %d tools, want only the existing one", r.Len())
+	}
+}
+
+func TestStartFailure(t *testing.T) {
+	_, err := startStub(t, map[string]string{"STUB_CRASH": "1"})
+	if err == nil {
+		t.Fatal("Start succeeded with a server that exits immediately")
+	}
+	if !strings.Contains(err.Error(), "crashing on purpose") {
+		t.Errorf("error %q does not include the server's stderr", err)
+	}
+}
+
+func TestToolName(t *testing.T) {
+	c := &Client{Name: "my server"}
+	if got := c.toolName("do/thing"); got != "my_server__do_thing" {
+		t.Errorf("toolName = %q", got)
+	}
+	if got := c.toolName(strings.Repeat("x", 100)); len(got) != maxNameLength {
+		t.Errorf("toolName length = %d, want %d", len(got), maxNameLength)
+	}
+}
@@ -0,0 +1,121 @@
+package mcp
+
+import (
+	"context"
+)
+
+// Tool は、サーバーが提供するツールの定義です。
+type Tool struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	InputSchema map[string]any   `json:"inputSchema"`
+	Annotations *ToolAnnotations `json:"annotations,omitempty"`
+}
+
+// ToolAnnotations は、ツールの振る舞いについてのサーバーからのヒントです。
+type ToolAnnotations struct {
+	ReadOnlyHint bool `json:"readOnlyHint"`
+}
+
+// Content は、ツールの実行結果に含まれる1つの要素です。
+type Content struct {
+	Type     string            `json:"type"` // text, image, audio, resource のいずれか
+	Text     string            `json:"text,omitempty"`
+	MIMEType string            `json:"mimeType,omitempty"`
+	Data     string            `json:"data,omitempty"` // base64 でエンコードされたデータ
+	Resource *ResourceContents `json:"resource,omitempty"`
+}
+
+// CallToolResult は、tools/call の結果です。
+type CallToolResult struct {
+	Content []Content `json:"content"`
+	IsError bool      `json:"isError"`
+}
+
+// Resource は、サーバーが提供するリソースの定義です。
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MIMEType    string `json:"mimeType,omitempty"`
+}
+
+// ResourceContents は、リソースの内容です。Text と Blob のどちらか一方が設定されます。
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MIMEType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"` // base64 でエンコードされたデータ
+}
+
+// ListTools は、サーバーが提供する全てのツールを返します。
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var all []Tool
+	cursor := ""
+	for {
+		var result struct {
+			Tools      []Tool `json:"tools"`
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := c.call(ctx, "tools/list", cursorParams(cursor), &result); err != nil {
+			return nil, err
+		}
+		all = append(all, result.Tools...)
+		if result.NextCursor == "" {
+			return all, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// CallTool は、name のツールを args を引数にして実行します。
+// ツール自体が失敗した場合は、error ではなく IsError が true の結果を返します。
+func (c *Client) CallTool(ctx context.Context, name string, args map[string]any) (*CallToolResult, error) {
+	if args == nil {
+		args = map[string]any{}
+	}
+	var result CallToolResult
+	if err := c.call(ctx, "tools/call", map[string]any{"name": name, "arguments": args}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ListResources は、サーバーが提供する全てのリソースを返します。
+func (c *Client) ListResources(ctx context.Context) ([]Resource, error) {
+	var all []Resource
+	cursor := ""
+	for {
+		var result struct {
+			Resources  []Resource `json:"resources"`
+			NextCursor string     `json:"nextCursor"`
+		}
+		if err := c.call(ctx, "resources/list", cursorParams(cursor), &result); err != nil {
+			return nil, err
+		}
+		all = append(all, result.Resources...)
+		if result.NextCursor == "" {
+			return all, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// ReadResource は、uri のリソースの内容を返します。
+func (c *Client) ReadResource(ctx context.Context, uri string) ([]ResourceContents, error) {
+	var result struct {
+		Contents []ResourceContents `json:"contents"`
+	}
+	if err := c.call(ctx, "resources/read", map[string]any{"uri": uri}, &result); err != nil {
+		return nil, err
+	}
+	return result.Contents, nil
+}
+
+// cursorParams は、ページ送りのためのパラメータを作成します。
+func cursorParams(cursor string) map[string]any {
+	if cursor == "" {
+		return nil
+	}
+	return map[string]any{"cursor": cursor}
+}
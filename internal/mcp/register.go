@@ -0,0 +1,169 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kou12345/gollm/internal/secret"
+	"github.com/kou12345/gollm/internal/tools"
+)
+
+// maxNameLength は、Gemini が受け付ける関数名の長さの上限です。
+const maxNameLength = 64
+
+// Register は、サーバーのツールとリソースを r に登録します。
+// ツールは "<サーバー名>__<ツール名>" という名前で登録され、
+// readOnlyHint が指定されていないツールは副作用があるものとして扱います。
+// サーバーがリソースに対応している場合は、一覧と読み込みのためのツールも登録します。
+// 1つでも登録できないツールがある場合は、どのツールも登録せずにエラーを返します。
+func (c *Client) Register(ctx context.Context, r *tools.Registry) error {
+	var all []tools.Tool
+	if c.Capabilities.Tools != nil {
+		list, err := c.ListTools(ctx)
+		if err != nil {
+			return err
+		}
+		for _, t := range list {
+			tool, err := c.tool(t)
+			if err != nil {
+				return err
+			}
+			all = append(all, tool)
+		}
+	}
+	if c.Capabilities.Resources != nil {
+		all = append(all, c.listResourcesTool(), c.readResourceTool())
+	}
+	return r.RegisterAll(all)
+}
+
+// tool は、サーバーのツールを tools.Tool に変換します。
+// 名前がないツールや、引数の JSON Schema が object 型でないツールはエラーになります。
+func (c *Client) tool(t Tool) (tools.Tool, error) {
+	if t.Name == "" {
+		return tools.Tool{}, errors.New("the server listed a tool without a name")
+	}
+	params := t.InputSchema
+	if params == nil {
+		params = map[string]any{"type": "object"}
+	}
+	if typ, ok := params["type"]; ok && typ != "object" {
+		return tools.Tool{}, fmt.Errorf("tool %q: inputSchema must have type \"object\", not %v", t.Name, typ)
+	}
+	return tools.Tool{
+		Name:        c.toolName(t.Name),
+		Description: fmt.Sprintf("[MCP server %s] %s", c.Name, t.Description),
+		Parameters:  params,
+		SideEffects: t.Annotations == nil || !t.Annotations.ReadOnlyHint,
+		Run: func(ctx context.Context, args map[string]any) (map[string]any, error) {
+			result, err := c.CallTool(ctx, t.Name, args)
+			if err != nil {
+				return nil, err
+			}
+			text := contentText(result.Content)
+			if result.IsError {
+				return nil, errors.New(text)
+			}
+			return map[string]any{"content": text}, nil
+		},
+	}, nil
+}
+
+// listResourcesTool は、サーバーのリソースを一覧表示するツールを作成します。
+func (c *Client) listResourcesTool() tools.Tool {
+	return tools.Tool{
+		Name:        c.toolName("list_resources"),
+		Description: fmt.Sprintf("List the resources (files, documents, records) provided by the MCP server %s.", c.Name),
+		Parameters:  map[string]any{"type": "object"},
+		Run: func(ctx context.Context, args map[string]any) (map[string]any, error) {
+			list, err := c.ListResources(ctx)
+			if err != nil {
+				return nil, err
+			}
+			resources := make([]any, 0, len(list))
+			for _, res := range list {
+				resources = append(resources, map[string]any{
+					"uri":         res.URI,
+					"name":        res.Name,
+					"description": res.Description,
+					"mime_type":   res.MIMEType,
+				})
+			}
+			return map[string]any{"resources": resources}, nil
+		},
+	}
+}
+
+// readResourceTool は、サーバーのリソースを URI で読み込むツールを作成します。
+func (c *Client) readResourceTool() tools.Tool {
+	return tools.Tool{
+		Name:        c.toolName("read_resource"),
+		Description: fmt.Sprintf("Read a resource provided by the MCP server %s by its URI.", c.Name),
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"uri": map[string]any{"type": "string", "description": "URI of the resource, as returned by the list tool."},
+			},
+			"required": []any{"uri"},
+		},
+		Run: func(ctx context.Context, args map[string]any) (map[string]any, error) {
+			uri, _ := args["uri"].(string)
+			if uri == "" {
+				return nil, errors.New(`missing required argument "uri"`)
+			}
+			contents, err := c.ReadResource(ctx, uri)
+			if err != nil {
+				return nil, err
+			}
+			parts := make([]Content, 0, len(contents))
+			for i := range contents {
+				parts = append(parts, Content{Type: "resource", Resource: &contents[i]})
+			}
+			return map[string]any{"content": contentText(parts)}, nil
+		},
+	}
+}
+
+// toolName は、サーバー名とツール名から Gemini が受け付ける関数名を作成します。
+// 英数字・アンダースコア・ドット・ハイフン以外の文字はアンダースコアに置き換えます。
+func (c *Client) toolName(name string) string {
+	full := sanitize(c.Name) + "__" + sanitize(name)
+	if len(full) > maxNameLength {
+		full = full[:maxNameLength]
+	}
+	return full
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// contentText は、ツールやリソースの内容をモデルに返すテキストに変換します。
+// テキスト以外のデータは種類だけを示し、内容は含めません。
+func contentText(contents []Content) string {
+	var b strings.Builder
+	for i, ct := range contents {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		switch {
+		case ct.Type == "text":
+			b.WriteString(ct.Text)
+		case ct.Resource != nil && ct.Resource.Blob == "":
+			b.WriteString(ct.Resource.Text)
+		case ct.Resource != nil:
+			fmt.Fprintf(&b, "[binary resource %s (%s) omitted]", ct.Resource.URI, ct.Resource.MIMEType)
+		default:
+			fmt.Fprintf(&b, "[%s content (%s) omitted]", ct.Type, ct.MIMEType)
+		}
+	}
+	return secret.Redact(b.String())
+}
@@ -0,0 +1,115 @@
+// stubserver は、mcp パッケージのテストで使用する最小限の MCP サーバーです。
+// echo と fail の2つのツールと、1つのテキストリソースを提供します。
+// STUB_INVALID_TOOL を設定すると、fail の引数の JSON Schema を object 型でないものにします。
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   any             `json:"error,omitempty"`
+}
+
+var out = json.NewEncoder(os.Stdout)
+
+func main() {
+	if os.Getenv("STUB_CRASH") != "" {
+		fmt.Fprintln(os.Stderr, "stub: crashing on purpose")
+		os.Exit(1)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var m message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			fmt.Fprintln(os.Stderr, "stub: invalid message:", err)
+			os.Exit(1)
+		}
+		if m.ID == nil {
+			continue
+		}
+		result, rpcErr := handle(m)
+		reply := message{JSONRPC: "2.0", ID: m.ID, Result: result}
+		if rpcErr != nil {
+			reply = message{JSONRPC: "2.0", ID: m.ID, Error: rpcErr}
+		}
+		out.Encode(reply)
+	}
+}
+
+func handle(m message) (any, any) {
+	switch m.Method {
+	case "initialize":
+		return map[string]any{
+			"protocolVersion": "2024-11-05",
+			"capabilities":    map[string]any{"tools": map[string]any{}, "resources": map[string]any{}},
+			"serverInfo":      map[string]any{"name": "stub", "version": "1.0.0"},
+		}, nil
+	case "tools/list":
+		// クライアントが通知とサーバーからのリクエストを処理できることを確認するために、応答の前に送信します。
+		out.Encode(message{JSONRPC: "2.0", Method: "notifications/message", Params: json.RawMessage(`{"level":"info","data":"listing"}`)})
+		out.Encode(message{JSONRPC: "2.0", ID: json.RawMessage(`"ping-1"`), Method: "ping"})
+
+		var params struct {
+			Cursor string `json:"cursor"`
+		}
+		json.Unmarshal(m.Params, &params)
+		if params.Cursor == "" {
+			return map[string]any{
+				"tools": []any{map[string]any{
+					"name":        "echo",
+					"description": "Echo the given text.",
+					"inputSchema": map[string]any{
+						"type":       "object",
+						"properties": map[string]any{"text": map[string]any{"type": "string"}},
+						"required":   []any{"text"},
+					},
+					"annotations": map[string]any{"readOnlyHint": true},
+				}},
+				"nextCursor": "page-2",
+			}, nil
+		}
+		schema := map[string]any{"type": "object"}
+		if os.Getenv("STUB_INVALID_TOOL") != "" {
+			schema = map[string]any{"type": "string"}
+		}
+		return map[string]any{
+			"tools": []any{map[string]any{
+				"name":        "fail",
+				"description": "Always fails.",
+				"inputSchema": schema,
+			}},
+		}, nil
+	case "tools/call":
+		var params struct {
+			Name      string         `json:"name"`
+			Arguments map[string]any `json:"arguments"`
+		}
+		json.Unmarshal(m.Params, &params)
+		switch params.Name {
+		case "echo":
+			return map[string]any{"content": []any{map[string]any{"type": "text", "text": fmt.Sprint(params.Arguments["text"])}}}, nil
+		case "fail":
+			return map[string]any{"content": []any{map[string]any{"type": "text", "text": "something went wrong"}}, "isError": true}, nil
+		}
+		return nil, map[string]any{"code": -32602, "message": "unknown tool " + params.Name}
+	case "resources/list":
+		return map[string]any{
+			"resources": []any{map[string]any{"uri": "stub://notes", "name": "notes", "mimeType": "text/plain"}},
+		}, nil
+	case "resources/read":
+		return map[string]any{
+			"contents": []any{map[string]any{"uri": "stub://notes", "mimeType": "text/plain", "text": "remember the milk"}},
+		}, nil
+	}
+	return nil, map[string]any{"code": -32601, "message": "method not found"}
+}
@@ -0,0 +1,66 @@
+// Package project は、作業ディレクトリが属するプロジェクト（git リポジトリ）を判定します。
+// チャットルームはプロジェクトのルートのパスで分けて保存されます。
+package project
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Project は、git リポジトリを単位とするプロジェクトです。
+type Project struct {
+	Root string // リポジトリのルートの絶対パス
+}
+
+// Name は、表示に使用するプロジェクトの名前（ルートのディレクトリ名）を返します。
+func (p Project) Name() string {
+	return filepath.Base(p.Root)
+}
+
+// Detect は、dir から親のディレクトリへ順に .git を探し、見つかったリポジトリのプロジェクトを返します。
+// ワークツリーやサブモジュールの .git ファイルも、リポジトリのルートとして扱います。
+// リポジトリの外の場合は false を返します。
+func Detect(dir string) (Project, bool, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return Project{}, false, err
+	}
+	for {
+		_, err := os.Stat(filepath.Join(dir, ".git"))
+		if err == nil {
+			return Project{Root: dir}, true, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return Project{}, false, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return Project{}, false, nil
+		}
+		dir = parent
+	}
+}
+
+// ReadContext は、プロジェクトのルートにある name のファイル（GOLLM.md など）を読み込みます。
+// ファイルが存在しない場合は空文字列を返します。limit バイトを超えるファイルはエラーになります。
+func (p Project) ReadContext(name string, limit int64) (string, error) {
+	path := filepath.Join(p.Root, name)
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if limit > 0 && info.Size() > limit {
+		return "", fmt.Errorf("%s is larger than %d bytes", path, limit)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
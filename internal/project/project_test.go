@@ -0,0 +1,68 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "cmd", "tool")
+	if err := os.MkdirAll(filepath.Join(root, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	p, ok, err := Detect(sub)
+	if err != nil || !ok || p.Root != root {
+		t.Fatalf("Detect = %+v, %v, %v, want the repository root %s", p, ok, err, root)
+	}
+	if p.Name() != filepath.Base(root) {
+		t.Errorf("Name = %q", p.Name())
+	}
+
+	// ワークツリーの .git はファイルです。
+	worktree := filepath.Join(root, "worktree")
+	if err := os.MkdirAll(worktree, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(worktree, ".git"), []byte("gitdir: ../.git/worktrees/w\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok, _ := Detect(worktree); !ok || p.Root != worktree {
+		t.Errorf("Detect(worktree) = %+v, %v", p, ok)
+	}
+}
+
+func TestDetectOutsideRepository(t *testing.T) {
+	dir := t.TempDir()
+	p, ok, err := Detect(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 一時ディレクトリの親がリポジトリの場合もあるため、dir 自身がルートにならないことだけを確認します。
+	if ok && p.Root == dir {
+		t.Errorf("Detect = %+v, want no repository at %s", p, dir)
+	}
+}
+
+func TestReadContext(t *testing.T) {
+	p := Project{Root: t.TempDir()}
+	if text, err := p.ReadContext("GOLLM.md", 0); err != nil || text != "" {
+		t.Errorf("missing file: %q, %v", text, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(p.Root, "GOLLM.md"), []byte("Use tabs."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if text, err := p.ReadContext("GOLLM.md", 1024); err != nil || text != "Use tabs." {
+		t.Errorf("ReadContext = %q, %v", text, err)
+	}
+	if _, err := p.ReadContext("GOLLM.md", 4); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("err = %v, want a size error", err)
+	}
+}
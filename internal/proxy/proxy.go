@@ -0,0 +1,405 @@
+// Package proxy は、OpenAI の Chat Completions API と互換性のある HTTP API を提供します。
+// OpenAI の API にしか対応していないツールから、gollm に設定したバックエンドを使用できます。
+//
+//	GET  /v1/models            使用できるモデルの一覧
+//	POST /v1/chat/completions  応答の生成（"stream": true で SSE）
+//
+// リクエストには Authorization: Bearer <token> ヘッダーが必要です。
+// OpenAI のクライアントでは、API キーとしてトークンを設定します。
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kou12345/gollm/internal/chat"
+	"github.com/kou12345/gollm/internal/history"
+	"github.com/kou12345/gollm/internal/secret"
+)
+
+// Backend は、会話を送信して応答を得る処理です。*chat.Chat が実装します。
+type Backend interface {
+	Exchange(ctx context.Context, req chat.Request, onText func(string)) (*chat.Response, error)
+}
+
+// Options は、Server の動作を設定する構造体です。
+type Options struct {
+	Backend Backend
+	Token   string   // リクエストの認証に使用するトークン（空の場合は全てのリクエストを拒否する）
+	Models  []string // /v1/models で返すモデル名（先頭はモデルを指定しないリクエストで使用する）
+}
+
+// Server は、OpenAI 互換のリクエストを処理する http.Handler です。
+type Server struct {
+	opts Options
+}
+
+// New は、o の設定で Server を作成します。
+func New(o Options) *Server {
+	return &Server{opts: o}
+}
+
+// ServeHTTP は、リクエストを認証してパスに対応する処理を呼び出します。
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || s.opts.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Token)) != 1 {
+		writeError(w, http.StatusUnauthorized, &apiError{Message: "invalid API key", Type: "invalid_request_error", Code: "invalid_api_key"})
+		return
+	}
+
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/v1/models":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		s.listModels(w)
+	case "/v1/chat/completions":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		s.completions(w, r)
+	default:
+		writeError(w, http.StatusNotFound, &apiError{Message: "unknown endpoint " + r.URL.Path, Type: "invalid_request_error"})
+	}
+}
+
+// listModels は、設定されたモデルを OpenAI の形式で返します。
+func (s *Server) listModels(w http.ResponseWriter) {
+	type model struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		Created int64  `json:"created"`
+		OwnedBy string `json:"owned_by"`
+	}
+	data := make([]model, 0, len(s.opts.Models))
+	for _, name := range s.opts.Models {
+		data = append(data, model{ID: name, Object: "model", OwnedBy: "gollm"})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"object": "list", "data": data})
+}
+
+// completions は、Chat Completions のリクエストをバックエンドに送信し、応答を OpenAI の形式で返します。
+func (s *Server) completions(w http.ResponseWriter, r *http.Request) {
+	var body completionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, invalidRequest("invalid request body: "+err.Error()))
+		return
+	}
+	req, err := s.toRequest(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, invalidRequest(err.Error()))
+		return
+	}
+
+	id := completionID()
+	created := time.Now().Unix()
+	if !body.Stream {
+		resp, err := s.opts.Backend.Exchange(r.Context(), req, nil)
+		if err != nil {
+			status, e := backendError(err)
+			writeError(w, status, e)
+			return
+		}
+		writeJSON(w, http.StatusOK, completion{
+			ID:      id,
+			Object:  "chat.completion",
+			Created: created,
+			Model:   req.Model,
+			Choices: []choice{{
+				Message:      &delta{Role: "assistant", Content: resp.Text},
+				FinishReason: finishReason(resp.FinishReason),
+			}},
+			Usage: toUsage(resp.Usage),
+		})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, &apiError{Message: "streaming is not supported", Type: "api_error"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// 最初のテキストを受信するまでヘッダーを送らず、送信前のエラーは通常のエラー応答として返します。
+	started := false
+	send := func(v any) {
+		if !started {
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		data, _ := json.Marshal(v)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	chunk := func(d delta, finish *string) completion {
+		return completion{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   req.Model,
+			Choices: []choice{{Delta: &d, FinishReason: finish}},
+		}
+	}
+
+	resp, err := s.opts.Backend.Exchange(r.Context(), req, func(text string) {
+		if !started {
+			send(chunk(delta{Role: "assistant"}, nil))
+		}
+		send(chunk(delta{Content: text}, nil))
+	})
+	if err != nil {
+		status, e := backendError(err)
+		if !started {
+			writeError(w, status, e)
+			return
+		}
+		send(map[string]*apiError{"error": e})
+		return
+	}
+	send(chunk(delta{}, finishReason(resp.FinishReason)))
+	if body.StreamOptions != nil && body.StreamOptions.IncludeUsage {
+		c := chunk(delta{}, nil)
+		c.Choices = []choice{}
+		c.Usage = toUsage(resp.Usage)
+		send(c)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// toRequest は、OpenAI のリクエストをバックエンドに送信する会話に変換します。
+// system と developer のメッセージはシステム指示にまとめます。
+func (s *Server) toRequest(body completionRequest) (chat.Request, error) {
+	req := chat.Request{
+		Model:       body.Model,
+		Temperature: body.Temperature,
+		TopP:        body.TopP,
+		MaxTokens:   body.MaxTokens,
+		Stop:        body.Stop,
+	}
+	if body.MaxCompletionTokens != nil {
+		req.MaxTokens = body.MaxCompletionTokens
+	}
+	if req.Model == "" && len(s.opts.Models) > 0 {
+		req.Model = s.opts.Models[0]
+	}
+	if body.N != nil && *body.N != 1 {
+		return req, errors.New("only n=1 is supported")
+	}
+	if len(body.Tools) > 0 || len(body.Functions) > 0 {
+		return req, errors.New("tools are not supported by the proxy")
+	}
+	if f := body.ResponseFormat; f != nil {
+		switch f.Type {
+		case "text":
+		case "json_object":
+			req.JSON = true
+		default:
+			return req, fmt.Errorf("response_format %q is not supported", f.Type)
+		}
+	}
+
+	var system []string
+	for i, m := range body.Messages {
+		switch m.Role {
+		case "system", "developer":
+			system = append(system, string(m.Content))
+		case "user", "assistant":
+			req.Messages = append(req.Messages, history.ChatMessage{Role: m.Role, Content: string(m.Content)})
+		default:
+			return req, fmt.Errorf("messages[%d]: role %q is not supported", i, m.Role)
+		}
+	}
+	if len(req.Messages) == 0 || req.Messages[len(req.Messages)-1].Role != "user" {
+		return req, errors.New("the last message must be from the user")
+	}
+	req.System = strings.Join(system, "\n\n")
+	return req, nil
+}
+
+// completionRequest は、Chat Completions のリクエストのうち、プロキシが扱う項目です。
+type completionRequest struct {
+	Model         string    `json:"model"`
+	Messages      []message `json:"messages"`
+	Stream        bool      `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	Temperature         *float32 `json:"temperature"`
+	TopP                *float32 `json:"top_p"`
+	MaxTokens           *int32   `json:"max_tokens"`
+	MaxCompletionTokens *int32   `json:"max_completion_tokens"`
+	Stop                stopList `json:"stop"`
+	N                   *int     `json:"n"`
+	ResponseFormat      *struct {
+		Type string `json:"type"`
+	} `json:"response_format"`
+	Tools     []json.RawMessage `json:"tools"`
+	Functions []json.RawMessage `json:"functions"`
+}
+
+// message は、リクエストに含まれる1つのメッセージです。
+type message struct {
+	Role    string  `json:"role"`
+	Content content `json:"content"`
+}
+
+// content は、文字列か、テキストの部分の配列で表されたメッセージの内容です。
+type content string
+
+func (c *content) UnmarshalJSON(data []byte) error {
+	var s *string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if s != nil {
+			*c = content(*s)
+		}
+		return nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return errors.New("content must be a string or an array of content parts")
+	}
+	var texts []string
+	for _, p := range parts {
+		if p.Type != "text" {
+			return fmt.Errorf("content part type %q is not supported", p.Type)
+		}
+		texts = append(texts, p.Text)
+	}
+	*c = content(strings.Join(texts, "\n"))
+	return nil
+}
+
+// stopList は、文字列か文字列の配列で表された停止シーケンスです。
+type stopList []string
+
+func (l *stopList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = stopList{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(l))
+}
+
+// completion は、Chat Completions の応答とストリーミングのチャンクです。
+type completion struct {
+	ID      string   `json:"id"`
+	Object  string   `json:"object"`
+	Created int64    `json:"created"`
+	Model   string   `json:"model"`
+	Choices []choice `json:"choices"`
+	Usage   *usage   `json:"usage,omitempty"`
+}
+
+type choice struct {
+	Index        int     `json:"index"`
+	Message      *delta  `json:"message,omitempty"`
+	Delta        *delta  `json:"delta,omitempty"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+type delta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type usage struct {
+	PromptTokens     int32 `json:"prompt_tokens"`
+	CompletionTokens int32 `json:"completion_tokens"`
+	TotalTokens      int32 `json:"total_tokens"`
+}
+
+func toUsage(u history.Usage) *usage {
+	return &usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.PromptTokens + u.CompletionTokens,
+	}
+}
+
+// finishReason は、バックエンドの終了理由を OpenAI の finish_reason に変換します。
+func finishReason(reason string) *string {
+	r := "stop"
+	switch reason {
+	case "MaxTokens":
+		r = "length"
+	case "Safety", "Recitation", "Blocklist", "ProhibitedContent", "Spii":
+		r = "content_filter"
+	}
+	return &r
+}
+
+// completionID は、応答の ID を作成します。
+func completionID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return "chatcmpl-" + hex.EncodeToString(buf)
+}
+
+// apiError は、OpenAI の形式のエラーです。
+type apiError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
+func invalidRequest(msg string) *apiError {
+	return &apiError{Message: msg, Type: "invalid_request_error"}
+}
+
+// backendError は、バックエンドのエラーを HTTP ステータスと OpenAI の形式のエラーに変換します。
+func backendError(err error) (int, *apiError) {
+	e := &apiError{Message: secret.Redact(err.Error()), Type: "api_error"}
+	var be *chat.BackendError
+	if !errors.As(err, &be) {
+		return http.StatusBadGateway, e
+	}
+	e.Code = string(be.Kind)
+	switch be.Kind {
+	case chat.ErrorRateLimit:
+		e.Type = "rate_limit_error"
+		return http.StatusTooManyRequests, e
+	case chat.ErrorQuota:
+		e.Type = "insufficient_quota"
+		return http.StatusTooManyRequests, e
+	case chat.ErrorSafety, chat.ErrorRequest:
+		e.Type = "invalid_request_error"
+		return http.StatusBadRequest, e
+	case chat.ErrorNetwork:
+		return http.StatusGatewayTimeout, e
+	}
+	return http.StatusBadGateway, e
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, invalidRequest("method not allowed"))
+}
+
+func writeError(w http.ResponseWriter, status int, e *apiError) {
+	e.Message = secret.Redact(e.Message)
+	writeJSON(w, status, map[string]*apiError{"error": e})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
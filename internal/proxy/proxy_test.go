@@ -0,0 +1,389 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kou12345/gollm/internal/chat"
+	"github.com/kou12345/gollm/internal/history"
+)
+
+const testToken = "secret-token"
+
+// fakeBackend は、最後のメッセージを "echo: " に続けて返す Backend です。
+type fakeBackend struct {
+	finish    string // 応答の終了理由（空の場合は Stop）
+	err       error  // 応答の代わりに返すエラー
+	failAfter int    // err を返す前に onText に渡す断片の数
+}
+
+func (b *fakeBackend) Exchange(ctx context.Context, req chat.Request, onText func(string)) (*chat.Response, error) {
+	last := req.Messages[len(req.Messages)-1].Content
+	chunks := strings.SplitAfter("echo: "+last, " ")
+	for i, chunk := range chunks {
+		if b.err != nil && i == b.failAfter {
+			return nil, b.err
+		}
+		if onText != nil {
+			onText(chunk)
+		}
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	finish := b.finish
+	if finish == "" {
+		finish = "Stop"
+	}
+	return &chat.Response{
+		Text:         strings.Join(chunks, ""),
+		FinishReason: finish,
+		Usage:        history.Usage{Model: req.Model, PromptTokens: 3, CompletionTokens: 2},
+	}, nil
+}
+
+// newTestServer は、backend にリクエストを転送するプロキシを起動します。
+func newTestServer(t *testing.T, backend Backend) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(New(Options{Backend: backend, Token: testToken, Models: []string{"gemini-test", "gemini-other"}}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// do は、トークンを付けてリクエストを送信します。
+func do(t *testing.T, srv *httptest.Server, method, path, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// decode は、レスポンスのステータスコードを確認して JSON の本文を v に読み込みます。
+func decode(t *testing.T, resp *http.Response, status int, v any) {
+	t.Helper()
+	if resp.StatusCode != status {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status = %d, want %d: %s", resp.StatusCode, status, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// errorBody は、OpenAI の形式のエラー応答です。
+type errorBody struct {
+	Error apiError `json:"error"`
+}
+
+// readEvents は、SSE の data 行を順に返します。
+func readEvents(t *testing.T, resp *http.Response) []string {
+	t.Helper()
+	var events []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestAuthAndRoutes(t *testing.T) {
+	srv := newTestServer(t, &fakeBackend{})
+
+	for _, header := range []string{"", "Bearer wrong", testToken} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/models", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body errorBody
+		decode(t, resp, http.StatusUnauthorized, &body)
+		resp.Body.Close()
+		if body.Error.Code != "invalid_api_key" {
+			t.Errorf("Authorization %q: error = %+v", header, body.Error)
+		}
+	}
+
+	var models struct {
+		Object string
+		Data   []struct{ ID, Object string }
+	}
+	decode(t, do(t, srv, http.MethodGet, "/v1/models/", ""), http.StatusOK, &models)
+	if models.Object != "list" || len(models.Data) != 2 || models.Data[0].ID != "gemini-test" || models.Data[0].Object != "model" {
+		t.Errorf("models = %+v", models)
+	}
+
+	resp := do(t, srv, http.MethodGet, "/v1/chat/completions", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != http.MethodPost {
+		t.Errorf("GET completions: status = %d, Allow = %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	var body errorBody
+	decode(t, do(t, srv, http.MethodGet, "/v1/embeddings", ""), http.StatusNotFound, &body)
+}
+
+func TestCompletion(t *testing.T) {
+	srv := newTestServer(t, &fakeBackend{finish: "MaxTokens"})
+
+	var c completion
+	decode(t, do(t, srv, http.MethodPost, "/v1/chat/completions", `{"messages":[{"role":"user","content":"hi there"}]}`), http.StatusOK, &c)
+	if !strings.HasPrefix(c.ID, "chatcmpl-") || c.Object != "chat.completion" || c.Model != "gemini-test" || c.Created == 0 {
+		t.Errorf("completion = %+v", c)
+	}
+	if len(c.Choices) != 1 || c.Choices[0].Message == nil || c.Choices[0].Delta != nil {
+		t.Fatalf("choices = %+v", c.Choices)
+	}
+	if m := c.Choices[0].Message; m.Role != "assistant" || m.Content != "echo: hi there" {
+		t.Errorf("message = %+v", m)
+	}
+	if f := c.Choices[0].FinishReason; f == nil || *f != "length" {
+		t.Errorf("finish_reason = %v, want length", f)
+	}
+	if c.Usage == nil || *c.Usage != (usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5}) {
+		t.Errorf("usage = %+v", c.Usage)
+	}
+
+	var body errorBody
+	decode(t, do(t, srv, http.MethodPost, "/v1/chat/completions", `{"messages":`), http.StatusBadRequest, &body)
+	if body.Error.Type != "invalid_request_error" || !strings.Contains(body.Error.Message, "invalid request body") {
+		t.Errorf("error = %+v", body.Error)
+	}
+	decode(t, do(t, srv, http.MethodPost, "/v1/chat/completions", `{"messages":[{"role":"user","content":"hi"}],"n":2}`), http.StatusBadRequest, &body)
+	if body.Error.Message != "only n=1 is supported" {
+		t.Errorf("error = %+v", body.Error)
+	}
+}
+
+func TestCompletionStream(t *testing.T) {
+	srv := newTestServer(t, &fakeBackend{})
+
+	for _, includeUsage := range []bool{false, true} {
+		body := `{"model":"gemini-other","messages":[{"role":"user","content":"one two"}],"stream":true}`
+		if includeUsage {
+			body = strings.Replace(body, `"stream":true`, `"stream":true,"stream_options":{"include_usage":true}`, 1)
+		}
+		resp := do(t, srv, http.MethodPost, "/v1/chat/completions", body)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("status = %d, Content-Type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		events := readEvents(t, resp)
+		if len(events) == 0 || events[len(events)-1] != "[DONE]" {
+			t.Fatalf("events = %q, want [DONE] last", events)
+		}
+
+		var (
+			chunks []completion
+			raw    []map[string]any
+		)
+		for _, data := range events[:len(events)-1] {
+			var c completion
+			var m map[string]any
+			if err := json.Unmarshal([]byte(data), &c); err != nil {
+				t.Fatal(err)
+			}
+			json.Unmarshal([]byte(data), &m)
+			chunks, raw = append(chunks, c), append(raw, m)
+		}
+		for _, c := range chunks {
+			if c.ID != chunks[0].ID || c.Object != "chat.completion.chunk" || c.Model != "gemini-other" {
+				t.Errorf("chunk = %+v, want the same id, object and model in every chunk", c)
+			}
+		}
+
+		// 役割だけの最初のチャンク、テキストのチャンク、終了理由のチャンクの順に送ります。
+		first := chunks[0].Choices[0]
+		if first.Delta == nil || first.Delta.Role != "assistant" || first.Delta.Content != "" || first.FinishReason != nil {
+			t.Errorf("first chunk = %+v", first)
+		}
+		var text string
+		n := len(chunks)
+		if includeUsage {
+			n--
+		}
+		for _, c := range chunks[1 : n-1] {
+			if c.Choices[0].FinishReason != nil || c.Usage != nil {
+				t.Errorf("content chunk = %+v", c)
+			}
+			text += c.Choices[0].Delta.Content
+		}
+		if text != "echo: one two" {
+			t.Errorf("streamed text = %q", text)
+		}
+		final := chunks[n-1].Choices[0]
+		if final.FinishReason == nil || *final.FinishReason != "stop" || *final.Delta != (delta{}) {
+			t.Errorf("final chunk = %+v", final)
+		}
+		if _, ok := raw[n-1]["usage"]; ok {
+			t.Errorf("final chunk has usage: %v", raw[n-1])
+		}
+
+		// include_usage を指定した場合だけ、choices が空の使用量のチャンクを最後に送ります。
+		if includeUsage {
+			u := chunks[n]
+			if choices, ok := raw[n]["choices"].([]any); !ok || len(choices) != 0 {
+				t.Errorf("usage chunk choices = %v, want an empty array", raw[n]["choices"])
+			}
+			if u.Usage == nil || u.Usage.TotalTokens != 5 {
+				t.Errorf("usage chunk = %+v", u)
+			}
+		}
+	}
+}
+
+func TestCompletionBackendError(t *testing.T) {
+	rateLimit := &chat.BackendError{Kind: chat.ErrorRateLimit, Err: errors.New("too many requests")}
+	tests := []struct {
+		name     string
+		backend  *fakeBackend
+		status   int
+		wantType string
+		wantCode string
+	}{
+		{"rate limit", &fakeBackend{err: rateLimit}, http.StatusTooManyRequests, "rate_limit_error", "rate_limit"},
+		{"quota", &fakeBackend{err: &chat.BackendError{Kind: chat.ErrorQuota}}, http.StatusTooManyRequests, "insufficient_quota", "quota"},
+		{"safety", &fakeBackend{err: &chat.BackendError{Kind: chat.ErrorSafety}}, http.StatusBadRequest, "invalid_request_error", "safety"},
+		{"network", &fakeBackend{err: &chat.BackendError{Kind: chat.ErrorNetwork}}, http.StatusGatewayTimeout, "api_error", "network"},
+		{"unclassified", &fakeBackend{err: errors.New("boom")}, http.StatusBadGateway, "api_error", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, tt.backend)
+			// 最初のテキストより前のエラーは、ストリーミングでも通常のエラー応答として返します。
+			for _, stream := range []string{"false", "true"} {
+				resp := do(t, srv, http.MethodPost, "/v1/chat/completions", `{"messages":[{"role":"user","content":"hi"}],"stream":`+stream+`}`)
+				var body errorBody
+				decode(t, resp, tt.status, &body)
+				if resp.Header.Get("Content-Type") != "application/json" || body.Error.Type != tt.wantType || body.Error.Code != tt.wantCode {
+					t.Errorf("stream=%s: Content-Type = %q, error = %+v", stream, resp.Header.Get("Content-Type"), body.Error)
+				}
+			}
+		})
+	}
+
+	// テキストを送り始めた後のエラーは、エラーのイベントで伝え、[DONE] を送りません。
+	srv := newTestServer(t, &fakeBackend{err: rateLimit, failAfter: 1})
+	resp := do(t, srv, http.MethodPost, "/v1/chat/completions", `{"messages":[{"role":"user","content":"one two"}],"stream":true}`)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, Content-Type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	events := readEvents(t, resp)
+	if len(events) != 3 {
+		t.Fatalf("events = %q, want the role, one chunk and the error", events)
+	}
+	var body errorBody
+	if err := json.Unmarshal([]byte(events[2]), &body); err != nil || body.Error.Code != "rate_limit" {
+		t.Errorf("last event = %s, want the rate limit error", events[2])
+	}
+}
+
+func TestToRequest(t *testing.T) {
+	s := New(Options{Models: []string{"gemini-test"}})
+	tests := []struct {
+		name    string
+		body    string
+		want    chat.Request
+		wantErr string
+	}{
+		{
+			name: "system and developer messages",
+			body: `{"messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"hi"},{"role":"assistant","content":"hello"},{"role":"developer","content":"Use English."},{"role":"user","content":"bye"}]}`,
+			want: chat.Request{Model: "gemini-test", System: "Be brief.\n\nUse English.", Messages: []history.ChatMessage{
+				{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}, {Role: "user", Content: "bye"},
+			}},
+		},
+		{
+			name: "content parts",
+			body: `{"model":"gemini-other","messages":[{"role":"user","content":[{"type":"text","text":"line 1"},{"type":"text","text":"line 2"}]}]}`,
+			want: chat.Request{Model: "gemini-other", Messages: []history.ChatMessage{{Role: "user", Content: "line 1\nline 2"}}},
+		},
+		{
+			name: "options",
+			body: `{"messages":[{"role":"user","content":"hi"}],"n":1,"max_tokens":10,"max_completion_tokens":20,"stop":"END","response_format":{"type":"json_object"}}`,
+			want: chat.Request{Model: "gemini-test", Messages: []history.ChatMessage{{Role: "user", Content: "hi"}}, MaxTokens: ptr[int32](20), Stop: []string{"END"}, JSON: true},
+		},
+		{name: "n", body: `{"messages":[{"role":"user","content":"hi"}],"n":3}`, wantErr: "only n=1 is supported"},
+		{name: "tools", body: `{"messages":[{"role":"user","content":"hi"}],"tools":[{"type":"function"}]}`, wantErr: "tools are not supported"},
+		{name: "functions", body: `{"messages":[{"role":"user","content":"hi"}],"functions":[{"name":"f"}]}`, wantErr: "tools are not supported"},
+		{name: "tool role", body: `{"messages":[{"role":"user","content":"hi"},{"role":"tool","content":"42"}]}`, wantErr: `messages[1]: role "tool" is not supported`},
+		{name: "last message from the assistant", body: `{"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]}`, wantErr: "the last message must be from the user"},
+		{name: "no messages", body: `{"messages":[]}`, wantErr: "the last message must be from the user"},
+		{name: "response format", body: `{"messages":[{"role":"user","content":"hi"}],"response_format":{"type":"json_schema"}}`, wantErr: `response_format "json_schema" is not supported`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body completionRequest
+			if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+				t.Fatal(err)
+			}
+			req, err := s.toRequest(body)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(req)
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("request = %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestContent(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    string
+		wantErr string
+	}{
+		{data: `"hello"`, want: "hello"},
+		{data: `null`, want: ""},
+		{data: `[{"type":"text","text":"a"},{"type":"text","text":"b"}]`, want: "a\nb"},
+		{data: `[]`, want: ""},
+		{data: `[{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]`, wantErr: `content part type "image_url" is not supported`},
+		{data: `42`, wantErr: "content must be a string or an array of content parts"},
+	}
+	for _, tt := range tests {
+		var c content
+		err := json.Unmarshal([]byte(tt.data), &c)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tt.data, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || string(c) != tt.want {
+			t.Errorf("%s: content = %q, %v, want %q", tt.data, c, err, tt.want)
+		}
+	}
+}
+
+// ptr は、v へのポインターを返します。
+func ptr[T any](v T) *T {
+	return &v
+}
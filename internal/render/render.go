@@ -7,31 +7,61 @@ import (
 	"github.com/charmbracelet/glamour"
 )
 
+// defaultWidth は、RenderMarkdown が折り返す桁数です。
+const defaultWidth = 100
+
 var (
-	renderer *glamour.TermRenderer
-	once     sync.Once
+	mu        sync.Mutex
+	renderers = map[int]*glamour.TermRenderer{}
+	style     = "auto"
 )
 
-// getRendererは、シングルトンパターンを使用してTermRendererを返します。
-// 初回呼び出し時にのみ新しいTermRendererを作成し、以降の呼び出しでは同じインスタンスを返します。
-func getRenderer() *glamour.TermRenderer {
-	once.Do(func() {
-		var err error
-		renderer, err = glamour.NewTermRenderer(
-			glamour.WithAutoStyle(),
-			glamour.WithWordWrap(100),
-		)
-		if err != nil {
-			panic(err)
-		}
-	})
-	return renderer
+// SetStyleは、Markdownの描画に使用するglamourのスタイル名（auto, dark, light, nottyなど）を設定します。
+// 最初のRenderMarkdown呼び出しより前に呼び出す必要があります。
+func SetStyle(name string) {
+	if name != "" {
+		style = name
+	}
+}
+
+// getRendererは、width 桁で折り返すTermRendererを返します。
+// 桁数ごとに初回呼び出し時にのみ新しいTermRendererを作成し、以降の呼び出しでは同じインスタンスを返します。
+// スタイル名が不明な場合などはエラーを返します。
+func getRenderer(width int) (*glamour.TermRenderer, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if r, ok := renderers[width]; ok {
+		return r, nil
+	}
+
+	styleOpt := glamour.WithAutoStyle()
+	if style != "auto" {
+		styleOpt = glamour.WithStandardStyle(style)
+	}
+	r, err := glamour.NewTermRenderer(
+		styleOpt,
+		glamour.WithWordWrap(width),
+	)
+	if err != nil {
+		return nil, err
+	}
+	renderers[width] = r
+	return r, nil
 }
 
 // RenderMarkdownは、指定されたMarkdown文字列をANSIカラーコードを使用してレンダリングします。
 // レンダリングに成功した場合は装飾されたテキストを、エラーが発生した場合は元のMarkdown文字列をそのまま返します。
 func RenderMarkdown(md string) string {
-	r := getRenderer()
+	return RenderMarkdownWidth(md, defaultWidth)
+}
+
+// RenderMarkdownWidthは、RenderMarkdownと同様にレンダリングしますが、width 桁で折り返します。
+// 複数の応答を横に並べて表示する場合など、表示幅が限られている場合に使用します。
+func RenderMarkdownWidth(md string, width int) string {
+	r, err := getRenderer(width)
+	if err != nil {
+		return md
+	}
 	out, err := r.Render(md)
 	if err != nil {
 		return md
@@ -0,0 +1,96 @@
+// Package secret は、APIキーなどの秘密情報をコマンド・ファイル・環境変数から取得し、
+// 取得した値が履歴やログに書き出されないように伏せ字にする機能を提供します。
+package secret
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// mask は、伏せ字にした秘密情報の代わりに表示される文字列です。
+const mask = "[REDACTED]"
+
+var (
+	mu    sync.RWMutex
+	known []string
+)
+
+// Register は、s を伏せ字の対象として登録します。
+// 取得した秘密情報は、使用する前に必ず登録してください。
+func Register(s string) {
+	if s == "" {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	known = append(known, s)
+}
+
+// Redact は、登録済みの秘密情報を s から取り除いた文字列を返します。
+func Redact(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, k := range known {
+		s = strings.ReplaceAll(s, k, mask)
+	}
+	return s
+}
+
+// FromEnv は、環境変数 name の値を返します。未設定の場合は空文字列を返します。
+func FromEnv(name string) string {
+	return strings.TrimSpace(os.Getenv(name))
+}
+
+// FromFile は、path に保存された秘密情報を読み込みます。
+// Unix 系の OS では、グループやその他のユーザーが読み書きできるファイルは拒否します。
+func FromFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("secret file %s is accessible by other users (mode %04o); run `chmod 600 %s`", path, info.Mode().Perm(), path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return firstLine(data), nil
+}
+
+// FromCommand は、cmdline をシェル経由で実行し、標準出力の最初の行を秘密情報として返します。
+// 標準エラー出力と標準入力はパスフレーズの入力などのために端末へ接続されます。
+// エラーメッセージにはコマンドの出力を含めません。
+func FromCommand(cmdline string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", cmdline)
+	} else {
+		cmd = exec.Command("sh", "-c", cmdline)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("secret command %q failed: %w", cmdline, err)
+	}
+
+	s := firstLine(out)
+	if s == "" {
+		return "", fmt.Errorf("secret command %q printed nothing", cmdline)
+	}
+	return s, nil
+}
+
+// firstLine は、data の最初の行を前後の空白を取り除いて返します。
+func firstLine(data []byte) string {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	return strings.TrimSpace(string(line))
+}
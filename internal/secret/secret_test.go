@@ -0,0 +1,114 @@
+package secret
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFromFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not checked on Windows")
+	}
+	tests := []struct {
+		name    string
+		content string
+		perm    os.FileMode
+		want    string
+		wantErr string
+	}{
+		{name: "owner only", content: "  key-1  \nsecond line\n", perm: 0600, want: "key-1"},
+		{name: "read only", content: "key-2", perm: 0400, want: "key-2"},
+		{name: "group readable", content: "key", perm: 0640, wantErr: "accessible by other users"},
+		{name: "others readable", content: "key", perm: 0604, wantErr: "accessible by other users"},
+		{name: "group writable", content: "key", perm: 0620, wantErr: "chmod 600"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "key")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(path, tt.perm); err != nil {
+				t.Fatal(err)
+			}
+			got, err := FromFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				if got != "" {
+					t.Errorf("FromFile returned %q with an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("FromFile = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := FromFile(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("err = %v, want a missing file", err)
+	}
+}
+
+func TestFromCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands use sh")
+	}
+	tests := []struct {
+		name    string
+		cmdline string
+		want    string
+		wantErr string
+	}{
+		{name: "first line", cmdline: `printf '  key-1 \nsecond line\n'`, want: "key-1"},
+		{name: "no newline", cmdline: `printf key-2`, want: "key-2"},
+		{name: "empty output", cmdline: `true`, wantErr: "printed nothing"},
+		{name: "blank first line", cmdline: `printf '\nkey\n'`, wantErr: "printed nothing"},
+		{name: "failure", cmdline: `printf 'leak%s\n' ed; exit 3`, wantErr: "exit status 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromCommand(tt.cmdline)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				// エラーメッセージには、コマンドの出力を含めません。
+				if err != nil && strings.Contains(err.Error(), "leaked") {
+					t.Errorf("err = %v, want the command output left out", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("FromCommand = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	Register("")
+	Register("sk-test-0123")
+	Register("hunter2")
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"key=sk-test-0123", "key=[REDACTED]"},
+		{"hunter2 and sk-test-0123 and hunter2", "[REDACTED] and [REDACTED] and [REDACTED]"},
+		{"sk-test-01", "sk-test-01"},
+		{"nothing secret", "nothing secret"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,518 @@
+// Package server は、チャットルームを HTTP で操作するローカル API を提供します。
+// エディタのプラグインやスクリプトから、TUI や `gollm chat` と同じルームを使用できます。
+//
+//	GET  /v1/rooms                  チャットルームの一覧
+//	POST /v1/rooms                  チャットルームの作成（{"name": "..."}）
+//	GET  /v1/rooms/{name}/messages  選択中の枝のメッセージ
+//	POST /v1/rooms/{name}/messages  メッセージの送信（{"content": "...", "stream": true} で SSE）
+//	GET  /v1/search?q=...&room=...  メッセージの検索
+//
+// 全てのリクエストには Authorization: Bearer <token> ヘッダーが必要です。
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kou12345/gollm/internal/chat"
+	"github.com/kou12345/gollm/internal/history"
+	"github.com/kou12345/gollm/internal/secret"
+)
+
+// 検索結果の件数の既定値と上限です。
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// Session は、1つのチャットルームでの会話です。*chat.Chat が実装します。
+type Session interface {
+	AskStream(input string, onText func(string)) (string, error)
+	Close()
+}
+
+// OpenFunc は、room という名前のチャットルームの Session を作成します。
+type OpenFunc func(room string) (Session, error)
+
+// Options は、Server の動作を設定する構造体です。
+type Options struct {
+	Store   *history.Store // チャットルームとメッセージの保存先
+	Project string         // API で扱うチャットルームのプロジェクト（空の場合はどのプロジェクトにも属さないルーム）
+	Token   string         // リクエストの認証に使用するトークン（空の場合は全てのリクエストを拒否する）
+	Open    OpenFunc       // チャットルームに初めてメッセージを送信するときに呼び出す関数
+}
+
+// Server は、API のリクエストを処理する http.Handler です。
+// 作成した Session はチャットルームごとに再利用するため、使用後は Close で終了してください。
+type Server struct {
+	opts Options
+
+	mu     sync.Mutex
+	rooms  map[string]*roomState
+	closed bool
+}
+
+// roomState は、チャットルームへの送信の状態です。
+type roomState struct {
+	mu   sync.Mutex // メッセージの送信を1つずつ処理するためのロック
+	sess Session    // 作成済みの Session（nil の場合は未作成）
+	head int64      // sess が最後に保存したメッセージ（他のクライアントがルームに書き込んだかを確かめるために使用します）
+}
+
+// New は、o の設定で Server を作成します。
+func New(o Options) *Server {
+	return &Server{opts: o, rooms: map[string]*roomState{}}
+}
+
+// Close は、作成した全ての Session を終了します。処理中の送信があれば、終わるのを待ちます。
+// Close の後のメッセージの送信は失敗します。
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	rooms := s.rooms
+	s.mu.Unlock()
+
+	for _, st := range rooms {
+		st.mu.Lock()
+		st.discard()
+		st.mu.Unlock()
+	}
+}
+
+// ServeHTTP は、リクエストを認証してパスに対応する処理を呼び出します。
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gollm"`)
+		writeError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	// ルーム名に "/" を含められるように、エスケープされたままのパスを分割します。
+	segments := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	for i, seg := range segments {
+		var err error
+		if segments[i], err = url.PathUnescape(seg); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid path")
+			return
+		}
+	}
+
+	switch {
+	case len(segments) == 2 && segments[0] == "v1" && segments[1] == "rooms":
+		switch r.Method {
+		case http.MethodGet:
+			s.listRooms(w)
+		case http.MethodPost:
+			s.createRoom(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	case len(segments) == 4 && segments[0] == "v1" && segments[1] == "rooms" && segments[3] == "messages":
+		switch r.Method {
+		case http.MethodGet:
+			s.listMessages(w, segments[2])
+		case http.MethodPost:
+			s.postMessage(w, r, segments[2])
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	case len(segments) == 2 && segments[0] == "v1" && segments[1] == "search":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		s.search(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// authorized は、リクエストに正しいトークンが含まれているかを返します。
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && s.opts.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Token)) == 1
+}
+
+// room は、API で返すチャットルームです。
+type room struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	Folder       string    `json:"folder,omitempty"`
+	Tags         []string  `json:"tags,omitempty"`
+	Pinned       bool      `json:"pinned,omitempty"`
+	Archived     bool      `json:"archived,omitempty"`
+	MessageCount int       `json:"message_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// message は、API で返すメッセージです。
+type message struct {
+	ID        int64     `json:"id"`
+	ParentID  int64     `json:"parent_id,omitempty"`
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	Pinned    bool      `json:"pinned,omitempty"`
+	Model     string    `json:"model,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// searchResult は、API で返す検索結果です。
+type searchResult struct {
+	Room string `json:"room"`
+	message
+}
+
+func toRoom(r history.ChatRoom) room {
+	return room{
+		ID:           r.ID,
+		Name:         r.Name,
+		Folder:       r.Folder,
+		Tags:         r.Tags,
+		Pinned:       r.Pinned,
+		Archived:     r.Archived,
+		MessageCount: r.MessageCount,
+		CreatedAt:    r.CreatedAt,
+		UpdatedAt:    r.UpdatedAt,
+	}
+}
+
+func toMessage(m history.ChatMessage) message {
+	return message{
+		ID:        m.ID,
+		ParentID:  m.ParentID,
+		Role:      m.Role,
+		Content:   m.Content,
+		Pinned:    m.Pinned,
+		Model:     m.Usage.Model,
+		CreatedAt: m.Time,
+	}
+}
+
+// listRooms は、プロジェクトの全てのチャットルームを返します。
+func (s *Server) listRooms(w http.ResponseWriter) {
+	rooms, err := s.opts.Store.ChatRooms()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := make([]room, 0, len(rooms))
+	for _, r := range rooms {
+		if r.Project == s.opts.Project {
+			out = append(out, toRoom(r))
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// createRoom は、チャットルームを作成します。既に存在する場合はそのルームを返します。
+func (s *Server) createRoom(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	_, exists, err := s.findRoom(req.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	created, err := s.opts.Store.OpenProjectRoom(s.opts.Project, req.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	status := http.StatusCreated
+	if exists {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, toRoom(created))
+}
+
+// listMessages は、チャットルームで選択中の枝のメッセージを古い順に返します。
+func (s *Server) listMessages(w http.ResponseWriter, name string) {
+	rm, ok := s.lookupRoom(w, name)
+	if !ok {
+		return
+	}
+	h, err := s.opts.Store.Load(rm.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := make([]message, 0, len(h.Messages))
+	for _, m := range h.Messages {
+		out = append(out, toMessage(m))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// postMessage は、メッセージをチャットルームに送信し、保存されたモデルの応答を返します。
+// "stream" が true か Accept ヘッダーが text/event-stream の場合は、応答を Server-Sent Events で返します。
+// 同じルームへの送信は、届いた順に1つずつ処理します。
+func (s *Server) postMessage(w http.ResponseWriter, r *http.Request, name string) {
+	var req struct {
+		Content string `json:"content"`
+		Stream  bool   `json:"stream"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		writeError(w, http.StatusBadRequest, "content is required")
+		return
+	}
+	rm, ok := s.lookupRoom(w, name)
+	if !ok {
+		return
+	}
+
+	st, ok := s.room(rm.Name)
+	if !ok {
+		writeError(w, http.StatusServiceUnavailable, "the server is shutting down")
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	sess, err := s.session(st, rm)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	stream := req.Stream || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if !stream {
+		m, err := s.ask(st, rm, sess, req.Content, nil)
+		if err != nil {
+			writeBackendError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(event string, v any) {
+		writeEvent(w, event, v)
+		flusher.Flush()
+	}
+	// クライアントが切断しても、応答は最後まで受信してルームに保存します。
+	m, err := s.ask(st, rm, sess, req.Content, func(text string) {
+		send("delta", map[string]string{"text": text})
+	})
+	if err != nil {
+		send("error", errorBody(err))
+		return
+	}
+	send("done", m)
+}
+
+// session は、チャットルームの Session を返します。作成済みの Session は再利用しますが、
+// 他のクライアント（TUI など）がルームに書き込んで選択中の枝の末端が変わった場合は、履歴を読み込み直すために作成し直します。
+// 呼び出す前に st.mu を取得してください。
+func (s *Server) session(st *roomState, rm history.ChatRoom) (Session, error) {
+	if st.sess != nil {
+		if head, err := s.opts.Store.Head(rm.ID); err == nil && head == st.head {
+			return st.sess, nil
+		}
+		st.discard()
+	}
+	sess, err := s.opts.Open(rm.Name)
+	if err != nil {
+		return nil, err
+	}
+	st.sess = sess
+	return sess, nil
+}
+
+// ask は、sess で input を送信し、保存されたモデルの応答を返します。
+// 失敗した場合は、Session の状態がルームと食い違っている可能性があるため、次の送信で作成し直します。
+func (s *Server) ask(st *roomState, rm history.ChatRoom, sess Session, input string, onText func(string)) (message, error) {
+	_, err := sess.AskStream(input, onText)
+	var m message
+	if err == nil {
+		m, err = s.head(rm)
+	}
+	if err != nil {
+		st.discard()
+		return message{}, err
+	}
+	st.head = m.ID
+	return m, nil
+}
+
+// discard は、作成済みの Session を終了します。呼び出す前に st.mu を取得してください。
+func (st *roomState) discard() {
+	if st.sess != nil {
+		st.sess.Close()
+		st.sess, st.head = nil, 0
+	}
+}
+
+// head は、チャットルームで選択中の枝の最後のメッセージを返します。
+func (s *Server) head(rm history.ChatRoom) (message, error) {
+	h, err := s.opts.Store.Load(rm.ID)
+	if err != nil {
+		return message{}, err
+	}
+	if len(h.Messages) == 0 {
+		return message{}, errors.New("the response was not saved")
+	}
+	return toMessage(h.Messages[len(h.Messages)-1]), nil
+}
+
+// search は、本文にクエリを含むプロジェクトのメッセージを新しい順に返します。
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := q.Get("q")
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	limit := defaultSearchLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, maxSearchLimit)
+	}
+	var roomID int64
+	if name := q.Get("room"); name != "" {
+		rm, ok := s.lookupRoom(w, name)
+		if !ok {
+			return
+		}
+		roomID = rm.ID
+	}
+
+	results, err := s.opts.Store.Search(query, s.opts.Project, roomID, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := make([]searchResult, 0, len(results))
+	for _, res := range results {
+		out = append(out, searchResult{Room: res.Room, message: toMessage(res.Message)})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// findRoom は、プロジェクトの name という名前のチャットルームを探します。
+func (s *Server) findRoom(name string) (history.ChatRoom, bool, error) {
+	rooms, err := s.opts.Store.ChatRooms()
+	if err != nil {
+		return history.ChatRoom{}, false, err
+	}
+	for _, r := range rooms {
+		if r.Name == name && r.Project == s.opts.Project {
+			return r, true, nil
+		}
+	}
+	return history.ChatRoom{}, false, nil
+}
+
+// lookupRoom は、name という名前のチャットルームを返します。
+// 見つからない場合はエラーの応答を書き込み、false を返します。
+func (s *Server) lookupRoom(w http.ResponseWriter, name string) (history.ChatRoom, bool) {
+	rm, ok, err := s.findRoom(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return rm, false
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("room %q not found", name))
+	}
+	return rm, ok
+}
+
+// room は、チャットルームへの送信の状態を返します。Close の後は false を返します。
+func (s *Server) room(name string) (*roomState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, false
+	}
+	st, ok := s.rooms[name]
+	if !ok {
+		st = &roomState{}
+		s.rooms[name] = st
+	}
+	return st, true
+}
+
+// errorBody は、エラーを API で返す形式に変換します。バックエンドのエラーには分類を含めます。
+func errorBody(err error) map[string]string {
+	body := map[string]string{"error": secret.Redact(err.Error())}
+	var be *chat.BackendError
+	if errors.As(err, &be) {
+		body["kind"] = string(be.Kind)
+	}
+	return body
+}
+
+// writeBackendError は、モデルの呼び出しに失敗した理由を適切なステータスコードで返します。
+func writeBackendError(w http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+	var be *chat.BackendError
+	if errors.As(err, &be) {
+		switch be.Kind {
+		case chat.ErrorRateLimit, chat.ErrorQuota:
+			status = http.StatusTooManyRequests
+			if be.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(be.RetryAfter.Seconds()+0.5)))
+			}
+		case chat.ErrorSafety:
+			status = http.StatusUnprocessableEntity
+		}
+	}
+	writeJSON(w, status, errorBody(err))
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": secret.Redact(msg)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeEvent は、v を JSON に変換して Server-Sent Events の1つのイベントとして書き込みます。
+func writeEvent(w http.ResponseWriter, event string, v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,413 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kou12345/gollm/internal/chat"
+	"github.com/kou12345/gollm/internal/history"
+)
+
+const testToken = "secret-token"
+
+// echoSession は、入力をそのまま返し、やり取りをルームに保存する Session です。
+type echoSession struct {
+	store *history.Store
+	room  string
+	err   error
+}
+
+func (e *echoSession) AskStream(input string, onText func(string)) (string, error) {
+	if e.err != nil {
+		return "", e.err
+	}
+	reply := "echo: " + input
+	if onText != nil {
+		for _, chunk := range strings.SplitAfter(reply, " ") {
+			onText(chunk)
+		}
+	}
+
+	rm, err := e.store.OpenRoom(e.room)
+	if err != nil {
+		return "", err
+	}
+	h, err := e.store.Load(rm.ID)
+	if err != nil {
+		return "", err
+	}
+	for _, m := range []struct{ role, content string }{{"user", input}, {"assistant", reply}} {
+		if err := e.store.SaveMessage(rm.ID, h.AddMessage(m.role, m.content, 0)); err != nil {
+			return "", err
+		}
+	}
+	return reply, nil
+}
+
+func (e *echoSession) Close() {}
+
+// newTestServer は、一時的なデータベースを使用する API サーバーを起動します。
+// sessionErr が nil でない場合、メッセージの送信はそのエラーで失敗します。
+func newTestServer(t *testing.T, sessionErr error) (*httptest.Server, *history.Store) {
+	t.Helper()
+	store, err := history.OpenStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	srv := httptest.NewServer(New(Options{
+		Store: store,
+		Token: testToken,
+		Open: func(room string) (Session, error) {
+			return &echoSession{store: store, room: room, err: sessionErr}, nil
+		},
+	}))
+	t.Cleanup(srv.Close)
+	return srv, store
+}
+
+// do は、トークンを付けてリクエストを送信します。
+func do(t *testing.T, srv *httptest.Server, method, path, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// decode は、レスポンスのステータスコードを確認して JSON の本文を v に読み込みます。
+func decode(t *testing.T, resp *http.Response, status int, v any) {
+	t.Helper()
+	if resp.StatusCode != status {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status = %d, want %d: %s", resp.StatusCode, status, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+
+	for _, header := range []string{"", "Bearer wrong", testToken, "Basic " + testToken} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/rooms", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want 401", header, resp.StatusCode)
+		}
+		if resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("Authorization %q: missing WWW-Authenticate", header)
+		}
+	}
+
+	// トークンが設定されていないサーバーは全てのリクエストを拒否します。
+	open := httptest.NewServer(New(Options{}))
+	defer open.Close()
+	req, _ := http.NewRequest(http.MethodGet, open.URL+"/v1/rooms", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	resp, err := open.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("empty token: status = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestRooms(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+
+	var created room
+	decode(t, do(t, srv, http.MethodPost, "/v1/rooms", `{"name":"notes/go"}`), http.StatusCreated, &created)
+	if created.Name != "notes/go" || created.ID == 0 {
+		t.Fatalf("created = %+v", created)
+	}
+
+	var again room
+	decode(t, do(t, srv, http.MethodPost, "/v1/rooms", `{"name":"notes/go"}`), http.StatusOK, &again)
+	if again.ID != created.ID {
+		t.Errorf("creating an existing room returned id %d, want %d", again.ID, created.ID)
+	}
+
+	var errBody map[string]string
+	decode(t, do(t, srv, http.MethodPost, "/v1/rooms", `{"name":" "}`), http.StatusBadRequest, &errBody)
+	if errBody["error"] == "" {
+		t.Error("missing error message")
+	}
+
+	var rooms []room
+	decode(t, do(t, srv, http.MethodGet, "/v1/rooms", ""), http.StatusOK, &rooms)
+	if len(rooms) != 1 || rooms[0].Name != "notes/go" {
+		t.Errorf("rooms = %+v", rooms)
+	}
+
+	resp := do(t, srv, http.MethodDelete, "/v1/rooms", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") == "" {
+		t.Errorf("DELETE: status = %d, Allow = %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+}
+
+func TestPostMessage(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+	do(t, srv, http.MethodPost, "/v1/rooms", `{"name":"notes/go"}`)
+
+	var reply message
+	decode(t, do(t, srv, http.MethodPost, "/v1/rooms/notes%2Fgo/messages", `{"content":"hello"}`), http.StatusOK, &reply)
+	if reply.Role != "assistant" || reply.Content != "echo: hello" || reply.ParentID == 0 {
+		t.Errorf("reply = %+v", reply)
+	}
+
+	var msgs []message
+	decode(t, do(t, srv, http.MethodGet, "/v1/rooms/notes%2Fgo/messages", ""), http.StatusOK, &msgs)
+	if len(msgs) != 2 || msgs[0].Content != "hello" || msgs[1].ID != reply.ID {
+		t.Errorf("messages = %+v", msgs)
+	}
+
+	var errBody map[string]string
+	decode(t, do(t, srv, http.MethodPost, "/v1/rooms/missing/messages", `{"content":"hello"}`), http.StatusNotFound, &errBody)
+	decode(t, do(t, srv, http.MethodPost, "/v1/rooms/notes%2Fgo/messages", `{"content":""}`), http.StatusBadRequest, &errBody)
+	decode(t, do(t, srv, http.MethodPost, "/v1/rooms/notes%2Fgo/messages", `not json`), http.StatusBadRequest, &errBody)
+}
+
+func TestPostMessageStream(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+	do(t, srv, http.MethodPost, "/v1/rooms", `{"name":"default"}`)
+
+	resp := do(t, srv, http.MethodPost, "/v1/rooms/default/messages", `{"content":"one two","stream":true}`)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	var (
+		text  string
+		done  message
+		event string
+	)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		switch event {
+		case "delta":
+			var d struct{ Text string }
+			if err := json.Unmarshal([]byte(data), &d); err != nil {
+				t.Fatal(err)
+			}
+			text += d.Text
+		case "done":
+			if err := json.Unmarshal([]byte(data), &done); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			t.Fatalf("unexpected event %q: %s", event, data)
+		}
+	}
+	if text != "echo: one two" {
+		t.Errorf("streamed text = %q", text)
+	}
+	if done.Content != text || done.ID == 0 {
+		t.Errorf("done = %+v", done)
+	}
+}
+
+func TestPostMessageBackendError(t *testing.T) {
+	srv, _ := newTestServer(t, &chat.BackendError{Kind: chat.ErrorRateLimit, RetryAfter: 3 * time.Second})
+	do(t, srv, http.MethodPost, "/v1/rooms", `{"name":"default"}`)
+
+	resp := do(t, srv, http.MethodPost, "/v1/rooms/default/messages", `{"content":"hello"}`)
+	var body map[string]string
+	decode(t, resp, http.StatusTooManyRequests, &body)
+	if body["kind"] != "rate_limit" || resp.Header.Get("Retry-After") != "3" {
+		t.Errorf("body = %v, Retry-After = %q", body, resp.Header.Get("Retry-After"))
+	}
+
+	resp = do(t, srv, http.MethodPost, "/v1/rooms/default/messages", `{"content":"hello","stream":true}`)
+	data, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(data), "event: error\n") || !strings.Contains(string(data), `"kind":"rate_limit"`) {
+		t.Errorf("stream = %q", data)
+	}
+}
+
+// countingSession は、終了したかどうかを記録する echoSession です。
+type countingSession struct {
+	echoSession
+	closed bool
+}
+
+func (c *countingSession) Close() { c.closed = true }
+
+// TestSessionReuse は、同じルームへの送信で Session を再利用し、他のクライアントがルームに書き込んだ場合や
+// 送信に失敗した場合は作成し直し、Close で全ての Session を終了することを確認します。
+func TestSessionReuse(t *testing.T) {
+	store, err := history.OpenStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	var opened []*countingSession
+	var failNext error
+	handler := New(Options{
+		Store: store,
+		Token: testToken,
+		Open: func(room string) (Session, error) {
+			sess := &countingSession{echoSession: echoSession{store: store, room: room, err: failNext}}
+			opened = append(opened, sess)
+			return sess, nil
+		},
+	})
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	var created room
+	decode(t, do(t, srv, http.MethodPost, "/v1/rooms", `{"name":"a"}`), http.StatusCreated, &created)
+	do(t, srv, http.MethodPost, "/v1/rooms", `{"name":"b"}`)
+
+	var reply message
+	post := func(room, body string, status int) {
+		t.Helper()
+		decode(t, do(t, srv, http.MethodPost, "/v1/rooms/"+room+"/messages", body), status, &reply)
+	}
+	post("a", `{"content":"one"}`, http.StatusOK)
+	post("a", `{"content":"two","stream":false}`, http.StatusOK)
+	post("b", `{"content":"three"}`, http.StatusOK)
+	if len(opened) != 2 || opened[0].closed {
+		t.Fatalf("opened %d sessions, want one per room", len(opened))
+	}
+
+	// 他のクライアントが書き込んだ場合は、履歴を読み込み直すために作成し直します。
+	h, err := store.Load(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveMessage(created.ID, h.AddMessage("user", "from the TUI", 0)); err != nil {
+		t.Fatal(err)
+	}
+	post("a", `{"content":"four"}`, http.StatusOK)
+	if len(opened) != 3 || !opened[0].closed {
+		t.Fatalf("opened %d sessions, want a new one after another client wrote", len(opened))
+	}
+
+	// 送信に失敗した Session は、次の送信で作成し直します。
+	opened[2].err = errors.New("boom")
+	post("a", `{"content":"five"}`, http.StatusBadGateway)
+	post("a", `{"content":"six"}`, http.StatusOK)
+	if len(opened) != 4 || !opened[2].closed {
+		t.Fatalf("opened %d sessions, want a new one after the failure", len(opened))
+	}
+
+	handler.Close()
+	for i, sess := range opened {
+		if !sess.closed {
+			t.Errorf("session %d was not closed", i)
+		}
+	}
+	var errBody map[string]string
+	decode(t, do(t, srv, http.MethodPost, "/v1/rooms/a/messages", `{"content":"late"}`), http.StatusServiceUnavailable, &errBody)
+}
+
+func TestSearch(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+	for _, name := range []string{"a", "b"} {
+		do(t, srv, http.MethodPost, "/v1/rooms", `{"name":"`+name+`"}`)
+		do(t, srv, http.MethodPost, "/v1/rooms/"+name+"/messages", `{"content":"100% Gopher in `+name+`"}`)
+	}
+
+	var results []searchResult
+	decode(t, do(t, srv, http.MethodGet, "/v1/search?q=gopher", ""), http.StatusOK, &results)
+	if len(results) != 4 || results[0].Room != "b" {
+		t.Errorf("results = %+v", results)
+	}
+
+	decode(t, do(t, srv, http.MethodGet, "/v1/search?q=100%25+Gopher&room=a&limit=1", ""), http.StatusOK, &results)
+	if len(results) != 1 || results[0].Room != "a" || results[0].Role != "assistant" {
+		t.Errorf("results = %+v", results)
+	}
+
+	// % はワイルドカードではなく文字として扱います。
+	decode(t, do(t, srv, http.MethodGet, "/v1/search?q=1%25G", ""), http.StatusOK, &results)
+	if len(results) != 0 {
+		t.Errorf("results = %+v", results)
+	}
+
+	var errBody map[string]string
+	decode(t, do(t, srv, http.MethodGet, "/v1/search", ""), http.StatusBadRequest, &errBody)
+	decode(t, do(t, srv, http.MethodGet, "/v1/search?q=x&limit=0", ""), http.StatusBadRequest, &errBody)
+	decode(t, do(t, srv, http.MethodGet, "/v1/search?q=x&room=missing", ""), http.StatusNotFound, &errBody)
+}
+
+// TestProjectRooms は、Project を指定したサーバーがそのプロジェクトのルームだけを扱うことを確認します。
+func TestProjectRooms(t *testing.T) {
+	store, err := history.OpenStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	other, err := store.OpenRoom("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveMessage(other.ID, &history.ChatMessage{Role: "user", Content: "the plan outside the project"}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(New(Options{Store: store, Project: "/src/gollm", Token: testToken}))
+	t.Cleanup(srv.Close)
+
+	var rooms []room
+	decode(t, do(t, srv, http.MethodGet, "/v1/rooms", ""), http.StatusOK, &rooms)
+	if len(rooms) != 0 {
+		t.Errorf("rooms = %+v, want none outside the project", rooms)
+	}
+
+	var created room
+	decode(t, do(t, srv, http.MethodPost, "/v1/rooms", `{"name":"notes"}`), http.StatusCreated, &created)
+	if r, err := store.OpenProjectRoom("/src/gollm", "notes"); err != nil || r.ID != created.ID {
+		t.Errorf("created room %+v, project room = %+v, %v", created, r, err)
+	}
+	decode(t, do(t, srv, http.MethodGet, "/v1/rooms", ""), http.StatusOK, &rooms)
+	if len(rooms) != 1 || rooms[0].ID != created.ID {
+		t.Errorf("rooms = %+v", rooms)
+	}
+
+	// 検索も、プロジェクトのチャットルームのメッセージだけを返します。
+	if err := store.SaveMessage(created.ID, &history.ChatMessage{Role: "user", Content: "the plan in the project"}); err != nil {
+		t.Fatal(err)
+	}
+	var results []searchResult
+	decode(t, do(t, srv, http.MethodGet, "/v1/search?q=plan", ""), http.StatusOK, &results)
+	if len(results) != 1 || results[0].Content != "the plan in the project" {
+		t.Errorf("search results = %+v, want only the project's message", results)
+	}
+}
@@ -0,0 +1,209 @@
+// Package templates は、テンプレートディレクトリに置かれた .tmpl ファイルからプロンプトを作成します。
+//
+// テンプレートは text/template の構文で記述し、先頭に +++ で囲んだ TOML のフロントマターで
+// 説明と変数を宣言します。
+//
+//	+++
+//	description = "Review a diff"
+//
+//	[vars.lang]
+//	required = true
+//	description = "Language of the code"
+//
+//	[vars.focus]
+//	default = "correctness"
+//	+++
+//	Review the following {{.lang}} diff, focusing on {{.focus}}.
+//	{{include "CONTRIBUTING.md"}}
+//	{{stdin}}
+//
+// 変数は {{.name}} で参照し、include はファイルの内容を、stdin は標準入力の内容を展開します。
+package templates
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Ext は、テンプレートファイルの拡張子です。
+const Ext = ".tmpl"
+
+// frontMatterDelim は、フロントマターの開始と終了を示す行です。
+const frontMatterDelim = "+++"
+
+// Template は、読み込んだテンプレートを表現する構造体です。
+type Template struct {
+	Name        string         `toml:"-"`           // ファイル名から拡張子を除いたもの
+	Path        string         `toml:"-"`           // テンプレートファイルのパス
+	Description string         `toml:"description"` // テンプレートの説明
+	Vars        map[string]Var `toml:"vars"`        // 宣言された変数
+
+	body string
+}
+
+// Var は、テンプレートの変数の宣言です。
+type Var struct {
+	Description string `toml:"description"`
+	Required    bool   `toml:"required"` // true の場合は値の指定が必須
+	Default     string `toml:"default"`  // 値が指定されなかったときに使用する値
+}
+
+// RenderOptions は、テンプレートを展開するときの入力と上限を設定します。
+type RenderOptions struct {
+	Stdin          io.Reader // stdin で展開する入力（nil の場合は stdin を使用できない）
+	MaxIncludeSize int64     // include で読み込むファイルの上限（バイト、0 の場合は無制限）
+}
+
+// Load は、dir にある name という名前のテンプレートを読み込みます。
+func Load(dir, name string) (*Template, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("invalid template name %q", name)
+	}
+	path := filepath.Join(dir, name+Ext)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("template %q not found in %s", name, dir)
+		}
+		return nil, err
+	}
+	return parse(name, path, data)
+}
+
+// List は、dir にある全てのテンプレートを名前の順に返します。
+// dir が存在しない場合は、空のリストを返します。
+func List(dir string) ([]*Template, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+Ext))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var list []*Template
+	for _, path := range paths {
+		t, err := Load(dir, strings.TrimSuffix(filepath.Base(path), Ext))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+// parse は、フロントマターと本文を分けてテンプレートを作成します。
+func parse(name, path string, data []byte) (*Template, error) {
+	t := &Template{Name: name, Path: path}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+
+	if rest, ok := strings.CutPrefix(text, frontMatterDelim+"\n"); ok {
+		front, body, found := strings.Cut(rest, "\n"+frontMatterDelim+"\n")
+		if !found {
+			front, found = strings.CutSuffix(rest, "\n"+frontMatterDelim)
+		}
+		if !found {
+			return nil, fmt.Errorf("%s: front matter is not closed with %s", path, frontMatterDelim)
+		}
+		md, err := toml.Decode(front, t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid front matter: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("%s: unknown key %q in front matter", path, undecoded[0].String())
+		}
+		text = body
+	}
+
+	t.body = text
+	// 構文エラーは実行時ではなく読み込み時に報告します。
+	if _, err := t.parseBody(RenderOptions{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Render は、vars の値でテンプレートを展開します。
+// 宣言されていない変数や、必須の変数が指定されていない場合はエラーを返します。
+func (t *Template) Render(vars map[string]string, opts RenderOptions) (string, error) {
+	data := map[string]string{}
+	for name, v := range t.Vars {
+		data[name] = v.Default
+	}
+
+	var missing []string
+	for name, value := range vars {
+		if _, ok := t.Vars[name]; !ok {
+			return "", fmt.Errorf("template %s does not declare variable %q", t.Name, name)
+		}
+		data[name] = value
+	}
+	for name, v := range t.Vars {
+		if _, ok := vars[name]; !ok && v.Required {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", fmt.Errorf("template %s requires variable(s): %s", t.Name, strings.Join(missing, ", "))
+	}
+
+	tmpl, err := t.parseBody(opts)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("template %s: %w", t.Name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// parseBody は、include と stdin を使用できる text/template として本文を解析します。
+// 宣言されていない変数を参照した場合は、実行時にエラーになります。
+func (t *Template) parseBody(opts RenderOptions) (*template.Template, error) {
+	var (
+		stdin     string
+		stdinRead bool
+	)
+	funcs := template.FuncMap{
+		"include": func(path string) (string, error) {
+			info, err := os.Stat(path)
+			if err != nil {
+				return "", err
+			}
+			if opts.MaxIncludeSize > 0 && info.Size() > opts.MaxIncludeSize {
+				return "", fmt.Errorf("%s is larger than %d bytes", path, opts.MaxIncludeSize)
+			}
+			data, err := os.ReadFile(path)
+			return string(data), err
+		},
+		// 標準入力は一度だけ読み込み、複数回参照しても同じ内容を返します。
+		"stdin": func() (string, error) {
+			if opts.Stdin == nil {
+				return "", errors.New("stdin is not available here")
+			}
+			if !stdinRead {
+				data, err := io.ReadAll(opts.Stdin)
+				if err != nil {
+					return "", err
+				}
+				stdin, stdinRead = string(data), true
+			}
+			return stdin, nil
+		},
+	}
+
+	tmpl, err := template.New(t.Name).Funcs(funcs).Option("missingkey=error").Parse(t.body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", t.Path, err)
+	}
+	return tmpl, nil
+}
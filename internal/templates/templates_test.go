@@ -0,0 +1,170 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeTemplate は、dir に name という名前のテンプレートを作成します。
+func writeTemplate(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name+Ext), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const reviewTemplate = `+++
+description = "Review a diff"
+
+[vars.lang]
+required = true
+description = "Language of the code"
+
+[vars.focus]
+default = "correctness"
++++
+Review the following {{.lang}} diff, focusing on {{.focus}}.
+`
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantDesc string
+		wantVars []string
+		wantErr  string
+	}{
+		{name: "front matter", content: reviewTemplate, wantDesc: "Review a diff", wantVars: []string{"focus", "lang"}},
+		{name: "crlf", content: strings.ReplaceAll(reviewTemplate, "\n", "\r\n"), wantDesc: "Review a diff", wantVars: []string{"focus", "lang"}},
+		{name: "no front matter", content: "Just say hi.\n"},
+		{name: "front matter only", content: "+++\ndescription = \"empty\"\n+++", wantDesc: "empty"},
+		{name: "not closed", content: "+++\ndescription = \"x\"\nBody\n", wantErr: "front matter is not closed with +++"},
+		{name: "invalid toml", content: "+++\ndescription = \n+++\nBody\n", wantErr: "invalid front matter"},
+		{name: "unknown key", content: "+++\ndescripton = \"x\"\n+++\nBody\n", wantErr: `unknown key "descripton" in front matter`},
+		{name: "unknown var key", content: "+++\n[vars.x]\nrequird = true\n+++\n{{.x}}\n", wantErr: `unknown key "vars.x.requird"`},
+		{name: "template syntax error", content: "Hello {{.name\n", wantErr: "unclosed action"},
+		{name: "unknown function", content: "{{shell \"ls\"}}\n", wantErr: `function "shell" not defined`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTemplate(t, dir, "review", tt.content)
+			tmpl, err := Load(dir, "review")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tmpl.Name != "review" || tmpl.Description != tt.wantDesc {
+				t.Errorf("name = %q, description = %q", tmpl.Name, tmpl.Description)
+			}
+			var vars []string
+			for name := range tmpl.Vars {
+				vars = append(vars, name)
+			}
+			sort.Strings(vars)
+			if strings.Join(vars, ",") != strings.Join(tt.wantVars, ",") {
+				t.Errorf("vars = %v, want %v", vars, tt.wantVars)
+			}
+		})
+	}
+}
+
+func TestLoadName(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"", "../review", `a\b`} {
+		if _, err := Load(dir, name); err == nil || !strings.Contains(err.Error(), "invalid template name") {
+			t.Errorf("Load(%q): err = %v", name, err)
+		}
+	}
+	if _, err := Load(dir, "missing"); err == nil || !strings.Contains(err.Error(), `template "missing" not found`) {
+		t.Errorf("Load(missing): err = %v", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	dir := t.TempDir()
+	include := filepath.Join(dir, "guide.md")
+	if err := os.WriteFile(include, []byte("Be kind."), 0600); err != nil {
+		t.Fatal(err)
+	}
+	writeTemplate(t, dir, "review", reviewTemplate)
+	writeTemplate(t, dir, "required", "+++\n[vars.a]\nrequired = true\n[vars.b]\nrequired = true\n[vars.c]\n+++\n{{.a}}{{.b}}{{.c}}\n")
+	writeTemplate(t, dir, "plain", "Hello {{.name}}\n")
+	writeTemplate(t, dir, "include", "+++\n[vars.path]\n+++\n{{include .path}}\n")
+	writeTemplate(t, dir, "stdin", "A: {{stdin}}\nB: {{stdin}}\n")
+
+	tests := []struct {
+		name     string
+		template string
+		vars     map[string]string
+		opts     RenderOptions
+		want     string
+		wantErr  string
+	}{
+		{name: "default", template: "review", vars: map[string]string{"lang": "Go"}, want: "Review the following Go diff, focusing on correctness."},
+		{name: "override default", template: "review", vars: map[string]string{"lang": "Go", "focus": "style"}, want: "Review the following Go diff, focusing on style."},
+		{name: "empty value counts as given", template: "review", vars: map[string]string{"lang": ""}, want: "Review the following  diff, focusing on correctness."},
+		{name: "missing required", template: "review", wantErr: "template review requires variable(s): lang"},
+		{name: "missing several required", template: "required", vars: map[string]string{"c": "x"}, wantErr: "requires variable(s): a, b"},
+		{name: "optional without default", template: "required", vars: map[string]string{"a": "1", "b": "2"}, want: "12"},
+		{name: "undeclared variable", template: "review", vars: map[string]string{"lang": "Go", "tone": "x"}, wantErr: `does not declare variable "tone"`},
+		{name: "reference to undeclared variable", template: "plain", wantErr: `map has no entry for key "name"`},
+		{name: "include", template: "include", vars: map[string]string{"path": include}, want: "Be kind."},
+		{name: "include too large", template: "include", vars: map[string]string{"path": include}, opts: RenderOptions{MaxIncludeSize: 3}, wantErr: "larger than 3 bytes"},
+		{name: "include missing", template: "include", vars: map[string]string{"path": filepath.Join(dir, "missing")}, wantErr: "no such file"},
+		{name: "stdin read once", template: "stdin", opts: RenderOptions{Stdin: strings.NewReader("diff")}, want: "A: diff\nB: diff"},
+		{name: "stdin unavailable", template: "stdin", wantErr: "stdin is not available here"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Load(dir, tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tmpl.Render(tt.vars, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Render = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "b", "+++\ndescription = \"second\"\n+++\nB\n")
+	writeTemplate(t, dir, "a", "A\n")
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a template"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "a" || list[1].Name != "b" || list[1].Description != "second" {
+		t.Errorf("List = %+v", list)
+	}
+
+	if list, err := List(filepath.Join(dir, "missing")); err != nil || len(list) != 0 {
+		t.Errorf("List(missing) = %v, %v, want an empty list", list, err)
+	}
+
+	writeTemplate(t, dir, "broken", "+++\n")
+	if _, err := List(dir); err == nil {
+		t.Error("List ignored a broken template")
+	}
+}
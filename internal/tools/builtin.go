@@ -0,0 +1,305 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/kou12345/gollm/internal/secret"
+)
+
+const (
+	// maxReadSize は、read_file と grep が読み込むファイルの上限です。
+	maxReadSize = 256 << 10
+	// maxOutputSize は、run_shell が返す出力の上限です。
+	maxOutputSize = 64 << 10
+	// defaultGrepResults は、grep が返す一致の数の既定値です。
+	defaultGrepResults = 100
+	// shellTimeout は、run_shell で実行するコマンドの制限時間です。
+	shellTimeout = time.Minute
+)
+
+// BuiltinOptions は、組み込みツールの登録方法を設定する構造体です。
+type BuiltinOptions struct {
+	RunShell bool   // run_shell を登録するかどうか
+	Root     string // read_file、list_dir、grep がアクセスできるディレクトリ（空の場合は作業ディレクトリ）
+}
+
+// RegisterBuiltins は、read_file、list_dir、grep と、有効な場合は run_shell を登録します。
+// ファイルを読むツールは、opts.Root の外（シンボリックリンクの参照先が外にある場合を含む）にアクセスできません。
+func RegisterBuiltins(r *Registry, opts BuiltinOptions) error {
+	root, err := newSandbox(opts.Root)
+	if err != nil {
+		return err
+	}
+	builtins := []Tool{readFileTool(root), listDirTool(root), grepTool(root)}
+	if opts.RunShell {
+		builtins = append(builtins, runShellTool)
+	}
+	for _, t := range builtins {
+		if err := r.Register(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sandbox は、ファイルを読むツールがアクセスできるディレクトリです。
+type sandbox string
+
+// newSandbox は、root（空の場合は作業ディレクトリ）のシンボリックリンクを解決した sandbox を作成します。
+func newSandbox(root string) (sandbox, error) {
+	if root == "" {
+		root = "."
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", fmt.Errorf("invalid tools root: %w", err)
+	}
+	return sandbox(resolved), nil
+}
+
+// resolve は、path（相対パスは作業ディレクトリが基準）のシンボリックリンクを解決したパスを返します。
+// 解決したパスが sandbox の外にある場合はエラーを返します。
+func (s sandbox) resolve(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(string(s), resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside %s; file tools can only access files under it", path, s)
+	}
+	return resolved, nil
+}
+
+// readFileTool は、root の下のファイルを読む read_file ツールを作成します。
+func readFileTool(root sandbox) Tool {
+	return Tool{
+		Name:        "read_file",
+		Description: "Read a UTF-8 text file under " + string(root) + " and return its content.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"path": map[string]any{"type": "string", "description": "Path of the file to read."},
+			},
+			"required": []any{"path"},
+		},
+		Run: func(ctx context.Context, args map[string]any) (map[string]any, error) {
+			path, err := stringArg(args, "path", true)
+			if err != nil {
+				return nil, err
+			}
+			path, err = root.resolve(path)
+			if err != nil {
+				return nil, err
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if info.Size() > maxReadSize {
+				return nil, fmt.Errorf("%s is larger than %d bytes", path, maxReadSize)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{"content": secret.Redact(string(data))}, nil
+		},
+	}
+}
+
+// listDirTool は、root の下のディレクトリを一覧表示する list_dir ツールを作成します。
+func listDirTool(root sandbox) Tool {
+	return Tool{
+		Name:        "list_dir",
+		Description: "List the entries of a directory under " + string(root) + ".",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"path": map[string]any{"type": "string", "description": "Directory to list. Defaults to the current directory."},
+			},
+		},
+		Run: func(ctx context.Context, args map[string]any) (map[string]any, error) {
+			path, err := stringArg(args, "path", false)
+			if err != nil {
+				return nil, err
+			}
+			if path == "" {
+				path = "."
+			}
+			path, err = root.resolve(path)
+			if err != nil {
+				return nil, err
+			}
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+
+			list := make([]any, 0, len(entries))
+			for _, e := range entries {
+				kind := "file"
+				if e.IsDir() {
+					kind = "dir"
+				}
+				entry := map[string]any{"name": e.Name(), "type": kind}
+				if info, err := e.Info(); err == nil && !e.IsDir() {
+					entry["size"] = info.Size()
+				}
+				list = append(list, entry)
+			}
+			return map[string]any{"entries": list}, nil
+		},
+	}
+}
+
+// grepTool は、root の下のテキストファイルを検索する grep ツールを作成します。
+func grepTool(root sandbox) Tool {
+	return Tool{
+		Name:        "grep",
+		Description: "Search text files under " + string(root) + " for lines matching a regular expression (RE2 syntax). Returns matches as path:line: text.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"pattern":     map[string]any{"type": "string", "description": "Regular expression to search for."},
+				"path":        map[string]any{"type": "string", "description": "File or directory to search. Defaults to the current directory."},
+				"max_results": map[string]any{"type": "integer", "description": "Maximum number of matches to return."},
+			},
+			"required": []any{"pattern"},
+		},
+		Run: func(ctx context.Context, args map[string]any) (map[string]any, error) {
+			pattern, err := stringArg(args, "pattern", true)
+			if err != nil {
+				return nil, err
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			dir, err := stringArg(args, "path", false)
+			if err != nil {
+				return nil, err
+			}
+			if dir == "" {
+				dir = "."
+			}
+			// 一致した行は指定されたパスを基準に表示します。WalkDir はシンボリックリンクを辿らず、
+			// リンクしたファイルは通常のファイルではないため読みません。
+			if _, err := root.resolve(dir); err != nil {
+				return nil, err
+			}
+			limit := defaultGrepResults
+			if n, ok := args["max_results"].(float64); ok && n > 0 {
+				limit = int(n)
+			}
+
+			var matches []any
+			errLimit := errors.New("limit reached")
+			err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if d.IsDir() {
+					if d.Name() == ".git" && path != dir {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				info, err := d.Info()
+				if err != nil || !info.Mode().IsRegular() || info.Size() > maxReadSize {
+					return nil
+				}
+				data, err := os.ReadFile(path)
+				if err != nil || bytes.IndexByte(data, 0) >= 0 {
+					return nil
+				}
+
+				scanner := bufio.NewScanner(bytes.NewReader(data))
+				for line := 1; scanner.Scan(); line++ {
+					if re.Match(scanner.Bytes()) {
+						matches = append(matches, fmt.Sprintf("%s:%d: %s", path, line, secret.Redact(scanner.Text())))
+						if len(matches) >= limit {
+							return errLimit
+						}
+					}
+				}
+				return nil
+			})
+			if err != nil && !errors.Is(err, errLimit) {
+				return nil, err
+			}
+			return map[string]any{"matches": matches, "truncated": errors.Is(err, errLimit)}, nil
+		},
+	}
+}
+
+var runShellTool = Tool{
+	Name:        "run_shell",
+	Description: "Run a shell command on the user's machine and return its exit code and combined output.",
+	Parameters: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"command": map[string]any{"type": "string", "description": "Command line to run."},
+		},
+		"required": []any{"command"},
+	},
+	SideEffects: true,
+	Run: func(ctx context.Context, args map[string]any) (map[string]any, error) {
+		command, err := stringArg(args, "command", true)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, shellTimeout)
+		defer cancel()
+
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", command)
+		}
+		out, err := cmd.CombinedOutput()
+
+		exitCode := 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		} else if err != nil {
+			return nil, err
+		}
+
+		truncated := len(out) > maxOutputSize
+		if truncated {
+			out = out[:maxOutputSize]
+		}
+		return map[string]any{
+			"exit_code": exitCode,
+			"output":    secret.Redact(string(out)),
+			"truncated": truncated,
+		}, nil
+	},
+}
@@ -0,0 +1,249 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/kou12345/gollm/internal/secret"
+)
+
+// setupFiles は、ツールのルートにする root と、その外にあるファイルを作成し、root を作業ディレクトリにします。
+func setupFiles(t *testing.T) (root, outside string) {
+	t.Helper()
+	base := t.TempDir()
+	root = filepath.Join(base, "root")
+	outside = filepath.Join(base, "outside", "secret.txt")
+
+	files := map[string]string{
+		filepath.Join(root, "a.txt"):        "hello\nworld\n",
+		filepath.Join(root, "sub", "b.go"):  "package b // hello\n",
+		filepath.Join(root, ".git", "HEAD"): "hello\n",
+		filepath.Join(root, "bin.dat"):      "hello\x00",
+		filepath.Join(root, "big.txt"):      strings.Repeat("x", maxReadSize+1),
+		filepath.Join(root, "token.txt"):    "key=tools-test-secret\n",
+		outside:                             "hello from outside\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link-out")); err != nil {
+		t.Skipf("symlinks are not available: %v", err)
+	}
+	if err := os.Symlink(filepath.Dir(outside), filepath.Join(root, "dir-out")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "a.txt"), filepath.Join(root, "link-in")); err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return root, outside
+}
+
+// builtin は、root をルートにして組み込みツールを登録し、name のツールを返します。
+func builtin(t *testing.T, root, name string) *Tool {
+	t.Helper()
+	r := NewRegistry()
+	if err := RegisterBuiltins(r, BuiltinOptions{Root: root}); err != nil {
+		t.Fatal(err)
+	}
+	tool, ok := r.Lookup(name)
+	if !ok {
+		t.Fatalf("%s is not registered", name)
+	}
+	return tool
+}
+
+func TestRegisterBuiltins(t *testing.T) {
+	root := t.TempDir()
+	for _, runShell := range []bool{false, true} {
+		r := NewRegistry()
+		if err := RegisterBuiltins(r, BuiltinOptions{RunShell: runShell, Root: root}); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, tool := range r.All() {
+			names = append(names, tool.Name)
+			if tool.SideEffects != (tool.Name == "run_shell") {
+				t.Errorf("%s: SideEffects = %v", tool.Name, tool.SideEffects)
+			}
+		}
+		want := "grep,list_dir,read_file"
+		if runShell {
+			want += ",run_shell"
+		}
+		if got := strings.Join(names, ","); got != want {
+			t.Errorf("RunShell %v: tools = %s, want %s", runShell, got, want)
+		}
+	}
+
+	if err := RegisterBuiltins(NewRegistry(), BuiltinOptions{Root: filepath.Join(root, "missing")}); err == nil {
+		t.Error("RegisterBuiltins accepted a missing root")
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	root, outside := setupFiles(t)
+	secret.Register("tools-test-secret")
+	readFile := builtin(t, root, "read_file")
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr string
+	}{
+		{path: "a.txt", want: "hello\nworld\n"},
+		{path: filepath.Join(root, "sub", "b.go"), want: "package b // hello\n"},
+		{path: "sub/../a.txt", want: "hello\nworld\n"},
+		{path: "link-in", want: "hello\nworld\n"},
+		{path: "token.txt", want: "key=[REDACTED]\n"},
+		{path: outside, wantErr: "outside"},
+		{path: "../outside/secret.txt", wantErr: "outside"},
+		{path: "link-out", wantErr: "outside"},
+		{path: "dir-out/secret.txt", wantErr: "outside"},
+		{path: "big.txt", wantErr: "larger than"},
+		{path: "missing.txt", wantErr: "no such file"},
+	}
+	for _, tt := range tests {
+		out, err := readFile.Run(context.Background(), map[string]any{"path": tt.path})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("read_file(%s): err = %v, want %q", tt.path, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || out["content"] != tt.want {
+			t.Errorf("read_file(%s) = %q, %v, want %q", tt.path, out["content"], err, tt.want)
+		}
+	}
+}
+
+func TestListDir(t *testing.T) {
+	root, _ := setupFiles(t)
+	listDir := builtin(t, root, "list_dir")
+
+	out, err := listDir.Run(context.Background(), map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]map[string]any{}
+	for _, e := range out["entries"].([]any) {
+		entry := e.(map[string]any)
+		entries[entry["name"].(string)] = entry
+	}
+	if e := entries["a.txt"]; e["type"] != "file" || e["size"] != int64(12) {
+		t.Errorf("a.txt = %v", e)
+	}
+	if e := entries["sub"]; e["type"] != "dir" || e["size"] != nil {
+		t.Errorf("sub = %v", e)
+	}
+
+	out, err = listDir.Run(context.Background(), map[string]any{"path": "sub"})
+	if err != nil || len(out["entries"].([]any)) != 1 {
+		t.Errorf("list_dir(sub) = %v, %v", out, err)
+	}
+
+	for _, path := range []string{"..", filepath.Dir(root), "dir-out"} {
+		if _, err := listDir.Run(context.Background(), map[string]any{"path": path}); err == nil || !strings.Contains(err.Error(), "outside") {
+			t.Errorf("list_dir(%s): err = %v, want it refused", path, err)
+		}
+	}
+}
+
+func TestGrep(t *testing.T) {
+	root, _ := setupFiles(t)
+	grep := builtin(t, root, "grep")
+
+	tests := []struct {
+		name          string
+		args          map[string]any
+		want          []any
+		wantTruncated bool
+		wantErr       string
+	}{
+		{
+			name: "skips .git, binary files and symlinks",
+			args: map[string]any{"pattern": "hello"},
+			want: []any{"a.txt:1: hello", filepath.Join("sub", "b.go") + ":1: package b // hello"},
+		},
+		{
+			name: "in a subdirectory",
+			args: map[string]any{"pattern": `^package \w+`, "path": "sub"},
+			want: []any{filepath.Join("sub", "b.go") + ":1: package b // hello"},
+		},
+		{
+			name:          "max results",
+			args:          map[string]any{"pattern": "hello", "max_results": 1.0},
+			want:          []any{"a.txt:1: hello"},
+			wantTruncated: true,
+		},
+		{name: "outside the root", args: map[string]any{"pattern": "hello", "path": ".."}, wantErr: "outside"},
+		{name: "symlink to outside", args: map[string]any{"pattern": "hello", "path": "dir-out"}, wantErr: "outside"},
+		{name: "invalid pattern", args: map[string]any{"pattern": "("}, wantErr: "missing closing )"},
+		{name: "missing pattern", args: map[string]any{}, wantErr: "missing required argument"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := grep.Run(context.Background(), tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			matches, _ := out["matches"].([]any)
+			if strings.Join(toStrings(matches), "\n") != strings.Join(toStrings(tt.want), "\n") {
+				t.Errorf("matches = %q, want %q", matches, tt.want)
+			}
+			if out["truncated"] != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", out["truncated"], tt.wantTruncated)
+			}
+		})
+	}
+}
+
+// toStrings は、[]any の要素を文字列に変換します。
+func toStrings(values []any) []string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i], _ = v.(string)
+	}
+	return s
+}
+
+func TestRunShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	r := NewRegistry()
+	if err := RegisterBuiltins(r, BuiltinOptions{RunShell: true, Root: t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+	runShell, _ := r.Lookup("run_shell")
+	out, err := runShell.Run(context.Background(), map[string]any{"command": "echo hi; echo err >&2; exit 3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["exit_code"] != 3 || out["output"] != "hi\nerr\n" || out["truncated"] != false {
+		t.Errorf("run_shell = %v", out)
+	}
+}
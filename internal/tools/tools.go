@@ -0,0 +1,94 @@
+// Package tools は、モデルから呼び出せるツール（関数）の登録と実行の仕組みを提供します。
+// 各ツールは引数を JSON Schema で宣言し、Go の関数として実装されます。
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Tool は、モデルから呼び出せる単一のツールを表現する構造体です。
+type Tool struct {
+	Name        string
+	Description string
+
+	// Parameters は、引数を表す JSON Schema です（type が object のもの）。
+	Parameters map[string]any
+
+	// SideEffects が true のツールは、実行前にユーザーの確認が必要です。
+	SideEffects bool
+
+	// Run は、モデルが指定した引数でツールを実行し、結果を返します。
+	Run func(ctx context.Context, args map[string]any) (map[string]any, error)
+}
+
+// Registry は、名前で検索できるツールの集合です。
+type Registry struct {
+	tools map[string]*Tool
+}
+
+// NewRegistry は、空の Registry を作成します。
+func NewRegistry() *Registry {
+	return &Registry{tools: map[string]*Tool{}}
+}
+
+// Register は、ツールを登録します。同じ名前のツールが既にある場合はエラーを返します。
+func (r *Registry) Register(t Tool) error {
+	return r.RegisterAll([]Tool{t})
+}
+
+// RegisterAll は、ts の全てのツールをまとめて登録します。
+// 同じ名前のツールが既にあるか ts の中で名前が重なる場合は、どのツールも登録せずにエラーを返します。
+func (r *Registry) RegisterAll(ts []Tool) error {
+	seen := map[string]bool{}
+	for _, t := range ts {
+		if _, ok := r.tools[t.Name]; ok || seen[t.Name] {
+			return fmt.Errorf("tool %q is already registered", t.Name)
+		}
+		seen[t.Name] = true
+	}
+	for i := range ts {
+		t := ts[i]
+		r.tools[t.Name] = &t
+	}
+	return nil
+}
+
+// Lookup は、name という名前のツールを返します。
+func (r *Registry) Lookup(name string) (*Tool, bool) {
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+// All は、登録された全てのツールを名前の順に返します。
+func (r *Registry) All() []*Tool {
+	all := make([]*Tool, 0, len(r.tools))
+	for _, t := range r.tools {
+		all = append(all, t)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// Len は、登録されたツールの数を返します。
+func (r *Registry) Len() int {
+	return len(r.tools)
+}
+
+// stringArg は、args[name] を文字列として取り出します。
+// required が true で値がない場合はエラーを返します。
+func stringArg(args map[string]any, name string, required bool) (string, error) {
+	v, ok := args[name]
+	if !ok || v == nil {
+		if required {
+			return "", fmt.Errorf("missing required argument %q", name)
+		}
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("argument %q must be a string", name)
+	}
+	return s, nil
+}
@@ -0,0 +1,89 @@
+package tools
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// echoTool は、引数 text をそのまま返すツールです。
+func echoTool(name string) Tool {
+	return Tool{
+		Name: name,
+		Run: func(ctx context.Context, args map[string]any) (map[string]any, error) {
+			text, err := stringArg(args, "text", true)
+			return map[string]any{"text": text}, err
+		},
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	for _, name := range []string{"b", "c", "a"} {
+		if err := r.Register(echoTool(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Register(echoTool("a")); err == nil || !strings.Contains(err.Error(), "already registered") {
+		t.Errorf("registering a duplicate: err = %v", err)
+	}
+	if err := r.RegisterAll([]Tool{echoTool("d"), echoTool("e"), echoTool("d")}); err == nil {
+		t.Error("RegisterAll accepted duplicate names")
+	}
+	if err := r.RegisterAll([]Tool{echoTool("d"), echoTool("b")}); err == nil {
+		t.Error("RegisterAll accepted a registered name")
+	}
+	if r.Len() != 3 {
+		t.Errorf("Len = %d, want 3 (RegisterAll must not register anything on error)", r.Len())
+	}
+
+	var names []string
+	for _, tool := range r.All() {
+		names = append(names, tool.Name)
+	}
+	if got := strings.Join(names, ","); got != "a,b,c" {
+		t.Errorf("All = %s, want the tools sorted by name", got)
+	}
+
+	tool, ok := r.Lookup("b")
+	if !ok {
+		t.Fatal("Lookup(b) found nothing")
+	}
+	out, err := tool.Run(context.Background(), map[string]any{"text": "hi"})
+	if err != nil || out["text"] != "hi" {
+		t.Errorf("Run = %v, %v", out, err)
+	}
+	if _, ok := r.Lookup("missing"); ok {
+		t.Error("Lookup(missing) found a tool")
+	}
+}
+
+func TestStringArg(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     map[string]any
+		required bool
+		want     string
+		wantErr  string
+	}{
+		{name: "present", args: map[string]any{"path": "a.go"}, want: "a.go"},
+		{name: "missing optional", args: map[string]any{}},
+		{name: "null optional", args: map[string]any{"path": nil}},
+		{name: "missing required", args: map[string]any{}, required: true, wantErr: `missing required argument "path"`},
+		{name: "not a string", args: map[string]any{"path": 1.0}, wantErr: `argument "path" must be a string`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stringArg(tt.args, "path", tt.required)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("stringArg = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}